@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/jsnctl/kubespiffe/pkg/helper"
+)
+
+func main() {
+	cfg := helper.Config{}
+	flag.StringVar(&cfg.URL, "url", helper.DefaultURL, "kubespiffed URL")
+	flag.StringVar(&cfg.TokenPath, "token-path", helper.DefaultTokenPath, "path to the projected PSAT")
+	flag.StringVar(&cfg.CertPath, "cert-path", "/var/run/secrets/spiffe/svid.pem", "where to write the X509-SVID")
+	flag.StringVar(&cfg.KeyPath, "key-path", "/var/run/secrets/spiffe/svid_key.pem", "where to write the X509-SVID key")
+	flag.StringVar(&cfg.BundlePath, "bundle-path", "/var/run/secrets/spiffe/bundle.pem", "where to write the trust bundle")
+	certMode := flag.String("cert-mode", "0644", "file mode for the X509-SVID and bundle")
+	keyMode := flag.String("key-mode", "0600", "file mode for the X509-SVID key")
+	sig := flag.String("signal", "", "signal to send to the process in -pid-file after each renewal, e.g. SIGHUP")
+	flag.StringVar(&cfg.PIDFile, "pid-file", "", "file holding the PID of the process to signal")
+	command := flag.String("command", "", "command to run after each renewal, e.g. \"nginx -s reload\"")
+	initMode := flag.Bool("init", false, "exit once the first SVID has been written")
+	flag.Parse()
+
+	var err error
+	if cfg.CertMode, err = parseMode(*certMode); err != nil {
+		log.Fatalf("invalid -cert-mode: %v", err)
+	}
+	if cfg.KeyMode, err = parseMode(*keyMode); err != nil {
+		log.Fatalf("invalid -key-mode: %v", err)
+	}
+	if *sig != "" {
+		if cfg.PIDFile == "" {
+			log.Fatal("-signal needs -pid-file")
+		}
+		if cfg.Signal, err = helper.ParseSignal(*sig); err != nil {
+			log.Fatalf("invalid -signal: %v", err)
+		}
+	}
+	cfg.Command = strings.Fields(*command)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	h := helper.New(cfg)
+	if *initMode {
+		if err := h.Init(ctx); err != nil {
+			log.Fatalf("problem writing SVID: %v", err)
+		}
+		return
+	}
+	if err := h.Run(ctx); err != nil {
+		log.Fatalf("problem renewing SVID: %v", err)
+	}
+}
+
+func parseMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	return os.FileMode(mode), nil
+}
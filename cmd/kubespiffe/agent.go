@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"os"
+
+	"github.com/jsnctl/kubespiffe/pkg/agent"
+	"github.com/jsnctl/kubespiffe/pkg/csi"
+)
+
+const (
+	DefaultAgentSocketPath = "/run/kubespiffe/agent.sock"
+	DefaultAgentTokenPath  = "/var/run/secrets/tokens/psat"
+	DefaultKubespiffedURL  = "http://kubespiffed.kubespiffe.svc.cluster.local:8080"
+	DefaultProcRoot        = "/proc"
+	serviceAccountToken    = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+)
+
+// runAgent serves the Workload API on a node-local Unix socket, attesting
+// callers through the kubelet and fetching their SVIDs from kubespiffed. When
+// CSI_SOCKET_PATH is set it also serves the CSI driver for SVID volumes
+func runAgent() {
+	ctx := context.Background()
+
+	nodeIP, ok := os.LookupEnv("NODE_IP")
+	if !ok {
+		log.Fatal("NODE_IP must be set in agent mode")
+	}
+	kubeletURL := getEnv("KUBELET_URL", "https://"+nodeIP+":10250")
+	kubelet, err := agent.NewKubeletClient(kubeletURL, serviceAccountToken, os.Getenv("KUBELET_CA_PATH"))
+	if err != nil {
+		log.Fatalf("problem with kubelet client: %v", err)
+	}
+
+	central := agent.NewCentralClient(getEnv("KUBESPIFFED_URL", DefaultKubespiffedURL), getEnv("AGENT_TOKEN_PATH", DefaultAgentTokenPath))
+	a := agent.New(agent.NewAttestor(getEnv("PROC_ROOT", DefaultProcRoot), kubelet), central)
+
+	if csiSocketPath, ok := os.LookupEnv("CSI_SOCKET_PATH"); ok {
+		nodeName, ok := os.LookupEnv("NODE_NAME")
+		if !ok {
+			log.Fatal("NODE_NAME must be set to serve the CSI driver")
+		}
+		driver := csi.New(nodeName, central)
+		go func() {
+			slog.Info("serving CSI driver", "driver", csi.DriverName, "socket", csiSocketPath)
+			log.Fatal(driver.Serve(ctx, csiSocketPath))
+		}()
+	}
+
+	socketPath := getEnv("AGENT_SOCKET_PATH", DefaultAgentSocketPath)
+	slog.Info("serving Workload API", "socket", socketPath)
+	log.Fatal(a.Serve(ctx, socketPath))
+}
+
+func getEnv(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return fallback
+}
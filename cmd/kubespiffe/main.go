@@ -2,86 +2,444 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"encoding/pem"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	secretv3 "github.com/envoyproxy/go-control-plane/envoy/service/secret/v3"
+	"github.com/jsnctl/kubespiffe/pkg/authz"
+	"github.com/jsnctl/kubespiffe/pkg/config"
+	"github.com/jsnctl/kubespiffe/pkg/controller"
+	"github.com/jsnctl/kubespiffe/pkg/events"
+	"github.com/jsnctl/kubespiffe/pkg/federation"
+	"github.com/jsnctl/kubespiffe/pkg/generated/informers/externalversions"
+	"github.com/jsnctl/kubespiffe/pkg/ha"
 	"github.com/jsnctl/kubespiffe/pkg/k8s"
+	"github.com/jsnctl/kubespiffe/pkg/metrics"
+	"github.com/jsnctl/kubespiffe/pkg/server"
 	"github.com/jsnctl/kubespiffe/pkg/svid"
+	"github.com/jsnctl/kubespiffe/pkg/tracing"
+	"github.com/jsnctl/kubespiffe/pkg/webhook"
+	"google.golang.org/grpc"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
 )
 
 const (
-	DefaultTrustDomain = "example.org"
+	InformerResync  = 10 * time.Minute
+	ShutdownTimeout = 25 * time.Second
+	// TraceFlushTimeout fits in what terminationGracePeriodSeconds leaves
+	// after ShutdownTimeout
+	TraceFlushTimeout = 4 * time.Second
 )
 
 func main() {
-	ctx := context.Background()
-	cs, err := k8s.GetKubernetesClientset()
+	mode := flag.String("mode", "server", "run as the central server, or as a node agent")
+	configPath := flag.String("config", "", "path to the kubespiffed configuration file")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration and exit")
+	overrides := config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	switch *mode {
+	case "server":
+		cfg, err := config.Load(*configPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := overrides.Apply(cfg, os.LookupEnv); err != nil {
+			log.Fatalf("problem with config overrides: %v", err)
+		}
+		if *printConfig {
+			out, err := cfg.Marshal()
+			if err != nil {
+				log.Fatal(err)
+			}
+			os.Stdout.Write(out)
+		}
+		if err := cfg.Validate(); err != nil {
+			log.Fatalf("invalid config:\n%v", err)
+		}
+		if *printConfig {
+			return
+		}
+		runServer(cfg)
+	case "agent":
+		runAgent()
+	default:
+		log.Fatalf("unknown mode %q: must be server or agent", *mode)
+	}
+}
+
+func runServer(cfg *config.Config) {
+	configureLogging(cfg.Log)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	shutdownTracing, err := tracing.Setup(ctx, cfg.TracingConfig())
+	if err != nil {
+		log.Fatalf("problem with tracing: %v", err)
+	}
+	restConfig, err := k8s.RESTConfig(cfg.Kubernetes.Kubeconfig, cfg.Kubernetes.Context)
+	if err != nil {
+		log.Fatalf("problem with k8s config: %v", err)
+	}
+	cs, err := k8s.GetKubernetesClientset(restConfig)
 	if err != nil {
 		log.Fatalf("problem with k8s clientset: %v", err)
 	}
-	kscs, err := k8s.GetKubespiffeClientset()
+	kscs, err := k8s.GetKubespiffeClientset(restConfig)
 	if err != nil {
 		log.Fatalf("problem with kubespiffe clientset: %v", err)
 	}
+	jwks := cfg.JWKSSource().Fetch
+	if cfg.OutOfCluster() {
+		jwks = getAPIServerJWKS(ctx, cs, cfg.PSAT.Issuer)
+		slog.Info("🔌 Running out of cluster", "host", restConfig.Host)
+	}
 
-	issuer, err := svid.NewSVIDIssuer()
+	var caSecret *ha.SecretCA
+	if cfg.CA.Source == config.CASourceSecret {
+		caSecret = &ha.SecretCA{Client: cs, Namespace: cfg.Namespace, Name: cfg.CA.SecretName, TTL: cfg.CA.TTL.Duration}
+	}
+	issuer, err := getIssuer(ctx, cfg, caSecret)
 	if err != nil {
 		log.Fatalf("problem with issuer: %v", err)
 	}
 
-	http.HandleFunc("/v1/svid", func(w http.ResponseWriter, r *http.Request) {
-		token := k8s.ExtractBearerToken(r.Header.Get("Authorization"))
-		if token == "" {
-			http.Error(w, "missing bearer token", http.StatusUnauthorized)
-			return
+	elector := ha.Standalone()
+	if cfg.HA.Enabled {
+		elector = ha.NewElector(cs, cfg.Election())
+	}
+	k8sInformers := informers.NewSharedInformerFactory(cs, InformerResync)
+	ksInformers := externalversions.NewSharedInformerFactory(kscs, InformerResync)
+	registrations := ksInformers.Kubespiffe().V1alpha1().WorkloadRegistrations()
+	metrics.RegisterWorkloadRegistrations(registrations.Lister())
+	synced := []cache.InformerSynced{registrations.Informer().HasSynced}
+	switch cfg.CA.Source {
+	case config.CASourceSecret:
+		caSynced, err := caSecret.Watch(ctx, issuer)
+		if err != nil {
+			log.Fatalf("problem watching CA secret: %v", err)
 		}
+		synced = append(synced, caSynced)
+		elector.AddDuty("CA rotation", ha.CARotation(issuer, ha.RotationCheckInterval, func(ctx context.Context) error {
+			return caSecret.Rotate(ctx, issuer)
+		}))
+	case config.CASourceGenerated:
+		elector.AddDuty("CA rotation", ha.CARotation(issuer, ha.RotationCheckInterval, func(context.Context) error {
+			return issuer.RotateCA()
+		}))
+	}
+	if cfg.Features.Revocation {
+		revocations := ksInformers.Kubespiffe().V1alpha1().SVIDRevocations()
+		revocationController, err := controller.NewRevocationController(kscs, revocations, issuer)
+		if err != nil {
+			log.Fatalf("problem with revocation controller: %v", err)
+		}
+		elector.AddDuty("revocation status", revocationController.Lead)
+		synced = append(synced, revocations.Informer().HasSynced)
+	}
+	if cfg.Features.Lifecycle {
+		pods := k8sInformers.Core().V1().Pods()
+		_, err = controller.NewLifecycleController(pods, registrations, issuer)
+		if err != nil {
+			log.Fatalf("problem with lifecycle controller: %v", err)
+		}
+		synced = append(synced, pods.Informer().HasSynced)
+	}
+	federatedBundles := federation.NewStore()
+	if cfg.Features.Federation {
+		trustDomains := ksInformers.Kubespiffe().V1alpha1().FederatedTrustDomains()
+		federationController, err := controller.NewFederationController(kscs, trustDomains, federatedBundles)
+		if err != nil {
+			log.Fatalf("problem with federation controller: %v", err)
+		}
+		elector.AddDuty("federation", federationController.Lead)
+		synced = append(synced, trustDomains.Informer().HasSynced)
+	}
 
-		jwks, err := k8s.GetKubernetesJWKS(ctx)
+	opts := []server.Option{
+		server.WithFederation(federatedBundles),
+		server.WithAgentServiceAccounts(cfg.AgentServiceAccounts...),
+		server.WithJWKS(jwks),
+		server.WithPSATValidation(cfg.PSATValidation()),
+		server.WithRateLimits(cfg.RateLimits()),
+	}
+	var evaluator *authz.Evaluator
+	if cfg.Features.AuthorizationPolicy {
+		policies := ksInformers.Kubespiffe().V1alpha1().SpiffeAuthorizationPolicies()
+		evaluator = authz.NewEvaluator(policies.Lister())
+		opts = append(opts, server.WithAuthorization(evaluator))
+		synced = append(synced, policies.Informer().HasSynced)
+	}
+	if cfg.Features.Events {
+		recorder, stopEvents := events.NewRecorder(cs)
+		defer stopEvents()
+		opts = append(opts, server.WithEvents(recorder))
+	}
+	if cfg.HA.Enabled {
+		shared := &ha.SharedRevocations{Client: cs, Namespace: cfg.Namespace, Name: cfg.HA.RevocationsConfigMap, Issuer: issuer, DenialTTL: cfg.HA.DenialTTL.Duration}
+		revocationsSynced, err := shared.Start(ctx)
 		if err != nil {
-			slog.Error("problem with JWKS", "error", err)
+			log.Fatalf("problem sharing revocations: %v", err)
+		}
+		synced = append(synced, revocationsSynced)
+		elector.AddDuty("CRL publishing", shared.PublishCRL(ha.CRLPublishInterval))
+		opts = append(opts, server.WithCRL(shared.CRL), server.WithRevoke(shared.Revoke), server.WithLiftDenial(shared.LiftDenial))
+	}
+	opts = append(opts, server.WithReadinessCheck("informers", server.InformersSynced(synced...)))
+	k8sInformers.Start(ctx.Done())
+	ksInformers.Start(ctx.Done())
+
+	// Duties start from what the informers hold, so wait for them to sync
+	electorDone := make(chan struct{})
+	go func() {
+		defer close(electorDone)
+		if !cache.WaitForCacheSync(ctx.Done(), synced...) {
 			return
 		}
+		if err := elector.Run(ctx); err != nil {
+			log.Fatalf("problem with leader election: %v", err)
+		}
+	}()
 
-		claims, err := k8s.VerifyPSAT(token, jwks)
+	srv := server.New(cs, kscs, issuer, opts...)
+	var (
+		httpServers []*http.Server
+		grpcServers []*grpc.Server
+	)
+	if addr := cfg.Listen.BundleEndpoint; addr != "" {
+		bundleServer, err := getBundleEndpointServer(addr, cfg, issuer)
 		if err != nil {
-			slog.Error("problem with PSAT", "error", err)
-			return
+			log.Fatalf("problem with bundle endpoint: %v", err)
+		}
+		httpServers = append(httpServers, bundleServer)
+		serve("bundle endpoint", func() error { return bundleServer.ListenAndServeTLS("", "") })
+	}
+	if addr := cfg.Listen.Webhook; addr != "" {
+		webhookServer := getWebhookServer(addr, cfg)
+		httpServers = append(httpServers, webhookServer)
+		serve("webhook", func() error { return webhookServer.ListenAndServeTLS(cfg.Webhook.CertFile, cfg.Webhook.KeyFile) })
+	}
+	if addr := cfg.Listen.ExtAuthz; addr != "" {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Fatalf("problem with ext_authz listener: %v", err)
 		}
+		extAuthzServer := grpc.NewServer()
+		authv3.RegisterAuthorizationServer(extAuthzServer, authz.NewExtAuthzServer(evaluator))
+		grpcServers = append(grpcServers, extAuthzServer)
+		serve("ext_authz", func() error { return extAuthzServer.Serve(l) })
+	}
+	if addr := cfg.Listen.SDS; addr != "" {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Fatalf("problem with SDS listener: %v", err)
+		}
+		sdsServer := grpc.NewServer()
+		secretv3.RegisterSecretDiscoveryServiceServer(sdsServer, srv.SDSServer())
+		grpcServers = append(grpcServers, sdsServer)
+		serve("SDS", func() error { return sdsServer.Serve(l) })
+	}
+	if addr := cfg.Listen.Admin; addr != "" {
+		adminServer := &http.Server{Addr: addr, Handler: srv.AdminHandler()}
+		httpServers = append(httpServers, adminServer)
+		serve("admin", adminServer.ListenAndServe)
+	}
+	workloadServer := &http.Server{Addr: cfg.Listen.Workload, Handler: srv.Handler()}
+	httpServers = append(httpServers, workloadServer)
+	serve("workload", workloadServer.ListenAndServe)
 
-		wr, err := k8s.AttestPod(ctx, cs, kscs, claims["kubernetes.io"].(map[string]any))
-		if err != nil || wr == nil {
-			slog.Info("❌ Pod rejected", "error", err)
-			return
+	<-ctx.Done()
+	stop()
+	shutdown(srv, httpServers, grpcServers)
+	// Duties have stopped and the Lease is released once the elector returns
+	<-electorDone
+	if err := issuer.Ledger().Close(); err != nil {
+		slog.Warn("problem flushing audit log", "error", err)
+	}
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), TraceFlushTimeout)
+	defer cancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Warn("problem flushing spans", "error", err)
+	}
+}
+
+// serve runs a listener in the background, exiting if it fails for any reason
+// other than being shut down
+func serve(name string, run func() error) {
+	go func() {
+		if err := run(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("problem with %s listener: %v", name, err)
 		}
-		slog.Info("✅ Pod attested", "registration", wr.Name, "spec", wr.Spec)
+	}()
+}
+
+// shutdown ends streams, then gives in-flight requests up to ShutdownTimeout
+// to finish before the listeners are closed
+func shutdown(srv *server.Server, httpServers []*http.Server, grpcServers []*grpc.Server) {
+	slog.Info("🛑 Shutting down", "timeout", ShutdownTimeout)
+	srv.Drain()
+
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, h := range httpServers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := h.Shutdown(ctx); err != nil {
+				slog.Warn("problem shutting down listener", "addr", h.Addr, "error", err)
+				h.Close()
+			}
+		}()
+	}
+	for _, g := range grpcServers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stopped := make(chan struct{})
+			go func() {
+				g.GracefulStop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+			case <-ctx.Done():
+				g.Stop()
+			}
+		}()
+	}
+	wg.Wait()
+	slog.Info("👋 Shut down")
+}
 
-		svid, svidKey, err := issuer.IssueX509SVID(wr)
+func configureLogging(cfg config.Log) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		level = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	if cfg.Format == "json" {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, opts)))
+		return
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, opts)))
+}
+
+func getIssuer(ctx context.Context, cfg *config.Config, caSecret *ha.SecretCA) (*svid.SVIDIssuer, error) {
+	ledger, err := getLedger(cfg.Audit)
+	if err != nil {
+		return nil, fmt.Errorf("problem with issuance ledger: %w", err)
+	}
+
+	opts := []svid.Option{
+		svid.WithLedger(ledger),
+		svid.WithTrustDomain(cfg.TrustDomain),
+		svid.WithX509SVIDTTL(cfg.SVID.X509TTL.Duration),
+		svid.WithJWTSVIDTTL(cfg.SVID.JWTTTL.Duration),
+		svid.WithCATTL(cfg.CA.TTL.Duration),
+	}
+	if cfg.Revocation.CRLURL != "" {
+		opts = append(opts, svid.WithCRLDistributionPoint(cfg.Revocation.CRLURL))
+	}
+	if cfg.Revocation.OCSPURL != "" {
+		opts = append(opts, svid.WithOCSPServer(cfg.Revocation.OCSPURL))
+	}
+	if cfg.CA.Source == config.CASourceFile {
+		signer, caCert, err := svid.LoadCA(cfg.CA.CertFile, cfg.CA.KeyFile)
 		if err != nil {
-			slog.Error("problem issuing SVID", "error", err)
+			return nil, err
 		}
+		opts = append(opts, svid.WithCA(signer, caCert))
+	}
+	if caSecret != nil {
+		m, err := caSecret.Load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, svid.WithMaterial(m))
+	}
+	return svid.NewSVIDIssuer(opts...)
+}
 
-		resp := map[string]any{
-			"x509_svid":     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: svid}),
-			"x509_svid_key": pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: svidKey}),
-			"bundle":        pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issuer.GetCACert()}),
+// getAPIServerJWKS fetches PSAT signing keys through the API server, warning
+// when the discovered issuer isn't the one PSATs are validated against
+func getAPIServerJWKS(ctx context.Context, cs *kubernetes.Clientset, issuer string) func(context.Context) (*k8s.JWKS, error) {
+	src := k8s.APIServerJWKSSource{Client: cs.Discovery().RESTClient()}
+	oidc, err := src.Discover(ctx)
+	if err != nil {
+		slog.Warn("problem with OpenID discovery", "error", err)
+	} else if oidc.Issuer != issuer {
+		slog.Warn("discovered service account issuer differs from psat.issuer", "discovered", oidc.Issuer, "configured", issuer)
+	}
+	return src.Fetch
+}
+
+// getBundleEndpointServer serves the trust bundle with the https_spiffe
+// profile, or with the configured certificate for https_web
+func getBundleEndpointServer(addr string, cfg *config.Config, issuer *svid.SVIDIssuer) (*http.Server, error) {
+	mux := http.NewServeMux()
+	mux.Handle("/", federation.NewBundleEndpoint(cfg.TrustDomain, issuer, federation.DefaultRefreshHint))
+
+	var tlsConfig *tls.Config
+	switch profile := cfg.Bundle.Profile; profile {
+	case federation.ProfileHTTPSSPIFFE:
+		tlsConfig = federation.SPIFFETLSConfig(issuer, fmt.Sprintf("spiffe://%s/kubespiffed", cfg.TrustDomain))
+	case federation.ProfileHTTPSWeb:
+		var err error
+		tlsConfig, err = federation.WebTLSConfig(cfg.Bundle.CertFile, cfg.Bundle.KeyFile)
+		if err != nil {
+			return nil, err
 		}
+	default:
+		return nil, fmt.Errorf("unknown bundle endpoint profile %q", profile)
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(resp)
-	})
+	return &http.Server{
+		Addr:      addr,
+		Handler:   mux,
+		TLSConfig: tlsConfig,
+	}, nil
+}
 
-	log.Fatal(http.ListenAndServe(":8080", nil))
+// getWebhookServer serves the pod injection webhook. The API server requires
+// TLS, so the webhook certificate and key must be configured
+func getWebhookServer(addr string, cfg *config.Config) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/mutate", webhook.NewInjector(webhook.Config{
+		HelperImage:    cfg.Webhook.HelperImage,
+		KubespiffedURL: cfg.Webhook.KubespiffedURL,
+	}))
+	return &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
 }
 
-func getTrustDomain() string {
-	trustDomain, ok := os.LookupEnv("TRUST_DOMAIN")
-	if !ok {
-		return DefaultTrustDomain
+// getLedger streams issuance records to the audit log path as JSON lines when
+// set, so that history outlives the in-memory retention window. It outlives
+// restarts only if the path is on a volume that does
+func getLedger(cfg config.Audit) (*svid.Ledger, error) {
+	if cfg.LogPath == "" {
+		return svid.NewLedger(cfg.Retention.Duration, nil), nil
+	}
+	f, err := os.OpenFile(cfg.LogPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
 	}
-	return trustDomain
+	return svid.NewLedger(cfg.Retention.Duration, f), nil
 }
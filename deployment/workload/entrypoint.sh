@@ -2,40 +2,62 @@
 
 # This is a very simple approximation of an onboarding flow for
 # a Kubernetes workload with kubespiffe. It uses the PSAT it has
-# been given by the Kubernetes API with the /v1/svid endpoint of
-# kubespiffd, and then tries to deserialise the response. If it
+# been given by the Kubernetes API with the /v1/svid/stream endpoint
+# of kubespiffd, and then tries to deserialise each event. If it
 # successfully attests with the PSAT and there's a WorkloadRegistration
-# CustomResource registered, then it will get an X509-SVID
+# CustomResource registered, then it will get an X509-SVID, followed
+# by a renewed one before each expires
 
 export IS_SERVER
 echo $IS_SERVER
 
-echo "Workload booting..."
-while true; do
-  # Read PSAT token from projected volume
-  TOKEN=$(cat /var/run/secrets/tokens/psat)
-  echo "Using PSAT:"
-  echo "$TOKEN"
-              
-  RESULT=$(curl -s -k -H "Authorization: Bearer $TOKEN" kubespiffed.kubespiffe.svc.cluster.local:8080/v1/svid)
-  X509_SVID=$(echo "$RESULT" | jq -r '.x509_svid' | base64 -d)
-  X509_SVID_KEY=$(echo "$RESULT" | jq -r '.x509_svid_key' | base64 -d)
-  TRUST_BUNDLE=$(echo "$RESULT" | jq -r '.bundle' | base64 -d)
+# write_pem decodes a base64 field of an event into a file, replacing the
+# file in one step so openssl never reads a partial certificate
+write_pem() {
+  echo "$1" | jq -r "$2" | base64 -d > "$3.new" && mv "$3.new" "$3"
+}
 
-  if [ -n "$X509_SVID" ]; then
-    echo "Obtained X509-SVID:"
-    echo "$X509_SVID"
+watch_svid() {
+  while true; do
+    # Read PSAT token from projected volume
+    TOKEN=$(cat /var/run/secrets/tokens/psat)
 
-    echo "$X509_SVID" > /tmp/cert.pem
-    echo "$X509_SVID_KEY"  > /tmp/key.pem
-    echo "$TRUST_BUNDLE" > /tmp/cacert.pem
+    curl -s -N -H "Authorization: Bearer $TOKEN" kubespiffed.kubespiffe.svc.cluster.local:8080/v1/svid/stream |
+    while read -r LINE; do
+      case "$LINE" in
+        "event: "*) EVENT="${LINE#event: }" ;;
+        "data: "*)
+          DATA="${LINE#data: }"
+          case "$EVENT" in
+            svid)
+              write_pem "$DATA" '.x509_svid_key' /tmp/key.pem
+              write_pem "$DATA" '.x509_svid' /tmp/cert.pem
+              write_pem "$DATA" '.bundle' /tmp/cacert.pem
+              echo "Obtained X509-SVID:"
+              openssl x509 -in /tmp/cert.pem -noout -ext subjectAltName
+              echo ""
+              ;;
+            bundles)
+              write_pem "$DATA" '.bundle' /tmp/cacert.pem
+              echo "Trust bundle updated"
+              ;;
+            revoked)
+              echo "X509-SVID revoked: $(echo "$DATA" | jq -r '.reason')"
+              ;;
+          esac
+          ;;
+      esac
+    done
 
-    echo "$X509_SVID" | openssl x509 -noout -ext subjectAltName
-    echo ""
-    break
-  fi
+    echo "SVID stream ended, reconnecting..."
+    sleep 10
+  done
+}
 
-  sleep 10
+echo "Workload booting..."
+watch_svid &
+until [ -s /tmp/cert.pem ]; do
+  sleep 1
 done
 
 if [ "$IS_SERVER" = "true" ]; then
@@ -0,0 +1,25 @@
+#!/usr/bin/env bash
+
+# Issues a self-signed serving certificate for the kubespiffed injection
+# webhook, stores it in the kubespiffed-webhook Secret and trusts it in the
+# MutatingWebhookConfiguration
+
+set -o errexit
+set -o nounset
+set -o pipefail
+
+CONTEXT="${CONTEXT:-kind-kubespiffe}"
+SERVICE="kubespiffed.kubespiffe.svc"
+DIR="$(mktemp -d)"
+trap 'rm -rf "${DIR}"' EXIT
+
+openssl req -x509 -newkey ec -pkeyopt ec_paramgen_curve:prime256v1 -nodes \
+  -keyout "${DIR}/tls.key" -out "${DIR}/tls.crt" -days 365 \
+  -subj "/CN=${SERVICE}" -addext "subjectAltName=DNS:${SERVICE}" 2>/dev/null
+
+kubectl create secret tls kubespiffed-webhook -n kubespiffe --context "${CONTEXT}" \
+  --cert "${DIR}/tls.crt" --key "${DIR}/tls.key" --dry-run=client -o yaml \
+  | kubectl apply --context "${CONTEXT}" -f -
+
+kubectl patch mutatingwebhookconfiguration kubespiffe-injector --context "${CONTEXT}" --type json \
+  -p "[{\"op\": \"replace\", \"path\": \"/webhooks/0/clientConfig/caBundle\", \"value\": \"$(base64 < "${DIR}/tls.crt" | tr -d '\n')\"}]"
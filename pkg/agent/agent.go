@@ -0,0 +1,204 @@
+package agent
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/api"
+)
+
+type contextKey struct{}
+
+type workloadAttestor interface {
+	Attest(ctx context.Context, pid int32) (*PodIdentity, error)
+}
+
+type svidFetcher interface {
+	FetchSVID(ctx context.Context, id PodIdentity) ([]byte, error)
+}
+
+type cachedSVID struct {
+	response  []byte
+	notBefore time.Time
+	notAfter  time.Time
+}
+
+func (c *cachedSVID) fresh(now time.Time) bool {
+	return now.Before(c.notBefore.Add(c.notAfter.Sub(c.notBefore) / 2))
+}
+
+// Agent serves the Workload API to pods on its node over a Unix socket. Pods
+// are attested from the PID of the connecting process, so they need no token
+// of their own, and SVIDs are cached so issuance survives kubespiffed outages
+type Agent struct {
+	attestor workloadAttestor
+	central  svidFetcher
+
+	mu    sync.Mutex
+	cache map[string]*cachedSVID
+}
+
+func New(attestor workloadAttestor, central svidFetcher) *Agent {
+	return &Agent{
+		attestor: attestor,
+		central:  central,
+		cache:    make(map[string]*cachedSVID),
+	}
+}
+
+// Handler serves the same /v1/svid response as kubespiffed. Connections must
+// be accepted by Serve, which records the peer PID for attestation
+func (a *Agent) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/svid", a.handleSVID)
+	return mux
+}
+
+// Serve listens on socketPath until ctx is done. The socket is world
+// writable, as pods run as arbitrary users and are attested by PID instead
+func (a *Agent) Serve(ctx context.Context, socketPath string) error {
+	if err := os.Remove(socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing stale socket: %w", err)
+	}
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(socketPath, 0o777); err != nil {
+		l.Close()
+		return err
+	}
+
+	srv := &http.Server{
+		Handler: a.Handler(),
+		ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+			pid, err := peerPID(conn)
+			if err != nil {
+				slog.Error("problem with peer credentials", "error", err)
+				return ctx
+			}
+			return context.WithValue(ctx, contextKey{}, pid)
+		},
+	}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	err = srv.Serve(l)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (a *Agent) handleSVID(w http.ResponseWriter, r *http.Request) {
+	pid, ok := r.Context().Value(contextKey{}).(int32)
+	if !ok {
+		http.Error(w, "unable to identify caller", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := a.attestor.Attest(r.Context(), pid)
+	if err != nil {
+		slog.Info("❌ Process rejected", "pid", pid, "error", err)
+		http.Error(w, "workload is not attested", http.StatusForbidden)
+		return
+	}
+
+	resp, err := a.svidFor(r.Context(), *id)
+	var centralErr *CentralError
+	if errors.As(err, &centralErr) {
+		http.Error(w, centralErr.Message, centralErr.StatusCode)
+		return
+	}
+	if err != nil {
+		slog.Error("problem fetching SVID", "namespace", id.Namespace, "pod", id.Name, "error", err)
+		http.Error(w, "problem fetching SVID", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// svidFor returns the cached SVID for the pod until half of its lifetime has
+// elapsed, then fetches a new one. If kubespiffed can't be reached or fails,
+// the cached SVID is served for as long as it is valid. A refusal from
+// kubespiffed, e.g. on revocation, drops the cached SVID
+func (a *Agent) svidFor(ctx context.Context, id PodIdentity) ([]byte, error) {
+	now := time.Now()
+
+	a.mu.Lock()
+	a.prune(now)
+	cached, ok := a.cache[id.UID]
+	a.mu.Unlock()
+	if ok && cached.fresh(now) {
+		return cached.response, nil
+	}
+
+	resp, err := a.central.FetchSVID(ctx, id)
+	var centralErr *CentralError
+	if errors.As(err, &centralErr) && centralErr.Refused() {
+		a.mu.Lock()
+		delete(a.cache, id.UID)
+		a.mu.Unlock()
+		return nil, err
+	}
+	if err != nil {
+		if ok {
+			slog.Warn("serving cached SVID", "namespace", id.Namespace, "pod", id.Name, "notAfter", cached.notAfter, "error", err)
+			return cached.response, nil
+		}
+		return nil, err
+	}
+
+	entry, err := parseSVIDResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	a.cache[id.UID] = entry
+	a.mu.Unlock()
+	return resp, nil
+}
+
+// prune must be called with mu held
+func (a *Agent) prune(now time.Time) {
+	for uid, c := range a.cache {
+		if !now.Before(c.notAfter) {
+			delete(a.cache, uid)
+		}
+	}
+}
+
+func parseSVIDResponse(resp []byte) (*cachedSVID, error) {
+	var body api.SVIDResponse
+	if err := json.Unmarshal(resp, &body); err != nil {
+		return nil, fmt.Errorf("decoding SVID response: %w", err)
+	}
+	block, _ := pem.Decode(body.X509SVID)
+	if block == nil {
+		return nil, errors.New("SVID response has no certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing SVID: %w", err)
+	}
+	return &cachedSVID{
+		response:  resp,
+		notBefore: cert.NotBefore,
+		notAfter:  cert.NotAfter,
+	}, nil
+}
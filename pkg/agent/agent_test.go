@@ -0,0 +1,171 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type mockAttestor struct {
+	pid int32
+	id  PodIdentity
+}
+
+func (m mockAttestor) Attest(ctx context.Context, pid int32) (*PodIdentity, error) {
+	if pid != m.pid {
+		return nil, fmt.Errorf("unknown pid %d", pid)
+	}
+	return &m.id, nil
+}
+
+// mockCentral issues SVIDs from a local issuer until it is told to fail
+type mockCentral struct {
+	issuer *svid.SVIDIssuer
+
+	mu      sync.Mutex
+	fetches int
+	err     error
+}
+
+func (m *mockCentral) FetchSVID(ctx context.Context, id PodIdentity) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fetches++
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	wr := &v1alpha1.WorkloadRegistration{
+		ObjectMeta: metav1.ObjectMeta{Name: "workload"},
+		Spec:       v1alpha1.WorkloadRegistrationSpec{SPIFFEID: "spiffe://example.org/workload"},
+	}
+	cert, key, err := m.issuer.IssueX509SVID(context.Background(), wr, svid.Workload{PodName: id.Name, PodUID: id.UID, Namespace: id.Namespace})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]any{
+		"x509_svid":     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}),
+		"x509_svid_key": pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}),
+	})
+}
+
+func (m *mockCentral) fail(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.err = err
+}
+
+func TestSVIDCache(t *testing.T) {
+	ctx := context.Background()
+	id := PodIdentity{Namespace: "default", Name: "workload-abc", UID: "pod-1"}
+
+	issuer, err := svid.NewSVIDIssuer()
+	require.NoError(t, err)
+	central := &mockCentral{issuer: issuer}
+	a := New(mockAttestor{}, central)
+
+	first, err := a.svidFor(ctx, id)
+	require.NoError(t, err)
+	second, err := a.svidFor(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+	assert.Equal(t, 1, central.fetches)
+
+	// Past half-life the agent refetches, but falls back to the cached SVID
+	// while kubespiffed is unreachable
+	entry := a.cache[id.UID]
+	entry.notBefore = time.Now().Add(-6 * time.Minute)
+	central.fail(errors.New("connection refused"))
+	cached, err := a.svidFor(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, first, cached)
+	assert.Equal(t, 2, central.fetches)
+
+	// Once expired there is nothing left to serve
+	entry.notAfter = time.Now().Add(-time.Second)
+	_, err = a.svidFor(ctx, id)
+	assert.Error(t, err)
+
+	// kubespiffed failing or overloaded is an outage like any other
+	central.fail(nil)
+	_, err = a.svidFor(ctx, id)
+	require.NoError(t, err)
+	a.cache[id.UID].notBefore = time.Now().Add(-6 * time.Minute)
+	renewed := a.cache[id.UID].response
+	for _, code := range []int{http.StatusServiceUnavailable, http.StatusInternalServerError, http.StatusTooManyRequests} {
+		central.fail(&CentralError{StatusCode: code, Message: http.StatusText(code)})
+		cached, err = a.svidFor(ctx, id)
+		require.NoError(t, err, code)
+		assert.Equal(t, renewed, cached, code)
+	}
+
+	// A refusal, e.g. after revocation, drops the cached SVID
+	central.fail(&CentralError{StatusCode: http.StatusForbidden, Message: "identity has been revoked"})
+	_, err = a.svidFor(ctx, id)
+	var centralErr *CentralError
+	require.ErrorAs(t, err, &centralErr)
+	assert.NotContains(t, a.cache, id.UID)
+}
+
+func TestServe(t *testing.T) {
+	if _, err := peerPID(nil); err != nil && err.Error() == "peer credentials are only supported on linux" {
+		t.Skip(err)
+	}
+
+	// Unix socket paths are limited to ~100 bytes, which t.TempDir can exceed
+	dir, err := os.MkdirTemp("", "agent")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socketPath := filepath.Join(dir, "agent.sock")
+
+	issuer, err := svid.NewSVIDIssuer()
+	require.NoError(t, err)
+	a := New(
+		mockAttestor{pid: int32(os.Getpid()), id: PodIdentity{Namespace: "default", Name: "workload-abc", UID: "pod-1"}},
+		&mockCentral{issuer: issuer},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- a.Serve(ctx, socketPath) }()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+		},
+	}}
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(socketPath)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	resp, err := client.Get("http://agent/v1/svid")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+
+	entry, err := parseSVIDResponse(body)
+	require.NoError(t, err)
+	assert.True(t, entry.notAfter.After(time.Now()))
+
+	cancel()
+	require.NoError(t, <-done)
+}
@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+var (
+	// Pod UIDs appear in cgroup paths as e.g. kubepods-besteffort-pod<uid>.slice
+	// with systemd, where the dashes are replaced by underscores, or as
+	// /kubepods/besteffort/pod<uid>/ with cgroupfs
+	podUIDPattern = regexp.MustCompile(`pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})`)
+
+	// Container IDs are the final path element, optionally wrapped by the
+	// runtime as cri-containerd-<id>.scope, crio-<id>.scope or docker-<id>.scope
+	containerIDPattern = regexp.MustCompile(`([0-9a-f]{64})(?:\.scope)?$`)
+)
+
+// PodIdentity identifies the pod that a local process belongs to
+type PodIdentity struct {
+	Namespace string
+	Name      string
+	UID       string
+}
+
+type podLister interface {
+	Pods(ctx context.Context) ([]corev1.Pod, error)
+}
+
+// Attestor resolves the PID of a process connecting to the agent socket to a
+// pod, via the process's cgroup and the kubelet's view of its pods
+type Attestor struct {
+	procRoot string
+	kubelet  podLister
+}
+
+func NewAttestor(procRoot string, kubelet podLister) *Attestor {
+	return &Attestor{
+		procRoot: procRoot,
+		kubelet:  kubelet,
+	}
+}
+
+func (a *Attestor) Attest(ctx context.Context, pid int32) (*PodIdentity, error) {
+	podUID, containerID, err := a.cgroupIdentity(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	pods, err := a.kubelet.Pods(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing kubelet pods: %w", err)
+	}
+	for _, pod := range pods {
+		if string(pod.UID) != podUID {
+			continue
+		}
+		if !hasContainer(&pod, containerID) {
+			return nil, fmt.Errorf("container %s is not part of pod %s/%s", containerID, pod.Namespace, pod.Name)
+		}
+		return &PodIdentity{
+			Namespace: pod.Namespace,
+			Name:      pod.Name,
+			UID:       podUID,
+		}, nil
+	}
+	return nil, fmt.Errorf("no pod with UID %s on this node", podUID)
+}
+
+// cgroupIdentity reads /proc/<pid>/cgroup for the pod UID and container ID
+func (a *Attestor) cgroupIdentity(pid int32) (string, string, error) {
+	f, err := os.Open(filepath.Join(a.procRoot, fmt.Sprint(pid), "cgroup"))
+	if err != nil {
+		return "", "", fmt.Errorf("reading cgroup for pid %d: %w", pid, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		podUID, containerID, ok := parseCgroupLine(scanner.Text())
+		if ok {
+			return podUID, containerID, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", err
+	}
+	return "", "", fmt.Errorf("pid %d is not running in a Kubernetes pod", pid)
+}
+
+func parseCgroupLine(line string) (string, string, bool) {
+	// hierarchy-ID:controller-list:cgroup-path
+	parts := strings.SplitN(line, ":", 3)
+	if len(parts) != 3 {
+		return "", "", false
+	}
+	path := parts[2]
+
+	podMatch := podUIDPattern.FindStringSubmatch(path)
+	containerMatch := containerIDPattern.FindStringSubmatch(path)
+	if podMatch == nil || containerMatch == nil {
+		return "", "", false
+	}
+	return strings.ReplaceAll(podMatch[1], "_", "-"), containerMatch[1], true
+}
+
+func hasContainer(pod *corev1.Pod, containerID string) bool {
+	statuses := append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.ContainerStatuses...)
+	for _, cs := range statuses {
+		// Status container IDs are prefixed with the runtime, e.g. containerd://
+		if strings.HasSuffix(cs.ContainerID, "://"+containerID) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	testPodUID      = "2c48913c-b29f-11e7-9350-020968147796"
+	testContainerID = "9bca8d63d5fa610783847915bcff0ecac1273e5b4bed3f6fa1b07350e0135961"
+)
+
+type mockKubelet []corev1.Pod
+
+func (m mockKubelet) Pods(ctx context.Context) ([]corev1.Pod, error) {
+	return m, nil
+}
+
+func TestParseCgroupLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		ok   bool
+	}{
+		{
+			name: "cgroup v2 systemd containerd",
+			line: "0::/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod2c48913c_b29f_11e7_9350_020968147796.slice/cri-containerd-" + testContainerID + ".scope",
+			ok:   true,
+		},
+		{
+			name: "cgroup v1 cgroupfs",
+			line: "11:memory:/kubepods/burstable/pod" + testPodUID + "/" + testContainerID,
+			ok:   true,
+		},
+		{
+			name: "cgroup v1 systemd docker",
+			line: "4:cpu,cpuacct:/kubepods.slice/kubepods-pod2c48913c_b29f_11e7_9350_020968147796.slice/docker-" + testContainerID + ".scope",
+			ok:   true,
+		},
+		{
+			name: "pod sandbox without container",
+			line: "0::/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod2c48913c_b29f_11e7_9350_020968147796.slice",
+		},
+		{
+			name: "host process",
+			line: "0::/system.slice/kubelet.service",
+		},
+		{
+			name: "malformed",
+			line: "garbage",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			podUID, containerID, ok := parseCgroupLine(tt.line)
+			require.Equal(t, tt.ok, ok)
+			if tt.ok {
+				assert.Equal(t, testPodUID, podUID)
+				assert.Equal(t, testContainerID, containerID)
+			}
+		})
+	}
+}
+
+func TestAttest(t *testing.T) {
+	procRoot := t.TempDir()
+	writeCgroup := func(pid, content string) {
+		require.NoError(t, os.MkdirAll(filepath.Join(procRoot, pid), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(procRoot, pid, "cgroup"), []byte(content), 0o644))
+	}
+	writeCgroup("100", "0::/kubepods/besteffort/pod"+testPodUID+"/"+testContainerID+"\n")
+	writeCgroup("200", "0::/kubepods/besteffort/pod"+testPodUID+"/"+strings.Repeat("a", 64)+"\n")
+	writeCgroup("300", "0::/system.slice/kubelet.service\n")
+
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "workload-abc", Namespace: "default", UID: testPodUID},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{{ContainerID: "containerd://" + testContainerID}},
+		},
+	}
+	attestor := NewAttestor(procRoot, mockKubelet{pod})
+
+	id, err := attestor.Attest(context.Background(), 100)
+	require.NoError(t, err)
+	assert.Equal(t, PodIdentity{Namespace: "default", Name: "workload-abc", UID: testPodUID}, *id)
+
+	_, err = attestor.Attest(context.Background(), 200)
+	assert.ErrorContains(t, err, "is not part of pod")
+
+	_, err = attestor.Attest(context.Background(), 300)
+	assert.ErrorContains(t, err, "not running in a Kubernetes pod")
+
+	_, err = attestor.Attest(context.Background(), 400)
+	assert.Error(t, err)
+}
@@ -0,0 +1,98 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/api"
+	"github.com/jsnctl/kubespiffe/pkg/tracing"
+)
+
+const (
+	maxSVIDResponseBytes = 1024 * 1024
+)
+
+// CentralError is an error response from kubespiffed. It is distinct from
+// kubespiffed being unreachable, although cached SVIDs are still served for
+// errors other than a refusal
+type CentralError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *CentralError) Error() string {
+	return fmt.Sprintf("kubespiffed responded %d: %s", e.StatusCode, e.Message)
+}
+
+// Refused reports whether kubespiffed refused to issue the pod an SVID, e.g.
+// on revocation, rather than failing or being overloaded
+func (e *CentralError) Refused() bool {
+	switch e.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+		return true
+	}
+	return false
+}
+
+// CentralClient requests SVIDs from kubespiffed on behalf of local pods,
+// authenticating with the agent's own PSAT
+type CentralClient struct {
+	url       string
+	tokenPath string
+	client    *http.Client
+}
+
+func NewCentralClient(url, tokenPath string) *CentralClient {
+	return &CentralClient{
+		url:       strings.TrimSuffix(url, "/"),
+		tokenPath: tokenPath,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// FetchSVID returns the raw kubespiffed SVID response for the pod
+func (c *CentralClient) FetchSVID(ctx context.Context, id PodIdentity) ([]byte, error) {
+	token, err := os.ReadFile(c.tokenPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading agent token: %w", err)
+	}
+
+	body, err := json.Marshal(api.AgentSVIDRequest{
+		Namespace: id.Namespace,
+		PodName:   id.Name,
+		PodUID:    id.UID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+"/v1/agent/svid", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Content-Type", "application/json")
+	tracing.Inject(ctx, req.Header)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting SVID: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSVIDResponseBytes))
+	if err != nil {
+		return nil, fmt.Errorf("reading SVID response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &CentralError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+	}
+	return data, nil
+}
@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// KubeletClient reads the pods running on this node from the kubelet API,
+// authenticating with the agent's service account token
+type KubeletClient struct {
+	url       string
+	tokenPath string
+	client    *http.Client
+}
+
+// NewKubeletClient verifies the kubelet's serving certificate against caPath
+// if set. Kubelets commonly serve self-signed certificates, so without a CA
+// the connection is not verified
+func NewKubeletClient(url, tokenPath, caPath string) (*KubeletClient, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caPath == "" {
+		tlsConfig.InsecureSkipVerify = true
+	} else {
+		caData, err := os.ReadFile(caPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading kubelet CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("failed to append kubelet CA certs")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &KubeletClient{
+		url:       strings.TrimSuffix(url, "/"),
+		tokenPath: tokenPath,
+		client: &http.Client{
+			Timeout:   5 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+func (k *KubeletClient) Pods(ctx context.Context) ([]corev1.Pod, error) {
+	token, err := os.ReadFile(k.tokenPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading service account token: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.url+"/pods", nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching pods: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+
+	var pods corev1.PodList
+	if err := json.NewDecoder(resp.Body).Decode(&pods); err != nil {
+		return nil, fmt.Errorf("decoding pods: %w", err)
+	}
+	return pods.Items, nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package agent
+
+import (
+	"errors"
+	"net"
+)
+
+func peerPID(conn net.Conn) (int32, error) {
+	return 0, errors.New("peer credentials are only supported on linux")
+}
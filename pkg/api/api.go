@@ -0,0 +1,58 @@
+// Package api holds the types kubespiffed's workload API sends and receives.
+// It has no dependencies, so that clients needn't import the server
+package api
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	// Server-sent event types on /v1/svid/stream. A revoked event is the last
+	// on the stream
+	EventSVID    = "svid"
+	EventBundles = "bundles"
+	EventRevoked = "revoked"
+)
+
+// SVIDResponse is returned by /v1/svid. Certificates are PEM encoded
+type SVIDResponse struct {
+	X509SVID    []byte            `json:"x509_svid"`
+	X509SVIDKey []byte            `json:"x509_svid_key"`
+	Bundle      []byte            `json:"bundle"`
+	Bundles     map[string][]byte `json:"bundles"`
+}
+
+// BundlesUpdate is sent on /v1/svid/stream when the CA is rotated or a
+// federated bundle is refreshed
+type BundlesUpdate struct {
+	Bundle  []byte            `json:"bundle"`
+	Bundles map[string][]byte `json:"bundles"`
+}
+
+// RevokedEvent ends /v1/svid/stream once the workload can no longer be issued
+// an SVID
+type RevokedEvent struct {
+	Reason string `json:"reason"`
+}
+
+// JWTSVIDResponse is returned by /v1/jwt-svid
+type JWTSVIDResponse struct {
+	Token     string    `json:"token"`
+	SPIFFEID  string    `json:"spiffeID"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// BundlesResponse is returned by /v1/bundles. Each bundle is a SPIFFE bundle
+// JWK Set holding both X.509 and JWT authorities, keyed by trust domain
+type BundlesResponse struct {
+	Bundles map[string]json.RawMessage `json:"bundles"`
+}
+
+// AgentSVIDRequest is sent by a node agent to obtain an SVID on behalf of a
+// pod it has attested locally
+type AgentSVIDRequest struct {
+	Namespace string `json:"namespace"`
+	PodName   string `json:"podName"`
+	PodUID    string `json:"podUID"`
+}
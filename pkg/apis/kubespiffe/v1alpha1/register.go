@@ -30,6 +30,12 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 	scheme.AddKnownTypes(SchemeGroupVersion,
 		&WorkloadRegistration{},
 		&WorkloadRegistrationList{},
+		&SVIDRevocation{},
+		&SVIDRevocationList{},
+		&FederatedTrustDomain{},
+		&FederatedTrustDomainList{},
+		&SpiffeAuthorizationPolicy{},
+		&SpiffeAuthorizationPolicyList{},
 	)
 	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
 	return nil
@@ -20,6 +20,10 @@ type WorkloadRegistration struct {
 type WorkloadRegistrationSpec struct {
 	SPIFFEID string `json:"spiffeID"`
 	SVIDType string `json:"svidType"`
+
+	// FederatesWith lists the foreign trust domains whose bundles matched
+	// workloads receive, each of which must have a FederatedTrustDomain
+	FederatesWith []string `json:"federatesWith,omitempty"`
 }
 
 type WorkloadRegistrationStatus struct{}
@@ -32,3 +36,124 @@ type WorkloadRegistrationList struct {
 
 	Items []WorkloadRegistration `json:"items"`
 }
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// SVIDRevocation is a custom resource requesting that kubespiffed revoke
+// SVIDs by serial, SPIFFE ID or pod UID. Revoking a SPIFFE ID or pod UID also
+// refuses reissuance to it for as long as the resource exists. Its spec is
+// immutable, as the SVIDs it revoked stay revoked
+type SVIDRevocation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SVIDRevocationSpec   `json:"spec"`
+	Status SVIDRevocationStatus `json:"status"`
+}
+
+type SVIDRevocationSpec struct {
+	Serial   string `json:"serial,omitempty"`
+	SPIFFEID string `json:"spiffeID,omitempty"`
+	PodUID   string `json:"podUID,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+type SVIDRevocationStatus struct {
+	RevokedSerials []string     `json:"revokedSerials,omitempty"`
+	RevokedAt      *metav1.Time `json:"revokedAt,omitempty"`
+	Error          string       `json:"error,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+type SVIDRevocationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []SVIDRevocation `json:"items"`
+}
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// FederatedTrustDomain is a custom resource naming a foreign trust domain
+// whose bundle kubespiffed fetches from its SPIFFE bundle endpoint
+type FederatedTrustDomain struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   FederatedTrustDomainSpec   `json:"spec"`
+	Status FederatedTrustDomainStatus `json:"status"`
+}
+
+type FederatedTrustDomainSpec struct {
+	TrustDomain           string                `json:"trustDomain"`
+	BundleEndpointURL     string                `json:"bundleEndpointURL"`
+	BundleEndpointProfile BundleEndpointProfile `json:"bundleEndpointProfile"`
+
+	// TrustDomainBundle is the foreign trust domain's bundle in SPIFFE format,
+	// used to authenticate an https_spiffe endpoint before the first fetch
+	TrustDomainBundle string `json:"trustDomainBundle,omitempty"`
+}
+
+type BundleEndpointProfile struct {
+	Type             string `json:"type"`
+	EndpointSPIFFEID string `json:"endpointSPIFFEID,omitempty"`
+}
+
+type FederatedTrustDomainStatus struct {
+	LastFetchTime           *metav1.Time `json:"lastFetchTime,omitempty"`
+	LastSuccessfulFetchTime *metav1.Time `json:"lastSuccessfulFetchTime,omitempty"`
+	NextFetchTime           *metav1.Time `json:"nextFetchTime,omitempty"`
+	Sequence                int64        `json:"sequence,omitempty"`
+	// Bundle is the last bundle fetched, for replicas that aren't polling
+	Bundle string `json:"bundle,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+type FederatedTrustDomainList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []FederatedTrustDomain `json:"items"`
+}
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// SpiffeAuthorizationPolicy is a custom resource allowing source SPIFFE IDs
+// to call a destination SPIFFE ID. A destination selected by any policy only
+// accepts the calls its policies allow
+type SpiffeAuthorizationPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec SpiffeAuthorizationPolicySpec `json:"spec"`
+}
+
+// SpiffeAuthorizationPolicySpec matches SPIFFE IDs and paths with patterns in
+// which * matches within a path segment and ** matches across segments
+type SpiffeAuthorizationPolicySpec struct {
+	Destination string   `json:"destination"`
+	Sources     []string `json:"sources"`
+
+	// Methods and Paths restrict the allowed HTTP requests, and allow any
+	// when empty
+	Methods []string `json:"methods,omitempty"`
+	Paths   []string `json:"paths,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+type SpiffeAuthorizationPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []SpiffeAuthorizationPolicy `json:"items"`
+}
@@ -14,11 +14,326 @@ import (
 )
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *WorkloadRegistration) DeepCopyInto(out *WorkloadRegistration) {
+func (in *BundleEndpointProfile) DeepCopyInto(out *BundleEndpointProfile) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BundleEndpointProfile.
+func (in *BundleEndpointProfile) DeepCopy() *BundleEndpointProfile {
+	if in == nil {
+		return nil
+	}
+	out := new(BundleEndpointProfile)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FederatedTrustDomain) DeepCopyInto(out *FederatedTrustDomain) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FederatedTrustDomain.
+func (in *FederatedTrustDomain) DeepCopy() *FederatedTrustDomain {
+	if in == nil {
+		return nil
+	}
+	out := new(FederatedTrustDomain)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *FederatedTrustDomain) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FederatedTrustDomainList) DeepCopyInto(out *FederatedTrustDomainList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]FederatedTrustDomain, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FederatedTrustDomainList.
+func (in *FederatedTrustDomainList) DeepCopy() *FederatedTrustDomainList {
+	if in == nil {
+		return nil
+	}
+	out := new(FederatedTrustDomainList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *FederatedTrustDomainList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FederatedTrustDomainSpec) DeepCopyInto(out *FederatedTrustDomainSpec) {
+	*out = *in
+	out.BundleEndpointProfile = in.BundleEndpointProfile
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FederatedTrustDomainSpec.
+func (in *FederatedTrustDomainSpec) DeepCopy() *FederatedTrustDomainSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(FederatedTrustDomainSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FederatedTrustDomainStatus) DeepCopyInto(out *FederatedTrustDomainStatus) {
+	*out = *in
+	if in.LastFetchTime != nil {
+		in, out := &in.LastFetchTime, &out.LastFetchTime
+		*out = (*in).DeepCopy()
+	}
+	if in.LastSuccessfulFetchTime != nil {
+		in, out := &in.LastSuccessfulFetchTime, &out.LastSuccessfulFetchTime
+		*out = (*in).DeepCopy()
+	}
+	if in.NextFetchTime != nil {
+		in, out := &in.NextFetchTime, &out.NextFetchTime
+		*out = (*in).DeepCopy()
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FederatedTrustDomainStatus.
+func (in *FederatedTrustDomainStatus) DeepCopy() *FederatedTrustDomainStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(FederatedTrustDomainStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SVIDRevocation) DeepCopyInto(out *SVIDRevocation) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SVIDRevocation.
+func (in *SVIDRevocation) DeepCopy() *SVIDRevocation {
+	if in == nil {
+		return nil
+	}
+	out := new(SVIDRevocation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *SVIDRevocation) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SVIDRevocationList) DeepCopyInto(out *SVIDRevocationList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]SVIDRevocation, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SVIDRevocationList.
+func (in *SVIDRevocationList) DeepCopy() *SVIDRevocationList {
+	if in == nil {
+		return nil
+	}
+	out := new(SVIDRevocationList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *SVIDRevocationList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SVIDRevocationSpec) DeepCopyInto(out *SVIDRevocationSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SVIDRevocationSpec.
+func (in *SVIDRevocationSpec) DeepCopy() *SVIDRevocationSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SVIDRevocationSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SVIDRevocationStatus) DeepCopyInto(out *SVIDRevocationStatus) {
+	*out = *in
+	if in.RevokedSerials != nil {
+		in, out := &in.RevokedSerials, &out.RevokedSerials
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RevokedAt != nil {
+		in, out := &in.RevokedAt, &out.RevokedAt
+		*out = (*in).DeepCopy()
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SVIDRevocationStatus.
+func (in *SVIDRevocationStatus) DeepCopy() *SVIDRevocationStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(SVIDRevocationStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SpiffeAuthorizationPolicy) DeepCopyInto(out *SpiffeAuthorizationPolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SpiffeAuthorizationPolicy.
+func (in *SpiffeAuthorizationPolicy) DeepCopy() *SpiffeAuthorizationPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(SpiffeAuthorizationPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *SpiffeAuthorizationPolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SpiffeAuthorizationPolicyList) DeepCopyInto(out *SpiffeAuthorizationPolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]SpiffeAuthorizationPolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SpiffeAuthorizationPolicyList.
+func (in *SpiffeAuthorizationPolicyList) DeepCopy() *SpiffeAuthorizationPolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(SpiffeAuthorizationPolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *SpiffeAuthorizationPolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SpiffeAuthorizationPolicySpec) DeepCopyInto(out *SpiffeAuthorizationPolicySpec) {
+	*out = *in
+	if in.Sources != nil {
+		in, out := &in.Sources, &out.Sources
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Methods != nil {
+		in, out := &in.Methods, &out.Methods
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Paths != nil {
+		in, out := &in.Paths, &out.Paths
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SpiffeAuthorizationPolicySpec.
+func (in *SpiffeAuthorizationPolicySpec) DeepCopy() *SpiffeAuthorizationPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SpiffeAuthorizationPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkloadRegistration) DeepCopyInto(out *WorkloadRegistration) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
 	out.Status = in.Status
 	return
 }
@@ -77,6 +392,11 @@ func (in *WorkloadRegistrationList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WorkloadRegistrationSpec) DeepCopyInto(out *WorkloadRegistrationSpec) {
 	*out = *in
+	if in.FederatesWith != nil {
+		in, out := &in.FederatesWith, &out.FederatesWith
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
@@ -0,0 +1,87 @@
+// Package atomicdir writes sets of files that readers must see change
+// together, such as an SVID and its key
+package atomicdir
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DataDir is the link each file is reached through
+const DataDir = "..data"
+
+// File is the contents and mode of a file written by Write
+type File struct {
+	Data []byte
+	Mode os.FileMode
+}
+
+// Write writes files into dir the way Kubernetes projects Secrets: into a
+// fresh hidden directory that ..data is then atomically repointed at, with
+// each file a symlink through ..data. Readers never see a certificate paired
+// with the previous key
+func Write(dir string, files map[string]File) error {
+	tsDir, err := os.MkdirTemp(dir, "..svid_")
+	if err != nil {
+		return fmt.Errorf("creating data dir: %w", err)
+	}
+	if err := os.Chmod(tsDir, 0o755); err != nil {
+		return err
+	}
+	for name, f := range files {
+		path := filepath.Join(tsDir, name)
+		if err := os.WriteFile(path, f.Data, f.Mode); err != nil {
+			os.RemoveAll(tsDir)
+			return fmt.Errorf("writing %s: %w", name, err)
+		}
+		// WriteFile's mode is subject to the umask
+		if err := os.Chmod(path, f.Mode); err != nil {
+			os.RemoveAll(tsDir)
+			return fmt.Errorf("writing %s: %w", name, err)
+		}
+	}
+
+	tmpLink := filepath.Join(dir, DataDir+"_tmp")
+	os.Remove(tmpLink)
+	if err := os.Symlink(filepath.Base(tsDir), tmpLink); err != nil {
+		os.RemoveAll(tsDir)
+		return fmt.Errorf("linking data dir: %w", err)
+	}
+	oldDir, _ := os.Readlink(filepath.Join(dir, DataDir))
+	if err := os.Rename(tmpLink, filepath.Join(dir, DataDir)); err != nil {
+		os.RemoveAll(tsDir)
+		return fmt.Errorf("swapping data dir: %w", err)
+	}
+
+	for name := range files {
+		if err := link(dir, name); err != nil {
+			return err
+		}
+	}
+
+	if strings.HasPrefix(oldDir, "..svid_") {
+		os.RemoveAll(filepath.Join(dir, oldDir))
+	}
+	return nil
+}
+
+// link points name at its file in ..data, atomically replacing a regular file
+// left by an earlier writer
+func link(dir, name string) error {
+	path := filepath.Join(dir, name)
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+	tmp := filepath.Join(dir, "."+name+".tmp")
+	os.Remove(tmp)
+	if err := os.Symlink(filepath.Join(DataDir, name), tmp); err != nil {
+		return fmt.Errorf("linking %s: %w", name, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("linking %s: %w", name, err)
+	}
+	return nil
+}
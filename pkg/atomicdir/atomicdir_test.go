@@ -0,0 +1,52 @@
+package atomicdir
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func files(a, b string) map[string]File {
+	return map[string]File{
+		"a.pem": {Data: []byte(a), Mode: 0o644},
+		"b.pem": {Data: []byte(b), Mode: 0o600},
+	}
+}
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	// A file left by an earlier writer is replaced by a link
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.pem"), []byte("a0"), 0o644))
+
+	require.NoError(t, Write(dir, files("a1", "b1")))
+	first, err := os.Readlink(filepath.Join(dir, DataDir))
+	require.NoError(t, err)
+
+	require.NoError(t, Write(dir, files("a2", "b2")))
+	second, err := os.Readlink(filepath.Join(dir, DataDir))
+	require.NoError(t, err)
+	assert.NotEqual(t, first, second)
+
+	for name, want := range map[string]string{"a.pem": "a2", "b.pem": "b2"} {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got))
+	}
+	for name, mode := range map[string]os.FileMode{"a.pem": 0o644, "b.pem": 0o600} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		require.NoError(t, err)
+		assert.Equal(t, mode, info.Mode().Perm(), name)
+	}
+
+	// Only the current data dir, its link and the file links remain
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.ElementsMatch(t, []string{second, DataDir, "a.pem", "b.pem"}, names)
+}
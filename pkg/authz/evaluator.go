@@ -0,0 +1,125 @@
+package authz
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	listers "github.com/jsnctl/kubespiffe/pkg/generated/listers/kubespiffe/v1alpha1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Request describes a call from a source workload to a destination workload
+type Request struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Method      string `json:"method,omitempty"`
+	Path        string `json:"path,omitempty"`
+}
+
+// Decision is the outcome of evaluating a Request, naming the policy that
+// allowed it
+type Decision struct {
+	Allowed bool   `json:"allowed"`
+	Policy  string `json:"policy,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+// Evaluator decides requests against the SpiffeAuthorizationPolicies held in
+// an informer cache
+type Evaluator struct {
+	policies listers.SpiffeAuthorizationPolicyLister
+}
+
+func NewEvaluator(policies listers.SpiffeAuthorizationPolicyLister) *Evaluator {
+	return &Evaluator{policies: policies}
+}
+
+// Evaluate allows a request to a destination no policy selects, and otherwise
+// only if one of the destination's policies allows it
+func (e *Evaluator) Evaluate(req Request) (Decision, error) {
+	policies, err := e.policies.List(labels.Everything())
+	if err != nil {
+		return Decision{}, fmt.Errorf("problem listing policies: %w", err)
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].Name < policies[j].Name })
+
+	selected := false
+	for _, p := range policies {
+		if !Match(p.Spec.Destination, req.Destination) {
+			continue
+		}
+		selected = true
+		if allows(p.Spec, req) {
+			return Decision{Allowed: true, Policy: p.Name, Reason: "allowed by policy"}, nil
+		}
+	}
+	if !selected {
+		return Decision{Allowed: true, Reason: "no policy selects destination"}, nil
+	}
+	return Decision{Allowed: false, Reason: "no policy allows source"}, nil
+}
+
+func allows(spec v1alpha1.SpiffeAuthorizationPolicySpec, req Request) bool {
+	if req.Source == "" || !matchAny(spec.Sources, req.Source) {
+		return false
+	}
+	if len(spec.Methods) > 0 && !containsFold(spec.Methods, req.Method) {
+		return false
+	}
+	if len(spec.Paths) > 0 && !matchAny(spec.Paths, req.Path) {
+		return false
+	}
+	return true
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if Match(p, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match reports whether s matches pattern, in which * matches any characters
+// within a path segment and ** matches any characters across segments
+func Match(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch {
+		case strings.HasPrefix(pattern, "**"):
+			rest := pattern[2:]
+			for i := 0; i <= len(s); i++ {
+				if Match(rest, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case pattern[0] == '*':
+			rest := pattern[1:]
+			for i := 0; i <= len(s); i++ {
+				if Match(rest, s[i:]) {
+					return true
+				}
+				if i < len(s) && s[i] == '/' {
+					break
+				}
+			}
+			return false
+		case len(s) == 0 || pattern[0] != s[0]:
+			return false
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
@@ -0,0 +1,120 @@
+package authz
+
+import (
+	"testing"
+
+	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	listers "github.com/jsnctl/kubespiffe/pkg/generated/listers/kubespiffe/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func mockEvaluator(t *testing.T, policies ...*v1alpha1.SpiffeAuthorizationPolicy) *Evaluator {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, p := range policies {
+		require.NoError(t, indexer.Add(p))
+	}
+	return NewEvaluator(listers.NewSpiffeAuthorizationPolicyLister(indexer))
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"spiffe://example.org/api", "spiffe://example.org/api", true},
+		{"spiffe://example.org/api", "spiffe://example.org/api/v2", false},
+		{"spiffe://example.org/ns/*/sa/api", "spiffe://example.org/ns/default/sa/api", true},
+		{"spiffe://example.org/ns/*/sa/api", "spiffe://example.org/ns/a/b/sa/api", false},
+		{"spiffe://example.org/*", "spiffe://example.org/ns/default", false},
+		{"spiffe://example.org/**", "spiffe://example.org/ns/default/sa/api", true},
+		{"spiffe://example.org/**", "spiffe://example.org.evil/api", false},
+		{"spiffe://*/billing", "spiffe://partner.org/billing", true},
+		{"/api/**", "/api/invoices/1", true},
+		{"/api/*", "/api/invoices/1", false},
+		{"/api/*/items", "/api/invoices/items", true},
+		{"**", "", true},
+		{"*", "", true},
+		{"", "/", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.s, func(t *testing.T) {
+			assert.Equal(t, tt.want, Match(tt.pattern, tt.s))
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	e := mockEvaluator(t,
+		&v1alpha1.SpiffeAuthorizationPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "payments-read"},
+			Spec: v1alpha1.SpiffeAuthorizationPolicySpec{
+				Destination: "spiffe://example.org/ns/payments/sa/*",
+				Sources:     []string{"spiffe://example.org/ns/frontend/sa/**"},
+				Methods:     []string{"GET"},
+				Paths:       []string{"/api/**"},
+			},
+		},
+		&v1alpha1.SpiffeAuthorizationPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "payments-partner"},
+			Spec: v1alpha1.SpiffeAuthorizationPolicySpec{
+				Destination: "spiffe://example.org/ns/payments/sa/api",
+				Sources:     []string{"spiffe://partner.org/billing"},
+			},
+		},
+	)
+
+	tests := []struct {
+		name    string
+		req     Request
+		allowed bool
+		policy  string
+	}{
+		{
+			name:    "unselected destination",
+			req:     Request{Source: "spiffe://example.org/anything", Destination: "spiffe://example.org/ns/default/sa/web"},
+			allowed: true,
+		},
+		{
+			name:    "allowed source, method and path",
+			req:     Request{Source: "spiffe://example.org/ns/frontend/sa/web", Destination: "spiffe://example.org/ns/payments/sa/api", Method: "get", Path: "/api/invoices"},
+			allowed: true,
+			policy:  "payments-read",
+		},
+		{
+			name: "disallowed method",
+			req:  Request{Source: "spiffe://example.org/ns/frontend/sa/web", Destination: "spiffe://example.org/ns/payments/sa/api", Method: "DELETE", Path: "/api/invoices"},
+		},
+		{
+			name: "disallowed path",
+			req:  Request{Source: "spiffe://example.org/ns/frontend/sa/web", Destination: "spiffe://example.org/ns/payments/sa/api", Method: "GET", Path: "/admin"},
+		},
+		{
+			name:    "second policy allows any method and path",
+			req:     Request{Source: "spiffe://partner.org/billing", Destination: "spiffe://example.org/ns/payments/sa/api", Method: "DELETE", Path: "/admin"},
+			allowed: true,
+			policy:  "payments-partner",
+		},
+		{
+			name: "policy selects a different destination",
+			req:  Request{Source: "spiffe://partner.org/billing", Destination: "spiffe://example.org/ns/payments/sa/worker"},
+		},
+		{
+			name: "unauthenticated source",
+			req:  Request{Destination: "spiffe://example.org/ns/payments/sa/api"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision, err := e.Evaluate(tt.req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.allowed, decision.Allowed, decision.Reason)
+			assert.Equal(t, tt.policy, decision.Policy)
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package authz
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ExtAuthzServer implements the Envoy ext_authz gRPC API. Envoy sets the
+// source and destination principals to the URI SANs of the downstream peer's
+// certificate and of its own, which are the two workloads' SPIFFE IDs
+type ExtAuthzServer struct {
+	authv3.UnimplementedAuthorizationServer
+	evaluator *Evaluator
+}
+
+func NewExtAuthzServer(evaluator *Evaluator) *ExtAuthzServer {
+	return &ExtAuthzServer{evaluator: evaluator}
+}
+
+func (s *ExtAuthzServer) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	attrs := req.GetAttributes()
+	httpReq := attrs.GetRequest().GetHttp()
+	path, _, _ := strings.Cut(httpReq.GetPath(), "?")
+
+	decision, err := s.evaluator.Evaluate(Request{
+		Source:      attrs.GetSource().GetPrincipal(),
+		Destination: attrs.GetDestination().GetPrincipal(),
+		Method:      httpReq.GetMethod(),
+		Path:        path,
+	})
+	if err != nil {
+		slog.Error("problem evaluating policy", "error", err)
+		return nil, status.Error(codes.Unavailable, "problem evaluating policy")
+	}
+
+	if decision.Allowed {
+		return &authv3.CheckResponse{
+			Status:       &rpcstatus.Status{Code: int32(codes.OK)},
+			HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: &authv3.OkHttpResponse{}},
+		}, nil
+	}
+
+	slog.Info("⛔ Call denied",
+		"source", attrs.GetSource().GetPrincipal(),
+		"destination", attrs.GetDestination().GetPrincipal(),
+		"method", httpReq.GetMethod(),
+		"path", path,
+	)
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(codes.PermissionDenied), Message: decision.Reason},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{DeniedResponse: &authv3.DeniedHttpResponse{
+			Status: &typev3.HttpStatus{Code: typev3.StatusCode_Forbidden},
+			Headers: []*corev3.HeaderValueOption{{
+				Header: &corev3.HeaderValue{Key: "content-type", Value: "text/plain"},
+			}},
+			Body: decision.Reason + "\n",
+		}},
+	}, nil
+}
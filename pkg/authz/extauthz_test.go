@@ -0,0 +1,45 @@
+package authz
+
+import (
+	"context"
+	"testing"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func checkRequest(source, destination, method, path string) *authv3.CheckRequest {
+	return &authv3.CheckRequest{Attributes: &authv3.AttributeContext{
+		Source:      &authv3.AttributeContext_Peer{Principal: source},
+		Destination: &authv3.AttributeContext_Peer{Principal: destination},
+		Request: &authv3.AttributeContext_Request{
+			Http: &authv3.AttributeContext_HttpRequest{Method: method, Path: path},
+		},
+	}}
+}
+
+func TestExtAuthzCheck(t *testing.T) {
+	s := NewExtAuthzServer(mockEvaluator(t, &v1alpha1.SpiffeAuthorizationPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "api"},
+		Spec: v1alpha1.SpiffeAuthorizationPolicySpec{
+			Destination: "spiffe://example.org/api",
+			Sources:     []string{"spiffe://example.org/frontend"},
+			Paths:       []string{"/v1/**"},
+		},
+	}))
+
+	resp, err := s.Check(context.Background(), checkRequest("spiffe://example.org/frontend", "spiffe://example.org/api", "GET", "/v1/items?page=2"))
+	require.NoError(t, err)
+	assert.Equal(t, int32(codes.OK), resp.GetStatus().GetCode())
+	assert.NotNil(t, resp.GetOkResponse())
+
+	resp, err = s.Check(context.Background(), checkRequest("spiffe://example.org/intruder", "spiffe://example.org/api", "GET", "/v1/items"))
+	require.NoError(t, err)
+	assert.Equal(t, int32(codes.PermissionDenied), resp.GetStatus().GetCode())
+	assert.Equal(t, typev3.StatusCode_Forbidden, resp.GetDeniedResponse().GetStatus().GetCode())
+}
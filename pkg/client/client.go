@@ -0,0 +1,389 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/api"
+	"github.com/jsnctl/kubespiffe/pkg/federation"
+	"github.com/jsnctl/kubespiffe/pkg/tlsconfig"
+	"github.com/jsnctl/kubespiffe/pkg/tracing"
+)
+
+const (
+	DefaultURL           = "http://kubespiffed.kubespiffe.svc.cluster.local:8080"
+	DefaultTokenPath     = "/var/run/secrets/tokens/psat"
+	DefaultRetryInterval = 5 * time.Second
+	maxResponseBytes     = 1024 * 1024
+)
+
+// ErrRevoked is returned by WatchX509SVID when the workload's identity is
+// revoked or its registration removed
+var ErrRevoked = errors.New("SVID revoked")
+
+// X509SVID is a workload's X.509 identity, with the bundles of every trust
+// domain it trusts, including its own
+type X509SVID struct {
+	ID           string
+	Certificates []*x509.Certificate
+	PrivateKey   crypto.Signer
+	Bundles      map[string][]*x509.Certificate
+}
+
+// TLSCertificate returns the SVID for use in a tls.Config
+func (s *X509SVID) TLSCertificate() *tls.Certificate {
+	cert := &tls.Certificate{
+		PrivateKey: s.PrivateKey,
+		Leaf:       s.Certificates[0],
+	}
+	for _, c := range s.Certificates {
+		cert.Certificate = append(cert.Certificate, c.Raw)
+	}
+	return cert
+}
+
+// JWTSVID is a workload's JWT identity for a set of audiences
+type JWTSVID struct {
+	ID       string
+	Token    string
+	Audience []string
+	Expiry   time.Time
+}
+
+// Client obtains SVIDs from kubespiffed, authenticating with the pod's PSAT
+type Client struct {
+	url           string
+	tokenPath     string
+	http          *http.Client
+	retryInterval time.Duration
+}
+
+type Option func(*Client)
+
+func WithURL(url string) Option {
+	return func(c *Client) {
+		c.url = strings.TrimSuffix(url, "/")
+	}
+}
+
+func WithTokenPath(path string) Option {
+	return func(c *Client) {
+		c.tokenPath = path
+	}
+}
+
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.http = client
+	}
+}
+
+// WithRetryInterval sets how long sources wait after a failed fetch
+func WithRetryInterval(d time.Duration) Option {
+	return func(c *Client) {
+		c.retryInterval = d
+	}
+}
+
+func New(opts ...Option) *Client {
+	c := &Client{
+		url:           DefaultURL,
+		tokenPath:     DefaultTokenPath,
+		http:          &http.Client{Timeout: 10 * time.Second},
+		retryInterval: DefaultRetryInterval,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *Client) FetchX509SVID(ctx context.Context) (*X509SVID, error) {
+	var resp api.SVIDResponse
+	if err := c.get(ctx, "/v1/svid", nil, &resp); err != nil {
+		return nil, err
+	}
+	return parseX509SVID(resp)
+}
+
+// WatchX509SVID streams the workload's SVIDs from kubespiffed, calling update
+// with the first and with each renewed SVID or changed bundles. It returns
+// once ctx is done or the stream ends, with an error wrapping ErrRevoked if
+// the workload can no longer be issued an SVID
+func (c *Client) WatchX509SVID(ctx context.Context, update func(*X509SVID)) error {
+	resp, err := c.stream(ctx, "/v1/svid/stream")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var current *X509SVID
+	return readEvents(resp.Body, func(event string, data []byte) error {
+		switch event {
+		case api.EventSVID:
+			var svidResp api.SVIDResponse
+			if err := json.Unmarshal(data, &svidResp); err != nil {
+				return fmt.Errorf("decoding SVID: %w", err)
+			}
+			svid, err := parseX509SVID(svidResp)
+			if err != nil {
+				return err
+			}
+			current = svid
+		case api.EventBundles:
+			if current == nil {
+				return errors.New("bundles received before an SVID")
+			}
+			var bundlesResp api.BundlesUpdate
+			if err := json.Unmarshal(data, &bundlesResp); err != nil {
+				return fmt.Errorf("decoding bundles: %w", err)
+			}
+			bundles, err := parseBundles(current.ID, bundlesResp.Bundle, bundlesResp.Bundles)
+			if err != nil {
+				return err
+			}
+			updated := *current
+			updated.Bundles = bundles
+			current = &updated
+		case api.EventRevoked:
+			var revoked api.RevokedEvent
+			if err := json.Unmarshal(data, &revoked); err != nil {
+				return fmt.Errorf("decoding revoked event: %w", err)
+			}
+			return fmt.Errorf("%w: %s", ErrRevoked, revoked.Reason)
+		default:
+			return nil
+		}
+		update(current)
+		return nil
+	})
+}
+
+func parseX509SVID(resp api.SVIDResponse) (*X509SVID, error) {
+	certs, err := parseCertificates(resp.X509SVID)
+	if err != nil {
+		return nil, fmt.Errorf("parsing SVID: %w", err)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("SVID response has no certificate")
+	}
+	id, err := tlsconfig.SPIFFEIDFrom(certs[0])
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(resp.X509SVIDKey)
+	if block == nil {
+		return nil, errors.New("SVID response has no key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing SVID key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported SVID key %T", key)
+	}
+
+	bundles, err := parseBundles(id.String(), resp.Bundle, resp.Bundles)
+	if err != nil {
+		return nil, err
+	}
+
+	return &X509SVID{
+		ID:           id.String(),
+		Certificates: certs,
+		PrivateKey:   signer,
+		Bundles:      bundles,
+	}, nil
+}
+
+// parseBundles parses the PEM bundles of each trust domain the SVID with the
+// given ID trusts
+func parseBundles(id string, local []byte, all map[string][]byte) (map[string][]*x509.Certificate, error) {
+	u, err := url.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+
+	bundles := make(map[string][]*x509.Certificate)
+	for td, data := range all {
+		if bundles[td], err = parseCertificates(data); err != nil {
+			return nil, fmt.Errorf("parsing bundle for %s: %w", td, err)
+		}
+	}
+	// Older servers only return the local bundle
+	if _, ok := bundles[u.Host]; !ok {
+		if bundles[u.Host], err = parseCertificates(local); err != nil {
+			return nil, fmt.Errorf("parsing bundle: %w", err)
+		}
+	}
+	return bundles, nil
+}
+
+func (c *Client) FetchJWTSVID(ctx context.Context, audience ...string) (*JWTSVID, error) {
+	if len(audience) == 0 {
+		return nil, errors.New("at least one audience is required")
+	}
+
+	var resp api.JWTSVIDResponse
+	if err := c.get(ctx, "/v1/jwt-svid", url.Values{"audience": audience}, &resp); err != nil {
+		return nil, err
+	}
+	return &JWTSVID{
+		ID:       resp.SPIFFEID,
+		Token:    resp.Token,
+		Audience: audience,
+		Expiry:   resp.ExpiresAt,
+	}, nil
+}
+
+// FetchBundles returns the bundles of the workload's own and federated trust
+// domains, keyed by trust domain
+func (c *Client) FetchBundles(ctx context.Context) (map[string]*federation.Bundle, error) {
+	var resp api.BundlesResponse
+	if err := c.get(ctx, "/v1/bundles", nil, &resp); err != nil {
+		return nil, err
+	}
+
+	bundles := make(map[string]*federation.Bundle)
+	for td, data := range resp.Bundles {
+		b, err := federation.ParseBundle(td, data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing bundle for %s: %w", td, err)
+		}
+		bundles[td] = b
+	}
+	return bundles, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, query url.Values, out any) error {
+	req, err := c.request(ctx, path, query)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("requesting %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return fmt.Errorf("reading response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("kubespiffed responded %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
+
+// stream opens a long-lived response, which the client's timeout would cut off
+func (c *Client) stream(ctx context.Context, path string) (*http.Response, error) {
+	req, err := c.request(ctx, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	streaming := *c.http
+	streaming.Timeout = 0
+	resp, err := streaming.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting %s: %w", path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+		return nil, fmt.Errorf("kubespiffed responded %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+func (c *Client) request(ctx context.Context, path string, query url.Values) (*http.Request, error) {
+	token, err := os.ReadFile(c.tokenPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading PSAT: %w", err)
+	}
+
+	u := c.url + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	tracing.Inject(ctx, req.Header)
+	return req, nil
+}
+
+// readEvents calls handle with each server-sent event until the stream ends
+// or handle returns an error
+func readEvents(r io.Reader, handle func(event string, data []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxResponseBytes)
+
+	var (
+		event string
+		data  []byte
+	)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if event != "" || data != nil {
+				if err := handle(event, data); err != nil {
+					return err
+				}
+			}
+			event, data = "", nil
+		case strings.HasPrefix(line, ":"):
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if data != nil {
+				data = append(data, '\n')
+			}
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading stream: %w", err)
+	}
+	return io.ErrUnexpectedEOF
+}
+
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+}
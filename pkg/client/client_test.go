@@ -0,0 +1,272 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	"github.com/jsnctl/kubespiffe/pkg/k8s"
+	"github.com/jsnctl/kubespiffe/pkg/server"
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	"github.com/jsnctl/kubespiffe/pkg/tlsconfig"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// kubespiffed runs the workload API in-process, attesting pods by the
+// registration named after them, with PSATs signed by a local key
+type kubespiffed struct {
+	url    string
+	issuer *svid.SVIDIssuer
+	key    *rsa.PrivateKey
+}
+
+func mockKubespiffed(t *testing.T, registrations ...string) *kubespiffed {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	pub, err := jwk.New(&key.PublicKey)
+	require.NoError(t, err)
+	require.NoError(t, pub.Set(jwk.KeyIDKey, "psat"))
+	data, err := json.Marshal(pub)
+	require.NoError(t, err)
+	var jwkMap map[string]any
+	require.NoError(t, json.Unmarshal(data, &jwkMap))
+	jwks := &k8s.JWKS{Keys: []map[string]any{jwkMap}}
+
+	wrs := make(map[string]*v1alpha1.WorkloadRegistration)
+	for _, name := range registrations {
+		wrs[name] = &v1alpha1.WorkloadRegistration{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       v1alpha1.WorkloadRegistrationSpec{SPIFFEID: "spiffe://example.org/" + name, SVIDType: "X509"},
+		}
+	}
+
+	issuer, err := svid.NewSVIDIssuer()
+	require.NoError(t, err)
+	srv := server.New(nil, nil, issuer,
+		server.WithJWKS(func(context.Context) (*k8s.JWKS, error) { return jwks, nil }),
+		server.WithAttestor(func(ctx context.Context, c *k8s.KubernetesWorkloadClaims) (*v1alpha1.WorkloadRegistration, error) {
+			wr, ok := wrs[c.Pod.Name]
+			if !ok {
+				return nil, fmt.Errorf("no registration for %s", c.Pod.Name)
+			}
+			return wr, nil
+		}),
+	)
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	return &kubespiffed{url: ts.URL, issuer: issuer, key: key}
+}
+
+// client returns a Client for the named pod, with its PSAT written to disk
+func (k *kubespiffed) client(t *testing.T, pod string) *Client {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss": "https://kubernetes.default.svc.cluster.local",
+		"aud": []string{"kubespiffed"},
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"kubernetes.io": map[string]any{
+			"namespace":      "default",
+			"pod":            map[string]any{"name": pod, "uid": pod + "-uid"},
+			"serviceaccount": map[string]any{"name": "default"},
+		},
+	})
+	token.Header["kid"] = "psat"
+	signed, err := token.SignedString(k.key)
+	require.NoError(t, err)
+
+	tokenPath := filepath.Join(t.TempDir(), "psat")
+	require.NoError(t, os.WriteFile(tokenPath, []byte(signed), 0o600))
+	return New(WithURL(k.url), WithTokenPath(tokenPath), WithRetryInterval(10*time.Millisecond))
+}
+
+func TestFetchX509SVID(t *testing.T) {
+	k := mockKubespiffed(t, "workload")
+
+	x509SVID, err := k.client(t, "workload").FetchX509SVID(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "spiffe://example.org/workload", x509SVID.ID)
+	require.Len(t, x509SVID.Certificates, 1)
+	assert.Equal(t, x509SVID.PrivateKey.Public(), x509SVID.Certificates[0].PublicKey)
+	require.Contains(t, x509SVID.Bundles, "example.org")
+	assert.Equal(t, k.issuer.GetCACert(), x509SVID.Bundles["example.org"][0].Raw)
+
+	_, err = k.client(t, "unregistered").FetchX509SVID(context.Background())
+	assert.ErrorContains(t, err, "403")
+}
+
+func TestWatchX509SVIDMalformedRevoked(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: revoked\ndata: {\"reason\":\n\n")
+	}))
+	defer ts.Close()
+	tokenPath := filepath.Join(t.TempDir(), "psat")
+	require.NoError(t, os.WriteFile(tokenPath, []byte("token"), 0o600))
+
+	// A revoked event that can't be decoded is a broken stream, not a
+	// revocation
+	err := New(WithURL(ts.URL), WithTokenPath(tokenPath)).WatchX509SVID(context.Background(), func(*X509SVID) {})
+	assert.ErrorContains(t, err, "decoding revoked event")
+	assert.NotErrorIs(t, err, ErrRevoked)
+}
+
+func TestX509SourceBlocksUntilFetched(t *testing.T) {
+	k := mockKubespiffed(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := NewX509Source(ctx, k.client(t, "unregistered"))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestWatchX509SVID(t *testing.T) {
+	k := mockKubespiffed(t, "workload")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	source, err := NewX509Source(ctx, k.client(t, "workload"))
+	require.NoError(t, err)
+	defer source.Close()
+	first, err := source.GetX509SVID()
+	require.NoError(t, err)
+	current := func() *X509SVID {
+		svid, err := source.GetX509SVID()
+		require.NoError(t, err)
+		return svid
+	}
+
+	// The source picks up rotated bundles without fetching again
+	require.NoError(t, k.issuer.RotateCA())
+	assert.Eventually(t, func() bool {
+		bundle, err := source.GetX509BundleForTrustDomain("example.org")
+		return err == nil && len(bundle) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, first.Certificates, current().Certificates)
+
+	// Revoking the SVID delivers a replacement
+	_, err = k.issuer.Revoke(svid.RevocationRequest{Serial: first.Certificates[0].SerialNumber.Text(16)})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return current().Certificates[0].SerialNumber.Cmp(first.Certificates[0].SerialNumber) != 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Denying the identity ends the watch
+	updates := 0
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- k.client(t, "workload").WatchX509SVID(ctx, func(*X509SVID) { updates++ })
+	}()
+	assert.Eventually(t, func() bool { return len(k.issuer.Ledger().Query(svid.LedgerQuery{})) == 3 }, 5*time.Second, 10*time.Millisecond)
+	_, err = k.issuer.Revoke(svid.RevocationRequest{SPIFFEID: "spiffe://example.org/workload"})
+	require.NoError(t, err)
+	assert.ErrorIs(t, <-watchErr, ErrRevoked)
+	assert.Equal(t, 1, updates)
+
+	// The source stops serving the revoked SVID
+	assert.Eventually(t, func() bool {
+		_, err := source.GetX509SVID()
+		return errors.Is(err, ErrRevoked)
+	}, 5*time.Second, 10*time.Millisecond)
+	_, err = source.GetTLSCertificate()
+	assert.ErrorIs(t, err, ErrRevoked)
+	_, err = source.GetX509BundleForTrustDomain("example.org")
+	assert.ErrorIs(t, err, ErrRevoked)
+}
+
+func TestMTLS(t *testing.T) {
+	ctx := context.Background()
+	k := mockKubespiffed(t, "frontend", "backend", "intruder")
+
+	sources := map[string]*X509Source{}
+	for _, name := range []string{"frontend", "backend", "intruder"} {
+		source, err := NewX509Source(ctx, k.client(t, name))
+		require.NoError(t, err)
+		t.Cleanup(func() { source.Close() })
+		sources[name] = source
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	backend := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "hello "+r.TLS.PeerCertificates[0].URIs[0].String())
+		}),
+		TLSConfig: tlsconfig.MTLSServerConfig(sources["backend"], sources["backend"], tlsconfig.AuthorizeID("spiffe://example.org/frontend")),
+	}
+	go backend.ServeTLS(l, "", "")
+	t.Cleanup(func() { backend.Close() })
+
+	get := func(source string, authorize tlsconfig.Authorizer) (string, error) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: tlsconfig.MTLSClientConfig(sources[source], sources[source], authorize),
+		}}
+		resp, err := client.Get("https://" + l.Addr().String())
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	body, err := get("frontend", tlsconfig.AuthorizeID("spiffe://example.org/backend"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello spiffe://example.org/frontend", body)
+
+	_, err = get("intruder", tlsconfig.AuthorizeID("spiffe://example.org/backend"))
+	assert.Error(t, err, "backend only authorizes frontend")
+
+	_, err = get("frontend", tlsconfig.AuthorizeID("spiffe://example.org/database"))
+	assert.ErrorContains(t, err, "unexpected peer ID")
+}
+
+func TestJWTSource(t *testing.T) {
+	ctx := context.Background()
+	k := mockKubespiffed(t, "frontend", "backend")
+
+	frontend, err := NewJWTSource(ctx, k.client(t, "frontend"))
+	require.NoError(t, err)
+	defer frontend.Close()
+	backend, err := NewJWTSource(ctx, k.client(t, "backend"))
+	require.NoError(t, err)
+	defer backend.Close()
+
+	jwtSVID, err := frontend.FetchJWTSVID(ctx, "spiffe://example.org/backend")
+	require.NoError(t, err)
+	assert.Equal(t, "spiffe://example.org/frontend", jwtSVID.ID)
+	cached, err := frontend.FetchJWTSVID(ctx, "spiffe://example.org/backend")
+	require.NoError(t, err)
+	assert.Equal(t, jwtSVID.Token, cached.Token)
+
+	validated, err := backend.ValidateJWTSVID(jwtSVID.Token, "spiffe://example.org/backend")
+	require.NoError(t, err)
+	assert.Equal(t, "spiffe://example.org/frontend", validated.ID)
+
+	_, err = backend.ValidateJWTSVID(jwtSVID.Token, "spiffe://example.org/database")
+	assert.Error(t, err)
+
+	// Tokens signed by an authority outside the bundle are rejected
+	other, err := svid.NewSVIDIssuer()
+	require.NoError(t, err)
+	forged, _, err := other.IssueJWTSVID(&v1alpha1.WorkloadRegistration{
+		Spec: v1alpha1.WorkloadRegistrationSpec{SPIFFEID: "spiffe://example.org/frontend"},
+	}, svid.Workload{}, []string{"spiffe://example.org/backend"})
+	require.NoError(t, err)
+	_, err = backend.ValidateJWTSVID(forged, "spiffe://example.org/backend")
+	assert.ErrorContains(t, err, "no JWT authority")
+}
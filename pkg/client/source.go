@@ -0,0 +1,325 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jsnctl/kubespiffe/pkg/federation"
+)
+
+// X509Source holds the workload's current X509-SVID, kept up to date in the
+// background by streaming renewed SVIDs and bundles from kubespiffed. Once the
+// workload's identity is revoked, the source stops renewing and returns
+// ErrRevoked in place of the SVID
+type X509Source struct {
+	client *Client
+
+	mu        sync.RWMutex
+	svid      *X509SVID
+	err       error
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewX509Source blocks until the first SVID has been received, retrying until
+// ctx is done or the workload's identity is revoked
+func NewX509Source(ctx context.Context, client *Client) (*X509Source, error) {
+	renewCtx, cancel := context.WithCancel(context.Background())
+	s := &X509Source{
+		client: client,
+		ready:  make(chan struct{}),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go s.renew(renewCtx)
+
+	select {
+	case <-s.ready:
+		if _, err := s.GetX509SVID(); err != nil {
+			s.Close()
+			return nil, err
+		}
+		return s, nil
+	case <-ctx.Done():
+		s.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// GetX509SVID returns the current SVID, or an error wrapping ErrRevoked once
+// the workload's identity has been revoked
+func (s *X509Source) GetX509SVID() (*X509SVID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.svid, nil
+}
+
+// GetTLSCertificate returns the current SVID, so the source can be used with
+// pkg/tlsconfig
+func (s *X509Source) GetTLSCertificate() (*tls.Certificate, error) {
+	svid, err := s.GetX509SVID()
+	if err != nil {
+		return nil, err
+	}
+	return svid.TLSCertificate(), nil
+}
+
+// GetX509BundleForTrustDomain returns the X.509 authorities of a trust domain
+// the workload trusts
+func (s *X509Source) GetX509BundleForTrustDomain(trustDomain string) ([]*x509.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	bundle, ok := s.svid.Bundles[trustDomain]
+	if !ok {
+		return nil, fmt.Errorf("no bundle for trust domain %q", trustDomain)
+	}
+	return bundle, nil
+}
+
+// Close stops renewal
+func (s *X509Source) Close() error {
+	s.cancel()
+	<-s.done
+	return nil
+}
+
+// renew watches for new SVIDs, reconnecting after the retry interval whenever
+// the stream ends, until the workload's identity is revoked
+func (s *X509Source) renew(ctx context.Context) {
+	defer close(s.done)
+	for {
+		err := s.client.WatchX509SVID(ctx, func(svid *X509SVID) {
+			s.mu.Lock()
+			s.svid = svid
+			s.mu.Unlock()
+			s.readyOnce.Do(func() { close(s.ready) })
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, ErrRevoked) {
+			// Retrying would only be refused, and the revoked SVID mustn't be
+			// served meanwhile
+			slog.Error("SVID revoked", "error", err)
+			s.mu.Lock()
+			s.svid = nil
+			s.err = err
+			s.mu.Unlock()
+			s.readyOnce.Do(func() { close(s.ready) })
+			return
+		}
+		slog.Warn("problem watching SVID, retrying", "error", err, "retryInterval", s.client.retryInterval)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.client.retryInterval):
+		}
+	}
+}
+
+type cachedJWTSVID struct {
+	svid    *JWTSVID
+	renewAt time.Time
+}
+
+// JWTSource fetches JWT-SVIDs for the workload, caching each until half of its
+// lifetime has elapsed, and validates JWT-SVIDs presented to the workload
+// against the bundles of its own and federated trust domains
+type JWTSource struct {
+	client *Client
+
+	mu      sync.RWMutex
+	svids   map[string]cachedJWTSVID
+	bundles map[string]*federation.Bundle
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewJWTSource blocks until the bundles have been fetched, retrying until ctx
+// is done. The bundles are refreshed according to their refresh hint
+func NewJWTSource(ctx context.Context, client *Client) (*JWTSource, error) {
+	bundles, err := retry(ctx, client, client.FetchBundles)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshCtx, cancel := context.WithCancel(context.Background())
+	s := &JWTSource{
+		client:  client,
+		svids:   make(map[string]cachedJWTSVID),
+		bundles: bundles,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	go s.refresh(refreshCtx)
+	return s, nil
+}
+
+func (s *JWTSource) FetchJWTSVID(ctx context.Context, audience string, extra ...string) (*JWTSVID, error) {
+	aud := append([]string{audience}, extra...)
+	sort.Strings(aud)
+	key := strings.Join(aud, " ")
+
+	s.mu.RLock()
+	cached, ok := s.svids[key]
+	s.mu.RUnlock()
+	if ok && time.Now().Before(cached.renewAt) {
+		return cached.svid, nil
+	}
+
+	fetchedAt := time.Now()
+	svid, err := s.client.FetchJWTSVID(ctx, aud...)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, v := range s.svids {
+		if time.Now().After(v.svid.Expiry) {
+			delete(s.svids, k)
+		}
+	}
+	s.svids[key] = cachedJWTSVID{
+		svid:    svid,
+		renewAt: fetchedAt.Add(svid.Expiry.Sub(fetchedAt) / 2),
+	}
+	return svid, nil
+}
+
+// ValidateJWTSVID verifies a JWT-SVID's signature against the bundle of the
+// trust domain in its subject, and that it is unexpired and for audience
+func (s *JWTSource) ValidateJWTSVID(token, audience string) (*JWTSVID, error) {
+	claims := &jwt.RegisteredClaims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, s.keyFor,
+		jwt.WithValidMethods([]string{"ES256", "ES384", "ES512", "RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT-SVID: %w", err)
+	}
+	if !parsed.Valid {
+		return nil, fmt.Errorf("invalid JWT-SVID")
+	}
+
+	return &JWTSVID{
+		ID:       claims.Subject,
+		Token:    token,
+		Audience: claims.Audience,
+		Expiry:   claims.ExpiresAt.Time,
+	}, nil
+}
+
+func (s *JWTSource) keyFor(token *jwt.Token) (any, error) {
+	sub, err := token.Claims.GetSubject()
+	if err != nil {
+		return nil, err
+	}
+	trustDomain, ok := trustDomainOf(sub)
+	if !ok {
+		return nil, fmt.Errorf("subject %q is not a SPIFFE ID", sub)
+	}
+	kid, _ := token.Header["kid"].(string)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	bundle, ok := s.bundles[trustDomain]
+	if !ok {
+		return nil, fmt.Errorf("no bundle for trust domain %q", trustDomain)
+	}
+	for _, a := range bundle.JWTAuthorities {
+		if a.KeyID == kid {
+			return a.PublicKey, nil
+		}
+	}
+	return nil, fmt.Errorf("no JWT authority %q in trust domain %q", kid, trustDomain)
+}
+
+// Close stops refreshing bundles
+func (s *JWTSource) Close() error {
+	s.cancel()
+	<-s.done
+	return nil
+}
+
+func (s *JWTSource) refresh(ctx context.Context) {
+	defer close(s.done)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.refreshInterval()):
+		}
+
+		bundles, err := retry(ctx, s.client, s.client.FetchBundles)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.bundles = bundles
+		s.mu.Unlock()
+	}
+}
+
+// refreshInterval is the shortest refresh hint of any bundle
+func (s *JWTSource) refreshInterval() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	interval := federation.DefaultRefreshHint
+	for _, b := range s.bundles {
+		if b.RefreshHint > 0 && b.RefreshHint < interval {
+			interval = b.RefreshHint
+		}
+	}
+	return interval
+}
+
+// retry calls fetch until it succeeds or ctx is done
+func retry[T any](ctx context.Context, c *Client, fetch func(context.Context) (T, error)) (T, error) {
+	for {
+		v, err := fetch(ctx)
+		if err == nil {
+			return v, nil
+		}
+		slog.Error("problem fetching from kubespiffed", "error", err)
+
+		select {
+		case <-ctx.Done():
+			var zero T
+			return zero, ctx.Err()
+		case <-time.After(c.retryInterval):
+		}
+	}
+}
+
+func trustDomainOf(spiffeID string) (string, bool) {
+	rest, ok := strings.CutPrefix(spiffeID, "spiffe://")
+	if !ok {
+		return "", false
+	}
+	td, _, _ := strings.Cut(rest, "/")
+	return td, td != ""
+}
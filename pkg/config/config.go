@@ -0,0 +1,437 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/jsnctl/kubespiffe/pkg/federation"
+	"github.com/jsnctl/kubespiffe/pkg/ha"
+	"github.com/jsnctl/kubespiffe/pkg/helper"
+	"github.com/jsnctl/kubespiffe/pkg/k8s"
+	"github.com/jsnctl/kubespiffe/pkg/server"
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	"github.com/jsnctl/kubespiffe/pkg/tracing"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	APIVersion = "kubespiffe.io/v1alpha1"
+	Kind       = "KubespiffedConfig"
+
+	CASourceGenerated = "generated"
+	CASourceFile      = "file"
+	CASourceSecret    = "secret"
+)
+
+// Config is the kubespiffed configuration file. Listeners with an empty
+// address are disabled
+type Config struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+
+	TrustDomain string         `json:"trustDomain"`
+	Namespace   string         `json:"namespace"`
+	Kubernetes  Kubernetes     `json:"kubernetes"`
+	Listen      Listen         `json:"listen"`
+	SVID        SVID           `json:"svid"`
+	CA          CA             `json:"ca"`
+	PSAT        PSAT           `json:"psat"`
+	Revocation  Revocation     `json:"revocation"`
+	Audit       Audit          `json:"audit"`
+	Bundle      BundleEndpoint `json:"bundleEndpoint"`
+	Webhook     Webhook        `json:"webhook"`
+	Log         Log            `json:"log"`
+	Tracing     Tracing        `json:"tracing"`
+	HA          HA             `json:"ha"`
+	RateLimit   RateLimit      `json:"rateLimit"`
+	Features    Features       `json:"features"`
+
+	// AgentServiceAccounts are the namespace/name service accounts node agents
+	// run as
+	AgentServiceAccounts []string `json:"agentServiceAccounts"`
+}
+
+// Kubernetes selects a kubeconfig, and optionally one of its contexts, to run
+// outside the cluster. Left empty, the in-cluster service account is used
+type Kubernetes struct {
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	Context    string `json:"context,omitempty"`
+}
+
+type Listen struct {
+	Workload       string `json:"workload"`
+	Admin          string `json:"admin"`
+	BundleEndpoint string `json:"bundleEndpoint"`
+	Webhook        string `json:"webhook"`
+	SDS            string `json:"sds"`
+	ExtAuthz       string `json:"extAuthz"`
+}
+
+type SVID struct {
+	X509TTL metav1.Duration `json:"x509TTL"`
+	JWTTTL  metav1.Duration `json:"jwtTTL"`
+}
+
+// CA is either generated by kubespiffed, read from CertFile and KeyFile, or
+// kept in the Secret SecretName, where kubespiffed generates it and every
+// replica shares it
+type CA struct {
+	Source     string          `json:"source"`
+	TTL        metav1.Duration `json:"ttl"`
+	CertFile   string          `json:"certFile,omitempty"`
+	KeyFile    string          `json:"keyFile,omitempty"`
+	SecretName string          `json:"secretName,omitempty"`
+}
+
+type PSAT struct {
+	Audience      string `json:"audience"`
+	Issuer        string `json:"issuer"`
+	JWKSURL       string `json:"jwksURL"`
+	JWKSTokenPath string `json:"jwksTokenPath"`
+	JWKSCAPath    string `json:"jwksCAPath"`
+}
+
+type Revocation struct {
+	CRLURL  string `json:"crlURL,omitempty"`
+	OCSPURL string `json:"ocspURL,omitempty"`
+}
+
+// Audit keeps issuance records in memory for Retention after their SVIDs
+// expire, and appends every record to LogPath when set
+type Audit struct {
+	LogPath   string          `json:"logPath,omitempty"`
+	Retention metav1.Duration `json:"retention"`
+}
+
+type BundleEndpoint struct {
+	Profile  string `json:"profile"`
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+}
+
+type Webhook struct {
+	CertFile       string `json:"certFile,omitempty"`
+	KeyFile        string `json:"keyFile,omitempty"`
+	HelperImage    string `json:"helperImage"`
+	KubespiffedURL string `json:"kubespiffedURL"`
+}
+
+type Log struct {
+	Level  string `json:"level"`
+	Format string `json:"format"`
+}
+
+// Tracing exports spans to an OTLP gRPC collector. Tracing is off without
+// an endpoint
+type Tracing struct {
+	OTLPEndpoint string  `json:"otlpEndpoint,omitempty"`
+	Insecure     bool    `json:"insecure,omitempty"`
+	SampleRatio  float64 `json:"sampleRatio"`
+}
+
+// HA runs several replicas, which elect a leader through the Lease LeaseName
+// to run CA rotation, CRL signing, federation polling and status writes.
+// Identity defaults to the hostname, which is the pod name. SPIFFE IDs and
+// pods revoked through the admin API stay denied on every replica for
+// DenialTTL
+type HA struct {
+	Enabled              bool            `json:"enabled"`
+	LeaseName            string          `json:"leaseName"`
+	Identity             string          `json:"identity,omitempty"`
+	LeaseDuration        metav1.Duration `json:"leaseDuration"`
+	RenewDeadline        metav1.Duration `json:"renewDeadline"`
+	RetryPeriod          metav1.Duration `json:"retryPeriod"`
+	RevocationsConfigMap string          `json:"revocationsConfigMap"`
+	DenialTTL            metav1.Duration `json:"denialTTL"`
+}
+
+// RateLimit caps SVID issuance per pod, service account and node with token
+// buckets, and the number of SVIDs signed at once. A zero perSecond or
+// maxConcurrentSigning turns that limit off
+type RateLimit struct {
+	PerPod               Rate `json:"perPod"`
+	PerServiceAccount    Rate `json:"perServiceAccount"`
+	PerNode              Rate `json:"perNode"`
+	MaxConcurrentSigning int  `json:"maxConcurrentSigning"`
+}
+
+type Rate struct {
+	PerSecond float64 `json:"perSecond"`
+	Burst     int     `json:"burst"`
+}
+
+// Features turns off controllers and endpoints that don't have a listener
+// of their own, and Kubernetes Events
+type Features struct {
+	Federation          bool `json:"federation"`
+	Revocation          bool `json:"revocation"`
+	Lifecycle           bool `json:"lifecycle"`
+	AuthorizationPolicy bool `json:"authorizationPolicy"`
+	Events              bool `json:"events"`
+}
+
+func Default() *Config {
+	return &Config{
+		APIVersion:  APIVersion,
+		Kind:        Kind,
+		TrustDomain: "example.org",
+		Namespace:   "kubespiffe",
+		Listen: Listen{
+			Workload: ":8080",
+			Admin:    ":8081",
+		},
+		SVID: SVID{
+			X509TTL: metav1.Duration{Duration: svid.DefaultX509SVIDTTL},
+			JWTTTL:  metav1.Duration{Duration: svid.DefaultJWTSVIDTTL},
+		},
+		CA: CA{
+			Source:     CASourceGenerated,
+			TTL:        metav1.Duration{Duration: svid.DefaultCATTL},
+			SecretName: ha.DefaultCASecretName,
+		},
+		PSAT: PSAT{
+			Audience:      k8s.DefaultPSATValidation.Audience,
+			Issuer:        k8s.DefaultPSATValidation.Issuer,
+			JWKSURL:       k8s.DefaultJWKSSource.URL,
+			JWKSTokenPath: k8s.DefaultJWKSSource.TokenPath,
+			JWKSCAPath:    k8s.DefaultJWKSSource.CAPath,
+		},
+		Audit: Audit{
+			Retention: metav1.Duration{Duration: svid.DefaultLedgerRetention},
+		},
+		Bundle: BundleEndpoint{
+			Profile: federation.ProfileHTTPSSPIFFE,
+		},
+		Webhook: Webhook{
+			HelperImage:    "kubespiffed:latest",
+			KubespiffedURL: helper.DefaultURL,
+		},
+		Log: Log{
+			Level:  "info",
+			Format: "text",
+		},
+		Tracing: Tracing{
+			SampleRatio: 1,
+		},
+		HA: HA{
+			LeaseName:            ha.DefaultLeaseName,
+			LeaseDuration:        metav1.Duration{Duration: ha.DefaultLeaseDuration},
+			RenewDeadline:        metav1.Duration{Duration: ha.DefaultRenewDeadline},
+			RetryPeriod:          metav1.Duration{Duration: ha.DefaultRetryPeriod},
+			RevocationsConfigMap: ha.DefaultRevocationsName,
+			DenialTTL:            metav1.Duration{Duration: ha.DefaultDenialTTL},
+		},
+		RateLimit: RateLimit{
+			PerPod:               Rate(server.DefaultRateLimits.PerPod),
+			PerServiceAccount:    Rate(server.DefaultRateLimits.PerServiceAccount),
+			PerNode:              Rate(server.DefaultRateLimits.PerNode),
+			MaxConcurrentSigning: server.DefaultRateLimits.MaxConcurrentSigning,
+		},
+		Features: Features{
+			Federation:          true,
+			Revocation:          true,
+			Lifecycle:           true,
+			AuthorizationPolicy: true,
+			Events:              true,
+		},
+		AgentServiceAccounts: []string{"kubespiffe/kubespiffe-agent"},
+	}
+}
+
+// Load reads the configuration file at path over the defaults, rejecting
+// unknown fields. An empty path leaves the defaults
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("problem reading config: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("problem parsing config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (c *Config) Marshal() ([]byte, error) {
+	return yaml.Marshal(c)
+}
+
+// OutOfCluster is whether the API server is reached through a kubeconfig, in
+// which case PSAT signing keys are found with OIDC discovery rather than
+// psat.jwksURL
+func (c *Config) OutOfCluster() bool {
+	return c.Kubernetes.Kubeconfig != "" || c.Kubernetes.Context != ""
+}
+
+// JWKSSource is where PSAT signing keys are fetched from
+func (c *Config) JWKSSource() k8s.JWKSSource {
+	return k8s.JWKSSource{URL: c.PSAT.JWKSURL, TokenPath: c.PSAT.JWKSTokenPath, CAPath: c.PSAT.JWKSCAPath}
+}
+
+// PSATValidation is what PSATs are required to contain
+func (c *Config) PSATValidation() k8s.PSATValidation {
+	return k8s.PSATValidation{Audience: c.PSAT.Audience, Issuer: c.PSAT.Issuer}
+}
+
+// TracingConfig is where and how often spans are exported
+func (c *Config) TracingConfig() tracing.Config {
+	return tracing.Config{Endpoint: c.Tracing.OTLPEndpoint, Insecure: c.Tracing.Insecure, SampleRatio: c.Tracing.SampleRatio}
+}
+
+// Election is the Lease replicas compete for when HA is enabled
+func (c *Config) Election() ha.Config {
+	identity := c.HA.Identity
+	if identity == "" {
+		identity, _ = os.Hostname()
+	}
+	return ha.Config{
+		Namespace:     c.Namespace,
+		LeaseName:     c.HA.LeaseName,
+		Identity:      identity,
+		LeaseDuration: c.HA.LeaseDuration.Duration,
+		RenewDeadline: c.HA.RenewDeadline.Duration,
+		RetryPeriod:   c.HA.RetryPeriod.Duration,
+	}
+}
+
+// RateLimits are the limits SVID issuance is held to
+func (c *Config) RateLimits() server.RateLimits {
+	return server.RateLimits{
+		PerPod:               server.Rate(c.RateLimit.PerPod),
+		PerServiceAccount:    server.Rate(c.RateLimit.PerServiceAccount),
+		PerNode:              server.Rate(c.RateLimit.PerNode),
+		MaxConcurrentSigning: c.RateLimit.MaxConcurrentSigning,
+	}
+}
+
+// Validate reports every problem with the configuration at once
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.APIVersion == APIVersion, "apiVersion must be %q, not %q", APIVersion, c.APIVersion)
+	check(c.Kind == Kind, "kind must be %q, not %q", Kind, c.Kind)
+	check(validTrustDomain(c.TrustDomain), "trustDomain %q is not a valid trust domain", c.TrustDomain)
+	check(c.Namespace != "", "namespace is required")
+
+	check(c.Listen.Workload != "", "listen.workload is required")
+	addrs := map[string]string{}
+	for name, addr := range map[string]string{
+		"workload":       c.Listen.Workload,
+		"admin":          c.Listen.Admin,
+		"bundleEndpoint": c.Listen.BundleEndpoint,
+		"webhook":        c.Listen.Webhook,
+		"sds":            c.Listen.SDS,
+		"extAuthz":       c.Listen.ExtAuthz,
+	} {
+		if addr == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			errs = append(errs, fmt.Errorf("listen.%s: %w", name, err))
+			continue
+		}
+		if other, ok := addrs[addr]; ok {
+			errs = append(errs, fmt.Errorf("listen.%s and listen.%s both use %s", min(name, other), max(name, other), addr))
+		}
+		addrs[addr] = name
+	}
+
+	check(c.SVID.X509TTL.Duration > 0, "svid.x509TTL must be positive")
+	check(c.SVID.JWTTTL.Duration > 0, "svid.jwtTTL must be positive")
+	switch c.CA.Source {
+	case CASourceGenerated:
+		check(c.CA.TTL.Duration > 0, "ca.ttl must be positive")
+		check(c.CA.TTL.Duration > c.SVID.X509TTL.Duration, "ca.ttl must be longer than svid.x509TTL")
+	case CASourceFile:
+		check(c.CA.CertFile != "" && c.CA.KeyFile != "", "ca.certFile and ca.keyFile are required when ca.source is %q", CASourceFile)
+	case CASourceSecret:
+		check(c.CA.TTL.Duration > 0, "ca.ttl must be positive")
+		check(c.CA.TTL.Duration > c.SVID.X509TTL.Duration, "ca.ttl must be longer than svid.x509TTL")
+		check(c.CA.SecretName != "", "ca.secretName is required when ca.source is %q", CASourceSecret)
+	default:
+		errs = append(errs, fmt.Errorf("ca.source must be %q, %q or %q, not %q", CASourceGenerated, CASourceFile, CASourceSecret, c.CA.Source))
+	}
+
+	if c.HA.Enabled {
+		// Otherwise each replica would sign with a JWT key, and possibly a CA,
+		// of its own
+		check(c.CA.Source == CASourceSecret, "ha.enabled requires ca.source %q", CASourceSecret)
+		check(c.HA.LeaseName != "", "ha.leaseName is required")
+		check(c.HA.RevocationsConfigMap != "", "ha.revocationsConfigMap is required")
+		check(c.HA.DenialTTL.Duration > 0, "ha.denialTTL must be positive")
+		check(c.HA.RetryPeriod.Duration > 0, "ha.retryPeriod must be positive")
+		check(c.HA.RenewDeadline.Duration > c.HA.RetryPeriod.Duration, "ha.renewDeadline must be longer than ha.retryPeriod")
+		check(c.HA.LeaseDuration.Duration > c.HA.RenewDeadline.Duration, "ha.leaseDuration must be longer than ha.renewDeadline")
+	}
+
+	check(c.PSAT.Audience != "", "psat.audience is required")
+	check(c.PSAT.Issuer != "", "psat.issuer is required")
+	check(validURL(c.PSAT.JWKSURL), "psat.jwksURL %q is not an http(s) URL", c.PSAT.JWKSURL)
+	check(c.Revocation.CRLURL == "" || validURL(c.Revocation.CRLURL), "revocation.crlURL %q is not an http(s) URL", c.Revocation.CRLURL)
+	check(c.Revocation.OCSPURL == "" || validURL(c.Revocation.OCSPURL), "revocation.ocspURL %q is not an http(s) URL", c.Revocation.OCSPURL)
+	check(c.Audit.Retention.Duration > 0, "audit.retention must be positive")
+
+	switch c.Bundle.Profile {
+	case federation.ProfileHTTPSSPIFFE:
+	case federation.ProfileHTTPSWeb:
+		check(c.Listen.BundleEndpoint == "" || (c.Bundle.CertFile != "" && c.Bundle.KeyFile != ""),
+			"bundleEndpoint.certFile and bundleEndpoint.keyFile are required with the %q profile", federation.ProfileHTTPSWeb)
+	default:
+		errs = append(errs, fmt.Errorf("bundleEndpoint.profile must be %q or %q, not %q", federation.ProfileHTTPSSPIFFE, federation.ProfileHTTPSWeb, c.Bundle.Profile))
+	}
+	check(c.Listen.Webhook == "" || (c.Webhook.CertFile != "" && c.Webhook.KeyFile != ""),
+		"webhook.certFile and webhook.keyFile are required when listen.webhook is set")
+	check(c.Listen.Webhook == "" || c.Webhook.HelperImage != "", "webhook.helperImage is required when listen.webhook is set")
+	check(c.Listen.ExtAuthz == "" || c.Features.AuthorizationPolicy, "listen.extAuthz requires features.authorizationPolicy")
+
+	check(slices.Contains([]string{"debug", "info", "warn", "error"}, c.Log.Level), "log.level must be debug, info, warn or error, not %q", c.Log.Level)
+	check(slices.Contains([]string{"text", "json"}, c.Log.Format), "log.format must be text or json, not %q", c.Log.Format)
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sampleRatio must be between 0 and 1, not %v", c.Tracing.SampleRatio)
+
+	for _, r := range []struct {
+		name string
+		Rate
+	}{
+		{"perPod", c.RateLimit.PerPod},
+		{"perServiceAccount", c.RateLimit.PerServiceAccount},
+		{"perNode", c.RateLimit.PerNode},
+	} {
+		check(r.PerSecond >= 0, "rateLimit.%s.perSecond must not be negative", r.name)
+		check(r.PerSecond == 0 || r.Burst > 0, "rateLimit.%s.burst must be positive", r.name)
+	}
+	check(c.RateLimit.MaxConcurrentSigning >= 0, "rateLimit.maxConcurrentSigning must not be negative")
+
+	for _, sa := range c.AgentServiceAccounts {
+		ns, name, ok := strings.Cut(sa, "/")
+		check(ok && ns != "" && name != "" && !strings.Contains(name, "/"), "agentServiceAccounts entry %q must be namespace/name", sa)
+	}
+
+	return errors.Join(errs...)
+}
+
+func validTrustDomain(td string) bool {
+	if td == "" || td != strings.ToLower(td) {
+		return false
+	}
+	u, err := url.Parse("spiffe://" + td)
+	return err == nil && u.Host == td && u.Port() == ""
+}
+
+func validURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
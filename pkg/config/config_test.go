@@ -0,0 +1,234 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("defaults are valid", func(t *testing.T) {
+		cfg, err := Load("")
+		require.NoError(t, err)
+		assert.NoError(t, cfg.Validate())
+		assert.False(t, cfg.OutOfCluster())
+	})
+
+	t.Run("file overrides defaults", func(t *testing.T) {
+		cfg, err := Load(writeConfig(t, `
+apiVersion: kubespiffe.io/v1alpha1
+kind: KubespiffedConfig
+trustDomain: test.domain
+listen:
+  sds: ":8090"
+svid:
+  x509TTL: 1h
+features:
+  federation: false
+`))
+		require.NoError(t, err)
+		require.NoError(t, cfg.Validate())
+		assert.Equal(t, "test.domain", cfg.TrustDomain)
+		assert.Equal(t, ":8090", cfg.Listen.SDS)
+		assert.Equal(t, ":8080", cfg.Listen.Workload)
+		assert.Equal(t, time.Hour, cfg.SVID.X509TTL.Duration)
+		assert.False(t, cfg.Features.Federation)
+		assert.True(t, cfg.Features.Revocation)
+	})
+
+	t.Run("unknown fields are rejected", func(t *testing.T) {
+		_, err := Load(writeConfig(t, "trustDomian: test.domain\n"))
+		assert.ErrorContains(t, err, "trustDomian")
+	})
+
+	t.Run("round trips", func(t *testing.T) {
+		out, err := Default().Marshal()
+		require.NoError(t, err)
+		cfg, err := Load(writeConfig(t, string(out)))
+		require.NoError(t, err)
+		assert.Equal(t, Default(), cfg)
+	})
+}
+
+func TestApply(t *testing.T) {
+	cfg, err := Load(writeConfig(t, `
+trustDomain: file.domain
+log:
+  level: debug
+svid:
+  jwtTTL: 10m
+`))
+	require.NoError(t, err)
+
+	fs := flag.NewFlagSet("kubespiffed", flag.ContinueOnError)
+	overrides := RegisterFlags(fs)
+	require.NoError(t, fs.Parse([]string{"-trust-domain", "flag.domain", "-feature-lifecycle=false", "-otlp-insecure"}))
+	env := map[string]string{
+		"TRUST_DOMAIN":                "env.domain",
+		"LOG_LEVEL":                   "warn",
+		"AGENT_SERVICE_ACCOUNTS":      "kube-system/agent, kubespiffe/agent",
+		"KUBE_CONTEXT":                "kind-kubespiffe",
+		"OTEL_EXPORTER_OTLP_ENDPOINT": "http://otel-collector:4317",
+		"TRACING_SAMPLE_RATIO":        "0.25",
+		"POD_NAMESPACE":               "identity",
+		"POD_NAME":                    "kubespiffed-6c8d7-x2k4p",
+		"RATE_LIMIT_PER_POD":          "0.5",
+		"MAX_CONCURRENT_SIGNING":      "4",
+	}
+	require.NoError(t, overrides.Apply(cfg, func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}))
+
+	assert.Equal(t, "flag.domain", cfg.TrustDomain)
+	assert.Equal(t, "warn", cfg.Log.Level)
+	assert.Equal(t, 10*time.Minute, cfg.SVID.JWTTTL.Duration)
+	assert.False(t, cfg.Features.Lifecycle)
+	assert.True(t, cfg.Tracing.Insecure)
+	assert.Equal(t, []string{"kube-system/agent", "kubespiffe/agent"}, cfg.AgentServiceAccounts)
+	assert.True(t, cfg.OutOfCluster())
+	assert.Equal(t, "http://otel-collector:4317", cfg.TracingConfig().Endpoint)
+	assert.Equal(t, 0.25, cfg.TracingConfig().SampleRatio)
+	assert.Equal(t, "identity", cfg.Election().Namespace)
+	assert.Equal(t, "kubespiffed-6c8d7-x2k4p", cfg.Election().Identity)
+	assert.Equal(t, server.Rate{PerSecond: 0.5, Burst: 10}, cfg.RateLimits().PerPod)
+	assert.Equal(t, 4, cfg.RateLimits().MaxConcurrentSigning)
+
+	env = map[string]string{"CA_TTL": "forever", "FEATURE_FEDERATION": "maybe", "TRACING_SAMPLE_RATIO": "half", "MAX_CONCURRENT_SIGNING": "many"}
+	err = RegisterFlags(flag.NewFlagSet("kubespiffed", flag.ContinueOnError)).Apply(cfg, func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	})
+	assert.ErrorContains(t, err, "CA_TTL")
+	assert.ErrorContains(t, err, "FEATURE_FEDERATION")
+	assert.ErrorContains(t, err, "TRACING_SAMPLE_RATIO")
+	assert.ErrorContains(t, err, "MAX_CONCURRENT_SIGNING")
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		errs   []string
+	}{
+		{
+			name:   "wrong kind",
+			modify: func(c *Config) { c.Kind = "Config" },
+			errs:   []string{"kind must be"},
+		},
+		{
+			name:   "invalid trust domain",
+			modify: func(c *Config) { c.TrustDomain = "Example.org:443" },
+			errs:   []string{"trustDomain"},
+		},
+		{
+			name: "conflicting listeners",
+			modify: func(c *Config) {
+				c.Listen.SDS = ":8080"
+				c.Listen.ExtAuthz = "9191"
+			},
+			errs: []string{"listen.sds and listen.workload both use :8080", "listen.extAuthz"},
+		},
+		{
+			name: "CA shorter lived than SVIDs",
+			modify: func(c *Config) {
+				c.CA.TTL.Duration = time.Minute
+			},
+			errs: []string{"ca.ttl must be longer than svid.x509TTL"},
+		},
+		{
+			name:   "CA file without key",
+			modify: func(c *Config) { c.CA = CA{Source: CASourceFile, CertFile: "ca.crt"} },
+			errs:   []string{"ca.keyFile are required"},
+		},
+		{
+			name: "HA with a generated CA",
+			modify: func(c *Config) {
+				c.HA.Enabled = true
+			},
+			errs: []string{`ha.enabled requires ca.source "secret"`},
+		},
+		{
+			name: "HA renew deadline outlasting the lease",
+			modify: func(c *Config) {
+				c.CA.Source = CASourceSecret
+				c.HA.Enabled = true
+				c.HA.RenewDeadline.Duration = time.Minute
+			},
+			errs: []string{"ha.leaseDuration must be longer than ha.renewDeadline"},
+		},
+		{
+			name: "HA denials that never lapse",
+			modify: func(c *Config) {
+				c.CA.Source = CASourceSecret
+				c.HA.Enabled = true
+				c.HA.DenialTTL.Duration = 0
+			},
+			errs: []string{"ha.denialTTL must be positive"},
+		},
+		{
+			name:   "audit records dropped as soon as they expire",
+			modify: func(c *Config) { c.Audit.Retention.Duration = 0 },
+			errs:   []string{"audit.retention must be positive"},
+		},
+		{
+			name:   "webhook without certificate",
+			modify: func(c *Config) { c.Listen.Webhook = ":9443" },
+			errs:   []string{"webhook.certFile"},
+		},
+		{
+			name: "ext_authz without authorization policy",
+			modify: func(c *Config) {
+				c.Listen.ExtAuthz = ":9191"
+				c.Features.AuthorizationPolicy = false
+			},
+			errs: []string{"features.authorizationPolicy"},
+		},
+		{
+			name:   "sample ratio out of range",
+			modify: func(c *Config) { c.Tracing.SampleRatio = 2 },
+			errs:   []string{"tracing.sampleRatio"},
+		},
+		{
+			name: "rate limit without burst",
+			modify: func(c *Config) {
+				c.RateLimit.PerNode.Burst = 0
+				c.RateLimit.MaxConcurrentSigning = -1
+			},
+			errs: []string{"rateLimit.perNode.burst", "rateLimit.maxConcurrentSigning"},
+		},
+		{
+			name: "every problem is reported",
+			modify: func(c *Config) {
+				c.PSAT.JWKSURL = "kubernetes.default.svc"
+				c.Log.Format = "xml"
+				c.AgentServiceAccounts = []string{"kubespiffe-agent"}
+			},
+			errs: []string{"psat.jwksURL", "log.format", "agentServiceAccounts"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.modify(cfg)
+			err := cfg.Validate()
+			require.Error(t, err)
+			for _, e := range tt.errs {
+				assert.ErrorContains(t, err, e)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// override sets a single field from a flag or an environment variable
+type override struct {
+	flag  string
+	env   string
+	usage string
+	set   setter
+}
+
+// setter parses a flag or environment variable into a field
+type setter interface {
+	apply(c *Config, v string) error
+}
+
+type setFunc func(c *Config, v string) error
+
+func (f setFunc) apply(c *Config, v string) error { return f(c, v) }
+
+// boolFunc sets a boolean field, whose flag may be given bare
+type boolFunc func(c *Config, v string) error
+
+func (f boolFunc) apply(c *Config, v string) error { return f(c, v) }
+
+func str(field func(*Config) *string) setFunc {
+	return func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}
+}
+
+func duration(field func(*Config) *metav1.Duration) setFunc {
+	return func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = metav1.Duration{Duration: d}
+		return nil
+	}
+}
+
+func boolean(field func(*Config) *bool) boolFunc {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func integer(field func(*Config) *int) setFunc {
+	return func(c *Config, v string) error {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = i
+		return nil
+	}
+}
+
+func float(field func(*Config) *float64) setFunc {
+	return func(c *Config, v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return err
+		}
+		*field(c) = f
+		return nil
+	}
+}
+
+// list sets a comma separated list
+func list(field func(*Config) *[]string) setFunc {
+	return func(c *Config, v string) error {
+		var out []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				out = append(out, item)
+			}
+		}
+		*field(c) = out
+		return nil
+	}
+}
+
+var overrides = []override{
+	{"trust-domain", "TRUST_DOMAIN", "trust domain of issued SVIDs", str(func(c *Config) *string { return &c.TrustDomain })},
+	{"namespace", "POD_NAMESPACE", "namespace kubespiffed runs in", str(func(c *Config) *string { return &c.Namespace })},
+
+	{"kubeconfig", "KUBECONFIG", "kubeconfig to run outside the cluster with", str(func(c *Config) *string { return &c.Kubernetes.Kubeconfig })},
+	{"context", "KUBE_CONTEXT", "kubeconfig context to run outside the cluster with", str(func(c *Config) *string { return &c.Kubernetes.Context })},
+
+	{"workload-addr", "WORKLOAD_ADDR", "address of the workload API", str(func(c *Config) *string { return &c.Listen.Workload })},
+	{"admin-addr", "ADMIN_ADDR", "address of the admin API, empty to disable", str(func(c *Config) *string { return &c.Listen.Admin })},
+	{"bundle-endpoint-addr", "BUNDLE_ENDPOINT_ADDR", "address of the SPIFFE bundle endpoint, empty to disable", str(func(c *Config) *string { return &c.Listen.BundleEndpoint })},
+	{"webhook-addr", "WEBHOOK_ADDR", "address of the injection webhook, empty to disable", str(func(c *Config) *string { return &c.Listen.Webhook })},
+	{"sds-addr", "SDS_ADDR", "address of the Envoy SDS API, empty to disable", str(func(c *Config) *string { return &c.Listen.SDS })},
+	{"ext-authz-addr", "EXT_AUTHZ_ADDR", "address of the Envoy ext_authz API, empty to disable", str(func(c *Config) *string { return &c.Listen.ExtAuthz })},
+
+	{"x509-svid-ttl", "X509_SVID_TTL", "lifetime of X509-SVIDs", duration(func(c *Config) *metav1.Duration { return &c.SVID.X509TTL })},
+	{"jwt-svid-ttl", "JWT_SVID_TTL", "lifetime of JWT-SVIDs", duration(func(c *Config) *metav1.Duration { return &c.SVID.JWTTTL })},
+
+	{"ca-source", "CA_SOURCE", "generated, file or secret", str(func(c *Config) *string { return &c.CA.Source })},
+	{"ca-ttl", "CA_TTL", "lifetime of generated CAs", duration(func(c *Config) *metav1.Duration { return &c.CA.TTL })},
+	{"ca-cert-file", "CA_CERT_FILE", "PEM CA certificate when the CA source is file", str(func(c *Config) *string { return &c.CA.CertFile })},
+	{"ca-key-file", "CA_KEY_FILE", "PEM CA key when the CA source is file", str(func(c *Config) *string { return &c.CA.KeyFile })},
+	{"ca-secret-name", "CA_SECRET_NAME", "Secret holding the CA when the CA source is secret", str(func(c *Config) *string { return &c.CA.SecretName })},
+
+	{"psat-audience", "PSAT_AUDIENCE", "audience PSATs must be issued for", str(func(c *Config) *string { return &c.PSAT.Audience })},
+	{"psat-issuer", "PSAT_ISSUER", "issuer PSATs must be issued by", str(func(c *Config) *string { return &c.PSAT.Issuer })},
+	{"jwks-url", "JWKS_URL", "URL of the service account signing keys", str(func(c *Config) *string { return &c.PSAT.JWKSURL })},
+
+	{"crl-url", "CRL_URL", "CRL distribution point embedded in SVIDs", str(func(c *Config) *string { return &c.Revocation.CRLURL })},
+	{"ocsp-url", "OCSP_URL", "OCSP responder embedded in SVIDs", str(func(c *Config) *string { return &c.Revocation.OCSPURL })},
+	{"audit-log-path", "AUDIT_LOG_PATH", "file issuance records are appended to", str(func(c *Config) *string { return &c.Audit.LogPath })},
+	{"audit-retention", "AUDIT_RETENTION", "how long issuance records are kept in memory after expiry", duration(func(c *Config) *metav1.Duration { return &c.Audit.Retention })},
+
+	{"bundle-endpoint-profile", "BUNDLE_ENDPOINT_PROFILE", "https_spiffe or https_web", str(func(c *Config) *string { return &c.Bundle.Profile })},
+	{"bundle-endpoint-cert-file", "BUNDLE_ENDPOINT_CERT_FILE", "certificate of an https_web bundle endpoint", str(func(c *Config) *string { return &c.Bundle.CertFile })},
+	{"bundle-endpoint-key-file", "BUNDLE_ENDPOINT_KEY_FILE", "key of an https_web bundle endpoint", str(func(c *Config) *string { return &c.Bundle.KeyFile })},
+
+	{"webhook-cert-file", "WEBHOOK_CERT_FILE", "certificate of the injection webhook", str(func(c *Config) *string { return &c.Webhook.CertFile })},
+	{"webhook-key-file", "WEBHOOK_KEY_FILE", "key of the injection webhook", str(func(c *Config) *string { return &c.Webhook.KeyFile })},
+	{"helper-image", "HELPER_IMAGE", "image of injected SVID helpers", str(func(c *Config) *string { return &c.Webhook.HelperImage })},
+	{"helper-kubespiffed-url", "HELPER_KUBESPIFFED_URL", "kubespiffed URL used by injected SVID helpers", str(func(c *Config) *string { return &c.Webhook.KubespiffedURL })},
+
+	{"log-level", "LOG_LEVEL", "debug, info, warn or error", str(func(c *Config) *string { return &c.Log.Level })},
+	{"log-format", "LOG_FORMAT", "text or json", str(func(c *Config) *string { return &c.Log.Format })},
+
+	{"otlp-endpoint", "OTEL_EXPORTER_OTLP_ENDPOINT", "OTLP gRPC collector spans are exported to, empty to disable tracing", str(func(c *Config) *string { return &c.Tracing.OTLPEndpoint })},
+	{"otlp-insecure", "OTEL_EXPORTER_OTLP_INSECURE", "export spans without TLS", boolean(func(c *Config) *bool { return &c.Tracing.Insecure })},
+	{"tracing-sample-ratio", "TRACING_SAMPLE_RATIO", "fraction of traces started by kubespiffed that are sampled", float(func(c *Config) *float64 { return &c.Tracing.SampleRatio })},
+
+	{"ha-enabled", "HA_ENABLED", "elect a leader among several replicas", boolean(func(c *Config) *bool { return &c.HA.Enabled })},
+	{"ha-identity", "POD_NAME", "identity this replica holds the Lease with", str(func(c *Config) *string { return &c.HA.Identity })},
+	{"ha-lease-name", "HA_LEASE_NAME", "Lease replicas compete for", str(func(c *Config) *string { return &c.HA.LeaseName })},
+
+	{"rate-limit-per-pod", "RATE_LIMIT_PER_POD", "SVIDs issued per second to each pod, 0 to disable", float(func(c *Config) *float64 { return &c.RateLimit.PerPod.PerSecond })},
+	{"rate-limit-per-service-account", "RATE_LIMIT_PER_SERVICE_ACCOUNT", "SVIDs issued per second to each service account, 0 to disable", float(func(c *Config) *float64 { return &c.RateLimit.PerServiceAccount.PerSecond })},
+	{"rate-limit-per-node", "RATE_LIMIT_PER_NODE", "SVIDs issued per second to pods on each node, 0 to disable", float(func(c *Config) *float64 { return &c.RateLimit.PerNode.PerSecond })},
+	{"max-concurrent-signing", "MAX_CONCURRENT_SIGNING", "SVIDs signed at once, 0 for no limit", integer(func(c *Config) *int { return &c.RateLimit.MaxConcurrentSigning })},
+
+	{"feature-federation", "FEATURE_FEDERATION", "run the FederatedTrustDomain controller", boolean(func(c *Config) *bool { return &c.Features.Federation })},
+	{"feature-revocation", "FEATURE_REVOCATION", "run the SVIDRevocation controller", boolean(func(c *Config) *bool { return &c.Features.Revocation })},
+	{"feature-lifecycle", "FEATURE_LIFECYCLE", "revoke SVIDs of deleted pods", boolean(func(c *Config) *bool { return &c.Features.Lifecycle })},
+	{"feature-authorization-policy", "FEATURE_AUTHORIZATION_POLICY", "serve SpiffeAuthorizationPolicy decisions", boolean(func(c *Config) *bool { return &c.Features.AuthorizationPolicy })},
+	{"feature-events", "FEATURE_EVENTS", "record Kubernetes Events on attested pods and their WorkloadRegistrations", boolean(func(c *Config) *bool { return &c.Features.Events })},
+
+	{"agent-service-accounts", "AGENT_SERVICE_ACCOUNTS", "comma separated namespace/name service accounts of node agents", list(func(c *Config) *[]string { return &c.AgentServiceAccounts })},
+}
+
+// Overrides holds the flags registered by RegisterFlags
+type Overrides struct {
+	fs     *flag.FlagSet
+	values map[string]*string
+}
+
+// boolFlag holds a boolean flag's value until Apply parses it, so that it can
+// be given bare, such as -feature-lifecycle
+type boolFlag struct {
+	value string
+}
+
+func (b *boolFlag) String() string {
+	if b == nil {
+		return ""
+	}
+	return b.value
+}
+
+func (b *boolFlag) Set(s string) error {
+	b.value = s
+	return nil
+}
+
+func (b *boolFlag) IsBoolFlag() bool {
+	return true
+}
+
+// RegisterFlags adds a flag to fs for every overridable field
+func RegisterFlags(fs *flag.FlagSet) *Overrides {
+	o := &Overrides{fs: fs, values: make(map[string]*string)}
+	for _, ov := range overrides {
+		usage := fmt.Sprintf("%s (env %s)", ov.usage, ov.env)
+		if _, ok := ov.set.(boolFunc); ok {
+			b := &boolFlag{}
+			fs.Var(b, ov.flag, usage)
+			o.values[ov.flag] = &b.value
+			continue
+		}
+		o.values[ov.flag] = fs.String(ov.flag, "", usage)
+	}
+	return o
+}
+
+// Apply sets fields from the environment variables found by lookupEnv, and
+// then from the flags given on the command line, so flags take precedence
+// over the environment, which takes precedence over the file
+func (o *Overrides) Apply(c *Config, lookupEnv func(string) (string, bool)) error {
+	var errs []error
+	for _, ov := range overrides {
+		if v, ok := lookupEnv(ov.env); ok {
+			if err := ov.set.apply(c, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", ov.env, err))
+			}
+		}
+	}
+
+	given := make(map[string]bool)
+	o.fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	for _, ov := range overrides {
+		if given[ov.flag] {
+			if err := ov.set.apply(c, *o.values[ov.flag]); err != nil {
+				errs = append(errs, fmt.Errorf("-%s: %w", ov.flag, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,277 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	"github.com/jsnctl/kubespiffe/pkg/federation"
+	"github.com/jsnctl/kubespiffe/pkg/generated/clientset/versioned"
+	informers "github.com/jsnctl/kubespiffe/pkg/generated/informers/externalversions/kubespiffe/v1alpha1"
+	listers "github.com/jsnctl/kubespiffe/pkg/generated/listers/kubespiffe/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	minRefreshInterval = 30 * time.Second
+	maxRefreshInterval = time.Hour
+	fetchRetryInterval = 30 * time.Second
+)
+
+// FederationController polls the bundle endpoint of every
+// FederatedTrustDomain, honouring the refresh hint each bundle carries, and
+// keeps the federation store and the resource's status up to date. Only the
+// leader polls; the other replicas take the bundle it writes to the status
+type FederationController struct {
+	kscs   versioned.Interface
+	lister listers.FederatedTrustDomainLister
+	store  *federation.Store
+
+	mu sync.Mutex
+	// ctx is the leader's term, and nil while following
+	ctx     context.Context
+	pollers map[string]context.CancelFunc
+
+	minRefresh time.Duration
+	maxRefresh time.Duration
+	retry      time.Duration
+}
+
+func NewFederationController(
+	kscs versioned.Interface,
+	informer informers.FederatedTrustDomainInformer,
+	store *federation.Store,
+) (*FederationController, error) {
+	c := &FederationController{
+		kscs:       kscs,
+		lister:     informer.Lister(),
+		store:      store,
+		pollers:    make(map[string]context.CancelFunc),
+		minRefresh: minRefreshInterval,
+		maxRefresh: maxRefreshInterval,
+		retry:      fetchRetryInterval,
+	}
+	_, err := informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.onAdd,
+		UpdateFunc: c.onUpdate,
+		DeleteFunc: c.onDelete,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *FederationController) onAdd(obj any) {
+	ftd, ok := obj.(*v1alpha1.FederatedTrustDomain)
+	if !ok {
+		return
+	}
+	c.applyStatusBundle(ftd)
+	c.startPoller(ftd)
+}
+
+func (c *FederationController) onUpdate(oldObj, newObj any) {
+	oldFTD, ok := oldObj.(*v1alpha1.FederatedTrustDomain)
+	if !ok {
+		return
+	}
+	newFTD, ok := newObj.(*v1alpha1.FederatedTrustDomain)
+	if !ok {
+		return
+	}
+	// Status writes by the poller come back through here too
+	if equality.Semantic.DeepEqual(oldFTD.Spec, newFTD.Spec) {
+		if oldFTD.Status.Bundle != newFTD.Status.Bundle {
+			c.applyStatusBundle(newFTD)
+		}
+		return
+	}
+	if oldFTD.Spec.TrustDomain != newFTD.Spec.TrustDomain {
+		c.store.Delete(oldFTD.Spec.TrustDomain)
+	}
+	c.startPoller(newFTD)
+}
+
+func (c *FederationController) onDelete(obj any) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	ftd, ok := obj.(*v1alpha1.FederatedTrustDomain)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	if cancel, ok := c.pollers[ftd.Name]; ok {
+		cancel()
+		delete(c.pollers, ftd.Name)
+	}
+	c.mu.Unlock()
+
+	c.store.Delete(ftd.Spec.TrustDomain)
+	slog.Info("Federation removed", "trustDomain", ftd.Spec.TrustDomain)
+}
+
+// Lead polls every trust domain until ctx is done
+func (c *FederationController) Lead(ctx context.Context) {
+	// Set before listing, so trust domains added meanwhile aren't missed
+	c.mu.Lock()
+	c.ctx = ctx
+	c.mu.Unlock()
+
+	ftds, err := c.lister.List(labels.Everything())
+	if err != nil {
+		slog.Error("problem listing federated trust domains", "error", err)
+	}
+	for _, ftd := range ftds {
+		c.startPoller(ftd)
+	}
+
+	<-ctx.Done()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, cancel := range c.pollers {
+		cancel()
+		delete(c.pollers, name)
+	}
+	c.ctx = nil
+}
+
+func (c *FederationController) startPoller(ftd *v1alpha1.FederatedTrustDomain) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ctx == nil || c.ctx.Err() != nil {
+		return
+	}
+	if cancel, ok := c.pollers[ftd.Name]; ok {
+		cancel()
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.pollers[ftd.Name] = cancel
+	go c.poll(ctx, ftd.DeepCopy())
+}
+
+// applyStatusBundle stores the bundle last fetched by the leader. The leader
+// itself skips it, since the store already holds what it fetched
+func (c *FederationController) applyStatusBundle(ftd *v1alpha1.FederatedTrustDomain) {
+	c.mu.Lock()
+	leading := c.ctx != nil
+	c.mu.Unlock()
+	if leading || ftd.Status.Bundle == "" {
+		return
+	}
+
+	bundle, err := federation.ParseBundle(ftd.Spec.TrustDomain, []byte(ftd.Status.Bundle))
+	if err == nil {
+		err = c.store.Set(bundle)
+	}
+	if err != nil {
+		slog.Warn("problem applying federated bundle from status", "trustDomain", ftd.Spec.TrustDomain, "error", err)
+	}
+}
+
+func (c *FederationController) poll(ctx context.Context, ftd *v1alpha1.FederatedTrustDomain) {
+	for {
+		next := c.fetch(ctx, ftd)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(next):
+		}
+	}
+}
+
+// fetch refreshes one trust domain's bundle and returns when to fetch next
+func (c *FederationController) fetch(ctx context.Context, ftd *v1alpha1.FederatedTrustDomain) time.Duration {
+	td := ftd.Spec.TrustDomain
+	bundle, err := c.fetchBundle(ctx, ftd)
+	if ctx.Err() != nil {
+		return 0
+	}
+
+	now := metav1.Now()
+	next := c.retry
+	var doc []byte
+	if err == nil {
+		doc, err = bundle.Marshal()
+	}
+	if err != nil {
+		slog.Error("problem fetching federated bundle", "trustDomain", td, "error", err)
+	} else {
+		next = c.refreshInterval(bundle)
+		slog.Info("Federated bundle refreshed", "trustDomain", td, "sequence", bundle.Sequence)
+	}
+	nextFetch := metav1.NewTime(now.Add(next))
+
+	c.updateStatus(ctx, ftd.Name, func(s *v1alpha1.FederatedTrustDomainStatus) {
+		s.LastFetchTime = &now
+		s.NextFetchTime = &nextFetch
+		if err != nil {
+			s.Error = err.Error()
+			return
+		}
+		s.LastSuccessfulFetchTime = &now
+		s.Sequence = int64(bundle.Sequence)
+		s.Bundle = string(doc)
+		s.Error = ""
+	})
+	return next
+}
+
+func (c *FederationController) fetchBundle(ctx context.Context, ftd *v1alpha1.FederatedTrustDomain) (*federation.Bundle, error) {
+	td := ftd.Spec.TrustDomain
+	trust, ok := c.store.Get(td)
+	if !ok && ftd.Spec.TrustDomainBundle != "" {
+		var err error
+		trust, err = federation.ParseBundle(td, []byte(ftd.Spec.TrustDomainBundle))
+		if err != nil {
+			return nil, fmt.Errorf("invalid trustDomainBundle: %w", err)
+		}
+	}
+
+	bundle, err := federation.FetchBundle(ctx, federation.Endpoint{
+		TrustDomain:      td,
+		URL:              ftd.Spec.BundleEndpointURL,
+		Profile:          ftd.Spec.BundleEndpointProfile.Type,
+		EndpointSPIFFEID: ftd.Spec.BundleEndpointProfile.EndpointSPIFFEID,
+	}, trust)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.store.Set(bundle); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+func (c *FederationController) refreshInterval(bundle *federation.Bundle) time.Duration {
+	hint := bundle.RefreshHint
+	if hint == 0 {
+		hint = federation.DefaultRefreshHint
+	}
+	return min(max(hint, c.minRefresh), c.maxRefresh)
+}
+
+func (c *FederationController) updateStatus(ctx context.Context, name string, mutate func(*v1alpha1.FederatedTrustDomainStatus)) {
+	ctx, cancel := context.WithTimeout(ctx, statusUpdateTimeout)
+	defer cancel()
+
+	client := c.kscs.KubespiffeV1alpha1().FederatedTrustDomains()
+	ftd, err := client.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		slog.Error("problem getting federated trust domain", "name", name, "error", err)
+		return
+	}
+	mutate(&ftd.Status)
+	if _, err := client.UpdateStatus(ctx, ftd, metav1.UpdateOptions{}); err != nil {
+		slog.Error("problem updating federated trust domain status", "name", name, "error", err)
+	}
+}
@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	"github.com/jsnctl/kubespiffe/pkg/federation"
+	"github.com/jsnctl/kubespiffe/pkg/generated/clientset/versioned/fake"
+	"github.com/jsnctl/kubespiffe/pkg/generated/informers/externalversions"
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFederationController(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	partner, err := svid.NewSVIDIssuer(svid.WithTrustDomain("partner.org"))
+	require.NoError(t, err)
+	srv := httptest.NewUnstartedServer(federation.NewBundleEndpoint("partner.org", partner, time.Second))
+	srv.Listener = tls.NewListener(srv.Listener, federation.SPIFFETLSConfig(partner, "spiffe://partner.org/kubespiffed"))
+	srv.Start()
+	defer srv.Close()
+
+	initial, err := federation.BundleFromAuthorities("partner.org", partner.Authorities(), 0).Marshal()
+	require.NoError(t, err)
+
+	ftd := &v1alpha1.FederatedTrustDomain{
+		ObjectMeta: metav1.ObjectMeta{Name: "partner"},
+		Spec: v1alpha1.FederatedTrustDomainSpec{
+			TrustDomain:       "partner.org",
+			BundleEndpointURL: strings.Replace(srv.URL, "http://", "https://", 1),
+			BundleEndpointProfile: v1alpha1.BundleEndpointProfile{
+				Type:             federation.ProfileHTTPSSPIFFE,
+				EndpointSPIFFEID: "spiffe://partner.org/kubespiffed",
+			},
+			TrustDomainBundle: string(initial),
+		},
+	}
+	kscs := fake.NewSimpleClientset(ftd)
+	factory := externalversions.NewSharedInformerFactory(kscs, 0)
+	store := federation.NewStore()
+
+	c, err := NewFederationController(kscs, factory.Kubespiffe().V1alpha1().FederatedTrustDomains(), store)
+	require.NoError(t, err)
+	c.minRefresh = 10 * time.Millisecond
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+	go c.Lead(ctx)
+
+	assert.Eventually(t, func() bool {
+		got, err := kscs.KubespiffeV1alpha1().FederatedTrustDomains().Get(ctx, ftd.Name, metav1.GetOptions{})
+		return err == nil && got.Status.LastSuccessfulFetchTime != nil && got.Status.Sequence == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Rotation on the partner side is picked up on the next refresh, using the
+	// previously fetched bundle to authenticate the endpoint
+	require.NoError(t, partner.RotateCA())
+	assert.Eventually(t, func() bool {
+		b, ok := store.Get("partner.org")
+		return ok && b.Sequence == partner.Authorities().Sequence && len(b.X509Authorities) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, kscs.KubespiffeV1alpha1().FederatedTrustDomains().Delete(ctx, ftd.Name, metav1.DeleteOptions{}))
+	assert.Eventually(t, func() bool {
+		_, ok := store.Get("partner.org")
+		return !ok
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestFederationControllerReportsFetchErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ftd := &v1alpha1.FederatedTrustDomain{
+		ObjectMeta: metav1.ObjectMeta{Name: "partner"},
+		Spec: v1alpha1.FederatedTrustDomainSpec{
+			TrustDomain:           "partner.org",
+			BundleEndpointURL:     "https://127.0.0.1:1",
+			BundleEndpointProfile: v1alpha1.BundleEndpointProfile{Type: federation.ProfileHTTPSSPIFFE},
+		},
+	}
+	kscs := fake.NewSimpleClientset(ftd)
+	factory := externalversions.NewSharedInformerFactory(kscs, 0)
+
+	c, err := NewFederationController(kscs, factory.Kubespiffe().V1alpha1().FederatedTrustDomains(), federation.NewStore())
+	require.NoError(t, err)
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+	go c.Lead(ctx)
+
+	assert.Eventually(t, func() bool {
+		got, err := kscs.KubespiffeV1alpha1().FederatedTrustDomains().Get(ctx, ftd.Name, metav1.GetOptions{})
+		return err == nil && got.Status.Error != "" && got.Status.LastSuccessfulFetchTime == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestFederationControllerFailover(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	partner, err := svid.NewSVIDIssuer(svid.WithTrustDomain("partner.org"))
+	require.NoError(t, err)
+	srv := httptest.NewUnstartedServer(federation.NewBundleEndpoint("partner.org", partner, time.Second))
+	srv.Listener = tls.NewListener(srv.Listener, federation.SPIFFETLSConfig(partner, "spiffe://partner.org/kubespiffed"))
+	srv.Start()
+	defer srv.Close()
+
+	initial, err := federation.BundleFromAuthorities("partner.org", partner.Authorities(), 0).Marshal()
+	require.NoError(t, err)
+	ftd := &v1alpha1.FederatedTrustDomain{
+		ObjectMeta: metav1.ObjectMeta{Name: "partner"},
+		Spec: v1alpha1.FederatedTrustDomainSpec{
+			TrustDomain:       "partner.org",
+			BundleEndpointURL: strings.Replace(srv.URL, "http://", "https://", 1),
+			BundleEndpointProfile: v1alpha1.BundleEndpointProfile{
+				Type:             federation.ProfileHTTPSSPIFFE,
+				EndpointSPIFFEID: "spiffe://partner.org/kubespiffed",
+			},
+			TrustDomainBundle: string(initial),
+		},
+	}
+	kscs := fake.NewSimpleClientset(ftd)
+
+	// Two replicas, each with its own informers and store
+	replica := func() (*FederationController, *federation.Store) {
+		factory := externalversions.NewSharedInformerFactory(kscs, 0)
+		store := federation.NewStore()
+		c, err := NewFederationController(kscs, factory.Kubespiffe().V1alpha1().FederatedTrustDomains(), store)
+		require.NoError(t, err)
+		c.minRefresh = 10 * time.Millisecond
+		factory.Start(ctx.Done())
+		factory.WaitForCacheSync(ctx.Done())
+		return c, store
+	}
+	first, firstStore := replica()
+	second, secondStore := replica()
+
+	firstCtx, firstCancel := context.WithCancel(ctx)
+	leaderDone := make(chan struct{})
+	go func() {
+		first.Lead(firstCtx)
+		close(leaderDone)
+	}()
+
+	// The follower takes the bundle the leader wrote to the status
+	assert.Eventually(t, func() bool {
+		b, ok := secondStore.Get("partner.org")
+		return ok && b.Sequence == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Once the leader steps down it stops polling, and the new leader picks
+	// up the partner's rotation
+	firstCancel()
+	<-leaderDone
+	go second.Lead(ctx)
+	require.NoError(t, partner.RotateCA())
+	assert.Eventually(t, func() bool {
+		b, ok := secondStore.Get("partner.org")
+		return ok && b.Sequence == partner.Authorities().Sequence
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The former leader now follows, and takes the new bundle from the status
+	assert.Eventually(t, func() bool {
+		b, ok := firstStore.Get("partner.org")
+		return ok && b.Sequence == partner.Authorities().Sequence
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	informers "github.com/jsnctl/kubespiffe/pkg/generated/informers/externalversions/kubespiffe/v1alpha1"
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	corev1 "k8s.io/api/core/v1"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// deletedPodDenial is how long a gone pod's UID is refused SVIDs, which
+// outlasts the projected PSATs kubespiffed is meant to be presented
+const deletedPodDenial = 48 * time.Hour
+
+// LifecycleController revokes SVIDs once the objects they were issued for are
+// gone: pods that are deleted or have terminated, which are also refused
+// further SVIDs, and WorkloadRegistrations that are deleted or now grant a
+// different SPIFFE ID
+type LifecycleController struct {
+	issuer *svid.SVIDIssuer
+}
+
+func NewLifecycleController(
+	pods coreinformers.PodInformer,
+	registrations informers.WorkloadRegistrationInformer,
+	issuer *svid.SVIDIssuer,
+) (*LifecycleController, error) {
+	c := &LifecycleController{
+		issuer: issuer,
+	}
+	_, err := pods.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: c.onPodUpdate,
+		DeleteFunc: c.onPodDelete,
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = registrations.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: c.onRegistrationUpdate,
+		DeleteFunc: c.onRegistrationDelete,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *LifecycleController) onPodUpdate(oldObj, newObj any) {
+	oldPod, ok := oldObj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	newPod, ok := newObj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	if isTerminated(newPod) && !isTerminated(oldPod) {
+		c.revokePod(newPod, "pod terminated")
+	}
+}
+
+func (c *LifecycleController) onPodDelete(obj any) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	c.revokePod(pod, "pod deleted")
+}
+
+func (c *LifecycleController) revokePod(pod *corev1.Pod, why string) {
+	serials := c.issuer.RevokePod(string(pod.UID), "cessationOfOperation", time.Now().Add(deletedPodDenial))
+	if len(serials) == 0 {
+		return
+	}
+	slog.Info("🚫 SVIDs revoked", "reason", why, "pod", pod.Name, "namespace", pod.Namespace, "serials", serials)
+	c.refreshCRL()
+}
+
+func (c *LifecycleController) onRegistrationUpdate(oldObj, newObj any) {
+	oldWR, ok := oldObj.(*v1alpha1.WorkloadRegistration)
+	if !ok {
+		return
+	}
+	newWR, ok := newObj.(*v1alpha1.WorkloadRegistration)
+	if !ok {
+		return
+	}
+	if oldWR.Spec.SPIFFEID == newWR.Spec.SPIFFEID {
+		return
+	}
+	c.revokeRegistration(oldWR, "superseded", "registration changed")
+}
+
+func (c *LifecycleController) onRegistrationDelete(obj any) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	wr, ok := obj.(*v1alpha1.WorkloadRegistration)
+	if !ok {
+		return
+	}
+	c.revokeRegistration(wr, "privilegeWithdrawn", "registration deleted")
+}
+
+// revokeRegistration only matches the SPIFFE ID the registration previously
+// granted, so SVIDs issued after an update are left alone
+func (c *LifecycleController) revokeRegistration(wr *v1alpha1.WorkloadRegistration, reason, why string) {
+	q := svid.LedgerQuery{
+		Registration: wr.Name,
+		SPIFFEID:     wr.Spec.SPIFFEID,
+	}
+	serials := c.issuer.RevokeMatching(q, reason)
+	if len(serials) == 0 {
+		return
+	}
+	slog.Info("🚫 SVIDs revoked", "reason", why, "registration", wr.Name, "serials", serials)
+	c.refreshCRL()
+}
+
+// refreshCRL re-signs the CRL straight away rather than on the next fetch
+func (c *LifecycleController) refreshCRL() {
+	if _, err := c.issuer.CRL(); err != nil {
+		slog.Error("problem generating CRL", "error", err)
+	}
+}
+
+func isTerminated(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	"github.com/jsnctl/kubespiffe/pkg/generated/clientset/versioned/fake"
+	"github.com/jsnctl/kubespiffe/pkg/generated/informers/externalversions"
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func mockPod(name, uid string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(uid)},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+func TestLifecycleController(t *testing.T) {
+	wr := &v1alpha1.WorkloadRegistration{
+		ObjectMeta: metav1.ObjectMeta{Name: "workload"},
+		Spec:       v1alpha1.WorkloadRegistrationSpec{SPIFFEID: "spiffe://example.org/workload"},
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(ctx context.Context, t *testing.T, cs *k8sfake.Clientset, kscs *fake.Clientset)
+		// podDenied is whether pod-1 is refused further SVIDs
+		podDenied bool
+	}{
+		{
+			name: "pod deleted",
+			mutate: func(ctx context.Context, t *testing.T, cs *k8sfake.Clientset, kscs *fake.Clientset) {
+				require.NoError(t, cs.CoreV1().Pods("default").Delete(ctx, "workload-abc", metav1.DeleteOptions{}))
+			},
+			podDenied: true,
+		},
+		{
+			name: "pod terminated",
+			mutate: func(ctx context.Context, t *testing.T, cs *k8sfake.Clientset, kscs *fake.Clientset) {
+				pod := mockPod("workload-abc", "pod-1")
+				pod.Status.Phase = corev1.PodSucceeded
+				_, err := cs.CoreV1().Pods("default").UpdateStatus(ctx, pod, metav1.UpdateOptions{})
+				require.NoError(t, err)
+			},
+			podDenied: true,
+		},
+		{
+			name: "registration deleted",
+			mutate: func(ctx context.Context, t *testing.T, cs *k8sfake.Clientset, kscs *fake.Clientset) {
+				require.NoError(t, kscs.KubespiffeV1alpha1().WorkloadRegistrations("").Delete(ctx, wr.Name, metav1.DeleteOptions{}))
+			},
+		},
+		{
+			name: "registration SPIFFE ID changed",
+			mutate: func(ctx context.Context, t *testing.T, cs *k8sfake.Clientset, kscs *fake.Clientset) {
+				changed := wr.DeepCopy()
+				changed.Spec.SPIFFEID = "spiffe://example.org/renamed"
+				_, err := kscs.KubespiffeV1alpha1().WorkloadRegistrations("").Update(ctx, changed, metav1.UpdateOptions{})
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			cs := k8sfake.NewSimpleClientset(mockPod("workload-abc", "pod-1"), mockPod("another-abc", "pod-2"))
+			kscs := fake.NewSimpleClientset(wr.DeepCopy())
+			k8sInformers := informers.NewSharedInformerFactory(cs, 0)
+			ksInformers := externalversions.NewSharedInformerFactory(kscs, 0)
+
+			issuer, err := svid.NewSVIDIssuer()
+			require.NoError(t, err)
+			revocations, unsubscribe := issuer.SubscribeRevocations()
+			defer unsubscribe()
+
+			_, err = NewLifecycleController(
+				k8sInformers.Core().V1().Pods(),
+				ksInformers.Kubespiffe().V1alpha1().WorkloadRegistrations(),
+				issuer,
+			)
+			require.NoError(t, err)
+			k8sInformers.Start(ctx.Done())
+			ksInformers.Start(ctx.Done())
+			k8sInformers.WaitForCacheSync(ctx.Done())
+			ksInformers.WaitForCacheSync(ctx.Done())
+
+			_, _, err = issuer.IssueX509SVID(context.Background(), wr, svid.Workload{PodName: "workload-abc", PodUID: "pod-1"})
+			require.NoError(t, err)
+			other := &v1alpha1.WorkloadRegistration{
+				ObjectMeta: metav1.ObjectMeta{Name: "another"},
+				Spec:       v1alpha1.WorkloadRegistrationSpec{SPIFFEID: "spiffe://example.org/another"},
+			}
+			_, _, err = issuer.IssueX509SVID(context.Background(), other, svid.Workload{PodName: "another-abc", PodUID: "pod-2"})
+			require.NoError(t, err)
+
+			tt.mutate(ctx, t, cs, kscs)
+
+			select {
+			case batch := <-revocations:
+				require.Len(t, batch, 1)
+				assert.Equal(t, "pod-1", batch[0].PodUID)
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for revocation")
+			}
+
+			assert.Len(t, issuer.Revoked(), 1)
+			_, _, err = issuer.IssueX509SVID(context.Background(), wr, svid.Workload{PodName: "workload-abc", PodUID: "pod-1"})
+			assert.Equal(t, tt.podDenied, errors.Is(err, svid.ErrRevoked))
+			der, err := issuer.CRL()
+			require.NoError(t, err)
+			assert.NotEmpty(t, der)
+		})
+	}
+}
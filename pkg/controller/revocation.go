@@ -0,0 +1,154 @@
+package controller
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	"github.com/jsnctl/kubespiffe/pkg/generated/clientset/versioned"
+	informers "github.com/jsnctl/kubespiffe/pkg/generated/informers/externalversions/kubespiffe/v1alpha1"
+	listers "github.com/jsnctl/kubespiffe/pkg/generated/listers/kubespiffe/v1alpha1"
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	statusUpdateTimeout = 10 * time.Second
+)
+
+// RevocationController applies SVIDRevocation resources to the issuer. The
+// resources are the durable record of revocation: every one is re-applied
+// when the informer lists them after a restart. Every replica applies them,
+// but only the leader writes their status
+type RevocationController struct {
+	kscs   versioned.Interface
+	lister listers.SVIDRevocationLister
+	issuer *svid.SVIDIssuer
+
+	leading atomic.Bool
+}
+
+func NewRevocationController(
+	kscs versioned.Interface,
+	informer informers.SVIDRevocationInformer,
+	issuer *svid.SVIDIssuer,
+) (*RevocationController, error) {
+	c := &RevocationController{
+		kscs:   kscs,
+		lister: informer.Lister(),
+		issuer: issuer,
+	}
+	_, err := informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.onAdd,
+		DeleteFunc: c.onDelete,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *RevocationController) onAdd(obj any) {
+	rev, ok := obj.(*v1alpha1.SVIDRevocation)
+	if !ok {
+		return
+	}
+
+	serials, err := c.issuer.Revoke(revocationRequestFrom(rev))
+	if err != nil {
+		slog.Error("problem applying revocation", "revocation", rev.Name, "error", err)
+	} else {
+		slog.Info("🚫 SVIDs revoked", "revocation", rev.Name, "serials", serials)
+	}
+	c.report(rev, err)
+}
+
+// Lead writes the status of revocations applied while no replica was leading,
+// and keeps writing status until ctx is done
+func (c *RevocationController) Lead(ctx context.Context) {
+	c.leading.Store(true)
+	defer c.leading.Store(false)
+
+	revs, err := c.lister.List(labels.Everything())
+	if err != nil {
+		slog.Error("problem listing revocations", "error", err)
+	}
+	for _, rev := range revs {
+		// Revoking again is harmless, and gives the outcome to report
+		if rev.Status.RevokedAt == nil {
+			c.onAdd(rev)
+		}
+	}
+	<-ctx.Done()
+}
+
+// report records the outcome of applying a revocation in its status. The
+// serials are every revoked SVID it selects that this replica knows of, which
+// with shared revocations includes those issued by other replicas
+func (c *RevocationController) report(rev *v1alpha1.SVIDRevocation, err error) {
+	if !c.leading.Load() {
+		return
+	}
+	if err != nil {
+		c.updateStatus(rev, func(s *v1alpha1.SVIDRevocationStatus) {
+			s.Error = err.Error()
+		})
+		return
+	}
+	if rev.Status.RevokedAt != nil {
+		return
+	}
+
+	serials := []string{}
+	serial, _ := svid.NormalizeSerial(rev.Spec.Serial)
+	for _, r := range c.issuer.Revoked() {
+		if (serial != "" && r.Serial == serial) ||
+			(rev.Spec.SPIFFEID != "" && r.SPIFFEID == rev.Spec.SPIFFEID) ||
+			(rev.Spec.PodUID != "" && r.PodUID == rev.Spec.PodUID) {
+			serials = append(serials, r.Serial)
+		}
+	}
+	c.updateStatus(rev, func(s *v1alpha1.SVIDRevocationStatus) {
+		now := metav1.Now()
+		s.RevokedSerials = serials
+		s.RevokedAt = &now
+		s.Error = ""
+	})
+}
+
+func (c *RevocationController) onDelete(obj any) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	rev, ok := obj.(*v1alpha1.SVIDRevocation)
+	if !ok {
+		return
+	}
+	c.issuer.LiftDenial(revocationRequestFrom(rev))
+	slog.Info("SVID revocation removed, reissuance allowed", "revocation", rev.Name)
+}
+
+func (c *RevocationController) updateStatus(rev *v1alpha1.SVIDRevocation, mutate func(*v1alpha1.SVIDRevocationStatus)) {
+	ctx, cancel := context.WithTimeout(context.Background(), statusUpdateTimeout)
+	defer cancel()
+
+	updated := rev.DeepCopy()
+	mutate(&updated.Status)
+	_, err := c.kscs.KubespiffeV1alpha1().SVIDRevocations().UpdateStatus(ctx, updated, metav1.UpdateOptions{})
+	if err != nil {
+		slog.Error("problem updating revocation status", "revocation", rev.Name, "error", err)
+	}
+}
+
+func revocationRequestFrom(rev *v1alpha1.SVIDRevocation) svid.RevocationRequest {
+	return svid.RevocationRequest{
+		Serial:   rev.Spec.Serial,
+		SPIFFEID: rev.Spec.SPIFFEID,
+		PodUID:   rev.Spec.PodUID,
+		Reason:   rev.Spec.Reason,
+	}
+}
@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	"github.com/jsnctl/kubespiffe/pkg/generated/clientset/versioned/fake"
+	"github.com/jsnctl/kubespiffe/pkg/generated/informers/externalversions"
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRevocationController(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	issuer, err := svid.NewSVIDIssuer()
+	require.NoError(t, err)
+	wr := &v1alpha1.WorkloadRegistration{
+		ObjectMeta: metav1.ObjectMeta{Name: "workload"},
+		Spec:       v1alpha1.WorkloadRegistrationSpec{SPIFFEID: "spiffe://example.org/workload"},
+	}
+	_, _, err = issuer.IssueX509SVID(context.Background(), wr, svid.Workload{PodUID: "pod-1"})
+	require.NoError(t, err)
+
+	kscs := fake.NewSimpleClientset()
+	factory := externalversions.NewSharedInformerFactory(kscs, 0)
+	c, err := NewRevocationController(kscs, factory.Kubespiffe().V1alpha1().SVIDRevocations(), issuer)
+	require.NoError(t, err)
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+	go c.Lead(ctx)
+
+	rev := &v1alpha1.SVIDRevocation{
+		ObjectMeta: metav1.ObjectMeta{Name: "offboard-workload"},
+		Spec:       v1alpha1.SVIDRevocationSpec{SPIFFEID: wr.Spec.SPIFFEID, Reason: "privilegeWithdrawn"},
+	}
+	_, err = kscs.KubespiffeV1alpha1().SVIDRevocations().Create(ctx, rev, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		got, err := kscs.KubespiffeV1alpha1().SVIDRevocations().Get(ctx, rev.Name, metav1.GetOptions{})
+		return err == nil && got.Status.RevokedAt != nil && len(got.Status.RevokedSerials) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, _, err = issuer.IssueX509SVID(context.Background(), wr, svid.Workload{PodUID: "pod-2"})
+	assert.ErrorIs(t, err, svid.ErrRevoked)
+
+	require.NoError(t, kscs.KubespiffeV1alpha1().SVIDRevocations().Delete(ctx, rev.Name, metav1.DeleteOptions{}))
+	assert.Eventually(t, func() bool {
+		_, _, err := issuer.IssueX509SVID(context.Background(), wr, svid.Workload{PodUID: "pod-2"})
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestRevocationControllerFollower(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	issuer, err := svid.NewSVIDIssuer()
+	require.NoError(t, err)
+	wr := &v1alpha1.WorkloadRegistration{
+		ObjectMeta: metav1.ObjectMeta{Name: "workload"},
+		Spec:       v1alpha1.WorkloadRegistrationSpec{SPIFFEID: "spiffe://example.org/workload"},
+	}
+	_, _, err = issuer.IssueX509SVID(context.Background(), wr, svid.Workload{PodUID: "pod-1"})
+	require.NoError(t, err)
+
+	rev := &v1alpha1.SVIDRevocation{
+		ObjectMeta: metav1.ObjectMeta{Name: "offboard-workload"},
+		Spec:       v1alpha1.SVIDRevocationSpec{SPIFFEID: wr.Spec.SPIFFEID, Reason: "privilegeWithdrawn"},
+	}
+	kscs := fake.NewSimpleClientset(rev)
+	factory := externalversions.NewSharedInformerFactory(kscs, 0)
+	c, err := NewRevocationController(kscs, factory.Kubespiffe().V1alpha1().SVIDRevocations(), issuer)
+	require.NoError(t, err)
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+
+	// A follower enforces the revocation without writing its status
+	_, _, err = issuer.IssueX509SVID(context.Background(), wr, svid.Workload{PodUID: "pod-2"})
+	assert.ErrorIs(t, err, svid.ErrRevoked)
+	got, err := kscs.KubespiffeV1alpha1().SVIDRevocations().Get(ctx, rev.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Nil(t, got.Status.RevokedAt)
+
+	// On becoming leader, it reports the revocation it applied while following
+	go c.Lead(ctx)
+	assert.Eventually(t, func() bool {
+		got, err := kscs.KubespiffeV1alpha1().SVIDRevocations().Get(ctx, rev.Name, metav1.GetOptions{})
+		return err == nil && got.Status.RevokedAt != nil && len(got.Status.RevokedSerials) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,314 @@
+package csi
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/jsnctl/kubespiffe/pkg/agent"
+	"github.com/jsnctl/kubespiffe/pkg/api"
+	"github.com/jsnctl/kubespiffe/pkg/atomicdir"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	DriverName    = "csi.kubespiffe.io"
+	DriverVersion = "0.1.0"
+
+	SVIDFile    = "svid.pem"
+	SVIDKeyFile = "svid_key.pem"
+	BundleFile  = "bundle.pem"
+
+	renewRetry = 10 * time.Second
+
+	// Set by the kubelet when the CSIDriver has podInfoOnMount
+	podNameKey      = "csi.storage.k8s.io/pod.name"
+	podNamespaceKey = "csi.storage.k8s.io/pod.namespace"
+	podUIDKey       = "csi.storage.k8s.io/pod.uid"
+	ephemeralKey    = "csi.storage.k8s.io/ephemeral"
+)
+
+type svidFetcher interface {
+	FetchSVID(ctx context.Context, id agent.PodIdentity) ([]byte, error)
+}
+
+type mounter interface {
+	Mount(target string) error
+	Unmount(target string) error
+	IsMounted(target string) (bool, error)
+}
+
+// Driver is a CSI node plugin for ephemeral inline volumes holding a pod's
+// X509-SVID, key and bundle as files, for workloads that can't use the
+// Workload API. The pod is identified from the pod info the kubelet passes on
+// publish, and SVIDs are requested from kubespiffed as the node agent
+type Driver struct {
+	csi.UnimplementedIdentityServer
+	csi.UnimplementedNodeServer
+
+	nodeID  string
+	central svidFetcher
+	mounter mounter
+
+	mu      sync.Mutex
+	volumes map[string]context.CancelFunc
+	// pending holds volumes being published, so the lock needn't be held while
+	// their SVIDs are fetched
+	pending map[string]struct{}
+}
+
+func New(nodeID string, central svidFetcher) *Driver {
+	return &Driver{
+		nodeID:  nodeID,
+		central: central,
+		mounter: tmpfsMounter{},
+		volumes: make(map[string]context.CancelFunc),
+		pending: make(map[string]struct{}),
+	}
+}
+
+// Serve listens for the kubelet on socketPath until ctx is done
+func (d *Driver) Serve(ctx context.Context, socketPath string) error {
+	if err := os.Remove(socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing stale socket: %w", err)
+	}
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+
+	srv := grpc.NewServer()
+	csi.RegisterIdentityServer(srv, d)
+	csi.RegisterNodeServer(srv, d)
+	go func() {
+		<-ctx.Done()
+		srv.GracefulStop()
+		d.stopRenewals()
+	}()
+	return srv.Serve(l)
+}
+
+func (d *Driver) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
+	return &csi.GetPluginInfoResponse{Name: DriverName, VendorVersion: DriverVersion}, nil
+}
+
+func (d *Driver) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
+	return &csi.GetPluginCapabilitiesResponse{}, nil
+}
+
+func (d *Driver) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	return &csi.ProbeResponse{}, nil
+}
+
+func (d *Driver) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+	return &csi.NodeGetInfoResponse{NodeId: d.nodeID}, nil
+}
+
+func (d *Driver) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
+	return &csi.NodeGetCapabilitiesResponse{}, nil
+}
+
+// NodePublishVolume mounts a tmpfs at the target path and writes the pod's
+// SVID into it. Republishing a volume that is already being renewed is a
+// no-op, so the kubelet can call it again after a restart of the driver
+func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	volumeID, target := req.GetVolumeId(), req.GetTargetPath()
+	if volumeID == "" || target == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume ID and target path are required")
+	}
+	if req.GetVolumeCapability().GetMount() == nil {
+		return nil, status.Error(codes.InvalidArgument, "only filesystem volumes are supported")
+	}
+	volumeContext := req.GetVolumeContext()
+	if volumeContext[ephemeralKey] != "true" {
+		return nil, status.Error(codes.InvalidArgument, "only ephemeral inline volumes are supported")
+	}
+	id := agent.PodIdentity{
+		Namespace: volumeContext[podNamespaceKey],
+		Name:      volumeContext[podNameKey],
+		UID:       volumeContext[podUIDKey],
+	}
+	if id.Namespace == "" || id.Name == "" || id.UID == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing pod info, is podInfoOnMount enabled?")
+	}
+
+	published, err := d.reserve(volumeID)
+	if err != nil || published {
+		return &csi.NodePublishVolumeResponse{}, err
+	}
+	defer d.release(volumeID)
+
+	if err := os.MkdirAll(target, 0o750); err != nil {
+		return nil, status.Errorf(codes.Internal, "creating target path: %v", err)
+	}
+	mounted, err := d.mounter.IsMounted(target)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "checking target path: %v", err)
+	}
+	if !mounted {
+		if err := d.mounter.Mount(target); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	notAfter, err := d.publish(ctx, id, target)
+	if err != nil {
+		if !mounted {
+			d.mounter.Unmount(target)
+		}
+		var centralErr *agent.CentralError
+		if errors.As(err, &centralErr) && centralErr.Refused() {
+			slog.Info("❌ Pod rejected", "namespace", id.Namespace, "pod", id.Name, "error", err)
+			return nil, status.Error(codes.PermissionDenied, centralErr.Message)
+		}
+		return nil, status.Errorf(codes.Unavailable, "problem fetching SVID: %v", err)
+	}
+
+	renewCtx, cancel := context.WithCancel(context.Background())
+	d.mu.Lock()
+	d.volumes[volumeID] = cancel
+	d.mu.Unlock()
+	go d.renew(renewCtx, id, target, notAfter)
+
+	slog.Info("✅ SVID volume published", "namespace", id.Namespace, "pod", id.Name, "volume", volumeID)
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+func (d *Driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
+	volumeID, target := req.GetVolumeId(), req.GetTargetPath()
+	if volumeID == "" || target == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume ID and target path are required")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.pending[volumeID]; ok {
+		return nil, status.Error(codes.Aborted, "volume is being published")
+	}
+	if cancel, ok := d.volumes[volumeID]; ok {
+		cancel()
+		delete(d.volumes, volumeID)
+	}
+
+	mounted, err := d.mounter.IsMounted(target)
+	if errors.Is(err, os.ErrNotExist) {
+		return &csi.NodeUnpublishVolumeResponse{}, nil
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "checking target path: %v", err)
+	}
+	if mounted {
+		if err := d.mounter.Unmount(target); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	if err := os.RemoveAll(target); err != nil {
+		return nil, status.Errorf(codes.Internal, "removing target path: %v", err)
+	}
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
+
+// reserve marks a volume as being published, reporting whether it already is.
+// A volume whose publish is still in progress is refused with Aborted, which
+// the kubelet retries
+func (d *Driver) reserve(volumeID string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.volumes[volumeID]; ok {
+		return true, nil
+	}
+	if _, ok := d.pending[volumeID]; ok {
+		return false, status.Error(codes.Aborted, "volume is being published")
+	}
+	d.pending[volumeID] = struct{}{}
+	return false, nil
+}
+
+func (d *Driver) release(volumeID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.pending, volumeID)
+}
+
+// publish fetches an SVID for the pod and writes it to target, returning
+// when the SVID expires
+func (d *Driver) publish(ctx context.Context, id agent.PodIdentity, target string) (time.Time, error) {
+	data, err := d.central.FetchSVID(ctx, id)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var resp api.SVIDResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return time.Time{}, fmt.Errorf("decoding SVID response: %w", err)
+	}
+	block, _ := pem.Decode(resp.X509SVID)
+	if block == nil {
+		return time.Time{}, errors.New("SVID response has no certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parsing SVID: %w", err)
+	}
+
+	// Certificates and the key are only visible inside the pod the volume is
+	// mounted in, which may run as any user
+	err = atomicdir.Write(target, map[string]atomicdir.File{
+		SVIDFile:    {Data: resp.X509SVID, Mode: 0o644},
+		SVIDKeyFile: {Data: resp.X509SVIDKey, Mode: 0o644},
+		BundleFile:  {Data: resp.Bundle, Mode: 0o644},
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cert.NotAfter, nil
+}
+
+// renew rewrites the volume's SVID once half of its remaining lifetime has
+// elapsed, retrying until the volume is unpublished or kubespiffed refuses
+// the pod
+func (d *Driver) renew(ctx context.Context, id agent.PodIdentity, target string, notAfter time.Time) {
+	timer := time.NewTimer(time.Until(notAfter) / 2)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		next, err := d.publish(ctx, id, target)
+		var centralErr *agent.CentralError
+		if errors.As(err, &centralErr) && centralErr.Refused() {
+			slog.Info("❌ Pod rejected, SVID volume no longer renewed", "namespace", id.Namespace, "pod", id.Name, "error", err)
+			return
+		}
+		if err != nil {
+			slog.Error("problem renewing SVID volume", "namespace", id.Namespace, "pod", id.Name, "notAfter", notAfter, "error", err)
+			timer.Reset(renewRetry)
+			continue
+		}
+		notAfter = next
+		timer.Reset(time.Until(notAfter) / 2)
+	}
+}
+
+func (d *Driver) stopRenewals() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for volumeID, cancel := range d.volumes {
+		cancel()
+		delete(d.volumes, volumeID)
+	}
+}
@@ -0,0 +1,225 @@
+package csi
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/jsnctl/kubespiffe/pkg/agent"
+	"github.com/jsnctl/kubespiffe/pkg/api"
+	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type mockCentral struct {
+	issuer *svid.SVIDIssuer
+	err    error
+}
+
+func (m *mockCentral) FetchSVID(ctx context.Context, id agent.PodIdentity) ([]byte, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	wr := &v1alpha1.WorkloadRegistration{
+		ObjectMeta: metav1.ObjectMeta{Name: "workload"},
+		Spec:       v1alpha1.WorkloadRegistrationSpec{SPIFFEID: "spiffe://example.org/workload"},
+	}
+	cert, key, err := m.issuer.IssueX509SVID(context.Background(), wr, svid.Workload{PodName: id.Name, PodUID: id.UID, Namespace: id.Namespace})
+	if err != nil {
+		return nil, err
+	}
+	var bundle []byte
+	for _, ca := range m.issuer.GetCACerts() {
+		bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca})...)
+	}
+	return json.Marshal(api.SVIDResponse{
+		X509SVID:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}),
+		X509SVIDKey: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}),
+		Bundle:      bundle,
+	})
+}
+
+// mockMounter tracks mounts without touching the filesystem
+type mockMounter struct {
+	mu      sync.Mutex
+	mounted map[string]bool
+}
+
+func (m *mockMounter) Mount(target string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mounted[target] = true
+	return nil
+}
+
+func (m *mockMounter) Unmount(target string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.mounted, target)
+	return nil
+}
+
+func (m *mockMounter) IsMounted(target string) (bool, error) {
+	if _, err := os.Stat(target); err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.mounted[target], nil
+}
+
+func publishRequest(target string, volumeContext map[string]string) *csi.NodePublishVolumeRequest {
+	return &csi.NodePublishVolumeRequest{
+		VolumeId:   "csi-123",
+		TargetPath: target,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		},
+		VolumeContext: volumeContext,
+	}
+}
+
+func TestNodePublishVolume(t *testing.T) {
+	podInfo := map[string]string{
+		podNameKey:      "workload-abc",
+		podNamespaceKey: "default",
+		podUIDKey:       "pod-1",
+		ephemeralKey:    "true",
+	}
+
+	tests := []struct {
+		name          string
+		volumeContext map[string]string
+		centralErr    error
+		code          codes.Code
+	}{
+		{
+			name:          "published",
+			volumeContext: podInfo,
+			code:          codes.OK,
+		},
+		{
+			name:          "persistent volume",
+			volumeContext: map[string]string{podNameKey: "workload-abc", podNamespaceKey: "default", podUIDKey: "pod-1"},
+			code:          codes.InvalidArgument,
+		},
+		{
+			name:          "no pod info",
+			volumeContext: map[string]string{ephemeralKey: "true"},
+			code:          codes.InvalidArgument,
+		},
+		{
+			name:          "refused by kubespiffed",
+			volumeContext: podInfo,
+			centralErr:    &agent.CentralError{StatusCode: 403, Message: "workload is not registered"},
+			code:          codes.PermissionDenied,
+		},
+		{
+			name:          "kubespiffed unavailable",
+			volumeContext: podInfo,
+			centralErr:    &agent.CentralError{StatusCode: 503, Message: "draining"},
+			code:          codes.Unavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			issuer, err := svid.NewSVIDIssuer()
+			require.NoError(t, err)
+			mounter := &mockMounter{mounted: make(map[string]bool)}
+			d := New("node-1", &mockCentral{issuer: issuer, err: tt.centralErr})
+			d.mounter = mounter
+			defer d.stopRenewals()
+
+			target := filepath.Join(t.TempDir(), "mount")
+			_, err = d.NodePublishVolume(ctx, publishRequest(target, tt.volumeContext))
+			require.Equal(t, tt.code, status.Code(err), "%v", err)
+			if tt.code != codes.OK {
+				assert.Empty(t, mounter.mounted)
+				return
+			}
+			assert.True(t, mounter.mounted[target])
+
+			cert, err := tls.LoadX509KeyPair(filepath.Join(target, SVIDFile), filepath.Join(target, SVIDKeyFile))
+			require.NoError(t, err)
+			assert.Equal(t, "spiffe://example.org/workload", cert.Leaf.URIs[0].String())
+			bundle, err := os.ReadFile(filepath.Join(target, BundleFile))
+			require.NoError(t, err)
+			assert.NotEmpty(t, bundle)
+
+			// Republishing leaves the existing SVID in place
+			_, err = d.NodePublishVolume(ctx, publishRequest(target, tt.volumeContext))
+			require.NoError(t, err)
+			again, err := tls.LoadX509KeyPair(filepath.Join(target, SVIDFile), filepath.Join(target, SVIDKeyFile))
+			require.NoError(t, err)
+			assert.Equal(t, cert.Leaf.SerialNumber, again.Leaf.SerialNumber)
+
+			_, err = d.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: "csi-123", TargetPath: target})
+			require.NoError(t, err)
+			assert.Empty(t, mounter.mounted)
+			assert.NoDirExists(t, target)
+			assert.Empty(t, d.volumes)
+
+			// Unpublishing is idempotent
+			_, err = d.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: "csi-123", TargetPath: target})
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestPublishInProgress(t *testing.T) {
+	issuer, err := svid.NewSVIDIssuer()
+	require.NoError(t, err)
+	d := New("node-1", &mockCentral{issuer: issuer})
+	d.mounter = &mockMounter{mounted: make(map[string]bool)}
+	target := filepath.Join(t.TempDir(), "mount")
+
+	// Another call for the volume is refused until the publish finishes
+	_, err = d.reserve("csi-123")
+	require.NoError(t, err)
+	_, err = d.NodePublishVolume(context.Background(), publishRequest(target, map[string]string{
+		podNameKey:      "workload-abc",
+		podNamespaceKey: "default",
+		podUIDKey:       "pod-1",
+		ephemeralKey:    "true",
+	}))
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	_, err = d.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: "csi-123", TargetPath: target})
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	d.release("csi-123")
+	published, err := d.reserve("csi-123")
+	require.NoError(t, err)
+	assert.False(t, published)
+}
+
+func TestRenewStopsWhenRefused(t *testing.T) {
+	d := New("node-1", &mockCentral{err: &agent.CentralError{StatusCode: 403, Message: "identity has been revoked"}})
+	id := agent.PodIdentity{Namespace: "default", Name: "workload-abc", UID: "pod-1"}
+
+	done := make(chan struct{})
+	go func() {
+		d.renew(context.Background(), id, t.TempDir(), time.Now())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("renewal continued after the pod was refused")
+	}
+}
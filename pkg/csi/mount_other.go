@@ -0,0 +1,13 @@
+//go:build !linux
+
+package csi
+
+import "errors"
+
+var errUnsupported = errors.New("tmpfs volumes are only supported on linux")
+
+type tmpfsMounter struct{}
+
+func (tmpfsMounter) Mount(target string) error             { return errUnsupported }
+func (tmpfsMounter) Unmount(target string) error           { return errUnsupported }
+func (tmpfsMounter) IsMounted(target string) (bool, error) { return false, errUnsupported }
@@ -0,0 +1,124 @@
+package events
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	kubespiffescheme "github.com/jsnctl/kubespiffe/pkg/generated/clientset/versioned/scheme"
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const Component = "kubespiffed"
+
+// Event reasons
+const (
+	ReasonIssued        = "SVIDIssued"
+	ReasonNotRegistered = "NotRegistered"
+	ReasonRevoked       = "SVIDRevoked"
+	ReasonExpiring      = "SVIDExpiring"
+)
+
+// SVID types, as they appear in event messages
+const (
+	X509SVID = "X509-SVID"
+	JWTSVID  = "JWT-SVID"
+)
+
+// Scheme resolves the kinds of the objects events are recorded on
+var Scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(Scheme))
+	utilruntime.Must(kubespiffescheme.AddToScheme(Scheme))
+}
+
+// Recorder records Events on the Pods kubespiffed attests, and on their
+// WorkloadRegistrations, so that `kubectl describe` explains why a workload
+// has or hasn't got an SVID. A nil Recorder records nothing
+type Recorder struct {
+	recorder record.EventRecorder
+}
+
+// NewRecorder records events through the API server. The returned func stops
+// recording once queued events are sent
+func NewRecorder(cs kubernetes.Interface) (*Recorder, func()) {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: cs.CoreV1().Events("")})
+	return New(broadcaster.NewRecorder(Scheme, corev1.EventSource{Component: Component})), broadcaster.Shutdown
+}
+
+// New records events with recorder, such as a record.FakeRecorder
+func New(recorder record.EventRecorder) *Recorder {
+	return &Recorder{recorder: recorder}
+}
+
+// podRef refers to the workload's pod, which is known from its PSAT claims
+// without fetching it. Workloads that aren't pods have no reference
+func podRef(w svid.Workload) *corev1.ObjectReference {
+	if w.PodName == "" {
+		return nil
+	}
+	return &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Namespace:  w.Namespace,
+		Name:       w.PodName,
+		UID:        types.UID(w.PodUID),
+	}
+}
+
+// record records an event on the workload's pod and, if known, its
+// WorkloadRegistration, where the pod is named in the message
+func (r *Recorder) record(w svid.Workload, wr *v1alpha1.WorkloadRegistration, eventtype, reason, message string) {
+	if r == nil {
+		return
+	}
+	pod := podRef(w)
+	if pod != nil {
+		r.recorder.Event(pod, eventtype, reason, message)
+	}
+	if wr != nil {
+		if pod != nil {
+			message = fmt.Sprintf("Pod %s/%s: %s", pod.Namespace, pod.Name, message)
+		}
+		r.recorder.Event(wr, eventtype, reason, message)
+	}
+}
+
+// NotRegistered records that no WorkloadRegistration matched the workload
+func (r *Recorder) NotRegistered(w svid.Workload, err error) {
+	message := "No SVID issued: no WorkloadRegistration matches this pod"
+	if err != nil {
+		message = fmt.Sprintf("%s: %v", message, err)
+	}
+	r.record(w, nil, corev1.EventTypeWarning, ReasonNotRegistered, message)
+}
+
+// Revoked records that an SVID was refused because the workload's SPIFFE ID
+// or pod is revoked
+func (r *Recorder) Revoked(w svid.Workload, wr *v1alpha1.WorkloadRegistration, svidType string, err error) {
+	r.record(w, wr, corev1.EventTypeWarning, ReasonRevoked,
+		fmt.Sprintf("No %s issued for %s: %v", svidType, wr.Spec.SPIFFEID, err))
+}
+
+// Issued records that the workload was issued an SVID
+func (r *Recorder) Issued(w svid.Workload, wr *v1alpha1.WorkloadRegistration, svidType string, expiresAt time.Time) {
+	r.record(w, wr, corev1.EventTypeNormal, ReasonIssued,
+		fmt.Sprintf("Issued %s for %s, expiring at %s", svidType, wr.Spec.SPIFFEID, expiresAt.UTC().Format(time.RFC3339)))
+}
+
+// Expiring records that the workload's SVID could not be renewed, and will
+// expire unless a later attempt succeeds
+func (r *Recorder) Expiring(w svid.Workload, wr *v1alpha1.WorkloadRegistration, svidType string, expiresAt time.Time, err error) {
+	r.record(w, wr, corev1.EventTypeWarning, ReasonExpiring,
+		fmt.Sprintf("%s for %s expires at %s and could not be renewed: %v", svidType, wr.Spec.SPIFFEID, expiresAt.UTC().Format(time.RFC3339), err))
+}
@@ -0,0 +1,137 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func drain(fake *record.FakeRecorder) []string {
+	var out []string
+	for {
+		select {
+		case e := <-fake.Events:
+			out = append(out, e)
+		default:
+			return out
+		}
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	pod := svid.Workload{PodName: "payments-7d9f8", PodUID: "uid", Namespace: "shop"}
+	wr := &v1alpha1.WorkloadRegistration{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "kubespiffe.io/v1alpha1", Kind: "WorkloadRegistration"},
+		ObjectMeta: metav1.ObjectMeta{Name: "payments"},
+		Spec:       v1alpha1.WorkloadRegistrationSpec{SPIFFEID: "spiffe://example.org/payments"},
+	}
+	expiry := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		record func(r *Recorder)
+		events []string
+	}{
+		{
+			name:   "not registered",
+			record: func(r *Recorder) { r.NotRegistered(pod, errors.New(`"payments" not found`)) },
+			events: []string{
+				`Warning NotRegistered No SVID issued: no WorkloadRegistration matches this pod: "payments" not found involvedObject{kind=Pod,apiVersion=v1}`,
+			},
+		},
+		{
+			name:   "issued",
+			record: func(r *Recorder) { r.Issued(pod, wr, X509SVID, expiry) },
+			events: []string{
+				"Normal SVIDIssued Issued X509-SVID for spiffe://example.org/payments, expiring at 2025-01-01T12:00:00Z involvedObject{kind=Pod,apiVersion=v1}",
+				"Normal SVIDIssued Pod shop/payments-7d9f8: Issued X509-SVID for spiffe://example.org/payments, expiring at 2025-01-01T12:00:00Z involvedObject{kind=WorkloadRegistration,apiVersion=kubespiffe.io/v1alpha1}",
+			},
+		},
+		{
+			name:   "revoked",
+			record: func(r *Recorder) { r.Revoked(pod, wr, JWTSVID, svid.ErrRevoked) },
+			events: []string{
+				"Warning SVIDRevoked No JWT-SVID issued for spiffe://example.org/payments: " + svid.ErrRevoked.Error() + " involvedObject{kind=Pod,apiVersion=v1}",
+				"Warning SVIDRevoked Pod shop/payments-7d9f8: No JWT-SVID issued for spiffe://example.org/payments: " + svid.ErrRevoked.Error() + " involvedObject{kind=WorkloadRegistration,apiVersion=kubespiffe.io/v1alpha1}",
+			},
+		},
+		{
+			name:   "expiring",
+			record: func(r *Recorder) { r.Expiring(pod, wr, X509SVID, expiry, errors.New("CA unavailable")) },
+			events: []string{
+				"Warning SVIDExpiring X509-SVID for spiffe://example.org/payments expires at 2025-01-01T12:00:00Z and could not be renewed: CA unavailable involvedObject{kind=Pod,apiVersion=v1}",
+				"Warning SVIDExpiring Pod shop/payments-7d9f8: X509-SVID for spiffe://example.org/payments expires at 2025-01-01T12:00:00Z and could not be renewed: CA unavailable involvedObject{kind=WorkloadRegistration,apiVersion=kubespiffe.io/v1alpha1}",
+			},
+		},
+		{
+			name:   "workloads without a pod",
+			record: func(r *Recorder) { r.Issued(svid.Workload{ServiceAccount: "kubespiffed"}, wr, X509SVID, expiry) },
+			events: []string{
+				"Normal SVIDIssued Issued X509-SVID for spiffe://example.org/payments, expiring at 2025-01-01T12:00:00Z involvedObject{kind=WorkloadRegistration,apiVersion=kubespiffe.io/v1alpha1}",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := record.NewFakeRecorder(10)
+			fake.IncludeObject = true
+			tt.record(New(fake))
+			assert.Equal(t, tt.events, drain(fake))
+		})
+	}
+
+	t.Run("nil recorder", func(t *testing.T) {
+		var r *Recorder
+		assert.NotPanics(t, func() { r.Issued(pod, wr, X509SVID, expiry) })
+	})
+}
+
+func TestNewRecorder(t *testing.T) {
+	cs := fake.NewClientset()
+	r, stop := NewRecorder(cs)
+	defer stop()
+
+	// Registrations from the clientset have no TypeMeta, so their kind comes
+	// from Scheme
+	wr := &v1alpha1.WorkloadRegistration{
+		ObjectMeta: metav1.ObjectMeta{Name: "payments", UID: "wr-uid"},
+		Spec:       v1alpha1.WorkloadRegistrationSpec{SPIFFEID: "spiffe://example.org/payments"},
+	}
+	r.Issued(svid.Workload{PodName: "payments-7d9f8", PodUID: "uid", Namespace: "shop"}, wr, X509SVID, time.Now().Add(time.Hour))
+
+	involved := func(namespace string) []corev1.ObjectReference {
+		list, err := cs.CoreV1().Events(namespace).List(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			return nil
+		}
+		var out []corev1.ObjectReference
+		for _, e := range list.Items {
+			assert.Equal(t, ReasonIssued, e.Reason)
+			assert.Equal(t, Component, e.Source.Component)
+			out = append(out, e.InvolvedObject)
+		}
+		return out
+	}
+	require.Eventually(t, func() bool {
+		return len(involved("shop")) == 1 && len(involved(metav1.NamespaceDefault)) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	pod := involved("shop")[0]
+	assert.Equal(t, "Pod", pod.Kind)
+	assert.Equal(t, "payments-7d9f8", pod.Name)
+	registration := involved(metav1.NamespaceDefault)[0]
+	assert.Equal(t, "WorkloadRegistration", registration.Kind)
+	assert.Equal(t, "kubespiffe.io/v1alpha1", registration.APIVersion)
+	assert.Equal(t, "payments", registration.Name)
+}
@@ -0,0 +1,176 @@
+package federation
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+const (
+	x509SVIDUse = "x509-svid"
+	jwtSVIDUse  = "jwt-svid"
+)
+
+// Bundle is a SPIFFE trust bundle: the X.509 and JWT authorities of a single
+// trust domain, as described by the SPIFFE Trust Domain and Bundle spec
+type Bundle struct {
+	TrustDomain     string
+	X509Authorities []*x509.Certificate
+	JWTAuthorities  []svid.JWTAuthority
+	Sequence        uint64
+	RefreshHint     time.Duration
+}
+
+type bundleDocument struct {
+	Keys        []json.RawMessage `json:"keys"`
+	Sequence    uint64            `json:"spiffe_sequence,omitempty"`
+	RefreshHint int64             `json:"spiffe_refresh_hint,omitempty"`
+}
+
+func BundleFromAuthorities(trustDomain string, authorities svid.Authorities, refreshHint time.Duration) *Bundle {
+	return &Bundle{
+		TrustDomain:     trustDomain,
+		X509Authorities: authorities.X509,
+		JWTAuthorities:  authorities.JWT,
+		Sequence:        authorities.Sequence,
+		RefreshHint:     refreshHint,
+	}
+}
+
+// Marshal encodes the bundle as a JWK Set with the SPIFFE extensions
+func (b *Bundle) Marshal() ([]byte, error) {
+	doc := bundleDocument{
+		Keys:        []json.RawMessage{},
+		Sequence:    b.Sequence,
+		RefreshHint: int64(b.RefreshHint / time.Second),
+	}
+
+	for _, cert := range b.X509Authorities {
+		key, err := jwk.New(cert.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("problem with X.509 authority: %w", err)
+		}
+		if err := key.Set(jwk.X509CertChainKey, []string{base64.StdEncoding.EncodeToString(cert.Raw)}); err != nil {
+			return nil, err
+		}
+		raw, err := marshalKey(key, x509SVIDUse)
+		if err != nil {
+			return nil, err
+		}
+		doc.Keys = append(doc.Keys, raw)
+	}
+
+	for _, authority := range b.JWTAuthorities {
+		key, err := jwk.New(authority.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("problem with JWT authority: %w", err)
+		}
+		if err := key.Set(jwk.KeyIDKey, authority.KeyID); err != nil {
+			return nil, err
+		}
+		raw, err := marshalKey(key, jwtSVIDUse)
+		if err != nil {
+			return nil, err
+		}
+		doc.Keys = append(doc.Keys, raw)
+	}
+
+	return json.Marshal(doc)
+}
+
+func marshalKey(key jwk.Key, use string) (json.RawMessage, error) {
+	if err := key.Set(jwk.KeyUsageKey, use); err != nil {
+		return nil, err
+	}
+	return json.Marshal(key)
+}
+
+// ParseBundle decodes a SPIFFE bundle document for the given trust domain
+func ParseBundle(trustDomain string, data []byte) (*Bundle, error) {
+	var doc struct {
+		Keys        []map[string]any `json:"keys"`
+		Sequence    uint64           `json:"spiffe_sequence"`
+		RefreshHint int64            `json:"spiffe_refresh_hint"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("decoding bundle: %w", err)
+	}
+
+	b := &Bundle{
+		TrustDomain: trustDomain,
+		Sequence:    doc.Sequence,
+		RefreshHint: time.Duration(doc.RefreshHint) * time.Second,
+	}
+	for n, keyMap := range doc.Keys {
+		use, _ := keyMap["use"].(string)
+		switch use {
+		case x509SVIDUse:
+			cert, err := parseX509Authority(keyMap)
+			if err != nil {
+				return nil, fmt.Errorf("key %d: %w", n, err)
+			}
+			b.X509Authorities = append(b.X509Authorities, cert)
+		case jwtSVIDUse:
+			authority, err := parseJWTAuthority(keyMap)
+			if err != nil {
+				return nil, fmt.Errorf("key %d: %w", n, err)
+			}
+			b.JWTAuthorities = append(b.JWTAuthorities, authority)
+		default:
+			// The spec requires unknown uses to be ignored
+		}
+	}
+	if len(b.X509Authorities) == 0 && len(b.JWTAuthorities) == 0 {
+		return nil, fmt.Errorf("bundle for %s has no authorities", trustDomain)
+	}
+	return b, nil
+}
+
+func parseX509Authority(keyMap map[string]any) (*x509.Certificate, error) {
+	chain, ok := keyMap["x5c"].([]any)
+	if !ok || len(chain) != 1 {
+		return nil, fmt.Errorf("x509-svid authority must have exactly one x5c entry")
+	}
+	encoded, ok := chain[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid x5c entry")
+	}
+	der, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("decoding x5c: %w", err)
+	}
+	return x509.ParseCertificate(der)
+}
+
+func parseJWTAuthority(keyMap map[string]any) (svid.JWTAuthority, error) {
+	kid, _ := keyMap["kid"].(string)
+	if kid == "" {
+		return svid.JWTAuthority{}, fmt.Errorf("jwt-svid authority is missing kid")
+	}
+
+	// jwx only accepts sig and enc as key uses
+	stripped := make(map[string]any, len(keyMap))
+	for k, v := range keyMap {
+		if k != "use" {
+			stripped[k] = v
+		}
+	}
+	keyData, err := json.Marshal(stripped)
+	if err != nil {
+		return svid.JWTAuthority{}, err
+	}
+	key, err := jwk.ParseKey(keyData)
+	if err != nil {
+		return svid.JWTAuthority{}, fmt.Errorf("parsing JWK: %w", err)
+	}
+	var publicKey any
+	if err := key.Raw(&publicKey); err != nil {
+		return svid.JWTAuthority{}, fmt.Errorf("extracting key: %w", err)
+	}
+	return svid.JWTAuthority{KeyID: kid, PublicKey: publicKey}, nil
+}
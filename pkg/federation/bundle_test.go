@@ -0,0 +1,158 @@
+package federation
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundleRoundTrip(t *testing.T) {
+	issuer, err := svid.NewSVIDIssuer()
+	require.NoError(t, err)
+	require.NoError(t, issuer.RotateCA())
+
+	authorities := issuer.Authorities()
+	doc, err := BundleFromAuthorities("example.org", authorities, DefaultRefreshHint).Marshal()
+	require.NoError(t, err)
+
+	b, err := ParseBundle("example.org", doc)
+	require.NoError(t, err)
+	assert.Equal(t, "example.org", b.TrustDomain)
+	assert.Equal(t, authorities.Sequence, b.Sequence)
+	assert.Equal(t, DefaultRefreshHint, b.RefreshHint)
+	require.Len(t, b.X509Authorities, 2)
+	assert.True(t, authorities.X509[0].Equal(b.X509Authorities[0]))
+	require.Len(t, b.JWTAuthorities, 2)
+	assert.Equal(t, authorities.JWT[0].KeyID, b.JWTAuthorities[0].KeyID)
+	assert.True(t, authorities.JWT[0].PublicKey.(interface{ Equal(crypto.PublicKey) bool }).Equal(b.JWTAuthorities[0].PublicKey))
+}
+
+func TestParseBundle(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		wantErr bool
+	}{
+		{
+			name:    "not JSON",
+			doc:     "not a bundle",
+			wantErr: true,
+		},
+		{
+			name:    "no authorities",
+			doc:     `{"keys": []}`,
+			wantErr: true,
+		},
+		{
+			name:    "only unknown uses",
+			doc:     `{"keys": [{"use": "something-else", "kty": "EC"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "x509-svid without x5c",
+			doc:     `{"keys": [{"use": "x509-svid", "kty": "EC"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "jwt-svid without kid",
+			doc:     `{"keys": [{"use": "jwt-svid", "kty": "EC"}]}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseBundle("example.org", []byte(tt.doc))
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestStoreRejectsOlderSequence(t *testing.T) {
+	store := NewStore()
+	require.NoError(t, store.Set(&Bundle{TrustDomain: "partner.org", Sequence: 2}))
+	require.NoError(t, store.Set(&Bundle{TrustDomain: "partner.org", Sequence: 2}))
+	assert.Error(t, store.Set(&Bundle{TrustDomain: "partner.org", Sequence: 1}))
+
+	b, ok := store.Get("partner.org")
+	require.True(t, ok)
+	assert.Equal(t, uint64(2), b.Sequence)
+}
+
+func mockSPIFFEEndpoint(t *testing.T, issuer *svid.SVIDIssuer, spiffeID string) string {
+	t.Helper()
+	// httptest's StartTLS would install its own certificate ahead of GetCertificate
+	srv := httptest.NewUnstartedServer(NewBundleEndpoint("partner.org", issuer, 0))
+	srv.Listener = tls.NewListener(srv.Listener, SPIFFETLSConfig(issuer, spiffeID))
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return strings.Replace(srv.URL, "http://", "https://", 1)
+}
+
+func TestFetchBundleSPIFFEProfile(t *testing.T) {
+	partner, err := svid.NewSVIDIssuer(svid.WithTrustDomain("partner.org"))
+	require.NoError(t, err)
+	url := mockSPIFFEEndpoint(t, partner, "spiffe://partner.org/kubespiffed")
+	trust := BundleFromAuthorities("partner.org", partner.Authorities(), 0)
+
+	untrusted, err := svid.NewSVIDIssuer(svid.WithTrustDomain("partner.org"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		spiffeID string
+		trust    *Bundle
+		wantErr  bool
+	}{
+		{
+			name:     "authenticated endpoint",
+			spiffeID: "spiffe://partner.org/kubespiffed",
+			trust:    trust,
+		},
+		{
+			name:     "unexpected SPIFFE ID",
+			spiffeID: "spiffe://partner.org/someone-else",
+			trust:    trust,
+			wantErr:  true,
+		},
+		{
+			name:     "untrusted CA",
+			spiffeID: "spiffe://partner.org/kubespiffed",
+			trust:    BundleFromAuthorities("partner.org", untrusted.Authorities(), 0),
+			wantErr:  true,
+		},
+		{
+			name:     "no trust bundle",
+			spiffeID: "spiffe://partner.org/kubespiffed",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := FetchBundle(context.Background(), Endpoint{
+				TrustDomain:      "partner.org",
+				URL:              url,
+				Profile:          ProfileHTTPSSPIFFE,
+				EndpointSPIFFEID: tt.spiffeID,
+			}, tt.trust)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, partner.Authorities().Sequence, b.Sequence)
+		})
+	}
+}
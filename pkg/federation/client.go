@@ -0,0 +1,106 @@
+package federation
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	fetchTimeout   = 10 * time.Second
+	maxBundleBytes = 1 << 20
+)
+
+// Endpoint describes where and how to fetch a foreign trust domain's bundle
+type Endpoint struct {
+	TrustDomain string
+	URL         string
+	Profile     string
+
+	// EndpointSPIFFEID is the SPIFFE ID an https_spiffe endpoint must present
+	EndpointSPIFFEID string
+}
+
+// FetchBundle retrieves a bundle from a SPIFFE bundle endpoint. For the
+// https_spiffe profile, trust is the foreign trust domain's current bundle
+// and is used to authenticate the endpoint
+func FetchBundle(ctx context.Context, endpoint Endpoint, trust *Bundle) (*Bundle, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	switch endpoint.Profile {
+	case ProfileHTTPSWeb:
+	case ProfileHTTPSSPIFFE:
+		if trust == nil {
+			return nil, errors.New("https_spiffe profile requires a trust domain bundle to authenticate the endpoint")
+		}
+		transport.TLSClientConfig = spiffeClientTLSConfig(trust, endpoint.EndpointSPIFFEID)
+	default:
+		return nil, fmt.Errorf("unknown bundle endpoint profile %q", endpoint.Profile)
+	}
+
+	client := &http.Client{
+		Timeout:   fetchTimeout,
+		Transport: transport,
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching bundle: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBundleBytes))
+	if err != nil {
+		return nil, fmt.Errorf("reading bundle: %w", err)
+	}
+	return ParseBundle(endpoint.TrustDomain, data)
+}
+
+// spiffeClientTLSConfig authenticates the server by SPIFFE ID against the
+// X.509 authorities of its trust domain, in place of Web PKI hostname checks
+func spiffeClientTLSConfig(trust *Bundle, spiffeID string) *tls.Config {
+	roots := x509.NewCertPool()
+	for _, ca := range trust.X509Authorities {
+		roots.AddCert(ca)
+	}
+
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("bundle endpoint presented no certificate")
+			}
+			leaf := cs.PeerCertificates[0]
+			intermediates := x509.NewCertPool()
+			for _, c := range cs.PeerCertificates[1:] {
+				intermediates.AddCert(c)
+			}
+			_, err := leaf.Verify(x509.VerifyOptions{
+				Roots:         roots,
+				Intermediates: intermediates,
+				KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			})
+			if err != nil {
+				return fmt.Errorf("verifying bundle endpoint SVID: %w", err)
+			}
+			if len(leaf.URIs) != 1 || leaf.URIs[0].String() != spiffeID {
+				return fmt.Errorf("bundle endpoint SVID does not match %q", spiffeID)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,130 @@
+package federation
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	ProfileHTTPSWeb    = "https_web"
+	ProfileHTTPSSPIFFE = "https_spiffe"
+
+	DefaultRefreshHint = 5 * time.Minute
+)
+
+// BundleEndpoint serves the local trust domain's bundle so that other trust
+// domains can federate with it
+type BundleEndpoint struct {
+	trustDomain string
+	issuer      *svid.SVIDIssuer
+	refreshHint time.Duration
+}
+
+func NewBundleEndpoint(trustDomain string, issuer *svid.SVIDIssuer, refreshHint time.Duration) *BundleEndpoint {
+	if refreshHint <= 0 {
+		refreshHint = DefaultRefreshHint
+	}
+	return &BundleEndpoint{
+		trustDomain: trustDomain,
+		issuer:      issuer,
+		refreshHint: refreshHint,
+	}
+}
+
+func (e *BundleEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	bundle := BundleFromAuthorities(e.trustDomain, e.issuer.Authorities(), e.refreshHint)
+	doc, err := bundle.Marshal()
+	if err != nil {
+		slog.Error("problem marshaling trust bundle", "error", err)
+		http.Error(w, "problem marshaling trust bundle", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(doc)
+}
+
+// WebTLSConfig is the https_web profile: the endpoint presents a certificate
+// from the Web PKI, loaded from disk
+func WebTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading bundle endpoint certificate: %w", err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// SPIFFETLSConfig is the https_spiffe profile: the endpoint presents an
+// X509-SVID for its own SPIFFE ID, issued by the local CA and renewed once
+// half of its lifetime has elapsed
+func SPIFFETLSConfig(issuer *svid.SVIDIssuer, spiffeID string) *tls.Config {
+	source := &selfSVID{
+		issuer:   issuer,
+		spiffeID: spiffeID,
+	}
+	return &tls.Config{
+		GetCertificate: source.getCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+}
+
+type selfSVID struct {
+	mu       sync.Mutex
+	issuer   *svid.SVIDIssuer
+	spiffeID string
+	cert     *tls.Certificate
+}
+
+func (s *selfSVID) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cert != nil {
+		leaf := s.cert.Leaf
+		if time.Now().Before(leaf.NotBefore.Add(leaf.NotAfter.Sub(leaf.NotBefore) / 2)) {
+			return s.cert, nil
+		}
+	}
+
+	wr := &v1alpha1.WorkloadRegistration{
+		ObjectMeta: metav1.ObjectMeta{Name: "kubespiffed"},
+		Spec:       v1alpha1.WorkloadRegistrationSpec{SPIFFEID: s.spiffeID, SVIDType: "X509"},
+	}
+	certDER, keyDER, err := s.issuer.IssueX509SVID(hello.Context(), wr, svid.Workload{ServiceAccount: "kubespiffed"})
+	if err != nil {
+		return nil, fmt.Errorf("issuing bundle endpoint SVID: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(keyDER)
+	if err != nil {
+		return nil, err
+	}
+
+	s.cert = &tls.Certificate{
+		Certificate: [][]byte{certDER},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+	return s.cert, nil
+}
@@ -0,0 +1,102 @@
+package federation
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type bundleKey struct {
+	Use string   `json:"use"`
+	Kty string   `json:"kty"`
+	Kid string   `json:"kid"`
+	X5c []string `json:"x5c"`
+}
+
+type bundleResponse struct {
+	Keys        []bundleKey `json:"keys"`
+	Sequence    uint64      `json:"spiffe_sequence"`
+	RefreshHint int64       `json:"spiffe_refresh_hint"`
+}
+
+func TestBundleEndpoint(t *testing.T) {
+	issuer, err := svid.NewSVIDIssuer()
+	require.NoError(t, err)
+	endpoint := NewBundleEndpoint("example.org", issuer, 0)
+
+	fetch := func() bundleResponse {
+		rec := httptest.NewRecorder()
+		endpoint.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+		var resp bundleResponse
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+		return resp
+	}
+
+	before := fetch()
+	assert.Equal(t, int64(DefaultRefreshHint.Seconds()), before.RefreshHint)
+	require.Len(t, before.Keys, 2)
+
+	x509Key, jwtKey := before.Keys[0], before.Keys[1]
+	assert.Equal(t, "x509-svid", x509Key.Use)
+	assert.Equal(t, "EC", x509Key.Kty)
+	require.Len(t, x509Key.X5c, 1)
+	der, err := base64.StdEncoding.DecodeString(x509Key.X5c[0])
+	require.NoError(t, err)
+	assert.Equal(t, issuer.GetCACert(), der)
+
+	assert.Equal(t, "jwt-svid", jwtKey.Use)
+	assert.NotEmpty(t, jwtKey.Kid)
+	assert.Empty(t, jwtKey.X5c)
+
+	require.NoError(t, issuer.RotateCA())
+	after := fetch()
+	assert.Greater(t, after.Sequence, before.Sequence)
+	assert.Len(t, after.Keys, 4)
+}
+
+func TestSPIFFETLSConfig(t *testing.T) {
+	issuer, err := svid.NewSVIDIssuer()
+	require.NoError(t, err)
+
+	url := mockSPIFFEEndpoint(t, issuer, "spiffe://example.org/kubespiffed")
+
+	roots := x509.NewCertPool()
+	for _, raw := range issuer.GetCACerts() {
+		ca, err := x509.ParseCertificate(raw)
+		require.NoError(t, err)
+		roots.AddCert(ca)
+	}
+
+	// https_spiffe authenticates the endpoint by SPIFFE ID rather than DNS name
+	var peerID string
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+				VerifyConnection: func(cs tls.ConnectionState) error {
+					leaf := cs.PeerCertificates[0]
+					_, err := leaf.Verify(x509.VerifyOptions{Roots: roots})
+					peerID = leaf.URIs[0].String()
+					return err
+				},
+			},
+		},
+	}
+
+	resp, err := client.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "spiffe://example.org/kubespiffed", peerID)
+}
@@ -0,0 +1,91 @@
+package federation
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Store holds the most recently fetched bundle of each foreign trust domain
+type Store struct {
+	mu          sync.RWMutex
+	bundles     map[string]*Bundle
+	subscribers map[chan struct{}]struct{}
+}
+
+func NewStore() *Store {
+	return &Store{
+		bundles:     make(map[string]*Bundle),
+		subscribers: make(map[chan struct{}]struct{}),
+	}
+}
+
+// Subscribe returns a channel signalled whenever a bundle is stored or
+// deleted, and a func to unsubscribe. Signals coalesce, so a subscriber that
+// falls behind sees one
+func (s *Store) Subscribe() (<-chan struct{}, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch := make(chan struct{}, 1)
+	s.subscribers[ch] = struct{}{}
+	return ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.subscribers[ch]; ok {
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// notify must be called with mu held
+func (s *Store) notify() {
+	for ch := range s.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *Store) Get(trustDomain string) (*Bundle, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	b, ok := s.bundles[trustDomain]
+	return b, ok
+}
+
+// Set stores a bundle, refusing one older than the bundle already held so a
+// stale or replayed endpoint response can't roll back the trust domain's keys
+func (s *Store) Set(b *Bundle) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if current, ok := s.bundles[b.TrustDomain]; ok && b.Sequence < current.Sequence {
+		return fmt.Errorf("bundle sequence %d for %s is older than current sequence %d", b.Sequence, b.TrustDomain, current.Sequence)
+	}
+	s.bundles[b.TrustDomain] = b
+	s.notify()
+	return nil
+}
+
+func (s *Store) Delete(trustDomain string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.bundles, trustDomain)
+	s.notify()
+}
+
+// All returns every foreign bundle keyed by trust domain
+func (s *Store) All() map[string]*Bundle {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	all := make(map[string]*Bundle, len(s.bundles))
+	for td, b := range s.bundles {
+		all[td] = b
+	}
+	return all
+}
@@ -0,0 +1,40 @@
+/*
+The kubespiffe Authors 2025
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	v1alpha1 "github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	kubespiffev1alpha1 "github.com/jsnctl/kubespiffe/pkg/generated/clientset/versioned/typed/kubespiffe/v1alpha1"
+	gentype "k8s.io/client-go/gentype"
+)
+
+// fakeFederatedTrustDomains implements FederatedTrustDomainInterface
+type fakeFederatedTrustDomains struct {
+	*gentype.FakeClientWithList[*v1alpha1.FederatedTrustDomain, *v1alpha1.FederatedTrustDomainList]
+	Fake *FakeKubespiffeV1alpha1
+}
+
+func newFakeFederatedTrustDomains(fake *FakeKubespiffeV1alpha1) kubespiffev1alpha1.FederatedTrustDomainInterface {
+	return &fakeFederatedTrustDomains{
+		gentype.NewFakeClientWithList[*v1alpha1.FederatedTrustDomain, *v1alpha1.FederatedTrustDomainList](
+			fake.Fake,
+			"",
+			v1alpha1.SchemeGroupVersion.WithResource("federatedtrustdomains"),
+			v1alpha1.SchemeGroupVersion.WithKind("FederatedTrustDomain"),
+			func() *v1alpha1.FederatedTrustDomain { return &v1alpha1.FederatedTrustDomain{} },
+			func() *v1alpha1.FederatedTrustDomainList { return &v1alpha1.FederatedTrustDomainList{} },
+			func(dst, src *v1alpha1.FederatedTrustDomainList) { dst.ListMeta = src.ListMeta },
+			func(list *v1alpha1.FederatedTrustDomainList) []*v1alpha1.FederatedTrustDomain {
+				return gentype.ToPointerSlice(list.Items)
+			},
+			func(list *v1alpha1.FederatedTrustDomainList, items []*v1alpha1.FederatedTrustDomain) {
+				list.Items = gentype.FromPointerSlice(items)
+			},
+		),
+		fake,
+	}
+}
@@ -16,6 +16,18 @@ type FakeKubespiffeV1alpha1 struct {
 	*testing.Fake
 }
 
+func (c *FakeKubespiffeV1alpha1) FederatedTrustDomains() v1alpha1.FederatedTrustDomainInterface {
+	return newFakeFederatedTrustDomains(c)
+}
+
+func (c *FakeKubespiffeV1alpha1) SVIDRevocations() v1alpha1.SVIDRevocationInterface {
+	return newFakeSVIDRevocations(c)
+}
+
+func (c *FakeKubespiffeV1alpha1) SpiffeAuthorizationPolicies() v1alpha1.SpiffeAuthorizationPolicyInterface {
+	return newFakeSpiffeAuthorizationPolicies(c)
+}
+
 func (c *FakeKubespiffeV1alpha1) WorkloadRegistrations(namespace string) v1alpha1.WorkloadRegistrationInterface {
 	return newFakeWorkloadRegistrations(c, namespace)
 }
@@ -0,0 +1,40 @@
+/*
+The kubespiffe Authors 2025
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	v1alpha1 "github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	kubespiffev1alpha1 "github.com/jsnctl/kubespiffe/pkg/generated/clientset/versioned/typed/kubespiffe/v1alpha1"
+	gentype "k8s.io/client-go/gentype"
+)
+
+// fakeSpiffeAuthorizationPolicies implements SpiffeAuthorizationPolicyInterface
+type fakeSpiffeAuthorizationPolicies struct {
+	*gentype.FakeClientWithList[*v1alpha1.SpiffeAuthorizationPolicy, *v1alpha1.SpiffeAuthorizationPolicyList]
+	Fake *FakeKubespiffeV1alpha1
+}
+
+func newFakeSpiffeAuthorizationPolicies(fake *FakeKubespiffeV1alpha1) kubespiffev1alpha1.SpiffeAuthorizationPolicyInterface {
+	return &fakeSpiffeAuthorizationPolicies{
+		gentype.NewFakeClientWithList[*v1alpha1.SpiffeAuthorizationPolicy, *v1alpha1.SpiffeAuthorizationPolicyList](
+			fake.Fake,
+			"",
+			v1alpha1.SchemeGroupVersion.WithResource("spiffeauthorizationpolicies"),
+			v1alpha1.SchemeGroupVersion.WithKind("SpiffeAuthorizationPolicy"),
+			func() *v1alpha1.SpiffeAuthorizationPolicy { return &v1alpha1.SpiffeAuthorizationPolicy{} },
+			func() *v1alpha1.SpiffeAuthorizationPolicyList { return &v1alpha1.SpiffeAuthorizationPolicyList{} },
+			func(dst, src *v1alpha1.SpiffeAuthorizationPolicyList) { dst.ListMeta = src.ListMeta },
+			func(list *v1alpha1.SpiffeAuthorizationPolicyList) []*v1alpha1.SpiffeAuthorizationPolicy {
+				return gentype.ToPointerSlice(list.Items)
+			},
+			func(list *v1alpha1.SpiffeAuthorizationPolicyList, items []*v1alpha1.SpiffeAuthorizationPolicy) {
+				list.Items = gentype.FromPointerSlice(items)
+			},
+		),
+		fake,
+	}
+}
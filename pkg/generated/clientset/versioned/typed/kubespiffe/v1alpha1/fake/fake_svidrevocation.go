@@ -0,0 +1,40 @@
+/*
+The kubespiffe Authors 2025
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	v1alpha1 "github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	kubespiffev1alpha1 "github.com/jsnctl/kubespiffe/pkg/generated/clientset/versioned/typed/kubespiffe/v1alpha1"
+	gentype "k8s.io/client-go/gentype"
+)
+
+// fakeSVIDRevocations implements SVIDRevocationInterface
+type fakeSVIDRevocations struct {
+	*gentype.FakeClientWithList[*v1alpha1.SVIDRevocation, *v1alpha1.SVIDRevocationList]
+	Fake *FakeKubespiffeV1alpha1
+}
+
+func newFakeSVIDRevocations(fake *FakeKubespiffeV1alpha1) kubespiffev1alpha1.SVIDRevocationInterface {
+	return &fakeSVIDRevocations{
+		gentype.NewFakeClientWithList[*v1alpha1.SVIDRevocation, *v1alpha1.SVIDRevocationList](
+			fake.Fake,
+			"",
+			v1alpha1.SchemeGroupVersion.WithResource("svidrevocations"),
+			v1alpha1.SchemeGroupVersion.WithKind("SVIDRevocation"),
+			func() *v1alpha1.SVIDRevocation { return &v1alpha1.SVIDRevocation{} },
+			func() *v1alpha1.SVIDRevocationList { return &v1alpha1.SVIDRevocationList{} },
+			func(dst, src *v1alpha1.SVIDRevocationList) { dst.ListMeta = src.ListMeta },
+			func(list *v1alpha1.SVIDRevocationList) []*v1alpha1.SVIDRevocation {
+				return gentype.ToPointerSlice(list.Items)
+			},
+			func(list *v1alpha1.SVIDRevocationList, items []*v1alpha1.SVIDRevocation) {
+				list.Items = gentype.FromPointerSlice(items)
+			},
+		),
+		fake,
+	}
+}
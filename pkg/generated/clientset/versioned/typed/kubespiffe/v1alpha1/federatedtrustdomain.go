@@ -0,0 +1,60 @@
+/*
+The kubespiffe Authors 2025
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	context "context"
+
+	kubespiffev1alpha1 "github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	scheme "github.com/jsnctl/kubespiffe/pkg/generated/clientset/versioned/scheme"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	gentype "k8s.io/client-go/gentype"
+)
+
+// FederatedTrustDomainsGetter has a method to return a FederatedTrustDomainInterface.
+// A group's client should implement this interface.
+type FederatedTrustDomainsGetter interface {
+	FederatedTrustDomains() FederatedTrustDomainInterface
+}
+
+// FederatedTrustDomainInterface has methods to work with FederatedTrustDomain resources.
+type FederatedTrustDomainInterface interface {
+	Create(ctx context.Context, federatedTrustDomain *kubespiffev1alpha1.FederatedTrustDomain, opts v1.CreateOptions) (*kubespiffev1alpha1.FederatedTrustDomain, error)
+	Update(ctx context.Context, federatedTrustDomain *kubespiffev1alpha1.FederatedTrustDomain, opts v1.UpdateOptions) (*kubespiffev1alpha1.FederatedTrustDomain, error)
+	// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+	UpdateStatus(ctx context.Context, federatedTrustDomain *kubespiffev1alpha1.FederatedTrustDomain, opts v1.UpdateOptions) (*kubespiffev1alpha1.FederatedTrustDomain, error)
+	Delete(ctx context.Context, name string, opts v1.DeleteOptions) error
+	DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error
+	Get(ctx context.Context, name string, opts v1.GetOptions) (*kubespiffev1alpha1.FederatedTrustDomain, error)
+	List(ctx context.Context, opts v1.ListOptions) (*kubespiffev1alpha1.FederatedTrustDomainList, error)
+	Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error)
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *kubespiffev1alpha1.FederatedTrustDomain, err error)
+	FederatedTrustDomainExpansion
+}
+
+// federatedTrustDomains implements FederatedTrustDomainInterface
+type federatedTrustDomains struct {
+	*gentype.ClientWithList[*kubespiffev1alpha1.FederatedTrustDomain, *kubespiffev1alpha1.FederatedTrustDomainList]
+}
+
+// newFederatedTrustDomains returns a FederatedTrustDomains
+func newFederatedTrustDomains(c *KubespiffeV1alpha1Client) *federatedTrustDomains {
+	return &federatedTrustDomains{
+		gentype.NewClientWithList[*kubespiffev1alpha1.FederatedTrustDomain, *kubespiffev1alpha1.FederatedTrustDomainList](
+			"federatedtrustdomains",
+			c.RESTClient(),
+			scheme.ParameterCodec,
+			"",
+			func() *kubespiffev1alpha1.FederatedTrustDomain { return &kubespiffev1alpha1.FederatedTrustDomain{} },
+			func() *kubespiffev1alpha1.FederatedTrustDomainList {
+				return &kubespiffev1alpha1.FederatedTrustDomainList{}
+			},
+		),
+	}
+}
@@ -6,4 +6,10 @@ The kubespiffe Authors 2025
 
 package v1alpha1
 
+type FederatedTrustDomainExpansion interface{}
+
+type SVIDRevocationExpansion interface{}
+
+type SpiffeAuthorizationPolicyExpansion interface{}
+
 type WorkloadRegistrationExpansion interface{}
@@ -16,6 +16,9 @@ import (
 
 type KubespiffeV1alpha1Interface interface {
 	RESTClient() rest.Interface
+	FederatedTrustDomainsGetter
+	SVIDRevocationsGetter
+	SpiffeAuthorizationPoliciesGetter
 	WorkloadRegistrationsGetter
 }
 
@@ -24,6 +27,18 @@ type KubespiffeV1alpha1Client struct {
 	restClient rest.Interface
 }
 
+func (c *KubespiffeV1alpha1Client) FederatedTrustDomains() FederatedTrustDomainInterface {
+	return newFederatedTrustDomains(c)
+}
+
+func (c *KubespiffeV1alpha1Client) SVIDRevocations() SVIDRevocationInterface {
+	return newSVIDRevocations(c)
+}
+
+func (c *KubespiffeV1alpha1Client) SpiffeAuthorizationPolicies() SpiffeAuthorizationPolicyInterface {
+	return newSpiffeAuthorizationPolicies(c)
+}
+
 func (c *KubespiffeV1alpha1Client) WorkloadRegistrations(namespace string) WorkloadRegistrationInterface {
 	return newWorkloadRegistrations(c, namespace)
 }
@@ -0,0 +1,60 @@
+/*
+The kubespiffe Authors 2025
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	context "context"
+
+	kubespiffev1alpha1 "github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	scheme "github.com/jsnctl/kubespiffe/pkg/generated/clientset/versioned/scheme"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	gentype "k8s.io/client-go/gentype"
+)
+
+// SpiffeAuthorizationPoliciesGetter has a method to return a SpiffeAuthorizationPolicyInterface.
+// A group's client should implement this interface.
+type SpiffeAuthorizationPoliciesGetter interface {
+	SpiffeAuthorizationPolicies() SpiffeAuthorizationPolicyInterface
+}
+
+// SpiffeAuthorizationPolicyInterface has methods to work with SpiffeAuthorizationPolicy resources.
+type SpiffeAuthorizationPolicyInterface interface {
+	Create(ctx context.Context, spiffeAuthorizationPolicy *kubespiffev1alpha1.SpiffeAuthorizationPolicy, opts v1.CreateOptions) (*kubespiffev1alpha1.SpiffeAuthorizationPolicy, error)
+	Update(ctx context.Context, spiffeAuthorizationPolicy *kubespiffev1alpha1.SpiffeAuthorizationPolicy, opts v1.UpdateOptions) (*kubespiffev1alpha1.SpiffeAuthorizationPolicy, error)
+	Delete(ctx context.Context, name string, opts v1.DeleteOptions) error
+	DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error
+	Get(ctx context.Context, name string, opts v1.GetOptions) (*kubespiffev1alpha1.SpiffeAuthorizationPolicy, error)
+	List(ctx context.Context, opts v1.ListOptions) (*kubespiffev1alpha1.SpiffeAuthorizationPolicyList, error)
+	Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error)
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *kubespiffev1alpha1.SpiffeAuthorizationPolicy, err error)
+	SpiffeAuthorizationPolicyExpansion
+}
+
+// spiffeAuthorizationPolicies implements SpiffeAuthorizationPolicyInterface
+type spiffeAuthorizationPolicies struct {
+	*gentype.ClientWithList[*kubespiffev1alpha1.SpiffeAuthorizationPolicy, *kubespiffev1alpha1.SpiffeAuthorizationPolicyList]
+}
+
+// newSpiffeAuthorizationPolicies returns a SpiffeAuthorizationPolicies
+func newSpiffeAuthorizationPolicies(c *KubespiffeV1alpha1Client) *spiffeAuthorizationPolicies {
+	return &spiffeAuthorizationPolicies{
+		gentype.NewClientWithList[*kubespiffev1alpha1.SpiffeAuthorizationPolicy, *kubespiffev1alpha1.SpiffeAuthorizationPolicyList](
+			"spiffeauthorizationpolicies",
+			c.RESTClient(),
+			scheme.ParameterCodec,
+			"",
+			func() *kubespiffev1alpha1.SpiffeAuthorizationPolicy {
+				return &kubespiffev1alpha1.SpiffeAuthorizationPolicy{}
+			},
+			func() *kubespiffev1alpha1.SpiffeAuthorizationPolicyList {
+				return &kubespiffev1alpha1.SpiffeAuthorizationPolicyList{}
+			},
+		),
+	}
+}
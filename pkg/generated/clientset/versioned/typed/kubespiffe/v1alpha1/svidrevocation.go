@@ -0,0 +1,58 @@
+/*
+The kubespiffe Authors 2025
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	context "context"
+
+	kubespiffev1alpha1 "github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	scheme "github.com/jsnctl/kubespiffe/pkg/generated/clientset/versioned/scheme"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	gentype "k8s.io/client-go/gentype"
+)
+
+// SVIDRevocationsGetter has a method to return a SVIDRevocationInterface.
+// A group's client should implement this interface.
+type SVIDRevocationsGetter interface {
+	SVIDRevocations() SVIDRevocationInterface
+}
+
+// SVIDRevocationInterface has methods to work with SVIDRevocation resources.
+type SVIDRevocationInterface interface {
+	Create(ctx context.Context, sVIDRevocation *kubespiffev1alpha1.SVIDRevocation, opts v1.CreateOptions) (*kubespiffev1alpha1.SVIDRevocation, error)
+	Update(ctx context.Context, sVIDRevocation *kubespiffev1alpha1.SVIDRevocation, opts v1.UpdateOptions) (*kubespiffev1alpha1.SVIDRevocation, error)
+	// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+	UpdateStatus(ctx context.Context, sVIDRevocation *kubespiffev1alpha1.SVIDRevocation, opts v1.UpdateOptions) (*kubespiffev1alpha1.SVIDRevocation, error)
+	Delete(ctx context.Context, name string, opts v1.DeleteOptions) error
+	DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error
+	Get(ctx context.Context, name string, opts v1.GetOptions) (*kubespiffev1alpha1.SVIDRevocation, error)
+	List(ctx context.Context, opts v1.ListOptions) (*kubespiffev1alpha1.SVIDRevocationList, error)
+	Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error)
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *kubespiffev1alpha1.SVIDRevocation, err error)
+	SVIDRevocationExpansion
+}
+
+// sVIDRevocations implements SVIDRevocationInterface
+type sVIDRevocations struct {
+	*gentype.ClientWithList[*kubespiffev1alpha1.SVIDRevocation, *kubespiffev1alpha1.SVIDRevocationList]
+}
+
+// newSVIDRevocations returns a SVIDRevocations
+func newSVIDRevocations(c *KubespiffeV1alpha1Client) *sVIDRevocations {
+	return &sVIDRevocations{
+		gentype.NewClientWithList[*kubespiffev1alpha1.SVIDRevocation, *kubespiffev1alpha1.SVIDRevocationList](
+			"svidrevocations",
+			c.RESTClient(),
+			scheme.ParameterCodec,
+			"",
+			func() *kubespiffev1alpha1.SVIDRevocation { return &kubespiffev1alpha1.SVIDRevocation{} },
+			func() *kubespiffev1alpha1.SVIDRevocationList { return &kubespiffev1alpha1.SVIDRevocationList{} },
+		),
+	}
+}
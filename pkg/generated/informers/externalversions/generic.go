@@ -41,6 +41,12 @@ func (f *genericInformer) Lister() cache.GenericLister {
 func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource) (GenericInformer, error) {
 	switch resource {
 	// Group=kubespiffe.io, Version=v1alpha1
+	case v1alpha1.SchemeGroupVersion.WithResource("federatedtrustdomains"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Kubespiffe().V1alpha1().FederatedTrustDomains().Informer()}, nil
+	case v1alpha1.SchemeGroupVersion.WithResource("svidrevocations"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Kubespiffe().V1alpha1().SVIDRevocations().Informer()}, nil
+	case v1alpha1.SchemeGroupVersion.WithResource("spiffeauthorizationpolicies"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Kubespiffe().V1alpha1().SpiffeAuthorizationPolicies().Informer()}, nil
 	case v1alpha1.SchemeGroupVersion.WithResource("workloadregistrations"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Kubespiffe().V1alpha1().WorkloadRegistrations().Informer()}, nil
 
@@ -12,6 +12,12 @@ import (
 
 // Interface provides access to all the informers in this group version.
 type Interface interface {
+	// FederatedTrustDomains returns a FederatedTrustDomainInformer.
+	FederatedTrustDomains() FederatedTrustDomainInformer
+	// SVIDRevocations returns a SVIDRevocationInformer.
+	SVIDRevocations() SVIDRevocationInformer
+	// SpiffeAuthorizationPolicies returns a SpiffeAuthorizationPolicyInformer.
+	SpiffeAuthorizationPolicies() SpiffeAuthorizationPolicyInformer
 	// WorkloadRegistrations returns a WorkloadRegistrationInformer.
 	WorkloadRegistrations() WorkloadRegistrationInformer
 }
@@ -27,6 +33,21 @@ func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakList
 	return &version{factory: f, namespace: namespace, tweakListOptions: tweakListOptions}
 }
 
+// FederatedTrustDomains returns a FederatedTrustDomainInformer.
+func (v *version) FederatedTrustDomains() FederatedTrustDomainInformer {
+	return &federatedTrustDomainInformer{factory: v.factory, tweakListOptions: v.tweakListOptions}
+}
+
+// SVIDRevocations returns a SVIDRevocationInformer.
+func (v *version) SVIDRevocations() SVIDRevocationInformer {
+	return &sVIDRevocationInformer{factory: v.factory, tweakListOptions: v.tweakListOptions}
+}
+
+// SpiffeAuthorizationPolicies returns a SpiffeAuthorizationPolicyInformer.
+func (v *version) SpiffeAuthorizationPolicies() SpiffeAuthorizationPolicyInformer {
+	return &spiffeAuthorizationPolicyInformer{factory: v.factory, tweakListOptions: v.tweakListOptions}
+}
+
 // WorkloadRegistrations returns a WorkloadRegistrationInformer.
 func (v *version) WorkloadRegistrations() WorkloadRegistrationInformer {
 	return &workloadRegistrationInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
@@ -0,0 +1,89 @@
+/*
+The kubespiffe Authors 2025
+*/
+
+// Code generated by informer-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	context "context"
+	time "time"
+
+	apiskubespiffev1alpha1 "github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	versioned "github.com/jsnctl/kubespiffe/pkg/generated/clientset/versioned"
+	internalinterfaces "github.com/jsnctl/kubespiffe/pkg/generated/informers/externalversions/internalinterfaces"
+	kubespiffev1alpha1 "github.com/jsnctl/kubespiffe/pkg/generated/listers/kubespiffe/v1alpha1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	watch "k8s.io/apimachinery/pkg/watch"
+	cache "k8s.io/client-go/tools/cache"
+)
+
+// SpiffeAuthorizationPolicyInformer provides access to a shared informer and lister for
+// SpiffeAuthorizationPolicies.
+type SpiffeAuthorizationPolicyInformer interface {
+	Informer() cache.SharedIndexInformer
+	Lister() kubespiffev1alpha1.SpiffeAuthorizationPolicyLister
+}
+
+type spiffeAuthorizationPolicyInformer struct {
+	factory          internalinterfaces.SharedInformerFactory
+	tweakListOptions internalinterfaces.TweakListOptionsFunc
+}
+
+// NewSpiffeAuthorizationPolicyInformer constructs a new informer for SpiffeAuthorizationPolicy type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewSpiffeAuthorizationPolicyInformer(client versioned.Interface, resyncPeriod time.Duration, indexers cache.Indexers) cache.SharedIndexInformer {
+	return NewFilteredSpiffeAuthorizationPolicyInformer(client, resyncPeriod, indexers, nil)
+}
+
+// NewFilteredSpiffeAuthorizationPolicyInformer constructs a new informer for SpiffeAuthorizationPolicy type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewFilteredSpiffeAuthorizationPolicyInformer(client versioned.Interface, resyncPeriod time.Duration, indexers cache.Indexers, tweakListOptions internalinterfaces.TweakListOptionsFunc) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options v1.ListOptions) (runtime.Object, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.KubespiffeV1alpha1().SpiffeAuthorizationPolicies().List(context.Background(), options)
+			},
+			WatchFunc: func(options v1.ListOptions) (watch.Interface, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.KubespiffeV1alpha1().SpiffeAuthorizationPolicies().Watch(context.Background(), options)
+			},
+			ListWithContextFunc: func(ctx context.Context, options v1.ListOptions) (runtime.Object, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.KubespiffeV1alpha1().SpiffeAuthorizationPolicies().List(ctx, options)
+			},
+			WatchFuncWithContext: func(ctx context.Context, options v1.ListOptions) (watch.Interface, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.KubespiffeV1alpha1().SpiffeAuthorizationPolicies().Watch(ctx, options)
+			},
+		},
+		&apiskubespiffev1alpha1.SpiffeAuthorizationPolicy{},
+		resyncPeriod,
+		indexers,
+	)
+}
+
+func (f *spiffeAuthorizationPolicyInformer) defaultInformer(client versioned.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+	return NewFilteredSpiffeAuthorizationPolicyInformer(client, resyncPeriod, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, f.tweakListOptions)
+}
+
+func (f *spiffeAuthorizationPolicyInformer) Informer() cache.SharedIndexInformer {
+	return f.factory.InformerFor(&apiskubespiffev1alpha1.SpiffeAuthorizationPolicy{}, f.defaultInformer)
+}
+
+func (f *spiffeAuthorizationPolicyInformer) Lister() kubespiffev1alpha1.SpiffeAuthorizationPolicyLister {
+	return kubespiffev1alpha1.NewSpiffeAuthorizationPolicyLister(f.Informer().GetIndexer())
+}
@@ -6,6 +6,18 @@ The kubespiffe Authors 2025
 
 package v1alpha1
 
+// FederatedTrustDomainListerExpansion allows custom methods to be added to
+// FederatedTrustDomainLister.
+type FederatedTrustDomainListerExpansion interface{}
+
+// SVIDRevocationListerExpansion allows custom methods to be added to
+// SVIDRevocationLister.
+type SVIDRevocationListerExpansion interface{}
+
+// SpiffeAuthorizationPolicyListerExpansion allows custom methods to be added to
+// SpiffeAuthorizationPolicyLister.
+type SpiffeAuthorizationPolicyListerExpansion interface{}
+
 // WorkloadRegistrationListerExpansion allows custom methods to be added to
 // WorkloadRegistrationLister.
 type WorkloadRegistrationListerExpansion interface{}
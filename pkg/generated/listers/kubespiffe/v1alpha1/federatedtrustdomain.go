@@ -0,0 +1,36 @@
+/*
+The kubespiffe Authors 2025
+*/
+
+// Code generated by lister-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	kubespiffev1alpha1 "github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	labels "k8s.io/apimachinery/pkg/labels"
+	listers "k8s.io/client-go/listers"
+	cache "k8s.io/client-go/tools/cache"
+)
+
+// FederatedTrustDomainLister helps list FederatedTrustDomains.
+// All objects returned here must be treated as read-only.
+type FederatedTrustDomainLister interface {
+	// List lists all FederatedTrustDomains in the indexer.
+	// Objects returned here must be treated as read-only.
+	List(selector labels.Selector) (ret []*kubespiffev1alpha1.FederatedTrustDomain, err error)
+	// Get retrieves the FederatedTrustDomain from the index for a given name.
+	// Objects returned here must be treated as read-only.
+	Get(name string) (*kubespiffev1alpha1.FederatedTrustDomain, error)
+	FederatedTrustDomainListerExpansion
+}
+
+// federatedTrustDomainLister implements the FederatedTrustDomainLister interface.
+type federatedTrustDomainLister struct {
+	listers.ResourceIndexer[*kubespiffev1alpha1.FederatedTrustDomain]
+}
+
+// NewFederatedTrustDomainLister returns a new FederatedTrustDomainLister.
+func NewFederatedTrustDomainLister(indexer cache.Indexer) FederatedTrustDomainLister {
+	return &federatedTrustDomainLister{listers.New[*kubespiffev1alpha1.FederatedTrustDomain](indexer, kubespiffev1alpha1.Resource("federatedtrustdomain"))}
+}
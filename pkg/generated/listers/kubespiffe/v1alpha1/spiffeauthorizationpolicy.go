@@ -0,0 +1,36 @@
+/*
+The kubespiffe Authors 2025
+*/
+
+// Code generated by lister-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	kubespiffev1alpha1 "github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	labels "k8s.io/apimachinery/pkg/labels"
+	listers "k8s.io/client-go/listers"
+	cache "k8s.io/client-go/tools/cache"
+)
+
+// SpiffeAuthorizationPolicyLister helps list SpiffeAuthorizationPolicies.
+// All objects returned here must be treated as read-only.
+type SpiffeAuthorizationPolicyLister interface {
+	// List lists all SpiffeAuthorizationPolicies in the indexer.
+	// Objects returned here must be treated as read-only.
+	List(selector labels.Selector) (ret []*kubespiffev1alpha1.SpiffeAuthorizationPolicy, err error)
+	// Get retrieves the SpiffeAuthorizationPolicy from the index for a given name.
+	// Objects returned here must be treated as read-only.
+	Get(name string) (*kubespiffev1alpha1.SpiffeAuthorizationPolicy, error)
+	SpiffeAuthorizationPolicyListerExpansion
+}
+
+// spiffeAuthorizationPolicyLister implements the SpiffeAuthorizationPolicyLister interface.
+type spiffeAuthorizationPolicyLister struct {
+	listers.ResourceIndexer[*kubespiffev1alpha1.SpiffeAuthorizationPolicy]
+}
+
+// NewSpiffeAuthorizationPolicyLister returns a new SpiffeAuthorizationPolicyLister.
+func NewSpiffeAuthorizationPolicyLister(indexer cache.Indexer) SpiffeAuthorizationPolicyLister {
+	return &spiffeAuthorizationPolicyLister{listers.New[*kubespiffev1alpha1.SpiffeAuthorizationPolicy](indexer, kubespiffev1alpha1.Resource("spiffeauthorizationpolicy"))}
+}
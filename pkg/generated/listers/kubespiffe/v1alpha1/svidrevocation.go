@@ -0,0 +1,36 @@
+/*
+The kubespiffe Authors 2025
+*/
+
+// Code generated by lister-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	kubespiffev1alpha1 "github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	labels "k8s.io/apimachinery/pkg/labels"
+	listers "k8s.io/client-go/listers"
+	cache "k8s.io/client-go/tools/cache"
+)
+
+// SVIDRevocationLister helps list SVIDRevocations.
+// All objects returned here must be treated as read-only.
+type SVIDRevocationLister interface {
+	// List lists all SVIDRevocations in the indexer.
+	// Objects returned here must be treated as read-only.
+	List(selector labels.Selector) (ret []*kubespiffev1alpha1.SVIDRevocation, err error)
+	// Get retrieves the SVIDRevocation from the index for a given name.
+	// Objects returned here must be treated as read-only.
+	Get(name string) (*kubespiffev1alpha1.SVIDRevocation, error)
+	SVIDRevocationListerExpansion
+}
+
+// sVIDRevocationLister implements the SVIDRevocationLister interface.
+type sVIDRevocationLister struct {
+	listers.ResourceIndexer[*kubespiffev1alpha1.SVIDRevocation]
+}
+
+// NewSVIDRevocationLister returns a new SVIDRevocationLister.
+func NewSVIDRevocationLister(indexer cache.Indexer) SVIDRevocationLister {
+	return &sVIDRevocationLister{listers.New[*kubespiffev1alpha1.SVIDRevocation](indexer, kubespiffev1alpha1.Resource("svidrevocation"))}
+}
@@ -0,0 +1,347 @@
+package ha
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	DefaultCASecretName = "kubespiffe-ca"
+
+	// RotationCheckInterval is how often the leader checks whether the CA is
+	// due for rotation
+	RotationCheckInterval = time.Minute
+
+	secretResync = 10 * time.Minute
+)
+
+// Keys of the CA Secret
+const (
+	keyCACert        = "ca.crt"
+	keyCAKey         = "ca.key"
+	keyRetiredCAs    = "retired-cas.crt"
+	keyRetiredCAKeys = "retired-cas.key"
+	keyJWTKey        = "jwt.key"
+	keyJWTKeyID      = "jwt.kid"
+	keyRetiredJWTKey = "retired-jwt.key"
+	keyRetiredJWTID  = "retired-jwt.kid"
+	keySequence      = "sequence"
+
+	// headerCA is the PEM header naming the CA a retired key belongs to
+	headerCA = "CA"
+)
+
+// SecretCA keeps the trust domain's signing material in a Secret, so that
+// every replica signs with the same CA and publishes the same bundle
+type SecretCA struct {
+	Client    kubernetes.Interface
+	Namespace string
+	Name      string
+	// TTL is how long generated CAs are valid for
+	TTL time.Duration
+}
+
+// Load returns the material in the Secret, creating the Secret with fresh
+// material if it doesn't exist. Of several replicas starting together, the
+// first to create it wins and the rest load what it created
+func (s *SecretCA) Load(ctx context.Context) (svid.Material, error) {
+	secrets := s.Client.CoreV1().Secrets(s.Namespace)
+	secret, err := secrets.Get(ctx, s.Name, metav1.GetOptions{})
+	if err == nil {
+		return decodeMaterial(secret.Data)
+	}
+	if !apierrors.IsNotFound(err) {
+		return svid.Material{}, fmt.Errorf("problem getting CA secret: %w", err)
+	}
+
+	m, err := svid.GenerateMaterial(s.TTL)
+	if err != nil {
+		return svid.Material{}, err
+	}
+	data, err := encodeMaterial(m)
+	if err != nil {
+		return svid.Material{}, err
+	}
+	_, err = secrets.Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: s.Namespace, Name: s.Name},
+		Type:       corev1.SecretTypeOpaque,
+		Data:       data,
+	}, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return s.Load(ctx)
+	}
+	if err != nil {
+		return svid.Material{}, fmt.Errorf("problem creating CA secret: %w", err)
+	}
+	slog.Info("🔑 CA created", "secret", s.Name, "expiry", m.CACert.NotAfter)
+	return m, nil
+}
+
+// Watch applies the material in the Secret to issuer whenever another replica
+// rotates it, until ctx is done
+func (s *SecretCA) Watch(ctx context.Context, issuer *svid.SVIDIssuer) (cache.InformerSynced, error) {
+	factory := informers.NewSharedInformerFactoryWithOptions(s.Client, secretResync,
+		informers.WithNamespace(s.Namespace),
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.FieldSelector = fields.OneTermEqualSelector("metadata.name", s.Name).String()
+		}),
+	)
+	informer := factory.Core().V1().Secrets().Informer()
+	apply := func(obj any) {
+		secret, ok := obj.(*corev1.Secret)
+		if !ok {
+			return
+		}
+		m, err := decodeMaterial(secret.Data)
+		if err == nil {
+			err = issuer.SetMaterial(m)
+		}
+		if err != nil {
+			slog.Error("problem applying CA secret", "secret", s.Name, "error", err)
+		}
+	}
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    apply,
+		UpdateFunc: func(_, obj any) { apply(obj) },
+	})
+	if err != nil {
+		return nil, err
+	}
+	factory.Start(ctx.Done())
+	return informer.HasSynced, nil
+}
+
+// Rotate writes a rotation of the material in the Secret and applies it to
+// issuer. The write is conditional on the Secret being unchanged since it was
+// read, so a replica that has lost the Lease can't overwrite a newer CA
+func (s *SecretCA) Rotate(ctx context.Context, issuer *svid.SVIDIssuer) error {
+	secrets := s.Client.CoreV1().Secrets(s.Namespace)
+	secret, err := secrets.Get(ctx, s.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("problem getting CA secret: %w", err)
+	}
+	m, err := decodeMaterial(secret.Data)
+	if err != nil {
+		return err
+	}
+	next, err := m.Rotate(s.TTL)
+	if err != nil {
+		return err
+	}
+	secret.Data, err = encodeMaterial(next)
+	if err != nil {
+		return err
+	}
+	if _, err := secrets.Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("problem updating CA secret: %w", err)
+	}
+	return issuer.SetMaterial(next)
+}
+
+// RotationDue is whether a CA has passed two thirds of its lifetime, which
+// leaves the last third for its successor to reach every bundle before it
+// expires
+func RotationDue(caCert *x509.Certificate, now time.Time) bool {
+	lifetime := caCert.NotAfter.Sub(caCert.NotBefore)
+	return now.After(caCert.NotBefore.Add(lifetime * 2 / 3))
+}
+
+// CARotation is a Duty checking every interval whether the issuer's CA is due
+// for rotation, and rotating it with rotate if so
+func CARotation(issuer *svid.SVIDIssuer, interval time.Duration, rotate func(context.Context) error) Duty {
+	return func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if caCert := issuer.Material().CACert; RotationDue(caCert, time.Now()) {
+				if err := rotate(ctx); err != nil {
+					slog.Error("problem rotating CA", "error", err)
+				} else {
+					slog.Info("🔄 CA rotated", "previousExpiry", caCert.NotAfter, "expiry", issuer.Material().CACert.NotAfter)
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}
+}
+
+func encodeMaterial(m svid.Material) (map[string][]byte, error) {
+	caKey, err := encodeKey(m.Signer, nil)
+	if err != nil {
+		return nil, fmt.Errorf("problem encoding CA key: %w", err)
+	}
+	jwtKey, err := encodeKey(m.JWT.Key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("problem encoding JWT signing key: %w", err)
+	}
+	var retired, retiredKeys []byte
+	for n, c := range m.RetiredCAs {
+		retired = append(retired, encodeCert(c)...)
+		if n >= len(m.RetiredSigners) || m.RetiredSigners[n] == nil {
+			continue
+		}
+		// Keys are matched to their CA by header, as some may be missing
+		key, err := encodeKey(m.RetiredSigners[n], map[string]string{headerCA: svid.CAKeyID(c)})
+		if err != nil {
+			return nil, fmt.Errorf("problem encoding retired CA key: %w", err)
+		}
+		retiredKeys = append(retiredKeys, key...)
+	}
+
+	data := map[string][]byte{
+		keyCACert:        encodeCert(m.CACert),
+		keyCAKey:         caKey,
+		keyRetiredCAs:    retired,
+		keyRetiredCAKeys: retiredKeys,
+		keyJWTKey:        jwtKey,
+		keyJWTKeyID:      []byte(m.JWT.KeyID),
+		keySequence:      []byte(strconv.FormatUint(m.Sequence, 10)),
+	}
+	if m.RetiredJWT != nil {
+		data[keyRetiredJWTKey], err = encodeKey(m.RetiredJWT.Key, nil)
+		if err != nil {
+			return nil, fmt.Errorf("problem encoding retired JWT signing key: %w", err)
+		}
+		data[keyRetiredJWTID] = []byte(m.RetiredJWT.KeyID)
+	}
+	return data, nil
+}
+
+func decodeMaterial(data map[string][]byte) (svid.Material, error) {
+	var m svid.Material
+	certs, err := decodeCerts(data[keyCACert])
+	if err != nil {
+		return m, fmt.Errorf("problem with %s: %w", keyCACert, err)
+	}
+	if len(certs) != 1 {
+		return m, fmt.Errorf("problem with %s: want one certificate, got %d", keyCACert, len(certs))
+	}
+	m.CACert = certs[0]
+	if m.RetiredCAs, err = decodeCerts(data[keyRetiredCAs]); err != nil {
+		return m, fmt.Errorf("problem with %s: %w", keyRetiredCAs, err)
+	}
+	if m.RetiredSigners, err = decodeRetiredKeys(data[keyRetiredCAKeys], m.RetiredCAs); err != nil {
+		return m, fmt.Errorf("problem with %s: %w", keyRetiredCAKeys, err)
+	}
+	if m.Signer, err = decodeKey(data[keyCAKey]); err != nil {
+		return m, fmt.Errorf("problem with %s: %w", keyCAKey, err)
+	}
+	if m.JWT, err = decodeJWTKey(data[keyJWTKey], data[keyJWTKeyID]); err != nil {
+		return m, fmt.Errorf("problem with %s: %w", keyJWTKey, err)
+	}
+	if len(data[keyRetiredJWTKey]) > 0 {
+		retired, err := decodeJWTKey(data[keyRetiredJWTKey], data[keyRetiredJWTID])
+		if err != nil {
+			return m, fmt.Errorf("problem with %s: %w", keyRetiredJWTKey, err)
+		}
+		m.RetiredJWT = &retired
+	}
+	if m.Sequence, err = strconv.ParseUint(string(data[keySequence]), 10, 64); err != nil {
+		return m, fmt.Errorf("problem with %s: %w", keySequence, err)
+	}
+	return m, nil
+}
+
+func encodeCert(c *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})
+}
+
+func decodeCerts(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs, nil
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+}
+
+func encodeKey(key crypto.Signer, headers map[string]string) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Headers: headers, Bytes: der}), nil
+}
+
+// decodeRetiredKeys returns the key of each retired CA, or nil for those
+// whose key isn't in data, such as CAs retired before their keys were kept
+func decodeRetiredKeys(data []byte, retired []*x509.Certificate) ([]crypto.Signer, error) {
+	keys := make(map[string]crypto.Signer)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		signer, err := parseKey(block)
+		if err != nil {
+			return nil, err
+		}
+		keys[block.Headers[headerCA]] = signer
+	}
+
+	signers := make([]crypto.Signer, len(retired))
+	for n, c := range retired {
+		signers[n] = keys[svid.CAKeyID(c)]
+	}
+	return signers, nil
+}
+
+func decodeKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM key")
+	}
+	return parseKey(block)
+}
+
+func parseKey(block *pem.Block) (crypto.Signer, error) {
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key %T", key)
+	}
+	return signer, nil
+}
+
+func decodeJWTKey(data, keyID []byte) (svid.JWTSigningKey, error) {
+	signer, err := decodeKey(data)
+	if err != nil {
+		return svid.JWTSigningKey{}, err
+	}
+	key, ok := signer.(*ecdsa.PrivateKey)
+	if !ok {
+		return svid.JWTSigningKey{}, fmt.Errorf("unsupported JWT signing key %T", signer)
+	}
+	return svid.JWTSigningKey{KeyID: string(keyID), Key: key}, nil
+}
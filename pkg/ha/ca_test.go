@@ -0,0 +1,132 @@
+package ha
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestSecretCALoad(t *testing.T) {
+	cs := fake.NewClientset()
+
+	// Replicas starting together all end up with the material of whichever
+	// created the Secret
+	loaded := make([]svid.Material, 3)
+	var wg sync.WaitGroup
+	for n := range loaded {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ca := &SecretCA{Client: cs, Namespace: "kubespiffe", Name: DefaultCASecretName, TTL: time.Hour}
+			m, err := ca.Load(context.Background())
+			assert.NoError(t, err)
+			loaded[n] = m
+		}()
+	}
+	wg.Wait()
+	for _, m := range loaded[1:] {
+		assert.True(t, m.Equal(loaded[0]))
+	}
+
+	secret, err := cs.CoreV1().Secrets("kubespiffe").Get(context.Background(), DefaultCASecretName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "1", string(secret.Data[keySequence]))
+}
+
+func TestSecretCARotate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cs := fake.NewClientset()
+	ca := &SecretCA{Client: cs, Namespace: "kubespiffe", Name: DefaultCASecretName, TTL: time.Hour}
+
+	replica := func() *svid.SVIDIssuer {
+		m, err := ca.Load(ctx)
+		require.NoError(t, err)
+		issuer, err := svid.NewSVIDIssuer(svid.WithMaterial(m))
+		require.NoError(t, err)
+		synced, err := ca.Watch(ctx, issuer)
+		require.NoError(t, err)
+		require.True(t, cache.WaitForCacheSync(ctx.Done(), synced))
+		return issuer
+	}
+	leader, follower := replica(), replica()
+
+	// The follower picks up the rotation through its watch
+	require.NoError(t, ca.Rotate(ctx, leader))
+	assert.Equal(t, uint64(2), leader.Material().Sequence)
+	assert.Eventually(t, func() bool {
+		return follower.Material().Equal(leader.Material())
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, leader.Authorities(), follower.Authorities())
+}
+
+func TestMaterialEncoding(t *testing.T) {
+	m, err := svid.GenerateMaterial(time.Hour)
+	require.NoError(t, err)
+	rotated, err := m.Rotate(time.Hour)
+	require.NoError(t, err)
+
+	for _, want := range []svid.Material{m, rotated} {
+		data, err := encodeMaterial(want)
+		require.NoError(t, err)
+		got, err := decodeMaterial(data)
+		require.NoError(t, err)
+		assert.True(t, got.Equal(want))
+		assert.True(t, got.JWT.Key.Equal(want.JWT.Key))
+		assert.ElementsMatch(t, want.RetiredSigners, got.RetiredSigners)
+	}
+
+	// Secrets written before retired CA keys were kept still load
+	data, err := encodeMaterial(rotated)
+	require.NoError(t, err)
+	delete(data, keyRetiredCAKeys)
+	got, err := decodeMaterial(data)
+	require.NoError(t, err)
+	assert.Equal(t, []crypto.Signer{nil}, got.RetiredSigners)
+
+	data, err = encodeMaterial(rotated)
+	require.NoError(t, err)
+	data[keyCACert] = append(data[keyCACert], data[keyRetiredCAs]...)
+	_, err = decodeMaterial(data)
+	assert.ErrorContains(t, err, "want one certificate, got 2")
+}
+
+func TestRotationDue(t *testing.T) {
+	now := time.Now()
+	caCert := &x509.Certificate{NotBefore: now, NotAfter: now.Add(3 * time.Hour)}
+	assert.False(t, RotationDue(caCert, now.Add(time.Hour)))
+	assert.True(t, RotationDue(caCert, now.Add(2*time.Hour+time.Minute)))
+}
+
+func TestCARotation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ca := &SecretCA{Client: fake.NewClientset(), Namespace: "kubespiffe", Name: DefaultCASecretName, TTL: 300 * time.Millisecond}
+	m, err := ca.Load(ctx)
+	require.NoError(t, err)
+	issuer, err := svid.NewSVIDIssuer(svid.WithMaterial(m))
+	require.NoError(t, err)
+
+	// The short-lived CA is due within the test, and its long-lived
+	// successor isn't
+	ca.TTL = time.Hour
+	go CARotation(issuer, 10*time.Millisecond, func(ctx context.Context) error {
+		return ca.Rotate(ctx, issuer)
+	})(ctx)
+	assert.Eventually(t, func() bool {
+		return issuer.Material().Sequence == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Never(t, func() bool {
+		return issuer.Material().Sequence > 2
+	}, 100*time.Millisecond, 10*time.Millisecond)
+}
@@ -0,0 +1,151 @@
+package ha
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/metrics"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	DefaultLeaseName     = "kubespiffed"
+	DefaultLeaseDuration = 15 * time.Second
+	DefaultRenewDeadline = 10 * time.Second
+	DefaultRetryPeriod   = 2 * time.Second
+)
+
+// Config is the Lease replicas compete for, and how this replica identifies
+// itself while holding it
+type Config struct {
+	Namespace     string
+	LeaseName     string
+	Identity      string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// Duty is singleton work, such as CA rotation, that runs on the leader until
+// ctx is cancelled when leadership is lost
+type Duty func(ctx context.Context)
+
+type namedDuty struct {
+	name string
+	run  Duty
+}
+
+// Elector runs duties on whichever replica holds the Lease, while every
+// replica keeps serving issuance. A standalone Elector leads unconditionally
+type Elector struct {
+	cs     kubernetes.Interface
+	cfg    Config
+	duties []namedDuty
+
+	leading atomic.Bool
+	// leadMu stops a replica that regains the Lease from starting duties
+	// before those of its previous term have returned
+	leadMu sync.Mutex
+}
+
+// NewElector competes for the Lease described by cfg
+func NewElector(cs kubernetes.Interface, cfg Config) *Elector {
+	return &Elector{cs: cs, cfg: cfg}
+}
+
+// Standalone leads for as long as Run runs, for a single replica
+func Standalone() *Elector {
+	return &Elector{}
+}
+
+// AddDuty runs duty whenever this replica leads. Duties must be added before
+// Run
+func (e *Elector) AddDuty(name string, duty Duty) {
+	e.duties = append(e.duties, namedDuty{name: name, run: duty})
+}
+
+// IsLeader is whether this replica currently runs the duties
+func (e *Elector) IsLeader() bool {
+	return e.leading.Load()
+}
+
+// Run competes for the Lease until ctx is done, running duties for each term
+// it wins. The Lease is released on return, so another replica takes over
+// without waiting for it to expire
+func (e *Elector) Run(ctx context.Context) error {
+	if e.cs == nil {
+		e.lead(ctx)
+		return nil
+	}
+
+	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Namespace: e.cfg.Namespace, Name: e.cfg.LeaseName},
+			Client:     e.cs.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: e.cfg.Identity},
+		},
+		LeaseDuration:   e.cfg.LeaseDuration,
+		RenewDeadline:   e.cfg.RenewDeadline,
+		RetryPeriod:     e.cfg.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            e.cfg.LeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: e.lead,
+			OnStoppedLeading: func() {},
+			OnNewLeader: func(identity string) {
+				slog.Info("👑 Leader elected", "lease", e.cfg.LeaseName, "leader", identity, "self", identity == e.cfg.Identity)
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("problem with leader election: %w", err)
+	}
+
+	// Run returns when a term ends, so campaign again until shut down
+	for ctx.Err() == nil {
+		le.Run(ctx)
+	}
+	// Duties are started in the background, so wait for the last term's to
+	// return
+	e.leadMu.Lock()
+	defer e.leadMu.Unlock()
+	return nil
+}
+
+// lead runs every duty until ctx is cancelled, and waits for them to return
+func (e *Elector) lead(ctx context.Context) {
+	e.leadMu.Lock()
+	defer e.leadMu.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+
+	e.leading.Store(true)
+	metrics.Leader.Set(1)
+	slog.Info("Leading", "duties", len(e.duties))
+
+	var wg sync.WaitGroup
+	for _, d := range e.duties {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.run(ctx)
+			if ctx.Err() == nil {
+				slog.Warn("duty returned while leading", "duty", d.name)
+			}
+		}()
+	}
+	<-ctx.Done()
+	wg.Wait()
+
+	e.leading.Store(false)
+	metrics.Leader.Set(0)
+	slog.Info("Stopped leading")
+}
@@ -0,0 +1,94 @@
+package ha
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testElection(identity string) Config {
+	return Config{
+		Namespace:     "kubespiffe",
+		LeaseName:     DefaultLeaseName,
+		Identity:      identity,
+		LeaseDuration: time.Second,
+		RenewDeadline: 500 * time.Millisecond,
+		RetryPeriod:   50 * time.Millisecond,
+	}
+}
+
+func TestElector(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cs := fake.NewClientset()
+
+	// Each replica counts its duties running, which must only happen on one
+	// replica at a time
+	var running atomic.Int32
+	type replica struct {
+		*Elector
+		stop context.CancelFunc
+		done chan struct{}
+	}
+	start := func(identity string) replica {
+		e := NewElector(cs, testElection(identity))
+		e.AddDuty("count", func(ctx context.Context) {
+			if running.Add(1) > 1 {
+				t.Errorf("%s leads alongside another replica", identity)
+			}
+			<-ctx.Done()
+			running.Add(-1)
+		})
+		ctx, stop := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			assert.NoError(t, e.Run(ctx))
+		}()
+		return replica{e, stop, done}
+	}
+	leader, follower := start("kubespiffed-0"), start("kubespiffed-1")
+
+	require.Eventually(t, func() bool {
+		return leader.IsLeader() != follower.IsLeader() && running.Load() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	if follower.IsLeader() {
+		leader, follower = follower, leader
+	}
+
+	// Shutting the leader down stops its duties and releases the Lease, so
+	// the follower takes over without waiting for it to expire
+	leader.stop()
+	<-leader.done
+	assert.False(t, leader.IsLeader())
+	assert.Eventually(t, func() bool {
+		return follower.IsLeader() && running.Load() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestStandalone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	e := Standalone()
+	started := make(chan struct{})
+	e.AddDuty("start", func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.NoError(t, e.Run(ctx))
+	}()
+	<-started
+	assert.True(t, e.IsLeader())
+
+	cancel()
+	<-done
+	assert.False(t, e.IsLeader())
+}
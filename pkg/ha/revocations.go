@@ -0,0 +1,437 @@
+package ha
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	DefaultRevocationsName = "kubespiffe-revocations"
+	DefaultDenialTTL       = 30 * 24 * time.Hour
+
+	// ShareInterval is how often revocations missing from the ConfigMap are
+	// shared, in case a notification was missed
+	ShareInterval = time.Minute
+	// CRLPublishInterval is how often the leader checks whether the CRL needs
+	// signing again
+	CRLPublishInterval = time.Minute
+
+	// keyCRLPrefix starts the key of the CRL signed by each CA, followed by
+	// its key ID. keyLegacyCRL is the single CRL published before CAs each
+	// had their own, removed by the leader
+	keyCRLPrefix = "crl."
+	keyLegacyCRL = "crl"
+	// keyDenialPrefix starts the key of a shared denial, which can't be
+	// mistaken for a hex serial
+	keyDenialPrefix = "denied."
+)
+
+// SharedRevocations shares revoked SVIDs between replicas through a
+// ConfigMap, with an entry per serial. SVIDs revoked by SPIFFE ID, pod or
+// registration are found in the ledger of the replica that issued them, so
+// without sharing, each replica's CRL and OCSP responses would only cover its
+// own SVIDs. The leader publishes the CRL every replica serves, so that CRL
+// numbers only increase however requests are balanced.
+//
+// SPIFFE IDs and pods denied through Revoke are shared too, and every replica
+// applies them to its own ledger. They lapse after DenialTTL, or
+// DefaultDenialTTL if it is zero, unless denied again, and can be lifted
+// sooner with LiftDenial. SVIDRevocations don't need to be shared, since
+// every replica applies those itself
+type SharedRevocations struct {
+	Client    kubernetes.Interface
+	Namespace string
+	Name      string
+	Issuer    *svid.SVIDIssuer
+	DenialTTL time.Duration
+
+	mu sync.RWMutex
+	// published is the expiry of each serial in the ConfigMap
+	published map[string]time.Time
+	// denials are the denials applied by this replica, and publishedDenials
+	// when those in the ConfigMap lapse, by key
+	denials          map[string]sharedDenial
+	publishedDenials map[string]time.Time
+	// crls are the CRLs published by the leader, by CA key ID
+	crls      map[string]sharedCRL
+	legacyCRL bool
+}
+
+type sharedCRL struct {
+	der  []byte
+	list *x509.RevocationList
+}
+
+// Revoke revokes SVIDs like Issuer.Revoke, sharing a denial of a SPIFFE ID or
+// pod with the other replicas
+func (s *SharedRevocations) Revoke(ctx context.Context, req svid.RevocationRequest) ([]string, error) {
+	serials, err := s.Issuer.Revoke(req)
+	if err != nil || (req.SPIFFEID == "" && req.PodUID == "") {
+		return serials, err
+	}
+
+	ttl := s.DenialTTL
+	if ttl == 0 {
+		ttl = DefaultDenialTTL
+	}
+	s.mu.Lock()
+	if s.denials == nil {
+		s.denials = make(map[string]sharedDenial)
+	}
+	s.denials[denialKey(req)] = sharedDenial{
+		RevocationRequest: svid.RevocationRequest{SPIFFEID: req.SPIFFEID, PodUID: req.PodUID, Reason: req.Reason},
+		Until:             time.Now().Add(ttl),
+	}
+	s.mu.Unlock()
+
+	// Sharing is retried every ShareInterval, so the denial isn't lost
+	if err := s.share(ctx); err != nil {
+		slog.Error("problem sharing revocations", "configMap", s.Name, "error", err)
+	}
+	return serials, nil
+}
+
+// LiftDenial allows a SPIFFE ID or pod denied through Revoke to be issued
+// SVIDs again on every replica. SVIDs already revoked stay revoked
+func (s *SharedRevocations) LiftDenial(ctx context.Context, req svid.RevocationRequest) error {
+	if (req.SPIFFEID == "") == (req.PodUID == "") {
+		return errors.New("exactly one of spiffeID or podUID must be set")
+	}
+
+	key := denialKey(req)
+	s.mu.Lock()
+	delete(s.denials, key)
+	s.mu.Unlock()
+	s.Issuer.LiftDenial(req)
+
+	// Other replicas lift it once they see it removed
+	return s.patch(ctx, map[string]any{"data": map[string]any{key: nil}})
+}
+
+// sharedDenial is the ConfigMap entry of a denial, which lapses at Until
+type sharedDenial struct {
+	svid.RevocationRequest
+	Until time.Time `json:"until"`
+}
+
+// denialKey is a valid ConfigMap key for the SPIFFE ID or pod denied
+func denialKey(req svid.RevocationRequest) string {
+	sum := sha256.Sum256([]byte(req.SPIFFEID + "\x00" + req.PodUID))
+	return keyDenialPrefix + hex.EncodeToString(sum[:])
+}
+
+// Start merges revocations shared by other replicas into Issuer, and shares
+// Issuer's own, until ctx is done
+func (s *SharedRevocations) Start(ctx context.Context) (cache.InformerSynced, error) {
+	factory := informers.NewSharedInformerFactoryWithOptions(s.Client, ShareInterval,
+		informers.WithNamespace(s.Namespace),
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.FieldSelector = fields.OneTermEqualSelector("metadata.name", s.Name).String()
+		}),
+	)
+	informer := factory.Core().V1().ConfigMaps().Informer()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    s.merge,
+		UpdateFunc: func(_, obj any) { s.merge(obj) },
+	})
+	if err != nil {
+		return nil, err
+	}
+	factory.Start(ctx.Done())
+
+	revoked, unsubscribe := s.Issuer.SubscribeRevocations()
+	go func() {
+		defer unsubscribe()
+		ticker := time.NewTicker(ShareInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-revoked:
+			case <-ticker.C:
+			}
+			if err := s.share(ctx); err != nil {
+				slog.Error("problem sharing revocations", "configMap", s.Name, "error", err)
+			}
+		}
+	}()
+	return informer.HasSynced, nil
+}
+
+// merge applies the ConfigMap's revocations and CRLs
+func (s *SharedRevocations) merge(obj any) {
+	cm, ok := obj.(*corev1.ConfigMap)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	published := make(map[string]time.Time, len(cm.Data))
+	publishedDenials := make(map[string]time.Time)
+	denials := make(map[string]sharedDenial)
+	var revoked []svid.RevokedSVID
+	for serial, entry := range cm.Data {
+		if strings.HasPrefix(serial, keyDenialPrefix) {
+			var d sharedDenial
+			if err := json.Unmarshal([]byte(entry), &d); err != nil || serial != denialKey(d.RevocationRequest) {
+				slog.Warn("invalid shared denial", "configMap", s.Name, "key", serial, "error", err)
+				continue
+			}
+			// Lapsed denials are left for the leader to remove
+			publishedDenials[serial] = d.Until
+			if d.Until.After(now) {
+				denials[serial] = d
+			}
+			continue
+		}
+		var r svid.RevokedSVID
+		if err := json.Unmarshal([]byte(entry), &r); err != nil || r.Serial != serial {
+			slog.Warn("invalid shared revocation", "configMap", s.Name, "serial", serial, "error", err)
+			continue
+		}
+		published[serial] = r.NotAfter
+		revoked = append(revoked, r)
+	}
+	s.Issuer.AddRevoked(revoked)
+
+	// Denials made on another replica also revoke the SVIDs in this one's
+	// ledger, which are then shared in turn. Denials that have lapsed, or
+	// have been removed from the ConfigMap since it was last seen, are lifted
+	var added, lifted []svid.RevocationRequest
+	s.mu.Lock()
+	if s.denials == nil {
+		s.denials = make(map[string]sharedDenial)
+	}
+	for key, d := range s.denials {
+		if _, ok := denials[key]; ok {
+			continue
+		}
+		until, wasPublished := s.publishedDenials[key]
+		if (wasPublished && !d.Until.After(until)) || !d.Until.After(now) {
+			delete(s.denials, key)
+			lifted = append(lifted, d.RevocationRequest)
+		}
+	}
+	for key, d := range denials {
+		local, ok := s.denials[key]
+		if !ok {
+			added = append(added, d.RevocationRequest)
+		}
+		if !ok || d.Until.After(local.Until) {
+			s.denials[key] = d
+		}
+	}
+	s.mu.Unlock()
+	for _, req := range lifted {
+		s.Issuer.LiftDenial(req)
+		slog.Info("✅ Denial lifted", "request", req, "configMap", s.Name)
+	}
+	for _, req := range added {
+		serials, err := s.Issuer.Revoke(req)
+		if err != nil {
+			slog.Warn("invalid shared denial", "configMap", s.Name, "request", req, "error", err)
+			continue
+		}
+		slog.Info("🚫 SVIDs revoked", "request", req, "serials", serials, "configMap", s.Name)
+	}
+
+	crls := make(map[string]sharedCRL)
+	for key, crl := range cm.BinaryData {
+		caID, ok := strings.CutPrefix(key, keyCRLPrefix)
+		if !ok {
+			continue
+		}
+		list, err := x509.ParseRevocationList(crl)
+		if err != nil {
+			slog.Warn("invalid shared CRL", "configMap", s.Name, "key", key, "error", err)
+			continue
+		}
+		s.Issuer.AdvanceCRLNumber(list.Number.Int64())
+		crls[caID] = sharedCRL{der: crl, list: list}
+	}
+
+	s.mu.Lock()
+	s.published = published
+	s.publishedDenials = publishedDenials
+	s.crls = crls
+	s.legacyCRL = cm.BinaryData[keyLegacyCRL] != nil
+	s.mu.Unlock()
+}
+
+// share adds Issuer's revocations and the denials made through Revoke that
+// are missing from the ConfigMap, or lapse later than there
+func (s *SharedRevocations) share(ctx context.Context) error {
+	now := time.Now()
+	s.mu.RLock()
+	missing := make(map[string]string)
+	for key, d := range s.denials {
+		if until, ok := s.publishedDenials[key]; (ok && !d.Until.After(until)) || !d.Until.After(now) {
+			continue
+		}
+		entry, err := json.Marshal(d)
+		if err != nil {
+			s.mu.RUnlock()
+			return err
+		}
+		missing[key] = string(entry)
+	}
+	for _, r := range s.Issuer.Revoked() {
+		if _, ok := s.published[r.Serial]; ok {
+			continue
+		}
+		entry, err := json.Marshal(r)
+		if err != nil {
+			s.mu.RUnlock()
+			return err
+		}
+		missing[r.Serial] = string(entry)
+	}
+	s.mu.RUnlock()
+
+	if len(missing) == 0 {
+		return nil
+	}
+	return s.patch(ctx, map[string]any{"data": missing})
+}
+
+// CRL returns the CRL of the CA with the key ID caID, or of the current CA if
+// caID is empty, as published by the leader while it is current and signed
+// by a CA this replica knows. Otherwise it returns one signed by Issuer
+func (s *SharedRevocations) CRL(caID string) ([]byte, error) {
+	m := s.Issuer.Material()
+	if caID == "" {
+		caID = svid.CAKeyID(m.CACert)
+	}
+	s.mu.RLock()
+	crl, ok := s.crls[caID]
+	s.mu.RUnlock()
+
+	if ok && time.Now().Before(crl.list.NextUpdate) {
+		for _, caCert := range append([]*x509.Certificate{m.CACert}, m.RetiredCAs...) {
+			if svid.CAKeyID(caCert) == caID && crl.list.CheckSignatureFrom(caCert) == nil {
+				return crl.der, nil
+			}
+		}
+	}
+	return s.Issuer.CRLFor(caID)
+}
+
+// PublishCRL is a Duty publishing the CRL signed by the leader with each live
+// CA whenever it changes, and dropping expired revocations, lapsed denials
+// and the CRLs of expired CAs from the ConfigMap
+func (s *SharedRevocations) PublishCRL(interval time.Duration) Duty {
+	return func(ctx context.Context) {
+		revoked, unsubscribeRevocations := s.Issuer.SubscribeRevocations()
+		defer unsubscribeRevocations()
+		rotations, unsubscribeRotations := s.Issuer.SubscribeRotations()
+		defer unsubscribeRotations()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := s.publishCRL(ctx); err != nil {
+				slog.Error("problem publishing CRL", "configMap", s.Name, "error", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-revoked:
+			case <-rotations:
+			case <-ticker.C:
+			}
+		}
+	}
+}
+
+func (s *SharedRevocations) publishCRL(ctx context.Context) error {
+	crls, err := s.Issuer.CRLs()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	s.mu.RLock()
+	changed := make(map[string]any)
+	if s.legacyCRL {
+		changed[keyLegacyCRL] = nil
+	}
+	for caID, crl := range crls {
+		if !bytes.Equal(crl, s.crls[caID].der) {
+			changed[keyCRLPrefix+caID] = crl
+		}
+	}
+	for caID := range s.crls {
+		if _, ok := crls[caID]; !ok {
+			changed[keyCRLPrefix+caID] = nil
+		}
+	}
+	expired := make(map[string]any)
+	for serial, notAfter := range s.published {
+		if !notAfter.After(now) {
+			expired[serial] = nil
+		}
+	}
+	for key, until := range s.publishedDenials {
+		if !until.After(now) {
+			expired[key] = nil
+		}
+	}
+	s.mu.RUnlock()
+
+	patch := make(map[string]any)
+	if len(changed) > 0 {
+		patch["binaryData"] = changed
+	}
+	if len(expired) > 0 {
+		patch["data"] = expired
+	}
+	if len(patch) == 0 {
+		return nil
+	}
+	return s.patch(ctx, patch)
+}
+
+// patch merges into the ConfigMap, creating it first if it doesn't exist.
+// Merge patches of different serials don't conflict, so every replica can
+// share its revocations without reading the ConfigMap first
+func (s *SharedRevocations) patch(ctx context.Context, patch map[string]any) error {
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	configMaps := s.Client.CoreV1().ConfigMaps(s.Namespace)
+	_, err = configMaps.Patch(ctx, s.Name, types.MergePatchType, body, metav1.PatchOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = configMaps.Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: s.Namespace, Name: s.Name},
+		}, metav1.CreateOptions{})
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("problem creating revocations config map: %w", err)
+		}
+		_, err = configMaps.Patch(ctx, s.Name, types.MergePatchType, body, metav1.PatchOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("problem patching revocations config map: %w", err)
+	}
+	return nil
+}
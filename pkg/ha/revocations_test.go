@@ -0,0 +1,307 @@
+package ha
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func issue(t *testing.T, issuer *svid.SVIDIssuer, podUID string) string {
+	t.Helper()
+	wr := &v1alpha1.WorkloadRegistration{
+		ObjectMeta: metav1.ObjectMeta{Name: "workload"},
+		Spec:       v1alpha1.WorkloadRegistrationSpec{SPIFFEID: "spiffe://example.org/workload"},
+	}
+	der, _, err := issuer.IssueX509SVID(context.Background(), wr, svid.Workload{PodUID: podUID})
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert.SerialNumber.Text(16)
+}
+
+func publishedCRL(t *testing.T, cs kubernetes.Interface, caCert *x509.Certificate) *x509.RevocationList {
+	cm, err := cs.CoreV1().ConfigMaps("kubespiffe").Get(context.Background(), DefaultRevocationsName, metav1.GetOptions{})
+	key := keyCRLPrefix + svid.CAKeyID(caCert)
+	if err != nil || cm.BinaryData[key] == nil {
+		return nil
+	}
+	crl, err := x509.ParseRevocationList(cm.BinaryData[key])
+	require.NoError(t, err)
+	return crl
+}
+
+func listed(crl *x509.RevocationList, serials ...string) bool {
+	if crl == nil {
+		return false
+	}
+	found := map[string]bool{}
+	for _, e := range crl.RevokedCertificateEntries {
+		found[e.SerialNumber.Text(16)] = true
+	}
+	for _, s := range serials {
+		if !found[s] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSharedRevocations(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cs := fake.NewClientset()
+	m, err := svid.GenerateMaterial(time.Hour)
+	require.NoError(t, err)
+
+	replica := func() *SharedRevocations {
+		issuer, err := svid.NewSVIDIssuer(svid.WithMaterial(m))
+		require.NoError(t, err)
+		s := &SharedRevocations{Client: cs, Namespace: "kubespiffe", Name: DefaultRevocationsName, Issuer: issuer}
+		synced, err := s.Start(ctx)
+		require.NoError(t, err)
+		require.True(t, cache.WaitForCacheSync(ctx.Done(), synced))
+		return s
+	}
+	first, second := replica(), replica()
+
+	// An SVID revoked by pod is only in the ledger of the replica that
+	// issued it, but ends up revoked on both
+	serial := issue(t, first.Issuer, "pod-1")
+	_, err = first.Issuer.Revoke(svid.RevocationRequest{PodUID: "pod-1", Reason: "keyCompromise"})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return second.Issuer.IsRevoked(serial)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The single CRL published by earlier versions is replaced by one per CA
+	require.NoError(t, first.patch(ctx, map[string]any{"binaryData": map[string][]byte{keyLegacyCRL: []byte("crl")}}))
+
+	// Both replicas serve the CRL published by the leader
+	leaderCtx, stepDown := context.WithCancel(ctx)
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		first.PublishCRL(10 * time.Millisecond)(leaderCtx)
+	}()
+	var published *x509.RevocationList
+	require.Eventually(t, func() bool {
+		published = publishedCRL(t, cs, m.CACert)
+		return listed(published, serial)
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		crl, err := second.CRL("")
+		return err == nil && bytes.Equal(published.Raw, crl)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// After failover the new leader continues the CRL numbering, and its CRL
+	// lists revocations made on either replica
+	stepDown()
+	<-leaderDone
+	other := issue(t, second.Issuer, "pod-2")
+	_, err = second.Issuer.Revoke(svid.RevocationRequest{Serial: other, Reason: "keyCompromise"})
+	require.NoError(t, err)
+	go second.PublishCRL(10 * time.Millisecond)(ctx)
+	assert.Eventually(t, func() bool {
+		crl := publishedCRL(t, cs, m.CACert)
+		return listed(crl, serial, other) && crl.Number.Cmp(published.Number) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		crl, err := first.CRL("")
+		if err != nil {
+			return false
+		}
+		parsed, err := x509.ParseRevocationList(crl)
+		return err == nil && listed(parsed, serial, other)
+	}, 5*time.Second, 10*time.Millisecond)
+	cm, err := cs.CoreV1().ConfigMaps("kubespiffe").Get(ctx, DefaultRevocationsName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, cm.BinaryData, keyLegacyCRL)
+
+	// After rotation the retired CA still signs a CRL for the SVIDs it issued
+	rotated, err := m.Rotate(time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, first.Issuer.SetMaterial(rotated))
+	require.NoError(t, second.Issuer.SetMaterial(rotated))
+	assert.Eventually(t, func() bool {
+		retired := publishedCRL(t, cs, m.CACert)
+		current := publishedCRL(t, cs, rotated.CACert)
+		return listed(retired, serial, other) && retired.CheckSignatureFrom(m.CACert) == nil &&
+			listed(current, serial, other) && current.CheckSignatureFrom(rotated.CACert) == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	crl, err := first.CRL(svid.CAKeyID(m.CACert))
+	require.NoError(t, err)
+	parsed, err := x509.ParseRevocationList(crl)
+	require.NoError(t, err)
+	assert.NoError(t, parsed.CheckSignatureFrom(m.CACert))
+}
+
+func TestSharedDenials(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cs := fake.NewClientset()
+	m, err := svid.GenerateMaterial(time.Hour)
+	require.NoError(t, err)
+
+	replica := func() *SharedRevocations {
+		issuer, err := svid.NewSVIDIssuer(svid.WithMaterial(m))
+		require.NoError(t, err)
+		s := &SharedRevocations{Client: cs, Namespace: "kubespiffe", Name: DefaultRevocationsName, Issuer: issuer}
+		synced, err := s.Start(ctx)
+		require.NoError(t, err)
+		require.True(t, cache.WaitForCacheSync(ctx.Done(), synced))
+		return s
+	}
+	first, second := replica(), replica()
+
+	// An SVID the second replica issued is revoked, and the identity denied
+	// there, by a revocation made on the first
+	serial := issue(t, second.Issuer, "pod-2")
+	other := issue(t, first.Issuer, "pod-1")
+	_, err = first.Revoke(ctx, svid.RevocationRequest{SPIFFEID: "spiffe://example.org/workload", Reason: "keyCompromise"})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return second.Issuer.IsRevoked(serial)
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return first.Issuer.IsRevoked(serial)
+	}, 5*time.Second, 10*time.Millisecond)
+	wr := &v1alpha1.WorkloadRegistration{
+		ObjectMeta: metav1.ObjectMeta{Name: "workload"},
+		Spec:       v1alpha1.WorkloadRegistrationSpec{SPIFFEID: "spiffe://example.org/workload"},
+	}
+	_, _, err = second.Issuer.IssueX509SVID(ctx, wr, svid.Workload{PodUID: "pod-3"})
+	assert.ErrorIs(t, err, svid.ErrRevoked)
+
+	// A replica starting later applies denials already shared
+	third := replica()
+	_, _, err = third.Issuer.IssueX509SVID(ctx, wr, svid.Workload{PodUID: "pod-3"})
+	assert.ErrorIs(t, err, svid.ErrRevoked)
+
+	// Revoking a serial shares the serial, but no denial
+	_, err = second.Revoke(ctx, svid.RevocationRequest{Serial: other})
+	require.NoError(t, err)
+	assert.Equal(t, 1, sharedDenials(t, cs))
+
+	// Lifting the denial on one replica lifts it on every replica
+	require.NoError(t, first.LiftDenial(ctx, svid.RevocationRequest{SPIFFEID: "spiffe://example.org/workload"}))
+	for _, r := range []*SharedRevocations{first, second, third} {
+		assert.Eventually(t, func() bool {
+			_, _, err := r.Issuer.IssueX509SVID(ctx, wr, svid.Workload{PodUID: "pod-4"})
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+	assert.True(t, second.Issuer.IsRevoked(serial))
+	assert.Equal(t, 0, sharedDenials(t, cs))
+}
+
+func sharedDenials(t *testing.T, cs kubernetes.Interface) int {
+	cm, err := cs.CoreV1().ConfigMaps("kubespiffe").Get(context.Background(), DefaultRevocationsName, metav1.GetOptions{})
+	require.NoError(t, err)
+	var denials int
+	for key := range cm.Data {
+		if strings.HasPrefix(key, keyDenialPrefix) {
+			denials++
+		}
+	}
+	return denials
+}
+
+func TestSharedDenialsLapse(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cs := fake.NewClientset()
+	m, err := svid.GenerateMaterial(time.Hour)
+	require.NoError(t, err)
+
+	replica := func() *SharedRevocations {
+		issuer, err := svid.NewSVIDIssuer(svid.WithMaterial(m))
+		require.NoError(t, err)
+		s := &SharedRevocations{Client: cs, Namespace: "kubespiffe", Name: DefaultRevocationsName, Issuer: issuer, DenialTTL: time.Second}
+		synced, err := s.Start(ctx)
+		require.NoError(t, err)
+		require.True(t, cache.WaitForCacheSync(ctx.Done(), synced))
+		return s
+	}
+	first, second := replica(), replica()
+	wr := &v1alpha1.WorkloadRegistration{
+		ObjectMeta: metav1.ObjectMeta{Name: "workload"},
+		Spec:       v1alpha1.WorkloadRegistrationSpec{SPIFFEID: "spiffe://example.org/workload"},
+	}
+	denied := func(r *SharedRevocations) bool {
+		_, _, err := r.Issuer.IssueX509SVID(ctx, wr, svid.Workload{PodUID: "pod-1"})
+		return errors.Is(err, svid.ErrRevoked)
+	}
+
+	_, err = first.Revoke(ctx, svid.RevocationRequest{SPIFFEID: "spiffe://example.org/workload"})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return denied(second) }, time.Second, 10*time.Millisecond)
+
+	// The leader drops the lapsed denial, and every replica lifts it
+	go first.PublishCRL(10 * time.Millisecond)(ctx)
+	assert.Eventually(t, func() bool {
+		return sharedDenials(t, cs) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	for _, r := range []*SharedRevocations{first, second} {
+		assert.Eventually(t, func() bool { return !denied(r) }, 5*time.Second, 10*time.Millisecond)
+	}
+}
+
+func TestSharedOCSP(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cs := fake.NewClientset()
+	m, err := svid.GenerateMaterial(time.Hour)
+	require.NoError(t, err)
+
+	replica := func() *SharedRevocations {
+		issuer, err := svid.NewSVIDIssuer(svid.WithMaterial(m))
+		require.NoError(t, err)
+		s := &SharedRevocations{Client: cs, Namespace: "kubespiffe", Name: DefaultRevocationsName, Issuer: issuer}
+		synced, err := s.Start(ctx)
+		require.NoError(t, err)
+		require.True(t, cache.WaitForCacheSync(ctx.Done(), synced))
+		return s
+	}
+	first, second := replica(), replica()
+
+	status := func(s *SharedRevocations, serial string) int {
+		n, ok := new(big.Int).SetString(serial, 16)
+		require.True(t, ok)
+		cert := &x509.Certificate{SerialNumber: n}
+		req, err := ocsp.CreateRequest(cert, m.CACert, nil)
+		require.NoError(t, err)
+		der, err := s.Issuer.OCSPResponse(req)
+		require.NoError(t, err)
+		resp, err := ocsp.ParseResponseForCert(der, cert, m.CACert)
+		require.NoError(t, err)
+		return resp.Status
+	}
+
+	// An SVID is good on a replica that didn't issue it, and revoked there
+	// once the revocation has been shared
+	serial := issue(t, first.Issuer, "pod-1")
+	assert.Equal(t, ocsp.Good, status(first, serial))
+	assert.Equal(t, ocsp.Good, status(second, serial))
+
+	_, err = first.Revoke(ctx, svid.RevocationRequest{Serial: serial, Reason: "keyCompromise"})
+	require.NoError(t, err)
+	assert.Equal(t, ocsp.Revoked, status(first, serial))
+	assert.Eventually(t, func() bool {
+		return status(second, serial) == ocsp.Revoked
+	}, 5*time.Second, 10*time.Millisecond)
+}
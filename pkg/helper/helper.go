@@ -0,0 +1,235 @@
+package helper
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/api"
+	"github.com/jsnctl/kubespiffe/pkg/atomicdir"
+	"github.com/jsnctl/kubespiffe/pkg/tracing"
+)
+
+const (
+	DefaultURL           = "http://kubespiffed.kubespiffe.svc.cluster.local:8080"
+	DefaultTokenPath     = "/var/run/secrets/tokens/psat"
+	DefaultRetryInterval = 5 * time.Second
+	maxResponseBytes     = 1024 * 1024
+)
+
+// Config describes where the helper fetches SVIDs from, where it writes them,
+// and how it tells the workload to reload them
+type Config struct {
+	URL       string
+	TokenPath string
+
+	CertPath   string
+	KeyPath    string
+	BundlePath string
+	CertMode   os.FileMode
+	KeyMode    os.FileMode
+
+	// Signal is sent to the process whose PID is in PIDFile after each write
+	Signal  os.Signal
+	PIDFile string
+	// Command is run after each write
+	Command []string
+
+	RetryInterval time.Duration
+}
+
+// Helper fetches SVIDs from kubespiffed with the pod's PSAT and writes them to
+// files, for workloads that can't call the Workload API themselves
+type Helper struct {
+	cfg    Config
+	client *http.Client
+}
+
+func New(cfg Config) *Helper {
+	if cfg.URL == "" {
+		cfg.URL = DefaultURL
+	}
+	if cfg.TokenPath == "" {
+		cfg.TokenPath = DefaultTokenPath
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = DefaultRetryInterval
+	}
+	return &Helper{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Init blocks until an SVID has been written, retrying until ctx is done
+func (h *Helper) Init(ctx context.Context) error {
+	if _, ok := h.writeUntilSuccess(ctx); !ok {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// Run writes an SVID and then renews it at half of its lifetime until ctx is
+// done, reloading the workload after each write
+func (h *Helper) Run(ctx context.Context) error {
+	for {
+		notAfter, ok := h.writeUntilSuccess(ctx)
+		if !ok {
+			return nil
+		}
+		h.reload(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Until(notAfter) / 2):
+		}
+	}
+}
+
+// writeUntilSuccess writes an SVID, retrying until ctx is done, and returns
+// false if it is done first
+func (h *Helper) writeUntilSuccess(ctx context.Context) (time.Time, bool) {
+	for {
+		notAfter, err := h.writeSVID(ctx)
+		if err == nil {
+			slog.Info("✅ SVID written", "cert", h.cfg.CertPath, "notAfter", notAfter)
+			return notAfter, true
+		}
+		if ctx.Err() != nil {
+			return time.Time{}, false
+		}
+		slog.Error("problem fetching SVID", "error", err)
+
+		select {
+		case <-ctx.Done():
+			return time.Time{}, false
+		case <-time.After(h.cfg.RetryInterval):
+		}
+	}
+}
+
+// writeSVID fetches an SVID and writes it out, returning when it expires
+func (h *Helper) writeSVID(ctx context.Context) (time.Time, error) {
+	resp, err := h.fetch(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	block, _ := pem.Decode(resp.X509SVID)
+	if block == nil {
+		return time.Time{}, errors.New("SVID response has no certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parsing SVID: %w", err)
+	}
+
+	// Files sharing a directory are swapped in together, so a reader never sees
+	// the new certificate with the old key. The key's directory goes first
+	dirs := make(map[string]map[string]atomicdir.File)
+	var order []string
+	for _, f := range []struct {
+		path string
+		data []byte
+		mode os.FileMode
+	}{
+		{h.cfg.KeyPath, resp.X509SVIDKey, h.cfg.KeyMode},
+		{h.cfg.CertPath, resp.X509SVID, h.cfg.CertMode},
+		{h.cfg.BundlePath, resp.Bundle, h.cfg.CertMode},
+	} {
+		if f.path == "" {
+			continue
+		}
+		dir := filepath.Dir(f.path)
+		if dirs[dir] == nil {
+			dirs[dir] = make(map[string]atomicdir.File)
+			order = append(order, dir)
+		}
+		dirs[dir][filepath.Base(f.path)] = atomicdir.File{Data: f.data, Mode: f.mode}
+	}
+	for _, dir := range order {
+		if err := atomicdir.Write(dir, dirs[dir]); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return cert.NotAfter, nil
+}
+
+func (h *Helper) fetch(ctx context.Context) (*api.SVIDResponse, error) {
+	token, err := os.ReadFile(h.cfg.TokenPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading PSAT: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(h.cfg.URL, "/")+"/v1/svid", nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	tracing.Inject(ctx, req.Header)
+
+	httpResp, err := h.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting SVID: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(httpResp.Body, maxResponseBytes))
+	if err != nil {
+		return nil, fmt.Errorf("reading SVID response: %w", err)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("kubespiffed responded %d: %s", httpResp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var resp api.SVIDResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("decoding SVID response: %w", err)
+	}
+	return &resp, nil
+}
+
+// reload signals the workload and runs the reload command. Failures are only
+// logged, as the workload may not have started yet
+func (h *Helper) reload(ctx context.Context) {
+	if h.cfg.Signal != nil && h.cfg.PIDFile != "" {
+		if err := signalPIDFile(h.cfg.PIDFile, h.cfg.Signal); err != nil {
+			slog.Warn("problem signalling workload", "pidFile", h.cfg.PIDFile, "error", err)
+		}
+	}
+	if len(h.cfg.Command) > 0 {
+		out, err := exec.CommandContext(ctx, h.cfg.Command[0], h.cfg.Command[1:]...).CombinedOutput()
+		if err != nil {
+			slog.Warn("problem running reload command", "command", h.cfg.Command, "output", string(out), "error", err)
+		}
+	}
+}
+
+func signalPIDFile(path string, sig os.Signal) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("invalid PID in %s: %w", path, err)
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Signal(sig)
+}
@@ -0,0 +1,142 @@
+package helper
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/api"
+	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	"github.com/jsnctl/kubespiffe/pkg/atomicdir"
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// mockKubespiffed issues SVIDs to requests bearing token, after failing the
+// first failures requests
+func mockKubespiffed(t *testing.T, token string, failures int32) *httptest.Server {
+	issuer, err := svid.NewSVIDIssuer()
+	require.NoError(t, err)
+	wr := &v1alpha1.WorkloadRegistration{
+		ObjectMeta: metav1.ObjectMeta{Name: "workload"},
+		Spec:       v1alpha1.WorkloadRegistrationSpec{SPIFFEID: "spiffe://example.org/workload"},
+	}
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= failures {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path != "/v1/svid" || r.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		cert, key, err := issuer.IssueX509SVID(context.Background(), wr, svid.Workload{PodName: "workload-abc"})
+		require.NoError(t, err)
+		json.NewEncoder(w).Encode(api.SVIDResponse{
+			X509SVID:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}),
+			X509SVIDKey: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}),
+			Bundle:      pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issuer.GetCACert()}),
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func testConfig(t *testing.T, url string) Config {
+	dir := t.TempDir()
+	tokenPath := filepath.Join(dir, "psat")
+	require.NoError(t, os.WriteFile(tokenPath, []byte("psat-token\n"), 0o600))
+	return Config{
+		URL:           url,
+		TokenPath:     tokenPath,
+		CertPath:      filepath.Join(dir, "svid.pem"),
+		KeyPath:       filepath.Join(dir, "svid_key.pem"),
+		BundlePath:    filepath.Join(dir, "bundle.pem"),
+		CertMode:      0o644,
+		KeyMode:       0o600,
+		RetryInterval: 10 * time.Millisecond,
+	}
+}
+
+func TestInit(t *testing.T) {
+	srv := mockKubespiffed(t, "psat-token", 2)
+	cfg := testConfig(t, srv.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, New(cfg).Init(ctx))
+
+	cert, err := tls.LoadX509KeyPair(cfg.CertPath, cfg.KeyPath)
+	require.NoError(t, err)
+	assert.Equal(t, "spiffe://example.org/workload", cert.Leaf.URIs[0].String())
+
+	for path, mode := range map[string]os.FileMode{cfg.CertPath: 0o644, cfg.KeyPath: 0o600, cfg.BundlePath: 0o644} {
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, mode, info.Mode().Perm(), path)
+	}
+
+	// The files are links through ..data, and no temporary files are left
+	// behind
+	for _, path := range []string{cfg.CertPath, cfg.KeyPath, cfg.BundlePath} {
+		target, err := os.Readlink(path)
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(atomicdir.DataDir, filepath.Base(path)), target)
+	}
+	entries, err := os.ReadDir(filepath.Dir(cfg.CertPath))
+	require.NoError(t, err)
+	assert.Len(t, entries, 6)
+}
+
+func TestInitCancelled(t *testing.T) {
+	srv := mockKubespiffed(t, "another-token", 0)
+	cfg := testConfig(t, srv.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, New(cfg).Init(ctx), context.DeadlineExceeded)
+	assert.NoFileExists(t, cfg.CertPath)
+}
+
+func TestRunCancelled(t *testing.T) {
+	srv := mockKubespiffed(t, "another-token", 0)
+	cfg := testConfig(t, srv.URL)
+
+	// Stopping while retrying is a clean shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.NoError(t, New(cfg).Run(ctx))
+	assert.NoFileExists(t, cfg.CertPath)
+}
+
+func TestRunReloads(t *testing.T) {
+	srv := mockKubespiffed(t, "psat-token", 0)
+	cfg := testConfig(t, srv.URL)
+	reloaded := filepath.Join(t.TempDir(), "reloaded")
+	cfg.Command = []string{"touch", reloaded}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- New(cfg).Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(reloaded)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.FileExists(t, cfg.CertPath)
+
+	cancel()
+	assert.NoError(t, <-done)
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package helper
+
+import (
+	"errors"
+	"os"
+)
+
+func ParseSignal(name string) (os.Signal, error) {
+	return nil, errors.New("signals are only supported on unix")
+}
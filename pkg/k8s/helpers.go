@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"time"
@@ -16,26 +17,39 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
 	"github.com/jsnctl/kubespiffe/pkg/generated/clientset/versioned"
+	"github.com/jsnctl/kubespiffe/pkg/tracing"
 	"github.com/lestrrat-go/jwx/jwk"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	authnv1 "k8s.io/api/authentication/v1"
+	authzv1 "k8s.io/api/authorization/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
-func GetKubernetesClientset() (*kubernetes.Clientset, error) {
-	cfg, err := rest.InClusterConfig()
-	if err != nil {
-		return nil, err
+// RESTConfig uses the in-cluster service account, unless a kubeconfig or a
+// context is given for running outside the cluster. An empty kubeconfig with
+// a context uses the default loading rules, honouring $KUBECONFIG
+func RESTConfig(kubeconfig, context string) (*rest.Config, error) {
+	if kubeconfig == "" && context == "" {
+		return rest.InClusterConfig()
 	}
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		rules,
+		&clientcmd.ConfigOverrides{CurrentContext: context},
+	).ClientConfig()
+}
+
+func GetKubernetesClientset(cfg *rest.Config) (*kubernetes.Clientset, error) {
 	return kubernetes.NewForConfig(cfg)
 }
 
-func GetKubespiffeClientset() (*versioned.Clientset, error) {
-	cfg, err := rest.InClusterConfig()
-	if err != nil {
-		return nil, err
-	}
+func GetKubespiffeClientset(cfg *rest.Config) (*versioned.Clientset, error) {
 	return versioned.NewForConfig(cfg)
 }
 
@@ -43,17 +57,31 @@ type JWKS struct {
 	Keys []map[string]interface{}
 }
 
+// JWKSSource fetches the keys service account tokens are signed with from
+// the API server, authenticating with a service account token
+type JWKSSource struct {
+	URL       string
+	TokenPath string
+	CAPath    string
+}
+
+var DefaultJWKSSource = JWKSSource{
+	URL:       "https://kubernetes.default.svc/openid/v1/jwks",
+	TokenPath: "/var/run/secrets/kubernetes.io/serviceaccount/token",
+	CAPath:    "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt",
+}
+
 func GetKubernetesJWKS(ctx context.Context) (*JWKS, error) {
-	k8sJWKSEndpoint := "https://kubernetes.default.svc/openid/v1/jwks"
-	satPath := "/var/run/secrets/kubernetes.io/serviceaccount/token"
-	caPath := "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	return DefaultJWKSSource.Fetch(ctx)
+}
 
-	token, err := os.ReadFile(satPath)
+func (src JWKSSource) Fetch(ctx context.Context) (*JWKS, error) {
+	token, err := os.ReadFile(src.TokenPath)
 	if err != nil {
 		return nil, fmt.Errorf("reading service account token: %w", err)
 	}
 
-	caCertPool, err := loadCertPool(caPath)
+	caCertPool, err := loadCertPool(src.CAPath)
 	if err != nil {
 		return nil, fmt.Errorf("loading CA cert: %w", err)
 	}
@@ -65,7 +93,7 @@ func GetKubernetesJWKS(ctx context.Context) (*JWKS, error) {
 		},
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k8sJWKSEndpoint, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src.URL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("creating request: %w", err)
 	}
@@ -89,6 +117,59 @@ func GetKubernetesJWKS(ctx context.Context) (*JWKS, error) {
 	return &jwks, nil
 }
 
+// OpenIDConfiguration is the API server's service account issuer discovery
+// document
+type OpenIDConfiguration struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+// APIServerJWKSSource fetches the keys service account tokens are signed with
+// through an API server client, finding them with OIDC discovery. It works
+// with any credentials the client has, such as those from a kubeconfig
+type APIServerJWKSSource struct {
+	Client rest.Interface
+}
+
+func (src APIServerJWKSSource) Discover(ctx context.Context) (*OpenIDConfiguration, error) {
+	data, err := src.Client.Get().AbsPath("/.well-known/openid-configuration").DoRaw(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetching OpenID configuration: %w", err)
+	}
+	var oidc OpenIDConfiguration
+	if err := json.Unmarshal(data, &oidc); err != nil {
+		return nil, fmt.Errorf("decoding OpenID configuration: %w", err)
+	}
+	if oidc.JWKSURI == "" {
+		return nil, errors.New("OpenID configuration has no jwks_uri")
+	}
+	return &oidc, nil
+}
+
+// Fetch requests the path of the discovered jwks_uri from the API server, as
+// its host is usually the in-cluster issuer rather than an address reachable
+// from where kubespiffed runs
+func (src APIServerJWKSSource) Fetch(ctx context.Context) (*JWKS, error) {
+	oidc, err := src.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	jwksURI, err := url.Parse(oidc.JWKSURI)
+	if err != nil {
+		return nil, fmt.Errorf("problem with jwks_uri: %w", err)
+	}
+
+	data, err := src.Client.Get().AbsPath(jwksURI.Path).DoRaw(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetching JWKS: %w", err)
+	}
+	var jwks JWKS
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("decoding JWKS: %w", err)
+	}
+	return &jwks, nil
+}
+
 func loadCertPool(path string) (*x509.CertPool, error) {
 	certData, err := os.ReadFile(path)
 	if err != nil {
@@ -109,8 +190,26 @@ func ExtractBearerToken(header string) string {
 	return strings.TrimPrefix(header, "Bearer ")
 }
 
-func VerifyPSAT(psat string, jwks *JWKS) (map[string]any, error) {
-	audience := "kubespiffed"
+// PSATValidation sets the audience and issuer a PSAT must have
+type PSATValidation struct {
+	Audience string
+	Issuer   string
+}
+
+var DefaultPSATValidation = PSATValidation{
+	Audience: "kubespiffed",
+	Issuer:   "https://kubernetes.default.svc.cluster.local",
+}
+
+func VerifyPSAT(ctx context.Context, psat string, jwks *JWKS) (map[string]any, error) {
+	return DefaultPSATValidation.Verify(ctx, psat, jwks)
+}
+
+func (v PSATValidation) Verify(ctx context.Context, psat string, jwks *JWKS) (_ map[string]any, err error) {
+	_, span := tracing.Start(ctx, "VerifyPSAT")
+	defer func() { tracing.End(span, err) }()
+
+	audience := v.Audience
 	parser := jwt.NewParser(jwt.WithoutClaimsValidation())
 	unverifiedPSAT, _, err := parser.ParseUnverified(psat, jwt.MapClaims{})
 	if err != nil {
@@ -148,7 +247,7 @@ func VerifyPSAT(psat string, jwks *JWKS) (map[string]any, error) {
 		return nil, errors.New("invalid token claims")
 	}
 
-	if iss, ok := claims["iss"].(string); !ok || iss != "https://kubernetes.default.svc.cluster.local" {
+	if iss, ok := claims["iss"].(string); !ok || iss != v.Issuer {
 		return nil, fmt.Errorf("invalid issuer: %v", claims["iss"])
 	}
 	if aud, ok := claims["aud"].([]interface{}); ok {
@@ -171,13 +270,17 @@ func VerifyPSAT(psat string, jwks *JWKS) (map[string]any, error) {
 	return claims, nil
 }
 
+// ErrUnknownKey is returned by Verify for a PSAT signed with a key missing
+// from the JWKS, which may have been rotated since it was fetched
+var ErrUnknownKey = errors.New("no key found for kid")
+
 func findKeyByKID(jwks *JWKS, kid string) (map[string]interface{}, error) {
 	for _, key := range jwks.Keys {
 		if key["kid"] == kid {
 			return key, nil
 		}
 	}
-	return nil, fmt.Errorf("no key found for kid: %s", kid)
+	return nil, fmt.Errorf("%w: %s", ErrUnknownKey, kid)
 }
 
 func jwkToPublicKey(keyMap map[string]interface{}) (*rsa.PublicKey, error) {
@@ -204,6 +307,9 @@ type KubernetesWorkloadClaims struct {
 	Node           KubernetesResource `json:"node"`
 	Pod            KubernetesResource `json:"pod"`
 	ServiceAccount KubernetesResource `json:"serviceAccount"`
+
+	// Expiry is the PSAT's exp claim, and zero if it has none
+	Expiry time.Time `json:"-"`
 }
 
 type KubernetesResource struct {
@@ -211,13 +317,13 @@ type KubernetesResource struct {
 	UID  string `json:"uid"`
 }
 
-func AttestPod(
-	ctx context.Context,
-	cs *kubernetes.Clientset,
-	kscs *versioned.Clientset,
-	claims map[string]any,
-) (*v1alpha1.WorkloadRegistration, error) {
-	b, err := json.Marshal(claims)
+// ParseWorkloadClaims extracts the kubernetes.io claim from a verified PSAT
+func ParseWorkloadClaims(claims map[string]any) (*KubernetesWorkloadClaims, error) {
+	k8sClaims, ok := claims["kubernetes.io"].(map[string]any)
+	if !ok {
+		return nil, errors.New("missing kubernetes.io claim in token")
+	}
+	b, err := json.Marshal(k8sClaims)
 	if err != nil {
 		return nil, fmt.Errorf("marshal: %w", err)
 	}
@@ -225,6 +331,27 @@ func AttestPod(
 	if err := json.Unmarshal(b, &c); err != nil {
 		return nil, err
 	}
+	exp, err := jwt.MapClaims(claims).GetExpirationTime()
+	if err != nil {
+		return nil, err
+	}
+	if exp != nil {
+		c.Expiry = exp.Time
+	}
+	return &c, nil
+}
+
+func AttestPod(
+	ctx context.Context,
+	cs *kubernetes.Clientset,
+	kscs *versioned.Clientset,
+	c *KubernetesWorkloadClaims,
+) (_ *v1alpha1.WorkloadRegistration, err error) {
+	ctx, span := tracing.Start(ctx, "AttestPod", trace.WithAttributes(
+		attribute.String("k8s.namespace.name", c.Namespace),
+		attribute.String("k8s.pod.name", c.Pod.Name),
+	))
+	defer func() { tracing.End(span, err) }()
 
 	// Quick hacky prune of workload pod name in PSAT claim to test allow/deny policy
 	podName := strings.Split(c.Pod.Name, "-")[0]
@@ -241,3 +368,49 @@ func checkForLabel(pod *corev1.Pod, key, value string) error {
 	}
 	return nil
 }
+
+var (
+	// ErrUnauthenticated is returned by ReviewAccess for a token the API
+	// server doesn't accept
+	ErrUnauthenticated = errors.New("token not authenticated")
+	// ErrForbidden is returned by ReviewAccess when RBAC doesn't allow the
+	// request
+	ErrForbidden = errors.New("request not allowed")
+)
+
+// ReviewAccess authenticates a bearer token with a TokenReview, and checks
+// with a SubjectAccessReview that RBAC allows its user verb on the
+// non-resource URL path. It returns the user's name
+func ReviewAccess(ctx context.Context, cs kubernetes.Interface, token, verb, path string) (string, error) {
+	tr, err := cs.AuthenticationV1().TokenReviews().Create(ctx, &authnv1.TokenReview{
+		Spec: authnv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("problem with TokenReview: %w", err)
+	}
+	if !tr.Status.Authenticated {
+		return "", fmt.Errorf("%w: %s", ErrUnauthenticated, tr.Status.Error)
+	}
+
+	user := tr.Status.User
+	extra := make(map[string]authzv1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		extra[k] = authzv1.ExtraValue(v)
+	}
+	sar, err := cs.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authzv1.SubjectAccessReview{
+		Spec: authzv1.SubjectAccessReviewSpec{
+			User:                  user.Username,
+			UID:                   user.UID,
+			Groups:                user.Groups,
+			Extra:                 extra,
+			NonResourceAttributes: &authzv1.NonResourceAttributes{Path: path, Verb: verb},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("problem with SubjectAccessReview: %w", err)
+	}
+	if !sar.Status.Allowed {
+		return user.Username, fmt.Errorf("%w: %s %s for %s", ErrForbidden, verb, path, user.Username)
+	}
+	return user.Username, nil
+}
@@ -1,13 +1,27 @@
 package k8s
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"encoding/base64"
+	"encoding/json"
 	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authnv1 "k8s.io/api/authentication/v1"
+	authzv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func Test_extractBearer(t *testing.T) {
@@ -118,3 +132,108 @@ func Test_jwkToPublicKey(t *testing.T) {
 		})
 	}
 }
+
+func TestAPIServerJWKSSource(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer kubeconfig-token", r.Header.Get("Authorization"))
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   "https://kubernetes.default.svc.cluster.local",
+			"jwks_uri": "https://172.18.0.2:6443/openid/v1/jwks",
+		})
+	})
+	mux.HandleFunc("/openid/v1/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(JWKS{Keys: []map[string]any{{"kid": "psat", "kty": "RSA"}}})
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	cs, err := kubernetes.NewForConfig(&rest.Config{Host: ts.URL, BearerToken: "kubeconfig-token"})
+	require.NoError(t, err)
+	src := APIServerJWKSSource{Client: cs.Discovery().RESTClient()}
+
+	oidc, err := src.Discover(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "https://kubernetes.default.svc.cluster.local", oidc.Issuer)
+
+	jwks, err := src.Fetch(context.Background())
+	require.NoError(t, err)
+	require.Len(t, jwks.Keys, 1)
+	assert.Equal(t, "psat", jwks.Keys[0]["kid"])
+}
+
+func TestRESTConfig(t *testing.T) {
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, os.WriteFile(kubeconfig, []byte(`
+apiVersion: v1
+kind: Config
+clusters:
+- name: kind
+  cluster:
+    server: https://127.0.0.1:6443
+- name: other
+  cluster:
+    server: https://10.0.0.1:6443
+contexts:
+- name: kind-kubespiffe
+  context: {cluster: kind, user: dev}
+- name: other
+  context: {cluster: other, user: dev}
+current-context: kind-kubespiffe
+users:
+- name: dev
+  user: {token: dev-token}
+`), 0o600))
+
+	cfg, err := RESTConfig(kubeconfig, "")
+	require.NoError(t, err)
+	assert.Equal(t, "https://127.0.0.1:6443", cfg.Host)
+	assert.Equal(t, "dev-token", cfg.BearerToken)
+
+	cfg, err = RESTConfig(kubeconfig, "other")
+	require.NoError(t, err)
+	assert.Equal(t, "https://10.0.0.1:6443", cfg.Host)
+
+	_, err = RESTConfig(kubeconfig, "missing")
+	assert.Error(t, err)
+}
+
+func TestReviewAccess(t *testing.T) {
+	cs := fake.NewClientset()
+	// The operator token belongs to alice, who may only read
+	cs.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		tr := action.(k8stesting.CreateAction).GetObject().(*authnv1.TokenReview)
+		if tr.Spec.Token == "operator" {
+			tr.Status = authnv1.TokenReviewStatus{Authenticated: true, User: authnv1.UserInfo{Username: "alice", Groups: []string{"ops"}}}
+		}
+		return true, tr, nil
+	})
+	cs.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		sar := action.(k8stesting.CreateAction).GetObject().(*authzv1.SubjectAccessReview)
+		attrs := sar.Spec.NonResourceAttributes
+		sar.Status.Allowed = sar.Spec.User == "alice" && sar.Spec.Groups[0] == "ops" && attrs.Verb == "get" && attrs.Path == "/admin/v1/svids"
+		return true, sar, nil
+	})
+
+	tests := []struct {
+		name    string
+		token   string
+		verb    string
+		wantErr error
+	}{
+		{name: "allowed", token: "operator", verb: "get"},
+		{name: "not allowed", token: "operator", verb: "post", wantErr: ErrForbidden},
+		{name: "unauthenticated", token: "stolen", verb: "get", wantErr: ErrUnauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := ReviewAccess(context.Background(), cs, tt.token, tt.verb, "/admin/v1/svids")
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "alice", user)
+		})
+	}
+}
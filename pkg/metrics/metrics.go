@@ -0,0 +1,172 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	listers "github.com/jsnctl/kubespiffe/pkg/generated/listers/kubespiffe/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const namespace = "kubespiffe"
+
+// Attestation outcomes and the reasons workloads are rejected
+const (
+	OutcomeAccepted = "accepted"
+	OutcomeRejected = "rejected"
+
+	ReasonAttested      = "attested"
+	ReasonMissingToken  = "missing_token"
+	ReasonInvalidToken  = "invalid_token"
+	ReasonJWKS          = "jwks_unavailable"
+	ReasonNotRegistered = "not_registered"
+)
+
+// SVID types
+const (
+	TypeX509 = "x509"
+	TypeJWT  = "jwt"
+)
+
+// Limits requests are refused by
+const (
+	LimitPod            = "pod"
+	LimitServiceAccount = "service_account"
+	LimitNode           = "node"
+	LimitSigning        = "signing"
+)
+
+// Registry holds every kubespiffe metric, along with the Go runtime and
+// process collectors
+var Registry = prometheus.NewRegistry()
+
+var (
+	Attestations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "attestations_total",
+		Help:      "Workload attestations by outcome and reason.",
+	}, []string{"outcome", "reason"})
+
+	SVIDsIssued = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "svids_issued_total",
+		Help:      "SVIDs issued by WorkloadRegistration and SVID type.",
+	}, []string{"registration", "type"})
+
+	SVIDsDenied = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "svids_denied_total",
+		Help:      "SVIDs refused to attested workloads whose SPIFFE ID or pod is revoked.",
+	}, []string{"registration", "type"})
+
+	SVIDExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "svid_expiry_timestamp_seconds",
+		Help:      "Expiry of the most recently issued X509-SVID of each WorkloadRegistration.",
+	}, []string{"registration"})
+
+	CAExpiry = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ca_expiry_timestamp_seconds",
+		Help:      "Expiry of the CA currently signing X509-SVIDs.",
+	})
+
+	JWKSFetchDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "jwks_fetch_duration_seconds",
+		Help:      "Latency of fetching the service account signing keys.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	JWKSFetchErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jwks_fetch_errors_total",
+		Help:      "Failed fetches of the service account signing keys.",
+	})
+
+	Leader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "leader",
+		Help:      "Whether this replica holds the Lease and runs singleton duties.",
+	})
+
+	RateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Issuance requests refused by the per-pod, per-service-account or per-node rate limit, or the signing concurrency limit.",
+	}, []string{"limit"})
+
+	SigningInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "signing_in_flight",
+		Help:      "SVIDs being signed.",
+	})
+
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests by route, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"handler", "method", "code"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		Attestations,
+		SVIDsIssued,
+		SVIDsDenied,
+		SVIDExpiry,
+		CAExpiry,
+		JWKSFetchDuration,
+		JWKSFetchErrors,
+		Leader,
+		RateLimited,
+		SigningInFlight,
+		RequestDuration,
+	)
+}
+
+// Handler serves Registry in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// InstrumentHandler records the latency of requests to a route
+func InstrumentHandler(route string, h http.Handler) http.Handler {
+	return promhttp.InstrumentHandlerDuration(RequestDuration.MustCurryWith(prometheus.Labels{"handler": route}), h)
+}
+
+// Attestation records the outcome of attesting a workload
+func Attestation(outcome, reason string) {
+	Attestations.WithLabelValues(outcome, reason).Inc()
+}
+
+// ObserveJWKSFetch records the latency and outcome of a JWKS fetch started at
+// start
+func ObserveJWKSFetch(start time.Time, err error) {
+	JWKSFetchDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		JWKSFetchErrors.Inc()
+	}
+}
+
+// RegisterWorkloadRegistrations reports the number of WorkloadRegistrations
+// in the lister's cache
+func RegisterWorkloadRegistrations(lister listers.WorkloadRegistrationLister) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workload_registrations",
+		Help:      "WorkloadRegistrations in the cluster.",
+	}, func() float64 {
+		wrs, err := lister.List(labels.Everything())
+		if err != nil {
+			return 0
+		}
+		return float64(len(wrs))
+	}))
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	listers "github.com/jsnctl/kubespiffe/pkg/generated/listers/kubespiffe/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestHandler(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, name := range []string{"frontend", "backend"} {
+		require.NoError(t, indexer.Add(&v1alpha1.WorkloadRegistration{ObjectMeta: metav1.ObjectMeta{Name: name}}))
+	}
+	RegisterWorkloadRegistrations(listers.NewWorkloadRegistrationLister(indexer))
+
+	errorsBefore := testutil.ToFloat64(JWKSFetchErrors)
+	ObserveJWKSFetch(time.Now(), nil)
+	ObserveJWKSFetch(time.Now(), errors.New("connection refused"))
+	assert.Equal(t, errorsBefore+1, testutil.ToFloat64(JWKSFetchErrors))
+
+	h := InstrumentHandler("GET /v1/crl", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/crl", nil))
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	assert.Contains(t, body, "kubespiffe_workload_registrations 2")
+	assert.Contains(t, body, `kubespiffe_http_request_duration_seconds_count{code="418",handler="GET /v1/crl",method="get"} 1`)
+	assert.Contains(t, body, "kubespiffe_jwks_fetch_duration_seconds_count")
+	assert.Contains(t, body, "go_goroutines")
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/k8s"
+	"github.com/jsnctl/kubespiffe/pkg/metrics"
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+)
+
+// AdminHandler serves the operator-facing API. It is expected to be bound to
+// a listener that is not exposed through the workload Service. Every request
+// but /metrics must carry a Kubernetes bearer token whose user RBAC allows the
+// request's verb on its path, as a non-resource URL
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/v1/svids", s.requireAdmin(s.handleListSVIDs))
+	mux.HandleFunc("GET /admin/v1/svids/export", s.requireAdmin(s.handleExportSVIDs))
+	mux.HandleFunc("GET /admin/v1/revocations", s.requireAdmin(s.handleListRevocations))
+	mux.HandleFunc("POST /admin/v1/revocations", s.requireAdmin(s.handleRevoke))
+	mux.HandleFunc("DELETE /admin/v1/revocations", s.requireAdmin(s.handleLiftDenial))
+	mux.Handle("GET /metrics", metrics.Handler())
+	return mux
+}
+
+// requireAdmin refuses requests whose bearer token isn't allowed to make them
+func (s *Server) requireAdmin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := k8s.ExtractBearerToken(r.Header.Get("Authorization"))
+		if token == "" {
+			http.Error(w, "missing bearer token", http.StatusUnauthorized)
+			return
+		}
+		user, err := s.admins(r.Context(), token, strings.ToLower(r.Method), r.URL.Path)
+		switch {
+		case errors.Is(err, k8s.ErrUnauthenticated):
+			http.Error(w, "invalid bearer token", http.StatusUnauthorized)
+			return
+		case errors.Is(err, k8s.ErrForbidden):
+			slog.Warn("❌ Admin request refused", "user", user, "method", r.Method, "path", r.URL.Path)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		case err != nil:
+			slog.Error("problem reviewing admin request", "error", err)
+			http.Error(w, "problem reviewing access", http.StatusInternalServerError)
+			return
+		}
+		slog.Info("🔑 Admin request", "user", user, "method", r.Method, "path", r.URL.Path)
+		h(w, r)
+	}
+}
+
+func (s *Server) handleListSVIDs(w http.ResponseWriter, r *http.Request) {
+	q, err := ledgerQueryFrom(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := map[string]any{
+		"records": s.issuer.Ledger().Query(q),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleExportSVIDs(w http.ResponseWriter, r *http.Request) {
+	q, err := ledgerQueryFrom(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	if err := s.issuer.Ledger().Export(w, q); err != nil {
+		slog.Error("problem exporting ledger", "error", err)
+	}
+}
+
+func (s *Server) handleListRevocations(w http.ResponseWriter, r *http.Request) {
+	resp := map[string]any{
+		"revoked": s.issuer.Revoked(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	var req svid.RevocationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid revocation request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	serials, err := s.revoke(r.Context(), req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	slog.Info("🚫 SVIDs revoked", "request", req, "serials", serials)
+
+	resp := map[string]any{
+		"revokedSerials": serials,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleLiftDenial allows a SPIFFE ID or pod revoked through handleRevoke to
+// be issued SVIDs again. SVIDs already revoked stay revoked
+func (s *Server) handleLiftDenial(w http.ResponseWriter, r *http.Request) {
+	var req svid.RevocationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid revocation request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if (req.SPIFFEID == "") == (req.PodUID == "") || req.Serial != "" || req.Registration != "" {
+		http.Error(w, "exactly one of spiffeID or podUID must be set", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.lift(r.Context(), req); err != nil {
+		slog.Error("problem lifting denial", "request", req, "error", err)
+		http.Error(w, "problem lifting denial", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("✅ Denial lifted", "request", req)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func ledgerQueryFrom(values url.Values) (svid.LedgerQuery, error) {
+	q := svid.LedgerQuery{
+		Serial:       values.Get("serial"),
+		SPIFFEID:     values.Get("spiffeID"),
+		PodUID:       values.Get("podUID"),
+		Registration: values.Get("registration"),
+	}
+	if at := values.Get("at"); at != "" {
+		t, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			return q, fmt.Errorf("invalid at %q: must be RFC3339", at)
+		}
+		q.At = t
+	}
+	return q, nil
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	"github.com/jsnctl/kubespiffe/pkg/k8s"
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func mockIssuedServer(t *testing.T) *Server {
+	issuer, err := svid.NewSVIDIssuer()
+	require.NoError(t, err)
+
+	for _, name := range []string{"workload", "another"} {
+		wr := &v1alpha1.WorkloadRegistration{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       v1alpha1.WorkloadRegistrationSpec{SPIFFEID: "spiffe://example.org/" + name},
+		}
+		_, _, err := issuer.IssueX509SVID(context.Background(), wr, svid.Workload{PodName: name, PodUID: name + "-uid"})
+		require.NoError(t, err)
+	}
+	return New(nil, nil, issuer, mockAdmins)
+}
+
+// mockAdmins allows the admin token everything and the reader token only reads
+var mockAdmins = WithAdminAuthorizer(func(_ context.Context, token, verb, _ string) (string, error) {
+	switch {
+	case token == "admin", token == "reader" && verb == "get":
+		return token, nil
+	case token == "reader":
+		return token, k8s.ErrForbidden
+	}
+	return "", k8s.ErrUnauthenticated
+})
+
+func adminRequest(method, path, token string, body io.Reader) *http.Request {
+	req := httptest.NewRequest(method, path, body)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func TestAdminListSVIDs(t *testing.T) {
+	srv := mockIssuedServer(t)
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantCount  int
+	}{
+		{
+			name:       "all records",
+			query:      "",
+			wantStatus: http.StatusOK,
+			wantCount:  2,
+		},
+		{
+			name:       "by SPIFFE ID",
+			query:      "?spiffeID=spiffe://example.org/workload",
+			wantStatus: http.StatusOK,
+			wantCount:  1,
+		},
+		{
+			name:       "invalid time",
+			query:      "?at=yesterday",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			srv.AdminHandler().ServeHTTP(rec, adminRequest(http.MethodGet, "/admin/v1/svids"+tt.query, "reader", nil))
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Records []svid.IssuanceRecord `json:"records"`
+			}
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Len(t, resp.Records, tt.wantCount)
+		})
+	}
+}
+
+func TestAdminExportSVIDs(t *testing.T) {
+	srv := mockIssuedServer(t)
+
+	rec := httptest.NewRecorder()
+	srv.AdminHandler().ServeHTTP(rec, adminRequest(http.MethodGet, "/admin/v1/svids/export", "reader", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/x-ndjson", rec.Header().Get("Content-Type"))
+	assert.Len(t, strings.Split(strings.TrimSpace(rec.Body.String()), "\n"), 2)
+}
+
+func TestAdminAuthorization(t *testing.T) {
+	srv := mockIssuedServer(t)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		wantStatus int
+	}{
+		{name: "no token", method: http.MethodGet, path: "/admin/v1/svids", wantStatus: http.StatusUnauthorized},
+		{name: "invalid token", method: http.MethodGet, path: "/admin/v1/svids/export", token: "workload", wantStatus: http.StatusUnauthorized},
+		{name: "read", method: http.MethodGet, path: "/admin/v1/revocations", token: "reader", wantStatus: http.StatusOK},
+		{name: "revoke without permission", method: http.MethodPost, path: "/admin/v1/revocations", token: "reader", wantStatus: http.StatusForbidden},
+		{name: "revoke", method: http.MethodPost, path: "/admin/v1/revocations", token: "admin", wantStatus: http.StatusOK},
+		{name: "lift without permission", method: http.MethodDelete, path: "/admin/v1/revocations", token: "reader", wantStatus: http.StatusForbidden},
+		{name: "lift", method: http.MethodDelete, path: "/admin/v1/revocations", token: "admin", wantStatus: http.StatusNoContent},
+		{name: "metrics", method: http.MethodGet, path: "/metrics", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			body := strings.NewReader(`{"spiffeID": "spiffe://example.org/another"}`)
+			srv.AdminHandler().ServeHTTP(rec, adminRequest(tt.method, tt.path, tt.token, body))
+			assert.Equal(t, tt.wantStatus, rec.Code, rec.Body.String())
+		})
+	}
+	assert.True(t, srv.issuer.IsRevoked(srv.issuer.Ledger().Query(svid.LedgerQuery{SPIFFEID: "spiffe://example.org/another"})[0].Serial))
+
+	// Once the denial is lifted, the SPIFFE ID is issued SVIDs again
+	wr := &v1alpha1.WorkloadRegistration{
+		ObjectMeta: metav1.ObjectMeta{Name: "another"},
+		Spec:       v1alpha1.WorkloadRegistrationSpec{SPIFFEID: "spiffe://example.org/another"},
+	}
+	_, _, err := srv.issuer.IssueX509SVID(context.Background(), wr, svid.Workload{PodName: "another", PodUID: "another-uid"})
+	assert.NoError(t, err)
+
+	// Without a Kubernetes client, nothing is allowed
+	rec := httptest.NewRecorder()
+	New(nil, nil, srv.issuer).AdminHandler().ServeHTTP(rec, adminRequest(http.MethodGet, "/admin/v1/svids", "admin", nil))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
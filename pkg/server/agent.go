@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/jsnctl/kubespiffe/pkg/api"
+	"github.com/jsnctl/kubespiffe/pkg/k8s"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	maxAgentRequestBytes = 4 * 1024
+)
+
+// WithAgentServiceAccounts allows the given service accounts, in
+// namespace/name form, to request SVIDs for pods on their own node
+func WithAgentServiceAccounts(accounts ...string) Option {
+	return func(s *Server) {
+		for _, a := range accounts {
+			s.agents[a] = struct{}{}
+		}
+	}
+}
+
+// handleAgentSVID issues an SVID to a pod on behalf of a node agent. The agent
+// authenticates with its own PSAT, and may only act for pods scheduled to the
+// node its token is bound to
+func (s *Server) handleAgentSVID(w http.ResponseWriter, r *http.Request) {
+	agent, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	agentID := fmt.Sprintf("%s/%s", agent.Namespace, agent.ServiceAccount.Name)
+	if _, ok := s.agents[agentID]; !ok {
+		slog.Info("❌ Agent rejected", "serviceAccount", agentID)
+		http.Error(w, "service account is not an agent", http.StatusForbidden)
+		return
+	}
+	if agent.Node.Name == "" {
+		http.Error(w, "agent token is not bound to a node", http.StatusForbidden)
+		return
+	}
+
+	var req api.AgentSVIDRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAgentRequestBytes)).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid agent request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	pod, err := s.cs.CoreV1().Pods(req.Namespace).Get(r.Context(), req.PodName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		http.Error(w, "pod not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("problem getting pod", "namespace", req.Namespace, "pod", req.PodName, "error", err)
+		http.Error(w, "problem getting pod", http.StatusInternalServerError)
+		return
+	}
+	if string(pod.UID) != req.PodUID || pod.Spec.NodeName != agent.Node.Name {
+		slog.Info("❌ Agent rejected", "serviceAccount", agentID, "node", agent.Node.Name, "pod", req.PodName)
+		http.Error(w, "pod is not on the agent's node", http.StatusForbidden)
+		return
+	}
+
+	s.issueSVID(w, r, &k8s.KubernetesWorkloadClaims{
+		Namespace:      pod.Namespace,
+		Node:           k8s.KubernetesResource{Name: pod.Spec.NodeName},
+		Pod:            k8s.KubernetesResource{Name: pod.Name, UID: string(pod.UID)},
+		ServiceAccount: k8s.KubernetesResource{Name: pod.Spec.ServiceAccountName},
+	})
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/jsnctl/kubespiffe/pkg/authz"
+)
+
+const (
+	maxAuthorizeRequestBytes = 4 * 1024
+)
+
+// WithAuthorization serves decisions from evaluator at /v1/authorize
+func WithAuthorization(evaluator *authz.Evaluator) Option {
+	return func(s *Server) {
+		s.authz = evaluator
+	}
+}
+
+// handleAuthorize decides whether the source SPIFFE ID may call the
+// destination, for services and proxies that authenticate peers themselves
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	var req authz.Request
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAuthorizeRequestBytes)).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid authorization request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.Destination == "" {
+		http.Error(w, "invalid authorization request: missing destination", http.StatusBadRequest)
+		return
+	}
+
+	decision, err := s.authz.Evaluate(req)
+	if err != nil {
+		slog.Error("problem evaluating policy", "error", err)
+		http.Error(w, "problem evaluating policy", http.StatusInternalServerError)
+		return
+	}
+	if !decision.Allowed {
+		slog.Info("⛔ Call denied", "source", req.Source, "destination", req.Destination, "method", req.Method, "path", req.Path)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(decision)
+}
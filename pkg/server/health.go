@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// jwksMaxAge is how long fetched JWKS are used to verify PSATs, and keep
+	// kubespiffed ready, before they are fetched again
+	jwksMaxAge = 5 * time.Minute
+	// jwksMinRefresh is how long fetched JWKS are used before a PSAT signed
+	// with a key missing from them has them fetched again
+	jwksMinRefresh = 10 * time.Second
+
+	readinessCheckTimeout = 5 * time.Second
+)
+
+// ReadinessCheck reports why kubespiffed can't serve workloads yet
+type ReadinessCheck func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check ReadinessCheck
+}
+
+// WithReadinessCheck adds a check to /readyz, alongside the JWKS and CA
+func WithReadinessCheck(name string, check ReadinessCheck) Option {
+	return func(s *Server) {
+		s.checks = append(s.checks, namedCheck{name, check})
+	}
+}
+
+// InformersSynced is ready once every informer has synced its cache
+func InformersSynced(synced ...cache.InformerSynced) ReadinessCheck {
+	return func(context.Context) error {
+		for _, hasSynced := range synced {
+			if !hasSynced() {
+				return errors.New("informer caches have not synced")
+			}
+		}
+		return nil
+	}
+}
+
+// Drain makes /readyz fail and ends every SVID stream and SDS stream, so that
+// in-flight requests can finish and streaming clients reconnect elsewhere
+// before the listeners are shut down
+func (s *Server) Drain() {
+	s.drainOnce.Do(func() {
+		slog.Info("🚰 Draining")
+		close(s.draining)
+	})
+}
+
+func (s *Server) isDraining() bool {
+	select {
+	case <-s.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// handleHealthz reports that the process is serving, for liveness probes
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReadyz runs every readiness check, listing each result in the style
+// of the API server's ?verbose output
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessCheckTimeout)
+	defer cancel()
+
+	checks := append([]namedCheck{
+		{"jwks", s.checkJWKS},
+		{"ca", func(context.Context) error { return s.issuer.CheckCA() }},
+	}, s.checks...)
+
+	var out strings.Builder
+	ready := true
+	if s.isDraining() {
+		ready = false
+		out.WriteString("[-]shutdown failed: draining\n")
+	}
+	for _, c := range checks {
+		if err := c.check(ctx); err != nil {
+			ready = false
+			fmt.Fprintf(&out, "[-]%s failed: %v\n", c.name, err)
+			continue
+		}
+		fmt.Fprintf(&out, "[+]%s ok\n", c.name)
+	}
+
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(status)
+	fmt.Fprint(w, out.String())
+}
+
+// checkJWKS fetches the JWKS unless it was fetched successfully within
+// jwksMaxAge, so probes don't add to the API server's load
+func (s *Server) checkJWKS(ctx context.Context) error {
+	_, err := s.currentJWKS(ctx, false)
+	return err
+}
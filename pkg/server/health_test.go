@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/api"
+	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	"github.com/jsnctl/kubespiffe/pkg/k8s"
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func readyz(t *testing.T, srv *Server) (int, string) {
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	return rec.Code, rec.Body.String()
+}
+
+func TestReadyz(t *testing.T) {
+	issuer, err := svid.NewSVIDIssuer()
+	require.NoError(t, err)
+
+	t.Run("waits for informers", func(t *testing.T) {
+		var synced atomic.Bool
+		psats, _ := mockPSATs(t)
+		srv := New(nil, nil, issuer, psats, WithReadinessCheck("informers", InformersSynced(synced.Load)))
+
+		code, body := readyz(t, srv)
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Contains(t, body, "[+]jwks ok")
+		assert.Contains(t, body, "[+]ca ok")
+		assert.Contains(t, body, "[-]informers failed")
+
+		synced.Store(true)
+		code, _ = readyz(t, srv)
+		assert.Equal(t, http.StatusOK, code)
+	})
+
+	t.Run("JWKS is only fetched when stale", func(t *testing.T) {
+		fetches := 0
+		var fail bool
+		srv := New(nil, nil, issuer, WithJWKS(func(context.Context) (*k8s.JWKS, error) {
+			fetches++
+			if fail {
+				return nil, errors.New("connection refused")
+			}
+			return &k8s.JWKS{}, nil
+		}))
+
+		code, _ := readyz(t, srv)
+		assert.Equal(t, http.StatusOK, code)
+		fail = true
+		code, _ = readyz(t, srv)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 1, fetches)
+
+		srv.jwksCache.Store(&cachedJWKS{jwks: &k8s.JWKS{}, fetchedAt: time.Now().Add(-jwksMaxAge)})
+		code, body := readyz(t, srv)
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Contains(t, body, "[-]jwks failed: connection refused")
+	})
+
+	t.Run("CA expiring before an SVID would", func(t *testing.T) {
+		shortLived, err := svid.NewSVIDIssuer(svid.WithCATTL(time.Minute))
+		require.NoError(t, err)
+		psats, _ := mockPSATs(t)
+
+		code, body := readyz(t, New(nil, nil, shortLived, psats))
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Contains(t, body, "[-]ca failed")
+	})
+}
+
+func TestDrain(t *testing.T) {
+	issuer, err := svid.NewSVIDIssuer()
+	require.NoError(t, err)
+	psats, sign := mockPSATs(t)
+	srv := New(nil, nil, issuer, psats, mockAttestor(&v1alpha1.WorkloadRegistration{
+		ObjectMeta: metav1.ObjectMeta{Name: "workload"},
+		Spec:       v1alpha1.WorkloadRegistrationSpec{SPIFFEID: "spiffe://example.org/workload"},
+	}))
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/v1/svid/stream", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+sign("workload"))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	events := bufio.NewReader(resp.Body)
+	event, _ := nextEvent(t, events)
+	assert.Equal(t, api.EventSVID, event)
+
+	srv.Drain()
+	srv.Drain()
+
+	// The stream ends so the client reconnects to another replica
+	rest, err := io.ReadAll(events)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+
+	code, body := readyz(t, srv)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body, "[-]shutdown failed: draining")
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/jsnctl/kubespiffe/pkg/api"
+	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	"github.com/jsnctl/kubespiffe/pkg/events"
+	"github.com/jsnctl/kubespiffe/pkg/federation"
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+)
+
+// handleJWTSVID issues a JWT-SVID for each audience query parameter
+func (s *Server) handleJWTSVID(w http.ResponseWriter, r *http.Request) {
+	audience := r.URL.Query()["audience"]
+	if len(audience) == 0 {
+		http.Error(w, "at least one audience is required", http.StatusBadRequest)
+		return
+	}
+
+	workloadClaims, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	workload := workloadFrom(r, workloadClaims)
+	if err := s.limits.admit(workload); writeRateLimited(w, err) {
+		return
+	}
+	wr, ok := s.attestWorkload(w, r, workloadClaims)
+	if !ok {
+		return
+	}
+
+	release, err := s.limits.acquireSigning(r.Context())
+	switch {
+	case r.Context().Err() != nil:
+		// The client has gone, so there is no one to respond to
+		return
+	case err != nil:
+		writeRateLimited(w, err)
+		return
+	}
+	token, expiresAt, err := s.issuer.IssueJWTSVID(wr, workload, audience)
+	release()
+	if errors.Is(err, svid.ErrRevoked) {
+		slog.Info("❌ Pod rejected", "registration", wr.Name, "error", err)
+		s.events.Revoked(workload, wr, events.JWTSVID, err)
+		http.Error(w, "identity has been revoked", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, svid.ErrForeignTrustDomain) {
+		slog.Info("❌ Pod rejected", "registration", wr.Name, "error", err)
+		http.Error(w, "SPIFFE ID is outside the trust domain", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		slog.Error("problem issuing JWT-SVID", "error", err)
+		http.Error(w, "problem issuing JWT-SVID", http.StatusInternalServerError)
+		return
+	}
+	s.events.Issued(workload, wr, events.JWTSVID, expiresAt)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(api.JWTSVIDResponse{
+		Token:     token,
+		SPIFFEID:  wr.Spec.SPIFFEID,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// handleBundles returns the bundles a workload needs to validate JWT-SVIDs
+// and X509-SVIDs from its own and federated trust domains
+func (s *Server) handleBundles(w http.ResponseWriter, r *http.Request) {
+	workloadClaims, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	wr, ok := s.attestWorkload(w, r, workloadClaims)
+	if !ok {
+		return
+	}
+
+	bundles, err := s.trustBundlesFor(wr)
+	if err != nil {
+		slog.Error("problem encoding bundles", "error", err)
+		http.Error(w, "problem encoding bundles", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(api.BundlesResponse{Bundles: bundles})
+}
+
+// trustBundlesFor is bundlesFor with JWT authorities as well
+func (s *Server) trustBundlesFor(wr *v1alpha1.WorkloadRegistration) (map[string]json.RawMessage, error) {
+	td := s.issuer.TrustDomain()
+	local, err := federation.BundleFromAuthorities(td, s.issuer.Authorities(), federation.DefaultRefreshHint).Marshal()
+	if err != nil {
+		return nil, err
+	}
+	bundles := map[string]json.RawMessage{td: local}
+
+	for _, td := range wr.Spec.FederatesWith {
+		b, ok := s.federation.Get(td)
+		if !ok {
+			slog.Warn("no bundle for federated trust domain", "registration", wr.Name, "trustDomain", td)
+			continue
+		}
+		data, err := b.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		bundles[td] = data
+	}
+	return bundles, nil
+}
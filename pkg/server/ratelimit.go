@@ -0,0 +1,226 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/metrics"
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// maxRequestBytes bounds the body of any workload API request. Handlers
+	// expecting a body may set a lower limit of their own
+	maxRequestBytes = 64 * 1024
+
+	// signingQueueTimeout is how long a request waits for a signing slot
+	// before it is refused
+	signingQueueTimeout = time.Second
+	// limiterPruneInterval is how often idle per-key buckets are dropped
+	limiterPruneInterval = time.Minute
+)
+
+// Rate is a token bucket refilled at PerSecond up to Burst. A zero rate is
+// unlimited
+type Rate struct {
+	PerSecond float64
+	Burst     int
+}
+
+// RateLimits caps how often each pod, service account and node is issued
+// SVIDs, and how many SVIDs are signed at once. Limits left zero are off
+type RateLimits struct {
+	PerPod               Rate
+	PerServiceAccount    Rate
+	PerNode              Rate
+	MaxConcurrentSigning int
+}
+
+// DefaultRateLimits leave room for a pod's containers to fetch their SVIDs
+// together at start-up, and for a node full of pods to start at once
+var DefaultRateLimits = RateLimits{
+	PerPod:               Rate{PerSecond: 1, Burst: 10},
+	PerServiceAccount:    Rate{PerSecond: 10, Burst: 50},
+	PerNode:              Rate{PerSecond: 20, Burst: 100},
+	MaxConcurrentSigning: 16,
+}
+
+// WithRateLimits refuses issuance beyond limits with 429 Too Many Requests
+func WithRateLimits(limits RateLimits) Option {
+	return func(s *Server) {
+		s.limits = newRateLimiter(limits)
+	}
+}
+
+// rateLimitError refuses a request, saying how long to wait before retrying
+type rateLimitError struct {
+	limit      string
+	retryAfter time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("%s rate limit exceeded, retry after %s", e.limit, e.retryAfter)
+}
+
+// writeRateLimited writes a 429 response if err is a rate limit error,
+// rounding Retry-After up to whole seconds
+func writeRateLimited(w http.ResponseWriter, err error) bool {
+	var limited *rateLimitError
+	if !errors.As(err, &limited) {
+		return false
+	}
+	seconds := int(math.Ceil(limited.retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+	http.Error(w, limited.Error(), http.StatusTooManyRequests)
+	return true
+}
+
+// keyedLimiter holds a token bucket per key
+type keyedLimiter struct {
+	limit string
+	rate  Rate
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+	pruned   time.Time
+}
+
+func newKeyedLimiter(limit string, r Rate) *keyedLimiter {
+	if r.PerSecond <= 0 {
+		return nil
+	}
+	return &keyedLimiter{limit: limit, rate: r, limiters: make(map[string]*rate.Limiter)}
+}
+
+func (k *keyedLimiter) reserve(key string, now time.Time) *rate.Reservation {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	// A full bucket behaves like a new one, so dropping it loses nothing
+	if now.Sub(k.pruned) > limiterPruneInterval {
+		for key, l := range k.limiters {
+			if l.TokensAt(now) >= float64(l.Burst()) {
+				delete(k.limiters, key)
+			}
+		}
+		k.pruned = now
+	}
+
+	l, ok := k.limiters[key]
+	if !ok {
+		l = rate.NewLimiter(rate.Limit(k.rate.PerSecond), k.rate.Burst)
+		k.limiters[key] = l
+	}
+	return l.ReserveN(now, 1)
+}
+
+type rateLimiter struct {
+	pod            *keyedLimiter
+	serviceAccount *keyedLimiter
+	node           *keyedLimiter
+	signing        chan struct{}
+}
+
+func newRateLimiter(limits RateLimits) *rateLimiter {
+	l := &rateLimiter{
+		pod:            newKeyedLimiter(metrics.LimitPod, limits.PerPod),
+		serviceAccount: newKeyedLimiter(metrics.LimitServiceAccount, limits.PerServiceAccount),
+		node:           newKeyedLimiter(metrics.LimitNode, limits.PerNode),
+	}
+	if limits.MaxConcurrentSigning > 0 {
+		l.signing = make(chan struct{}, limits.MaxConcurrentSigning)
+	}
+	return l
+}
+
+// admit takes a token from each of the workload's buckets, or from none of
+// them if any is empty, in which case the longest wait is returned
+func (l *rateLimiter) admit(workload svid.Workload) error {
+	if l == nil {
+		return nil
+	}
+
+	now := time.Now()
+	var (
+		reservations []*rate.Reservation
+		refused      *rateLimitError
+	)
+	var serviceAccount string
+	if workload.ServiceAccount != "" {
+		serviceAccount = workload.Namespace + "/" + workload.ServiceAccount
+	}
+	for _, b := range []struct {
+		limiter *keyedLimiter
+		key     string
+	}{
+		{l.pod, workload.PodUID},
+		{l.serviceAccount, serviceAccount},
+		{l.node, workload.Node},
+	} {
+		if b.limiter == nil || b.key == "" {
+			continue
+		}
+		r := b.limiter.reserve(b.key, now)
+		reservations = append(reservations, r)
+		delay := r.DelayFrom(now)
+		if !r.OK() {
+			delay = time.Second
+		}
+		if delay > 0 && (refused == nil || delay > refused.retryAfter) {
+			refused = &rateLimitError{limit: b.limiter.limit, retryAfter: delay}
+		}
+	}
+	if refused == nil {
+		return nil
+	}
+
+	for _, r := range reservations {
+		r.CancelAt(now)
+	}
+	metrics.RateLimited.WithLabelValues(refused.limit).Inc()
+	return refused
+}
+
+// acquireSigning waits up to signingQueueTimeout for a signing slot, returning
+// a func to release it
+func (l *rateLimiter) acquireSigning(ctx context.Context) (func(), error) {
+	if l == nil || l.signing == nil {
+		return func() {}, nil
+	}
+
+	timer := time.NewTimer(signingQueueTimeout)
+	defer timer.Stop()
+	select {
+	case l.signing <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+		metrics.RateLimited.WithLabelValues(metrics.LimitSigning).Inc()
+		return nil, &rateLimitError{limit: metrics.LimitSigning, retryAfter: time.Second}
+	}
+
+	metrics.SigningInFlight.Inc()
+	return func() {
+		metrics.SigningInFlight.Dec()
+		<-l.signing
+	}, nil
+}
+
+// limitBody refuses request bodies larger than maxRequestBytes
+func limitBody(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > maxRequestBytes {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBytes)
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	"github.com/jsnctl/kubespiffe/pkg/metrics"
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// slow refills so slowly that a test never sees a token come back
+var slow = Rate{PerSecond: 0.001, Burst: 2}
+
+func TestRateLimitedHandler(t *testing.T) {
+	issuer, err := svid.NewSVIDIssuer()
+	require.NoError(t, err)
+	psats, sign := mockPSATs(t)
+	var registrations []*v1alpha1.WorkloadRegistration
+	for _, name := range []string{"limited-a", "limited-b"} {
+		registrations = append(registrations, &v1alpha1.WorkloadRegistration{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       v1alpha1.WorkloadRegistrationSpec{SPIFFEID: "spiffe://example.org/" + name},
+		})
+	}
+	srv := New(nil, nil, issuer, psats, mockAttestor(registrations...), WithRateLimits(RateLimits{PerPod: slow}))
+	limited := testutil.ToFloat64(metrics.RateLimited.WithLabelValues(metrics.LimitPod))
+
+	get := func(path, pod string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+sign(pod))
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+	assert.Equal(t, http.StatusOK, get("/v1/svid", "limited-a").Code)
+	assert.Equal(t, http.StatusOK, get("/v1/jwt-svid?audience=db", "limited-a").Code)
+
+	rec := get("/v1/svid", "limited-a")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	retryAfter, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+	require.NoError(t, err)
+	assert.Greater(t, retryAfter, 1)
+	assert.Equal(t, http.StatusTooManyRequests, get("/v1/jwt-svid?audience=db", "limited-a").Code)
+	assert.Equal(t, limited+2, testutil.ToFloat64(metrics.RateLimited.WithLabelValues(metrics.LimitPod)))
+
+	// Other pods have buckets of their own
+	assert.Equal(t, http.StatusOK, get("/v1/svid", "limited-b").Code)
+}
+
+func TestAdmit(t *testing.T) {
+	workload := func(pod, serviceAccount, node string) svid.Workload {
+		return svid.Workload{PodUID: pod, Namespace: "default", ServiceAccount: serviceAccount, Node: node}
+	}
+
+	tests := []struct {
+		name      string
+		limits    RateLimits
+		workloads []svid.Workload
+		refused   []string
+	}{
+		{
+			name:      "per pod",
+			limits:    RateLimits{PerPod: slow},
+			workloads: []svid.Workload{workload("a", "sa", "node"), workload("a", "sa", "node"), workload("a", "sa", "node"), workload("b", "sa", "node")},
+			refused:   []string{"", "", metrics.LimitPod, ""},
+		},
+		{
+			name:      "per service account",
+			limits:    RateLimits{PerServiceAccount: slow},
+			workloads: []svid.Workload{workload("a", "sa", "node"), workload("b", "sa", "node"), workload("c", "sa", "node"), workload("d", "other", "node")},
+			refused:   []string{"", "", metrics.LimitServiceAccount, ""},
+		},
+		{
+			name:      "per node",
+			limits:    RateLimits{PerNode: slow},
+			workloads: []svid.Workload{workload("a", "sa", "node"), workload("b", "other", "node"), workload("c", "sa", "node"), workload("d", "sa", "other")},
+			refused:   []string{"", "", metrics.LimitNode, ""},
+		},
+		{
+			// The refused request takes nothing from the service account
+			name:      "refused by one bucket",
+			limits:    RateLimits{PerPod: Rate{PerSecond: 0.001, Burst: 1}, PerServiceAccount: slow},
+			workloads: []svid.Workload{workload("a", "sa", "node"), workload("a", "sa", "node"), workload("b", "sa", "node"), workload("c", "sa", "node")},
+			refused:   []string{"", metrics.LimitPod, "", metrics.LimitServiceAccount},
+		},
+		{
+			name:      "without node",
+			limits:    RateLimits{PerNode: slow},
+			workloads: []svid.Workload{workload("a", "sa", ""), workload("b", "sa", ""), workload("c", "sa", "")},
+			refused:   []string{"", "", ""},
+		},
+		{
+			name:      "off",
+			workloads: []svid.Workload{workload("a", "sa", "node"), workload("a", "sa", "node"), workload("a", "sa", "node")},
+			refused:   []string{"", "", ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRateLimiter(tt.limits)
+			for i, w := range tt.workloads {
+				err := l.admit(w)
+				if tt.refused[i] == "" {
+					assert.NoError(t, err, i)
+					continue
+				}
+				var limited *rateLimitError
+				require.ErrorAs(t, err, &limited, i)
+				assert.Equal(t, tt.refused[i], limited.limit, i)
+				assert.Greater(t, limited.retryAfter, time.Duration(0), i)
+			}
+		})
+	}
+
+	var l *rateLimiter
+	assert.NoError(t, l.admit(workload("a", "sa", "node")))
+}
+
+func TestAcquireSigning(t *testing.T) {
+	l := newRateLimiter(RateLimits{MaxConcurrentSigning: 1})
+	release, err := l.acquireSigning(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.SigningInFlight))
+
+	_, err = l.acquireSigning(context.Background())
+	var limited *rateLimitError
+	require.ErrorAs(t, err, &limited)
+	assert.Equal(t, metrics.LimitSigning, limited.limit)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = l.acquireSigning(ctx)
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	// A slot released while waiting is taken
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		release()
+	}()
+	release, err = l.acquireSigning(context.Background())
+	require.NoError(t, err)
+	release()
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.SigningInFlight))
+}
+
+func TestLimitBody(t *testing.T) {
+	issuer, err := svid.NewSVIDIssuer()
+	require.NoError(t, err)
+	psats, sign := mockPSATs(t)
+	srv := New(nil, nil, issuer, psats)
+
+	body := strings.Repeat("x", maxRequestBytes+1)
+	req := httptest.NewRequest(http.MethodPost, "/v1/agent/svid", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+sign("agent"))
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+	// Without a Content-Length, the body is cut off while it is read
+	req = httptest.NewRequest(http.MethodPost, "/v1/ocsp", strings.NewReader(body))
+	req.ContentLength = -1
+	var read error
+	limitBody(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, read = r.Body.Read(make([]byte, maxRequestBytes+1))
+		for read == nil {
+			_, read = r.Body.Read(make([]byte, 1))
+		}
+	})).ServeHTTP(httptest.NewRecorder(), req)
+	var tooLarge *http.MaxBytesError
+	assert.ErrorAs(t, read, &tooLarge)
+}
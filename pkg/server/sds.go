@@ -0,0 +1,365 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	tlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	secretv3 "github.com/envoyproxy/go-control-plane/envoy/service/secret/v3"
+	"github.com/jsnctl/kubespiffe/pkg/events"
+	"github.com/jsnctl/kubespiffe/pkg/k8s"
+	"github.com/jsnctl/kubespiffe/pkg/metrics"
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+const (
+	SecretTypeURL = "type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.Secret"
+
+	// DefaultSecretName is the workload's X509-SVID, RootCASecretName the
+	// bundle of its own trust domain, and AllBundlesSecretName a SPIFFE
+	// validator for its own and federated trust domains. The bundle of a single
+	// trust domain is named by its spiffe:// URI
+	DefaultSecretName    = "default"
+	RootCASecretName     = "ROOTCA"
+	AllBundlesSecretName = "ALL"
+
+	spiffeValidatorName = "envoy.tls.cert_validator.spiffe"
+)
+
+// SDSServer implements the Envoy Secret Discovery Service for the workload an
+// Envoy fronts, which it attests with the PSAT sent as a bearer token in the
+// authorization metadata
+type SDSServer struct {
+	secretv3.UnimplementedSecretDiscoveryServiceServer
+	s *Server
+}
+
+// SDSServer serves secrets issued by s over gRPC
+func (s *Server) SDSServer() *SDSServer {
+	return &SDSServer{s: s}
+}
+
+// FetchSecrets attests the workload and returns the requested secrets once
+func (sds *SDSServer) FetchSecrets(ctx context.Context, req *discoveryv3.DiscoveryRequest) (*discoveryv3.DiscoveryResponse, error) {
+	claims, workload, err := sds.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	current, err := sds.issue(ctx, claims, workload)
+	if err != nil {
+		return nil, err
+	}
+	resources, err := sds.resources(req.GetResourceNames(), current)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "problem building secrets")
+	}
+	return &discoveryv3.DiscoveryResponse{
+		VersionInfo: current.serial(),
+		Resources:   resources,
+		TypeUrl:     SecretTypeURL,
+	}, nil
+}
+
+// StreamSecrets attests the workload and streams the secrets it requests,
+// pushing a renewed SVID half way through each SVID's lifetime and fresh
+// bundles when the CA is rotated or a federated bundle is refreshed. The
+// stream ends once the workload can no longer be issued an SVID, and with
+// Unauthenticated when the PSAT it was opened with expires
+func (sds *SDSServer) StreamSecrets(stream secretv3.SecretDiscoveryService_StreamSecretsServer) error {
+	ctx := stream.Context()
+	claims, workload, err := sds.authenticate(ctx)
+	if err != nil {
+		return err
+	}
+	current, err := sds.issue(ctx, claims, workload)
+	if err != nil {
+		return err
+	}
+
+	updates := sds.s.subscribeUpdates()
+	defer updates.Close()
+
+	reqs := make(chan *discoveryv3.DiscoveryRequest)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case reqs <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		names   []string
+		nonce   string
+		version int
+		sent    []byte
+	)
+	// send pushes the requested secrets, skipping a push that would repeat
+	// what Envoy already holds unless it has asked again
+	send := func(force bool) error {
+		if len(names) == 0 {
+			return nil
+		}
+		resources, err := sds.resources(names, current)
+		if err != nil {
+			return status.Error(codes.Internal, "problem building secrets")
+		}
+		var content []byte
+		for _, r := range resources {
+			content = append(content, r.GetValue()...)
+		}
+		if !force && bytes.Equal(content, sent) {
+			return nil
+		}
+
+		version++
+		nonce = strconv.Itoa(version)
+		if err := stream.Send(&discoveryv3.DiscoveryResponse{
+			VersionInfo: nonce,
+			Resources:   resources,
+			TypeUrl:     SecretTypeURL,
+			Nonce:       nonce,
+		}); err != nil {
+			return err
+		}
+		sent = content
+		slog.Info("✅ Secrets pushed", "registration", current.registration.Name, "secrets", names, "version", version)
+		return nil
+	}
+
+	renew := time.NewTimer(time.Until(current.renewAt()))
+	defer renew.Stop()
+	var renewalFailed bool
+	reissue := func() error {
+		next, err := sds.issue(ctx, claims, workload)
+		switch status.Code(err) {
+		case codes.PermissionDenied, codes.Unauthenticated, codes.Canceled, codes.DeadlineExceeded:
+			return err
+		}
+		if err != nil {
+			slog.Error("problem renewing SVID", "registration", current.registration.Name, "error", err)
+			if !renewalFailed {
+				sds.s.events.Expiring(workload, current.registration, events.X509SVID, current.cert.NotAfter, err)
+				renewalFailed = true
+			}
+			renew.Reset(renewRetryInterval)
+			return nil
+		}
+		renewalFailed = false
+		current = next
+		renew.Reset(time.Until(current.renewAt()))
+		return send(false)
+	}
+	// push replaces a revoked SVID rather than pushing it again
+	push := func(force bool) error {
+		if sds.s.revoked(current) {
+			return reissue()
+		}
+		return send(force)
+	}
+
+	expired, stopExpiry := tokenExpiry(claims)
+	defer stopExpiry()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-updates.draining:
+			return status.Error(codes.Unavailable, "kubespiffed is shutting down")
+		case <-expired:
+			return status.Error(codes.Unauthenticated, errTokenExpired.Error())
+		case err := <-recvErr:
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		case req := <-reqs:
+			// Envoy acknowledges each push by echoing its nonce, and requests
+			// answering an older push are superseded by the latest one
+			if req.GetResponseNonce() != nonce {
+				continue
+			}
+			if req.GetErrorDetail() != nil {
+				slog.Warn("secrets rejected by Envoy", "registration", current.registration.Name, "error", req.GetErrorDetail().GetMessage())
+				continue
+			}
+			if nonce != "" && slices.Equal(req.GetResourceNames(), names) {
+				continue
+			}
+			names = req.GetResourceNames()
+			if err := push(true); err != nil {
+				return err
+			}
+		case <-renew.C:
+			if err := reissue(); err != nil {
+				return err
+			}
+		case <-updates.revocations:
+			if !sds.s.revoked(current) {
+				continue
+			}
+			if err := reissue(); err != nil {
+				return err
+			}
+		case <-updates.rotations:
+			if err := push(false); err != nil {
+				return err
+			}
+		case <-updates.federation:
+			if err := push(false); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// authenticate verifies the PSAT in the request metadata
+func (sds *SDSServer) authenticate(ctx context.Context) (*k8s.KubernetesWorkloadClaims, svid.Workload, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var token string
+	if values := md.Get("authorization"); len(values) > 0 {
+		token = k8s.ExtractBearerToken(values[0])
+	}
+	if token == "" {
+		metrics.Attestation(metrics.OutcomeRejected, metrics.ReasonMissingToken)
+		return nil, svid.Workload{}, status.Error(codes.Unauthenticated, "missing bearer token")
+	}
+
+	claims, err := sds.s.verifyToken(ctx, token)
+	if errors.Is(err, errJWKS) {
+		return nil, svid.Workload{}, status.Error(codes.Unavailable, "problem verifying token")
+	}
+	if err != nil {
+		return nil, svid.Workload{}, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	workload := svid.Workload{
+		PodName:        claims.Pod.Name,
+		PodUID:         claims.Pod.UID,
+		Namespace:      claims.Namespace,
+		Node:           claims.Node.Name,
+		ServiceAccount: claims.ServiceAccount.Name,
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		workload.RemoteAddr = p.Addr.String()
+	}
+	return claims, workload, nil
+}
+
+func (sds *SDSServer) issue(ctx context.Context, claims *k8s.KubernetesWorkloadClaims, workload svid.Workload) (*issuedSVID, error) {
+	current, err := sds.s.issue(ctx, claims, workload)
+	var limited *rateLimitError
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return nil, status.FromContextError(ctx.Err()).Err()
+	case errors.As(err, &limited):
+		return nil, status.Error(codes.ResourceExhausted, limited.Error())
+	case errors.Is(err, errTokenExpired):
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, errNotRegistered):
+		return nil, status.Error(codes.PermissionDenied, "workload is not registered")
+	case errors.Is(err, svid.ErrRevoked):
+		return nil, status.Error(codes.PermissionDenied, "identity has been revoked")
+	case err != nil:
+		slog.Error("problem issuing SVID", "error", err)
+		return nil, status.Error(codes.Internal, "problem issuing SVID")
+	}
+	return current, nil
+}
+
+// resources builds the named secrets, skipping names that are not known
+func (sds *SDSServer) resources(names []string, current *issuedSVID) ([]*anypb.Any, error) {
+	bundles := sds.s.bundlesFor(current.registration)
+
+	var resources []*anypb.Any
+	for _, name := range names {
+		secret := &tlsv3.Secret{Name: name}
+		switch {
+		case name == DefaultSecretName:
+			secret.Type = &tlsv3.Secret_TlsCertificate{TlsCertificate: &tlsv3.TlsCertificate{
+				CertificateChain: inlineBytes(current.certPEM),
+				PrivateKey:       inlineBytes(current.keyPEM),
+			}}
+		case name == RootCASecretName:
+			secret.Type = &tlsv3.Secret_ValidationContext{ValidationContext: &tlsv3.CertificateValidationContext{
+				TrustedCa: inlineBytes(encodeCertificates(sds.s.issuer.GetCACerts())),
+			}}
+		case name == AllBundlesSecretName:
+			validator, err := spiffeValidator(bundles)
+			if err != nil {
+				return nil, err
+			}
+			secret.Type = &tlsv3.Secret_ValidationContext{ValidationContext: &tlsv3.CertificateValidationContext{
+				CustomValidatorConfig: validator,
+			}}
+		case strings.HasPrefix(name, "spiffe://"):
+			bundle, ok := bundles[strings.TrimPrefix(name, "spiffe://")]
+			if !ok {
+				slog.Warn("no bundle for requested secret", "registration", current.registration.Name, "secret", name)
+				continue
+			}
+			secret.Type = &tlsv3.Secret_ValidationContext{ValidationContext: &tlsv3.CertificateValidationContext{
+				TrustedCa: inlineBytes(bundle),
+			}}
+		default:
+			slog.Warn("unknown secret requested", "registration", current.registration.Name, "secret", name)
+			continue
+		}
+
+		resource, err := anypb.New(secret)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, resource)
+	}
+	return resources, nil
+}
+
+// spiffeValidator configures Envoy's SPIFFE certificate validator, which
+// verifies a peer against the bundle of its own trust domain
+func spiffeValidator(bundles map[string][]byte) (*corev3.TypedExtensionConfig, error) {
+	trustDomains := make([]string, 0, len(bundles))
+	for td := range bundles {
+		trustDomains = append(trustDomains, td)
+	}
+	slices.Sort(trustDomains)
+
+	config := &tlsv3.SPIFFECertValidatorConfig{}
+	for _, td := range trustDomains {
+		config.TrustDomains = append(config.TrustDomains, &tlsv3.SPIFFECertValidatorConfig_TrustDomain{
+			Name:        td,
+			TrustBundle: inlineBytes(bundles[td]),
+		})
+	}
+	typed, err := anypb.New(config)
+	if err != nil {
+		return nil, err
+	}
+	return &corev3.TypedExtensionConfig{Name: spiffeValidatorName, TypedConfig: typed}, nil
+}
+
+func inlineBytes(b []byte) *corev3.DataSource {
+	return &corev3.DataSource{Specifier: &corev3.DataSource_InlineBytes{InlineBytes: b}}
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"testing"
+	"time"
+
+	tlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	secretv3 "github.com/envoyproxy/go-control-plane/envoy/service/secret/v3"
+	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	"github.com/jsnctl/kubespiffe/pkg/federation"
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type sdsFixture struct {
+	srv    *Server
+	issuer *svid.SVIDIssuer
+	store  *federation.Store
+	client secretv3.SecretDiscoveryServiceClient
+	sign   func(pod string, exp time.Time) string
+}
+
+func mockSDS(t *testing.T) *sdsFixture {
+	issuer, err := svid.NewSVIDIssuer()
+	require.NoError(t, err)
+	store := federation.NewStore()
+	require.NoError(t, store.Set(mockFederatedBundle(t, "partner.org")))
+
+	psats, sign := mockExpiringPSATs(t)
+	srv := New(nil, nil, issuer, psats, WithFederation(store), mockAttestor(&v1alpha1.WorkloadRegistration{
+		ObjectMeta: metav1.ObjectMeta{Name: "workload"},
+		Spec: v1alpha1.WorkloadRegistrationSpec{
+			SPIFFEID:      "spiffe://example.org/workload",
+			FederatesWith: []string{"partner.org"},
+		},
+	}))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	g := grpc.NewServer()
+	secretv3.RegisterSecretDiscoveryServiceServer(g, srv.SDSServer())
+	go g.Serve(l)
+	t.Cleanup(g.Stop)
+
+	conn, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return &sdsFixture{srv: srv, issuer: issuer, store: store, client: secretv3.NewSecretDiscoveryServiceClient(conn), sign: sign}
+}
+
+func (f *sdsFixture) ctx(t *testing.T, pod string) context.Context {
+	return f.ctxExpiring(t, pod, time.Now().Add(time.Hour))
+}
+
+// ctxExpiring carries a PSAT expiring at exp
+func (f *sdsFixture) ctxExpiring(t *testing.T, pod string, exp time.Time) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+f.sign(pod, exp))
+}
+
+// secrets unpacks a response by secret name
+func secrets(t *testing.T, resp *discoveryv3.DiscoveryResponse) map[string]*tlsv3.Secret {
+	require.Equal(t, SecretTypeURL, resp.GetTypeUrl())
+	out := make(map[string]*tlsv3.Secret)
+	for _, r := range resp.GetResources() {
+		var secret tlsv3.Secret
+		require.NoError(t, r.UnmarshalTo(&secret))
+		out[secret.GetName()] = &secret
+	}
+	return out
+}
+
+func certificateOf(t *testing.T, secret *tlsv3.Secret) *x509.Certificate {
+	block, _ := pem.Decode(secret.GetTlsCertificate().GetCertificateChain().GetInlineBytes())
+	require.NotNil(t, block)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	return cert
+}
+
+func TestFetchSecrets(t *testing.T) {
+	f := mockSDS(t)
+
+	resp, err := f.client.FetchSecrets(f.ctx(t, "workload"), &discoveryv3.DiscoveryRequest{
+		ResourceNames: []string{DefaultSecretName, RootCASecretName, AllBundlesSecretName, "spiffe://partner.org", "unknown"},
+	})
+	require.NoError(t, err)
+	got := secrets(t, resp)
+	require.Len(t, got, 4)
+
+	cert := certificateOf(t, got[DefaultSecretName])
+	assert.Equal(t, "spiffe://example.org/workload", cert.URIs[0].String())
+	assert.NotEmpty(t, got[DefaultSecretName].GetTlsCertificate().GetPrivateKey().GetInlineBytes())
+	assert.Equal(t, encodeCertificates(f.issuer.GetCACerts()), got[RootCASecretName].GetValidationContext().GetTrustedCa().GetInlineBytes())
+	assert.NotEmpty(t, got["spiffe://partner.org"].GetValidationContext().GetTrustedCa().GetInlineBytes())
+
+	var validator tlsv3.SPIFFECertValidatorConfig
+	require.NoError(t, got[AllBundlesSecretName].GetValidationContext().GetCustomValidatorConfig().GetTypedConfig().UnmarshalTo(&validator))
+	require.Len(t, validator.GetTrustDomains(), 2)
+	assert.Equal(t, "example.org", validator.GetTrustDomains()[0].GetName())
+	assert.Equal(t, "partner.org", validator.GetTrustDomains()[1].GetName())
+
+	_, err = f.client.FetchSecrets(f.ctx(t, "unregistered"), &discoveryv3.DiscoveryRequest{ResourceNames: []string{DefaultSecretName}})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = f.client.FetchSecrets(context.Background(), &discoveryv3.DiscoveryRequest{ResourceNames: []string{DefaultSecretName}})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestStreamSecrets(t *testing.T) {
+	f := mockSDS(t)
+
+	stream, err := f.client.StreamSecrets(f.ctx(t, "workload"))
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&discoveryv3.DiscoveryRequest{ResourceNames: []string{DefaultSecretName, RootCASecretName}}))
+
+	resp, err := stream.Recv()
+	require.NoError(t, err)
+	first := certificateOf(t, secrets(t, resp)[DefaultSecretName])
+	ack := func(resp *discoveryv3.DiscoveryResponse) {
+		require.NoError(t, stream.Send(&discoveryv3.DiscoveryRequest{
+			VersionInfo:   resp.GetVersionInfo(),
+			ResponseNonce: resp.GetNonce(),
+			ResourceNames: []string{DefaultSecretName, RootCASecretName},
+		}))
+	}
+	ack(resp)
+
+	// CA rotation pushes the new bundle
+	require.NoError(t, f.issuer.RotateCA())
+	resp, err = stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, encodeCertificates(f.issuer.GetCACerts()), secrets(t, resp)[RootCASecretName].GetValidationContext().GetTrustedCa().GetInlineBytes())
+	ack(resp)
+
+	// Revoking the current SVID pushes a replacement
+	_, err = f.issuer.Revoke(svid.RevocationRequest{Serial: first.SerialNumber.Text(16)})
+	require.NoError(t, err)
+	resp, err = stream.Recv()
+	require.NoError(t, err)
+	assert.NotEqual(t, first.SerialNumber, certificateOf(t, secrets(t, resp)[DefaultSecretName]).SerialNumber)
+	ack(resp)
+
+	// Denying the identity ends the stream
+	_, err = f.issuer.Revoke(svid.RevocationRequest{SPIFFEID: "spiffe://example.org/workload"})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestStreamSecretsDrain(t *testing.T) {
+	f := mockSDS(t)
+
+	stream, err := f.client.StreamSecrets(f.ctx(t, "workload"))
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&discoveryv3.DiscoveryRequest{ResourceNames: []string{DefaultSecretName}}))
+	_, err = stream.Recv()
+	require.NoError(t, err)
+
+	// Envoy reconnects to another replica on Unavailable
+	f.srv.Drain()
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestStreamSecretsTokenExpiry(t *testing.T) {
+	f := mockSDS(t)
+
+	stream, err := f.client.StreamSecrets(f.ctxExpiring(t, "workload", time.Now().Add(2*time.Second)))
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&discoveryv3.DiscoveryRequest{ResourceNames: []string{DefaultSecretName}}))
+	_, err = stream.Recv()
+	require.NoError(t, err)
+
+	// Envoy reconnects with its current PSAT on Unauthenticated
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
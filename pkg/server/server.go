@@ -0,0 +1,487 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/api"
+	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	"github.com/jsnctl/kubespiffe/pkg/authz"
+	"github.com/jsnctl/kubespiffe/pkg/events"
+	"github.com/jsnctl/kubespiffe/pkg/federation"
+	"github.com/jsnctl/kubespiffe/pkg/generated/clientset/versioned"
+	"github.com/jsnctl/kubespiffe/pkg/k8s"
+	"github.com/jsnctl/kubespiffe/pkg/metrics"
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	"github.com/jsnctl/kubespiffe/pkg/tracing"
+	"golang.org/x/crypto/ocsp"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	maxOCSPRequestBytes = 64 * 1024
+)
+
+// Server holds the dependencies shared by the kubespiffed HTTP handlers
+type Server struct {
+	cs         *kubernetes.Clientset
+	kscs       *versioned.Clientset
+	issuer     *svid.SVIDIssuer
+	federation *federation.Store
+	agents     map[string]struct{}
+	attest     AttestFunc
+	jwks       JWKSFunc
+	psat       k8s.PSATValidation
+	authz      *authz.Evaluator
+	events     *events.Recorder
+	crl        CRLFunc
+	revoke     RevokeFunc
+	lift       LiftDenialFunc
+	admins     AdminAuthorizer
+	limits     *rateLimiter
+
+	checks []namedCheck
+	// jwksCache holds the last keys fetched, and jwksFetch serialises fetches
+	jwksCache atomic.Pointer[cachedJWKS]
+	jwksFetch sync.Mutex
+	draining  chan struct{}
+	drainOnce sync.Once
+}
+
+type cachedJWKS struct {
+	jwks      *k8s.JWKS
+	fetchedAt time.Time
+}
+
+// AttestFunc resolves the verified PSAT claims of a workload to its
+// WorkloadRegistration
+type AttestFunc func(ctx context.Context, claims *k8s.KubernetesWorkloadClaims) (*v1alpha1.WorkloadRegistration, error)
+
+// JWKSFunc returns the keys that PSATs are verified with
+type JWKSFunc func(ctx context.Context) (*k8s.JWKS, error)
+
+// CRLFunc returns the DER encoded CRL served at /v1/crl, signed by the CA
+// with the key ID caID, or by the current CA if caID is empty
+type CRLFunc func(caID string) ([]byte, error)
+
+// RevokeFunc revokes the SVIDs selected by a request to the admin API
+type RevokeFunc func(context.Context, svid.RevocationRequest) ([]string, error)
+
+// LiftDenialFunc allows a SPIFFE ID or pod revoked through the admin API to be
+// issued SVIDs again
+type LiftDenialFunc func(context.Context, svid.RevocationRequest) error
+
+// AdminAuthorizer returns the user a bearer token belongs to, if it may make
+// a request with verb to the admin API's path
+type AdminAuthorizer func(ctx context.Context, token, verb, path string) (string, error)
+
+type Option func(*Server)
+
+// WithAttestor replaces attestation against the cluster's WorkloadRegistrations
+func WithAttestor(attest AttestFunc) Option {
+	return func(s *Server) {
+		s.attest = attest
+	}
+}
+
+// WithJWKS replaces fetching the cluster's service account signing keys
+func WithJWKS(jwks JWKSFunc) Option {
+	return func(s *Server) {
+		s.jwks = jwks
+	}
+}
+
+// WithPSATValidation replaces the audience and issuer PSATs must have
+func WithPSATValidation(v k8s.PSATValidation) Option {
+	return func(s *Server) {
+		s.psat = v
+	}
+}
+
+// WithCRL replaces serving the CRL signed by the issuer, such as with one
+// published by the leader of several replicas
+func WithCRL(crl CRLFunc) Option {
+	return func(s *Server) {
+		s.crl = crl
+	}
+}
+
+// WithRevoke replaces revoking with the issuer alone, such as to share
+// denials with other replicas
+func WithRevoke(revoke RevokeFunc) Option {
+	return func(s *Server) {
+		s.revoke = revoke
+	}
+}
+
+// WithLiftDenial replaces lifting denials with the issuer alone, such as to
+// lift them on every replica
+func WithLiftDenial(lift LiftDenialFunc) Option {
+	return func(s *Server) {
+		s.lift = lift
+	}
+}
+
+// WithAdminAuthorizer replaces reviewing admin API requests with the API
+// server's TokenReview and SubjectAccessReview
+func WithAdminAuthorizer(admins AdminAuthorizer) Option {
+	return func(s *Server) {
+		s.admins = admins
+	}
+}
+
+// WithEvents records Kubernetes Events on workloads' Pods and
+// WorkloadRegistrations as they are attested and issued SVIDs
+func WithEvents(recorder *events.Recorder) Option {
+	return func(s *Server) {
+		s.events = recorder
+	}
+}
+
+// WithFederation returns the foreign bundles held in store alongside SVIDs, to
+// workloads whose registration federates with them
+func WithFederation(store *federation.Store) Option {
+	return func(s *Server) {
+		s.federation = store
+	}
+}
+
+func New(cs *kubernetes.Clientset, kscs *versioned.Clientset, issuer *svid.SVIDIssuer, opts ...Option) *Server {
+	s := &Server{
+		cs:         cs,
+		kscs:       kscs,
+		issuer:     issuer,
+		federation: federation.NewStore(),
+		agents:     make(map[string]struct{}),
+		jwks:       k8s.GetKubernetesJWKS,
+		psat:       k8s.DefaultPSATValidation,
+		crl:        issuer.CRLFor,
+		draining:   make(chan struct{}),
+	}
+	s.attest = func(ctx context.Context, claims *k8s.KubernetesWorkloadClaims) (*v1alpha1.WorkloadRegistration, error) {
+		return k8s.AttestPod(ctx, s.cs, s.kscs, claims)
+	}
+	s.revoke = func(_ context.Context, req svid.RevocationRequest) ([]string, error) {
+		return issuer.Revoke(req)
+	}
+	s.lift = func(_ context.Context, req svid.RevocationRequest) error {
+		issuer.LiftDenial(req)
+		return nil
+	}
+	s.admins = func(ctx context.Context, token, verb, path string) (string, error) {
+		if s.cs == nil {
+			return "", errors.New("no Kubernetes client to review access with")
+		}
+		return k8s.ReviewAccess(ctx, s.cs, token, verb, path)
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Handler serves the workload-facing API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	handle := func(pattern string, h http.HandlerFunc) {
+		mux.Handle(pattern, metrics.InstrumentHandler(pattern, tracing.InstrumentHandler(pattern, h)))
+	}
+	handle("/v1/svid", s.handleSVID)
+	// Streams last as long as the workload, so their duration isn't latency
+	mux.HandleFunc("GET /v1/svid/stream", s.handleSVIDStream)
+	handle("GET /v1/jwt-svid", s.handleJWTSVID)
+	handle("GET /v1/bundles", s.handleBundles)
+	handle("POST /v1/agent/svid", s.handleAgentSVID)
+	handle("GET /v1/crl", s.handleCRL)
+	handle("POST /v1/ocsp", s.handleOCSP)
+	handle("GET /v1/ocsp/{request...}", s.handleOCSP)
+	mux.HandleFunc("GET /healthz", s.handleHealthz)
+	mux.HandleFunc("GET /readyz", s.handleReadyz)
+	if s.authz != nil {
+		handle("POST /v1/authorize", s.handleAuthorize)
+	}
+	return limitBody(mux)
+}
+
+func (s *Server) handleSVID(w http.ResponseWriter, r *http.Request) {
+	workloadClaims, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	s.issueSVID(w, r, workloadClaims)
+}
+
+// authenticate verifies the PSAT presented as a bearer token and returns its
+// kubernetes.io claims, writing an error response if it is not valid
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (*k8s.KubernetesWorkloadClaims, bool) {
+	token := k8s.ExtractBearerToken(r.Header.Get("Authorization"))
+	if token == "" {
+		metrics.Attestation(metrics.OutcomeRejected, metrics.ReasonMissingToken)
+		http.Error(w, "missing bearer token", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	workloadClaims, err := s.verifyToken(r.Context(), token)
+	if errors.Is(err, errJWKS) {
+		http.Error(w, "problem verifying token", http.StatusInternalServerError)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return nil, false
+	}
+	return workloadClaims, true
+}
+
+var errJWKS = errors.New("problem with JWKS")
+
+// verifyToken verifies a PSAT and returns its kubernetes.io claims. Failing to
+// fetch the keys to verify it with is reported as errJWKS
+func (s *Server) verifyToken(ctx context.Context, token string) (*k8s.KubernetesWorkloadClaims, error) {
+	jwks, err := s.currentJWKS(ctx, false)
+	if err != nil {
+		slog.Error("problem with JWKS", "error", err)
+		metrics.Attestation(metrics.OutcomeRejected, metrics.ReasonJWKS)
+		return nil, fmt.Errorf("%w: %w", errJWKS, err)
+	}
+
+	claims, err := s.psat.Verify(ctx, token, jwks)
+	if errors.Is(err, k8s.ErrUnknownKey) {
+		// The keys may have been rotated since they were cached
+		jwks, err = s.currentJWKS(ctx, true)
+		if err != nil {
+			slog.Error("problem with JWKS", "error", err)
+			metrics.Attestation(metrics.OutcomeRejected, metrics.ReasonJWKS)
+			return nil, fmt.Errorf("%w: %w", errJWKS, err)
+		}
+		claims, err = s.psat.Verify(ctx, token, jwks)
+	}
+	if err != nil {
+		slog.Error("problem with PSAT", "error", err)
+		metrics.Attestation(metrics.OutcomeRejected, metrics.ReasonInvalidToken)
+		return nil, err
+	}
+
+	workloadClaims, err := k8s.ParseWorkloadClaims(claims)
+	if err != nil {
+		slog.Error("problem with PSAT claims", "error", err)
+		metrics.Attestation(metrics.OutcomeRejected, metrics.ReasonInvalidToken)
+		return nil, err
+	}
+	return workloadClaims, nil
+}
+
+// currentJWKS returns the keys PSATs are verified with, fetching them once
+// they are older than jwksMaxAge. With refresh, as for a PSAT signed with an
+// unknown key, they are fetched once older than jwksMinRefresh, so that
+// invalid tokens can't drive fetches from the API server
+func (s *Server) currentJWKS(ctx context.Context, refresh bool) (*k8s.JWKS, error) {
+	maxAge := jwksMaxAge
+	if refresh {
+		maxAge = jwksMinRefresh
+	}
+	if cached := s.jwksCache.Load(); cached != nil && time.Since(cached.fetchedAt) < maxAge {
+		return cached.jwks, nil
+	}
+
+	s.jwksFetch.Lock()
+	defer s.jwksFetch.Unlock()
+	// Another request may have fetched them while this one waited
+	if cached := s.jwksCache.Load(); cached != nil && time.Since(cached.fetchedAt) < maxAge {
+		return cached.jwks, nil
+	}
+	jwks, err := s.fetchJWKS(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.jwksCache.Store(&cachedJWKS{jwks: jwks, fetchedAt: time.Now()})
+	return jwks, nil
+}
+
+// fetchJWKS fetches the keys PSATs are verified with, recording the latency
+// and outcome
+func (s *Server) fetchJWKS(ctx context.Context) (*k8s.JWKS, error) {
+	ctx, span := tracing.Start(ctx, "FetchJWKS")
+	start := time.Now()
+	jwks, err := s.jwks(ctx)
+	metrics.ObserveJWKSFetch(start, err)
+	tracing.End(span, err)
+	return jwks, err
+}
+
+// attestWorkload resolves the claims to a WorkloadRegistration, writing an
+// error response if the workload is not registered
+func (s *Server) attestWorkload(w http.ResponseWriter, r *http.Request, workloadClaims *k8s.KubernetesWorkloadClaims) (*v1alpha1.WorkloadRegistration, bool) {
+	wr, err := s.attest(r.Context(), workloadClaims)
+	if err != nil || wr == nil {
+		slog.Info("❌ Pod rejected", "error", err)
+		metrics.Attestation(metrics.OutcomeRejected, metrics.ReasonNotRegistered)
+		s.events.NotRegistered(workloadFrom(r, workloadClaims), err)
+		http.Error(w, "workload is not registered", http.StatusForbidden)
+		return nil, false
+	}
+	slog.Info("✅ Pod attested", "registration", wr.Name, "spec", wr.Spec)
+	metrics.Attestation(metrics.OutcomeAccepted, metrics.ReasonAttested)
+	return wr, true
+}
+
+// issueSVID attests the workload described by the claims against its
+// WorkloadRegistration and writes the SVID response
+func (s *Server) issueSVID(w http.ResponseWriter, r *http.Request, workloadClaims *k8s.KubernetesWorkloadClaims) {
+	workload := workloadFrom(r, workloadClaims)
+	if err := s.limits.admit(workload); writeRateLimited(w, err) {
+		return
+	}
+	wr, ok := s.attestWorkload(w, r, workloadClaims)
+	if !ok {
+		return
+	}
+
+	release, err := s.limits.acquireSigning(r.Context())
+	switch {
+	case r.Context().Err() != nil:
+		// The client has gone, so there is no one to respond to
+		return
+	case err != nil:
+		writeRateLimited(w, err)
+		return
+	}
+	x509SVID, svidKey, err := s.issuer.IssueX509SVID(r.Context(), wr, workload)
+	release()
+	if errors.Is(err, svid.ErrRevoked) {
+		slog.Info("❌ Pod rejected", "registration", wr.Name, "error", err)
+		s.events.Revoked(workload, wr, events.X509SVID, err)
+		http.Error(w, "identity has been revoked", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, svid.ErrForeignTrustDomain) {
+		slog.Info("❌ Pod rejected", "registration", wr.Name, "error", err)
+		http.Error(w, "SPIFFE ID is outside the trust domain", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		slog.Error("problem issuing SVID", "error", err)
+		http.Error(w, "problem issuing SVID", http.StatusInternalServerError)
+		return
+	}
+	if cert, err := x509.ParseCertificate(x509SVID); err == nil {
+		s.events.Issued(workload, wr, events.X509SVID, cert.NotAfter)
+	}
+
+	resp := api.SVIDResponse{
+		X509SVID:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: x509SVID}),
+		X509SVIDKey: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: svidKey}),
+		Bundle:      encodeCertificates(s.issuer.GetCACerts()),
+		Bundles:     s.bundlesFor(wr),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func workloadFrom(r *http.Request, workloadClaims *k8s.KubernetesWorkloadClaims) svid.Workload {
+	return svid.Workload{
+		PodName:        workloadClaims.Pod.Name,
+		PodUID:         workloadClaims.Pod.UID,
+		Namespace:      workloadClaims.Namespace,
+		Node:           workloadClaims.Node.Name,
+		ServiceAccount: workloadClaims.ServiceAccount.Name,
+		RemoteAddr:     r.RemoteAddr,
+	}
+}
+
+// handleCRL serves the CRL of the CA named by the ca query parameter, which
+// SVIDs' distribution points carry
+func (s *Server) handleCRL(w http.ResponseWriter, r *http.Request) {
+	crl, err := s.crl(r.URL.Query().Get("ca"))
+	if errors.Is(err, svid.ErrUnknownCA) {
+		http.Error(w, "unknown CA", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("problem generating CRL", "error", err)
+		http.Error(w, "problem generating CRL", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	w.WriteHeader(http.StatusOK)
+	w.Write(crl)
+}
+
+// handleOCSP accepts both RFC 6960 request forms: a DER POST body, or a
+// base64 encoded GET path segment
+func (s *Server) handleOCSP(w http.ResponseWriter, r *http.Request) {
+	var req []byte
+	var err error
+	if r.Method == http.MethodPost {
+		req, err = io.ReadAll(io.LimitReader(r.Body, maxOCSPRequestBytes))
+	} else {
+		var raw string
+		raw, err = url.PathUnescape(r.PathValue("request"))
+		if err == nil {
+			req, err = base64.StdEncoding.DecodeString(raw)
+		}
+	}
+	if err != nil {
+		http.Error(w, "malformed OCSP request", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := s.issuer.OCSPResponse(req)
+	if err != nil {
+		slog.Error("problem with OCSP request", "error", err)
+	}
+	if resp == nil {
+		// Failing to sign a response leaves none to send
+		resp = ocsp.InternalErrorErrorResponse
+	}
+
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// bundlesFor returns the X.509 authorities of the local trust domain and of
+// each foreign trust domain the registration federates with, keyed by trust
+// domain. Foreign trust domains that have not been fetched yet are left out
+func (s *Server) bundlesFor(wr *v1alpha1.WorkloadRegistration) map[string][]byte {
+	bundles := map[string][]byte{
+		s.issuer.TrustDomain(): encodeCertificates(s.issuer.GetCACerts()),
+	}
+
+	for _, td := range wr.Spec.FederatesWith {
+		b, ok := s.federation.Get(td)
+		if !ok {
+			slog.Warn("no bundle for federated trust domain", "registration", wr.Name, "trustDomain", td)
+			continue
+		}
+		var certs [][]byte
+		for _, c := range b.X509Authorities {
+			certs = append(certs, c.Raw)
+		}
+		bundles[td] = encodeCertificates(certs)
+	}
+	return bundles
+}
+
+func encodeCertificates(certs [][]byte) []byte {
+	var out []byte
+	for _, c := range certs {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c})...)
+	}
+	return out
+}
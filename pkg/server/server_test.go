@@ -0,0 +1,513 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	"github.com/jsnctl/kubespiffe/pkg/authz"
+	"github.com/jsnctl/kubespiffe/pkg/events"
+	"github.com/jsnctl/kubespiffe/pkg/federation"
+	listers "github.com/jsnctl/kubespiffe/pkg/generated/listers/kubespiffe/v1alpha1"
+	"github.com/jsnctl/kubespiffe/pkg/k8s"
+	"github.com/jsnctl/kubespiffe/pkg/metrics"
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	"github.com/jsnctl/kubespiffe/pkg/tracing"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+	"golang.org/x/crypto/ocsp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+// mockPSATs returns an Option verifying PSATs with a local key, and a func
+// signing a PSAT for the named pod
+func mockPSATs(t *testing.T) (Option, func(pod string) string) {
+	psats, sign := mockExpiringPSATs(t)
+	return psats, func(pod string) string { return sign(pod, time.Now().Add(time.Hour)) }
+}
+
+// mockExpiringPSATs is mockPSATs, signing PSATs that expire at exp
+func mockExpiringPSATs(t *testing.T) (Option, func(pod string, exp time.Time) string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	pub, err := jwk.New(&key.PublicKey)
+	require.NoError(t, err)
+	require.NoError(t, pub.Set(jwk.KeyIDKey, "psat"))
+	data, err := json.Marshal(pub)
+	require.NoError(t, err)
+	var jwkMap map[string]any
+	require.NoError(t, json.Unmarshal(data, &jwkMap))
+	jwks := &k8s.JWKS{Keys: []map[string]any{jwkMap}}
+
+	sign := func(pod string, exp time.Time) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss": "https://kubernetes.default.svc.cluster.local",
+			"aud": []string{"kubespiffed"},
+			"exp": exp.Unix(),
+			"kubernetes.io": map[string]any{
+				"namespace":      "default",
+				"pod":            map[string]any{"name": pod, "uid": pod + "-uid"},
+				"serviceaccount": map[string]any{"name": "default"},
+			},
+		})
+		token.Header["kid"] = "psat"
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+		return signed
+	}
+	return WithJWKS(func(context.Context) (*k8s.JWKS, error) { return jwks, nil }), sign
+}
+
+// mockAttestor attests pods by the registration named after them
+func mockAttestor(registrations ...*v1alpha1.WorkloadRegistration) Option {
+	return WithAttestor(func(ctx context.Context, c *k8s.KubernetesWorkloadClaims) (*v1alpha1.WorkloadRegistration, error) {
+		for _, wr := range registrations {
+			if wr.Name == c.Pod.Name {
+				return wr, nil
+			}
+		}
+		return nil, fmt.Errorf("no registration for %s", c.Pod.Name)
+	})
+}
+
+func mockFederatedBundle(t *testing.T, trustDomain string) *federation.Bundle {
+	issuer, err := svid.NewSVIDIssuer()
+	require.NoError(t, err)
+	return federation.BundleFromAuthorities(trustDomain, issuer.Authorities(), 0)
+}
+
+func TestBundlesFor(t *testing.T) {
+	issuer, err := svid.NewSVIDIssuer()
+	require.NoError(t, err)
+
+	store := federation.NewStore()
+	partner := mockFederatedBundle(t, "partner.org")
+	require.NoError(t, store.Set(partner))
+	require.NoError(t, store.Set(mockFederatedBundle(t, "other.org")))
+	srv := New(nil, nil, issuer, WithFederation(store))
+
+	tests := []struct {
+		name          string
+		spiffeID      string
+		federatesWith []string
+		want          []string
+	}{
+		{
+			name: "no federation",
+			want: []string{"example.org"},
+		},
+		{
+			// Such a registration is refused SVIDs, but its bundles are still
+			// keyed by the configured trust domain
+			name:     "SPIFFE ID in another trust domain",
+			spiffeID: "spiffe://elsewhere.org/ns/payments/sa/default",
+			want:     []string{"example.org"},
+		},
+		{
+			name:          "only selected trust domains",
+			federatesWith: []string{"partner.org"},
+			want:          []string{"example.org", "partner.org"},
+		},
+		{
+			name:          "trust domain not fetched yet",
+			federatesWith: []string{"partner.org", "unknown.org"},
+			want:          []string{"example.org", "partner.org"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spiffeID := tt.spiffeID
+			if spiffeID == "" {
+				spiffeID = "spiffe://example.org/ns/payments/sa/default"
+			}
+			wr := &v1alpha1.WorkloadRegistration{
+				ObjectMeta: metav1.ObjectMeta{Name: "payments"},
+				Spec: v1alpha1.WorkloadRegistrationSpec{
+					SPIFFEID:      spiffeID,
+					FederatesWith: tt.federatesWith,
+				},
+			}
+
+			bundles := srv.bundlesFor(wr)
+			got := []string{}
+			for td := range bundles {
+				got = append(got, td)
+			}
+			assert.ElementsMatch(t, tt.want, got)
+
+			if pemBytes, ok := bundles["partner.org"]; ok {
+				block, _ := pem.Decode(pemBytes)
+				require.NotNil(t, block)
+				cert, err := x509.ParseCertificate(block.Bytes)
+				require.NoError(t, err)
+				assert.True(t, partner.X509Authorities[0].Equal(cert))
+			}
+		})
+	}
+}
+
+// failingSigner can't sign anything
+type failingSigner struct{ crypto.Signer }
+
+func (failingSigner) Sign(io.Reader, []byte, crypto.SignerOpts) ([]byte, error) {
+	return nil, errors.New("HSM unavailable")
+}
+
+func TestHandleOCSP(t *testing.T) {
+	m, err := svid.GenerateMaterial(time.Hour)
+	require.NoError(t, err)
+	failing := m
+	failing.Signer = failingSigner{m.Signer}
+	cert := &x509.Certificate{SerialNumber: big.NewInt(42)}
+	ocspReq, err := ocsp.CreateRequest(cert, m.CACert, nil)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		material   svid.Material
+		wantStatus int
+		wantErr    error
+	}{
+		{
+			name:       "good",
+			material:   m,
+			wantStatus: ocsp.Good,
+		},
+		{
+			name:     "signing fails",
+			material: failing,
+			wantErr:  ocsp.ResponseError{Status: ocsp.InternalError},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer, err := svid.NewSVIDIssuer(svid.WithMaterial(tt.material))
+			require.NoError(t, err)
+			srv := New(nil, nil, issuer)
+
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/ocsp", bytes.NewReader(ocspReq)))
+			require.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "application/ocsp-response", rec.Header().Get("Content-Type"))
+
+			resp, err := ocsp.ParseResponseForCert(rec.Body.Bytes(), cert, m.CACert)
+			if tt.wantErr != nil {
+				assert.Equal(t, tt.wantErr, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, resp.Status)
+		})
+	}
+}
+
+func TestHandleCRL(t *testing.T) {
+	m, err := svid.GenerateMaterial(time.Hour)
+	require.NoError(t, err)
+	rotated, err := m.Rotate(time.Hour)
+	require.NoError(t, err)
+	issuer, err := svid.NewSVIDIssuer(svid.WithMaterial(rotated))
+	require.NoError(t, err)
+	srv := New(nil, nil, issuer)
+
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+		wantSigner *x509.Certificate
+	}{
+		{
+			name:       "current CA",
+			target:     "/v1/crl",
+			wantStatus: http.StatusOK,
+			wantSigner: rotated.CACert,
+		},
+		{
+			name:       "retired CA",
+			target:     "/v1/crl?ca=" + svid.CAKeyID(m.CACert),
+			wantStatus: http.StatusOK,
+			wantSigner: m.CACert,
+		},
+		{
+			name:       "unknown CA",
+			target:     "/v1/crl?ca=unknown",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			require.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantSigner == nil {
+				return
+			}
+			crl, err := x509.ParseRevocationList(rec.Body.Bytes())
+			require.NoError(t, err)
+			assert.NoError(t, crl.CheckSignatureFrom(tt.wantSigner))
+		})
+	}
+}
+
+func TestHandleAuthorize(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	require.NoError(t, indexer.Add(&v1alpha1.SpiffeAuthorizationPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "api"},
+		Spec: v1alpha1.SpiffeAuthorizationPolicySpec{
+			Destination: "spiffe://example.org/api",
+			Sources:     []string{"spiffe://example.org/frontend"},
+		},
+	}))
+	s := New(nil, nil, nil, WithAuthorization(authz.NewEvaluator(listers.NewSpiffeAuthorizationPolicyLister(indexer))))
+
+	tests := []struct {
+		name    string
+		body    string
+		status  int
+		allowed bool
+	}{
+		{"allowed", `{"source": "spiffe://example.org/frontend", "destination": "spiffe://example.org/api"}`, http.StatusOK, true},
+		{"denied", `{"source": "spiffe://example.org/intruder", "destination": "spiffe://example.org/api"}`, http.StatusOK, false},
+		{"missing destination", `{"source": "spiffe://example.org/frontend"}`, http.StatusBadRequest, false},
+		{"invalid body", `{`, http.StatusBadRequest, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/authorize", strings.NewReader(tt.body)))
+			require.Equal(t, tt.status, rec.Code, rec.Body.String())
+			if tt.status != http.StatusOK {
+				return
+			}
+			var decision authz.Decision
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&decision))
+			assert.Equal(t, tt.allowed, decision.Allowed)
+		})
+	}
+}
+
+func TestAttestationMetrics(t *testing.T) {
+	issuer, err := svid.NewSVIDIssuer()
+	require.NoError(t, err)
+	psats, sign := mockPSATs(t)
+	srv := New(nil, nil, issuer, psats, mockAttestor(&v1alpha1.WorkloadRegistration{
+		ObjectMeta: metav1.ObjectMeta{Name: "metrics-workload"},
+		Spec:       v1alpha1.WorkloadRegistrationSpec{SPIFFEID: "spiffe://example.org/metrics-workload"},
+	}))
+
+	count := func(outcome, reason string) float64 {
+		return testutil.ToFloat64(metrics.Attestations.WithLabelValues(outcome, reason))
+	}
+	accepted := count(metrics.OutcomeAccepted, metrics.ReasonAttested)
+	notRegistered := count(metrics.OutcomeRejected, metrics.ReasonNotRegistered)
+	invalid := count(metrics.OutcomeRejected, metrics.ReasonInvalidToken)
+
+	get := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/v1/svid", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusOK, get(sign("metrics-workload")))
+	assert.Equal(t, http.StatusForbidden, get(sign("unregistered")))
+	assert.Equal(t, http.StatusUnauthorized, get("not-a-psat"))
+
+	assert.Equal(t, accepted+1, count(metrics.OutcomeAccepted, metrics.ReasonAttested))
+	assert.Equal(t, notRegistered+1, count(metrics.OutcomeRejected, metrics.ReasonNotRegistered))
+	assert.Equal(t, invalid+1, count(metrics.OutcomeRejected, metrics.ReasonInvalidToken))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.SVIDsIssued.WithLabelValues("metrics-workload", metrics.TypeX509)))
+
+	_, err = issuer.Revoke(svid.RevocationRequest{SPIFFEID: "spiffe://example.org/metrics-workload"})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, get(sign("metrics-workload")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.SVIDsDenied.WithLabelValues("metrics-workload", metrics.TypeX509)))
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(tracenoop.NewTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+
+	issuer, err := svid.NewSVIDIssuer()
+	require.NoError(t, err)
+	psats, sign := mockPSATs(t)
+	srv := New(nil, nil, issuer, psats, mockAttestor(&v1alpha1.WorkloadRegistration{
+		ObjectMeta: metav1.ObjectMeta{Name: "tracing-workload"},
+		Spec:       v1alpha1.WorkloadRegistrationSpec{SPIFFEID: "spiffe://example.org/tracing-workload"},
+	}))
+
+	// The workload's span, propagated as it would be by pkg/client
+	clientCtx, clientSpan := provider.Tracer("workload").Start(context.Background(), "fetch")
+	req := httptest.NewRequest(http.MethodGet, "/v1/svid", nil)
+	req.Header.Set("Authorization", "Bearer "+sign("tracing-workload"))
+	tracing.Inject(clientCtx, req.Header)
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	clientSpan.End()
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	handler := spans["/v1/svid"]
+	require.NotNil(t, handler)
+	assert.Equal(t, clientSpan.SpanContext().TraceID(), handler.SpanContext().TraceID())
+	assert.Equal(t, clientSpan.SpanContext().SpanID(), handler.Parent().SpanID())
+	assert.Equal(t, trace.SpanKindServer, handler.SpanKind())
+
+	for _, name := range []string{"FetchJWKS", "VerifyPSAT", "IssueX509SVID"} {
+		span := spans[name]
+		require.NotNil(t, span, name)
+		assert.Equal(t, handler.SpanContext().SpanID(), span.Parent().SpanID(), name)
+	}
+	assert.Contains(t, spans["IssueX509SVID"].Attributes(), attribute.String("spiffe.id", "spiffe://example.org/tracing-workload"))
+
+	// A rejected token fails the VerifyPSAT span
+	recorder.Reset()
+	req = httptest.NewRequest(http.MethodGet, "/v1/svid", nil)
+	req.Header.Set("Authorization", "Bearer not-a-psat")
+	srv.Handler().ServeHTTP(httptest.NewRecorder(), req)
+	var verified bool
+	for _, span := range recorder.Ended() {
+		if span.Name() == "VerifyPSAT" {
+			verified = true
+			assert.Equal(t, codes.Error, span.Status().Code)
+		}
+	}
+	assert.True(t, verified)
+}
+
+func TestJWKSCache(t *testing.T) {
+	issuer, err := svid.NewSVIDIssuer()
+	require.NoError(t, err)
+	psats, sign := mockPSATs(t)
+	var mock Server
+	psats(&mock)
+
+	// The keys signing PSATs are rotated in after the first fetch
+	var rotated bool
+	fetches := 0
+	srv := New(nil, nil, issuer, WithJWKS(func(ctx context.Context) (*k8s.JWKS, error) {
+		fetches++
+		if !rotated {
+			return &k8s.JWKS{}, nil
+		}
+		return mock.jwks(ctx)
+	}), mockAttestor(&v1alpha1.WorkloadRegistration{
+		ObjectMeta: metav1.ObjectMeta{Name: "cached-workload"},
+		Spec:       v1alpha1.WorkloadRegistrationSpec{SPIFFEID: "spiffe://example.org/cached-workload"},
+	}))
+	get := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/v1/svid", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// An unknown key only has the keys fetched again once jwksMinRefresh passes
+	assert.Equal(t, http.StatusUnauthorized, get(sign("cached-workload")))
+	assert.Equal(t, http.StatusUnauthorized, get(sign("cached-workload")))
+	assert.Equal(t, 1, fetches)
+
+	rotated = true
+	cached := srv.jwksCache.Load()
+	srv.jwksCache.Store(&cachedJWKS{jwks: cached.jwks, fetchedAt: cached.fetchedAt.Add(-jwksMinRefresh)})
+	assert.Equal(t, http.StatusOK, get(sign("cached-workload")))
+	assert.Equal(t, http.StatusOK, get(sign("cached-workload")))
+	assert.Equal(t, 2, fetches)
+
+	// A flood of tokens signed with unknown keys doesn't reach the API server
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	unknown := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"aud": []string{"kubespiffed"}})
+	unknown.Header["kid"] = "unknown"
+	forged, err := unknown.SignedString(key)
+	require.NoError(t, err)
+	for range 10 {
+		assert.Equal(t, http.StatusUnauthorized, get(forged))
+	}
+	assert.Equal(t, 2, fetches)
+}
+
+func TestEvents(t *testing.T) {
+	issuer, err := svid.NewSVIDIssuer()
+	require.NoError(t, err)
+	psats, sign := mockPSATs(t)
+	recorder := record.NewFakeRecorder(10)
+	srv := New(nil, nil, issuer, psats, WithEvents(events.New(recorder)), mockAttestor(&v1alpha1.WorkloadRegistration{
+		ObjectMeta: metav1.ObjectMeta{Name: "events-workload"},
+		Spec:       v1alpha1.WorkloadRegistrationSpec{SPIFFEID: "spiffe://example.org/events-workload"},
+	}))
+
+	get := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/v1/svid", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec.Code
+	}
+	// next returns the reasons of the events recorded since it was last called
+	next := func() []string {
+		var reasons []string
+		for {
+			select {
+			case e := <-recorder.Events:
+				reasons = append(reasons, strings.Fields(e)[1])
+			default:
+				return reasons
+			}
+		}
+	}
+
+	assert.Equal(t, http.StatusOK, get(sign("events-workload")))
+	assert.Equal(t, []string{events.ReasonIssued, events.ReasonIssued}, next())
+
+	assert.Equal(t, http.StatusForbidden, get(sign("unregistered")))
+	assert.Equal(t, []string{events.ReasonNotRegistered}, next())
+
+	assert.Equal(t, http.StatusUnauthorized, get("not-a-psat"))
+	assert.Empty(t, next())
+
+	_, err = issuer.Revoke(svid.RevocationRequest{SPIFFEID: "spiffe://example.org/events-workload"})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, get(sign("events-workload")))
+	assert.Equal(t, []string{events.ReasonRevoked, events.ReasonRevoked}, next())
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/api"
+	"github.com/jsnctl/kubespiffe/pkg/events"
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+)
+
+const (
+	streamKeepAlive = 30 * time.Second
+)
+
+// handleSVIDStream attests the workload and streams its SVIDs as server-sent
+// events: a renewed SVID half way through each SVID's lifetime, bundles when
+// they change, and a final revoked event when the workload's identity is
+// revoked or its registration removed. The stream ends when the PSAT it was
+// opened with expires, so the workload reconnects with a current one
+func (s *Server) handleSVIDStream(w http.ResponseWriter, r *http.Request) {
+	claims, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ctx := r.Context()
+	workload := workloadFrom(r, claims)
+	current, err := s.issue(ctx, claims, workload)
+	switch {
+	case ctx.Err() != nil:
+		return
+	case writeRateLimited(w, err):
+		return
+	case errors.Is(err, errNotRegistered):
+		http.Error(w, "workload is not registered", http.StatusForbidden)
+		return
+	case errors.Is(err, svid.ErrRevoked):
+		http.Error(w, "identity has been revoked", http.StatusForbidden)
+		return
+	case err != nil:
+		slog.Error("problem issuing SVID", "error", err)
+		http.Error(w, "problem issuing SVID", http.StatusInternalServerError)
+		return
+	}
+
+	updates := s.subscribeUpdates()
+	defer updates.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	var sentBundles []byte
+	send := func(event string, data any) error {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	sendSVID := func() error {
+		resp := s.svidResponse(current)
+		sentBundles, _ = json.Marshal(api.BundlesUpdate{Bundle: resp.Bundle, Bundles: resp.Bundles})
+		return send(api.EventSVID, resp)
+	}
+	// sendBundles skips bundles the workload already holds
+	sendBundles := func() error {
+		update := api.BundlesUpdate{
+			Bundle:  encodeCertificates(s.issuer.GetCACerts()),
+			Bundles: s.bundlesFor(current.registration),
+		}
+		b, err := json.Marshal(update)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(b, sentBundles) {
+			return nil
+		}
+		sentBundles = b
+		return send(api.EventBundles, update)
+	}
+	// reissue replaces the SVID, ending the stream if that is refused
+	renew := time.NewTimer(time.Until(current.renewAt()))
+	defer renew.Stop()
+	var renewalFailed bool
+	reissue := func() (bool, error) {
+		next, err := s.issue(ctx, claims, workload)
+		switch {
+		case ctx.Err() != nil, errors.Is(err, errTokenExpired):
+			return true, nil
+		case errors.Is(err, errNotRegistered):
+			return true, send(api.EventRevoked, api.RevokedEvent{Reason: "workload is not registered"})
+		case errors.Is(err, svid.ErrRevoked):
+			return true, send(api.EventRevoked, api.RevokedEvent{Reason: "identity has been revoked"})
+		case err != nil:
+			slog.Error("problem renewing SVID", "registration", current.registration.Name, "error", err)
+			if !renewalFailed {
+				s.events.Expiring(workload, current.registration, events.X509SVID, current.cert.NotAfter, err)
+				renewalFailed = true
+			}
+			renew.Reset(renewRetryInterval)
+			return false, nil
+		}
+		renewalFailed = false
+		current = next
+		renew.Reset(time.Until(current.renewAt()))
+		return false, sendSVID()
+	}
+	pushBundles := func() (bool, error) {
+		if s.revoked(current) {
+			return reissue()
+		}
+		return false, sendBundles()
+	}
+
+	if err := sendSVID(); err != nil {
+		return
+	}
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	expired, stopExpiry := tokenExpiry(claims)
+	defer stopExpiry()
+	for {
+		var (
+			done bool
+			err  error
+		)
+		select {
+		case <-ctx.Done():
+			return
+		case <-updates.draining:
+			return
+		case <-expired:
+			slog.Info("SVID stream ended as its PSAT expired", "registration", current.registration.Name)
+			return
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case <-renew.C:
+			done, err = reissue()
+		case <-updates.revocations:
+			if s.revoked(current) {
+				done, err = reissue()
+			}
+		case <-updates.rotations:
+			done, err = pushBundles()
+		case <-updates.federation:
+			done, err = pushBundles()
+		}
+		if done || err != nil {
+			return
+		}
+	}
+}
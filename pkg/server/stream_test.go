@@ -0,0 +1,148 @@
+package server
+
+import (
+	"bufio"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/api"
+	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	"github.com/jsnctl/kubespiffe/pkg/k8s"
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// nextEvent reads a server-sent event, skipping keepalives
+func nextEvent(t *testing.T, r *bufio.Reader) (string, []byte) {
+	var event, data string
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && event != "":
+			return event, []byte(data)
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func serialOf(t *testing.T, data []byte) string {
+	var resp api.SVIDResponse
+	require.NoError(t, json.Unmarshal(data, &resp))
+	block, _ := pem.Decode(resp.X509SVID)
+	require.NotNil(t, block)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	return cert.SerialNumber.Text(16)
+}
+
+func TestSVIDStream(t *testing.T) {
+	issuer, err := svid.NewSVIDIssuer()
+	require.NoError(t, err)
+	psats, sign := mockPSATs(t)
+	srv := New(nil, nil, issuer, psats, mockAttestor(&v1alpha1.WorkloadRegistration{
+		ObjectMeta: metav1.ObjectMeta{Name: "workload"},
+		Spec:       v1alpha1.WorkloadRegistrationSpec{SPIFFEID: "spiffe://example.org/workload"},
+	}))
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	open := func(pod string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/v1/svid/stream", nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+sign(pod))
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := open("unregistered")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = open("workload")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	r := bufio.NewReader(resp.Body)
+
+	event, data := nextEvent(t, r)
+	require.Equal(t, api.EventSVID, event)
+	first := serialOf(t, data)
+
+	// CA rotation pushes the new bundles
+	require.NoError(t, issuer.RotateCA())
+	event, data = nextEvent(t, r)
+	require.Equal(t, api.EventBundles, event)
+	var bundles api.BundlesUpdate
+	require.NoError(t, json.Unmarshal(data, &bundles))
+	assert.Equal(t, encodeCertificates(issuer.GetCACerts()), bundles.Bundle)
+	assert.Equal(t, bundles.Bundle, bundles.Bundles["example.org"])
+
+	// Revoking the current SVID pushes a replacement
+	_, err = issuer.Revoke(svid.RevocationRequest{Serial: first})
+	require.NoError(t, err)
+	event, data = nextEvent(t, r)
+	require.Equal(t, api.EventSVID, event)
+	assert.NotEqual(t, first, serialOf(t, data))
+
+	// Denying the identity ends the stream
+	_, err = issuer.Revoke(svid.RevocationRequest{SPIFFEID: "spiffe://example.org/workload"})
+	require.NoError(t, err)
+	event, data = nextEvent(t, r)
+	require.Equal(t, api.EventRevoked, event)
+	var revoked api.RevokedEvent
+	require.NoError(t, json.Unmarshal(data, &revoked))
+	assert.Equal(t, "identity has been revoked", revoked.Reason)
+	_, err = r.ReadString('\n')
+	assert.Error(t, err)
+}
+
+func TestSVIDStreamTokenExpiry(t *testing.T) {
+	issuer, err := svid.NewSVIDIssuer()
+	require.NoError(t, err)
+	psats, sign := mockExpiringPSATs(t)
+	srv := New(nil, nil, issuer, psats, mockAttestor(&v1alpha1.WorkloadRegistration{
+		ObjectMeta: metav1.ObjectMeta{Name: "workload"},
+		Spec:       v1alpha1.WorkloadRegistrationSpec{SPIFFEID: "spiffe://example.org/workload"},
+	}))
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/v1/svid/stream", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+sign("workload", time.Now().Add(2*time.Second)))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	r := bufio.NewReader(resp.Body)
+
+	event, _ := nextEvent(t, r)
+	require.Equal(t, api.EventSVID, event)
+
+	// The stream ends without a revoked event once the PSAT expires
+	start := time.Now()
+	_, err = r.ReadString('\n')
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	// and no renewal is issued with the expired PSAT
+	_, err = srv.issue(req.Context(), &k8s.KubernetesWorkloadClaims{
+		Namespace: "default",
+		Pod:       k8s.KubernetesResource{Name: "workload", UID: "workload-uid"},
+		Expiry:    time.Now().Add(-time.Second),
+	}, svid.Workload{PodName: "workload"})
+	assert.ErrorIs(t, err, errTokenExpired)
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/api"
+	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	"github.com/jsnctl/kubespiffe/pkg/events"
+	"github.com/jsnctl/kubespiffe/pkg/k8s"
+	"github.com/jsnctl/kubespiffe/pkg/metrics"
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+)
+
+const (
+	renewRetryInterval = 10 * time.Second
+)
+
+var (
+	errNotRegistered = errors.New("workload is not registered")
+	errTokenExpired  = errors.New("PSAT has expired")
+)
+
+// issuedSVID is an X509-SVID held by a long-lived stream, which renews it
+// before it expires
+type issuedSVID struct {
+	registration *v1alpha1.WorkloadRegistration
+	cert         *x509.Certificate
+	certPEM      []byte
+	keyPEM       []byte
+}
+
+// renewAt is half way through the SVID's lifetime
+func (i *issuedSVID) renewAt() time.Time {
+	return i.cert.NotBefore.Add(i.cert.NotAfter.Sub(i.cert.NotBefore) / 2)
+}
+
+func (i *issuedSVID) serial() string {
+	return i.cert.SerialNumber.Text(16)
+}
+
+// issue attests the workload afresh and issues it an X509-SVID, so that a
+// stream stops renewing once the workload's registration is removed or the
+// PSAT it was opened with expires
+func (s *Server) issue(ctx context.Context, claims *k8s.KubernetesWorkloadClaims, workload svid.Workload) (*issuedSVID, error) {
+	if !claims.Expiry.IsZero() && !time.Now().Before(claims.Expiry) {
+		return nil, errTokenExpired
+	}
+	if err := s.limits.admit(workload); err != nil {
+		return nil, err
+	}
+	wr, err := s.attest(ctx, claims)
+	if err != nil || wr == nil {
+		slog.Info("❌ Pod rejected", "error", err)
+		metrics.Attestation(metrics.OutcomeRejected, metrics.ReasonNotRegistered)
+		s.events.NotRegistered(workload, err)
+		return nil, errNotRegistered
+	}
+	slog.Info("✅ Pod attested", "registration", wr.Name, "spec", wr.Spec)
+	metrics.Attestation(metrics.OutcomeAccepted, metrics.ReasonAttested)
+
+	release, err := s.limits.acquireSigning(ctx)
+	if err != nil {
+		return nil, err
+	}
+	certDER, keyDER, err := s.issuer.IssueX509SVID(ctx, wr, workload)
+	release()
+	if errors.Is(err, svid.ErrRevoked) {
+		slog.Info("❌ Pod rejected", "registration", wr.Name, "error", err)
+		s.events.Revoked(workload, wr, events.X509SVID, err)
+		return nil, err
+	}
+	if errors.Is(err, svid.ErrForeignTrustDomain) {
+		// The registration can't be served in this trust domain
+		slog.Info("❌ Pod rejected", "registration", wr.Name, "error", err)
+		return nil, errNotRegistered
+	}
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, err
+	}
+	s.events.Issued(workload, wr, events.X509SVID, cert.NotAfter)
+
+	return &issuedSVID{
+		registration: wr,
+		cert:         cert,
+		certPEM:      pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+		keyPEM:       pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
+
+// tokenExpiry fires once the PSAT a stream was opened with expires, so the
+// stream can end and the workload reconnect with a current one. It never
+// fires for a PSAT without an expiry
+func tokenExpiry(claims *k8s.KubernetesWorkloadClaims) (<-chan time.Time, func() bool) {
+	if claims.Expiry.IsZero() {
+		return nil, func() bool { return false }
+	}
+	t := time.NewTimer(time.Until(claims.Expiry))
+	return t.C, t.Stop
+}
+
+// updates signals a stream when the SVID or bundles it holds may be stale, and
+// when the server is draining so the stream should end
+type updates struct {
+	rotations   <-chan struct{}
+	federation  <-chan struct{}
+	revocations <-chan struct{}
+	draining    <-chan struct{}
+	unsubscribe []func()
+}
+
+func (s *Server) subscribeUpdates() *updates {
+	u := &updates{draining: s.draining}
+	var unsubscribe func()
+	u.rotations, unsubscribe = s.issuer.SubscribeRotations()
+	u.unsubscribe = append(u.unsubscribe, unsubscribe)
+	u.federation, unsubscribe = s.federation.Subscribe()
+	u.unsubscribe = append(u.unsubscribe, unsubscribe)
+	u.revocations, unsubscribe = s.issuer.SubscribeRevocationSignals()
+	u.unsubscribe = append(u.unsubscribe, unsubscribe)
+	return u
+}
+
+func (u *updates) Close() {
+	for _, unsubscribe := range u.unsubscribe {
+		unsubscribe()
+	}
+}
+
+// revoked reports whether the SVID a stream holds has been revoked, in which
+// case it must be replaced rather than pushed again
+func (s *Server) revoked(current *issuedSVID) bool {
+	return s.issuer.IsRevoked(current.serial())
+}
+
+func (s *Server) svidResponse(current *issuedSVID) api.SVIDResponse {
+	return api.SVIDResponse{
+		X509SVID:    current.certPEM,
+		X509SVIDKey: current.keyPEM,
+		Bundle:      encodeCertificates(s.issuer.GetCACerts()),
+		Bundles:     s.bundlesFor(current.registration),
+	}
+}
@@ -0,0 +1,84 @@
+package svid
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+)
+
+// JWTAuthority is a public key that JWT-SVIDs for the trust domain are signed with
+type JWTAuthority struct {
+	KeyID     string
+	PublicKey crypto.PublicKey
+}
+
+// Authorities is a snapshot of the trust domain's current signing authorities.
+// Sequence increases every time the set changes, so consumers such as the
+// bundle endpoint can tell when to republish
+type Authorities struct {
+	X509     []*x509.Certificate
+	JWT      []JWTAuthority
+	Sequence uint64
+}
+
+type jwtSigner struct {
+	keyID string
+	key   *ecdsa.PrivateKey
+}
+
+func createJWTSigner() (*jwtSigner, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	kid := make([]byte, 16)
+	if _, err := rand.Read(kid); err != nil {
+		return nil, err
+	}
+	return &jwtSigner{
+		keyID: base64.RawURLEncoding.EncodeToString(kid),
+		key:   key,
+	}, nil
+}
+
+func (i *SVIDIssuer) Authorities() Authorities {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	x509Authorities := []*x509.Certificate{i.caCert}
+	x509Authorities = append(x509Authorities, i.retired...)
+
+	jwtAuthorities := []JWTAuthority{{KeyID: i.jwt.keyID, PublicKey: i.jwt.key.Public()}}
+	if i.retiredJWT != nil {
+		jwtAuthorities = append(jwtAuthorities, JWTAuthority{KeyID: i.retiredJWT.keyID, PublicKey: i.retiredJWT.key.Public()})
+	}
+
+	return Authorities{
+		X509:     x509Authorities,
+		JWT:      jwtAuthorities,
+		Sequence: i.sequence,
+	}
+}
+
+// RotateJWTAuthority generates a fresh JWT signing key. The previous key is
+// still published until the next rotation, which comfortably outlives any
+// JWT-SVID it signed
+func (i *SVIDIssuer) RotateJWTAuthority() error {
+	signer, err := createJWTSigner()
+	if err != nil {
+		return fmt.Errorf("problem with JWT signing key: %w", err)
+	}
+
+	i.mu.Lock()
+	i.retiredJWT = i.jwt
+	i.jwt = signer
+	i.sequence++
+	i.mu.Unlock()
+
+	i.rotations.notify()
+	return nil
+}
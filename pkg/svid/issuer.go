@@ -1,55 +1,193 @@
 package svid
 
 import (
+	"context"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"errors"
 	"fmt"
 	"math/big"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	"github.com/jsnctl/kubespiffe/pkg/metrics"
+	"github.com/jsnctl/kubespiffe/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// SVIDIssuer is safe for concurrent use. The signing CA is guarded by mu so
+// that it can be swapped during rotation without blocking in-flight issuance
+// for longer than it takes to copy two pointers
 type SVIDIssuer struct {
-	signer crypto.Signer
-	caCert *x509.Certificate
-	svids  map[string][]byte
+	mu      sync.RWMutex
+	signer  crypto.Signer
+	caCert  *x509.Certificate
+	retired []*x509.Certificate
+	// retiredSigners holds the key of each retired CA, or nil where it isn't
+	// known, so that they can keep signing CRLs for the SVIDs they issued
+	retiredSigners []crypto.Signer
+	ledger         *Ledger
+
+	jwt        *jwtSigner
+	retiredJWT *jwtSigner
+	sequence   uint64
+
+	revocations *revocations
+	rotations   *rotations
+	crlURL      string
+	crlValidity time.Duration
+
+	ocsp         *ocspResponder
+	ocspURL      string
+	ocspValidity time.Duration
+
+	trustDomain string
+	x509TTL     time.Duration
+	jwtTTL      time.Duration
+	caTTL       time.Duration
+}
+
+const (
+	DefaultTrustDomain = "example.org"
+	DefaultX509SVIDTTL = 5 * time.Minute
+	DefaultCATTL       = 24 * time.Hour
+)
+
+// ErrForeignTrustDomain refuses SVIDs for SPIFFE IDs outside the trust domain
+// the issuer signs for
+var ErrForeignTrustDomain = errors.New("SPIFFE ID is outside the trust domain")
+
+type Option func(*SVIDIssuer)
+
+// WithCA signs with an externally provided CA from the start, instead of one
+// generated by the issuer
+func WithCA(signer crypto.Signer, caCert *x509.Certificate) Option {
+	return func(i *SVIDIssuer) {
+		i.signer = signer
+		i.caCert = caCert
+	}
+}
+
+// WithTrustDomain sets the trust domain SVIDs are issued in. Registrations
+// whose SPIFFE ID is in another are refused
+func WithTrustDomain(td string) Option {
+	return func(i *SVIDIssuer) {
+		i.trustDomain = td
+	}
+}
+
+// WithX509SVIDTTL sets how long X509-SVIDs are valid for
+func WithX509SVIDTTL(ttl time.Duration) Option {
+	return func(i *SVIDIssuer) {
+		i.x509TTL = ttl
+	}
+}
+
+// WithJWTSVIDTTL sets how long JWT-SVIDs are valid for
+func WithJWTSVIDTTL(ttl time.Duration) Option {
+	return func(i *SVIDIssuer) {
+		i.jwtTTL = ttl
+	}
+}
+
+// WithCATTL sets how long generated CAs are valid for
+func WithCATTL(ttl time.Duration) Option {
+	return func(i *SVIDIssuer) {
+		i.caTTL = ttl
+	}
+}
+
+// WithCRLDistributionPoint embeds the URL the CRL is published at in SVIDs,
+// with the key ID of the CA that signed them as its ca query parameter
+func WithCRLDistributionPoint(url string) Option {
+	return func(i *SVIDIssuer) {
+		i.crlURL = url
+	}
 }
 
-func NewSVIDIssuer() (*SVIDIssuer, error) {
+// WithLedger replaces the default in-memory issuance ledger
+func WithLedger(l *Ledger) Option {
+	return func(i *SVIDIssuer) {
+		i.ledger = l
+	}
+}
+
+// WithOCSPServer embeds the OCSP responder URL in SVIDs as an Authority
+// Information Access extension
+func WithOCSPServer(url string) Option {
+	return func(i *SVIDIssuer) {
+		i.ocspURL = url
+	}
+}
+
+func NewSVIDIssuer(opts ...Option) (*SVIDIssuer, error) {
+	jwt, err := createJWTSigner()
+	if err != nil {
+		return nil, fmt.Errorf("problem with JWT signing key: %w", err)
+	}
+
+	issuer := &SVIDIssuer{
+		ledger:   NewLedger(DefaultLedgerRetention, nil),
+		jwt:      jwt,
+		sequence: 1,
+
+		revocations: newRevocations(),
+		rotations:   newRotations(),
+		crlValidity: DefaultCRLValidity,
+
+		ocsp:         &ocspResponder{},
+		ocspValidity: DefaultOCSPResponderValidity,
+
+		trustDomain: DefaultTrustDomain,
+		x509TTL:     DefaultX509SVIDTTL,
+		jwtTTL:      DefaultJWTSVIDTTL,
+		caTTL:       DefaultCATTL,
+	}
+	for _, opt := range opts {
+		opt(issuer)
+	}
+
+	if issuer.signer != nil {
+		if err := validateCA(issuer.signer, issuer.caCert); err != nil {
+			return nil, err
+		}
+		metrics.CAExpiry.Set(float64(issuer.caCert.NotAfter.Unix()))
+		return issuer, nil
+	}
+
 	caKey, err := createCAKey()
 	if err != nil {
 		return nil, fmt.Errorf("problem with CA key: %w", err)
 	}
 
-	caCert, err := createCACert(caKey)
+	caCert, err := createCACert(caKey, issuer.caTTL)
 	if err != nil {
 		return nil, fmt.Errorf("problem with CA cert: %w", err)
 	}
-
-	svids := make(map[string][]byte)
-	return &SVIDIssuer{
-		signer: caKey,
-		caCert: caCert,
-		svids:  svids,
-	}, nil
+	issuer.signer = caKey
+	issuer.caCert = caCert
+	metrics.CAExpiry.Set(float64(caCert.NotAfter.Unix()))
+	return issuer, nil
 }
 
 func createCAKey() (*ecdsa.PrivateKey, error) {
 	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 }
 
-func createCACert(key *ecdsa.PrivateKey) (*x509.Certificate, error) {
+func createCACert(key *ecdsa.PrivateKey, ttl time.Duration) (*x509.Certificate, error) {
 	format := &x509.Certificate{
-		SerialNumber:          big.NewInt(1),
+		SerialNumber:          randomSerial(),
 		Subject:               pkix.Name{CommonName: "kubespiffe"},
 		NotBefore:             time.Now(),
-		NotAfter:              time.Now().Add(24 * time.Hour),
+		NotAfter:              time.Now().Add(ttl),
 		IsCA:                  true,
 		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
 		BasicConstraintsValid: true,
@@ -69,19 +207,43 @@ func createCACert(key *ecdsa.PrivateKey) (*x509.Certificate, error) {
 	return x509.ParseCertificate(certBytes)
 }
 
-func (i *SVIDIssuer) IssueX509SVID(wr *v1alpha1.WorkloadRegistration) ([]byte, []byte, error) {
-	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+func (i *SVIDIssuer) IssueX509SVID(ctx context.Context, wr *v1alpha1.WorkloadRegistration, workload Workload) (_ []byte, _ []byte, err error) {
+	_, span := tracing.Start(ctx, "IssueX509SVID", trace.WithAttributes(
+		attribute.String("spiffe.id", wr.Spec.SPIFFEID),
+		attribute.String("kubespiffe.registration", wr.Name),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	if err := i.checkTrustDomain(wr.Spec.SPIFFEID); err != nil {
+		return nil, nil, err
+	}
+	if err := i.checkDenied(wr.Spec.SPIFFEID, workload.PodUID); err != nil {
+		metrics.SVIDsDenied.WithLabelValues(wr.Name, metrics.TypeX509).Inc()
+		return nil, nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
 	svid := &x509.Certificate{
 		SerialNumber:          randomSerial(),
 		Subject:               pkixNameFrom(wr.Spec.SPIFFEID),
 		NotBefore:             time.Now(),
-		NotAfter:              time.Now().Add(time.Duration(5 * time.Minute)),
+		NotAfter:              time.Now().Add(i.x509TTL),
 		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
 		URIs:                  []*url.URL{mustParseSPIFFEID(wr.Spec.SPIFFEID)},
 		BasicConstraintsValid: true,
 	}
-	svidBytes, err := x509.CreateCertificate(rand.Reader, svid, i.caCert, &key.PublicKey, i.signer)
+	signer, caCert := i.currentCA()
+	if i.crlURL != "" {
+		svid.CRLDistributionPoints = []string{crlURLFor(i.crlURL, caCert)}
+	}
+	if i.ocspURL != "" {
+		svid.OCSPServer = []string{i.ocspURL}
+	}
+	svidBytes, err := x509.CreateCertificate(rand.Reader, svid, caCert, &key.PublicKey, signer)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -91,12 +253,150 @@ func (i *SVIDIssuer) IssueX509SVID(wr *v1alpha1.WorkloadRegistration) ([]byte, [
 		return nil, nil, err
 	}
 
-	i.svids[wr.Spec.SPIFFEID] = svidBytes
+	record := IssuanceRecord{
+		Serial:       svid.SerialNumber.Text(16),
+		SPIFFEID:     wr.Spec.SPIFFEID,
+		PodName:      workload.PodName,
+		PodUID:       workload.PodUID,
+		Namespace:    workload.Namespace,
+		Node:         workload.Node,
+		Registration: wr.Name,
+		Requester:    serviceAccountUsername(workload.Namespace, workload.ServiceAccount),
+		RemoteAddr:   workload.RemoteAddr,
+		NotBefore:    svid.NotBefore,
+		NotAfter:     svid.NotAfter,
+		IssuedAt:     time.Now(),
+	}
+	if err := i.ledger.Record(record); err != nil {
+		return nil, nil, fmt.Errorf("problem recording issuance: %w", err)
+	}
+	// A denial made while the SVID was being signed may have queried the
+	// ledger before it was recorded, so it is revoked here instead
+	if err := i.checkDenied(wr.Spec.SPIFFEID, workload.PodUID); err != nil {
+		i.revokeRecords([]IssuanceRecord{record}, "")
+		metrics.SVIDsDenied.WithLabelValues(wr.Name, metrics.TypeX509).Inc()
+		return nil, nil, err
+	}
+	metrics.SVIDsIssued.WithLabelValues(wr.Name, metrics.TypeX509).Inc()
+	metrics.SVIDExpiry.WithLabelValues(wr.Name).Set(float64(svid.NotAfter.Unix()))
+
 	return svidBytes, svidKeyBytes, nil
 }
 
 func (i *SVIDIssuer) GetCACert() []byte {
-	return i.caCert.Raw
+	_, caCert := i.currentCA()
+	return caCert.Raw
+}
+
+// GetCACerts returns the current CA followed by any retired CAs that have not
+// yet expired, since SVIDs they signed before rotation are still in use
+func (i *SVIDIssuer) GetCACerts() [][]byte {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	certs := [][]byte{i.caCert.Raw}
+	for _, c := range i.retired {
+		certs = append(certs, c.Raw)
+	}
+	return certs
+}
+
+// RotateCA generates a fresh CA and swaps it in for signing. The JWT signing
+// key is rotated alongside it so the whole trust domain rolls over together
+func (i *SVIDIssuer) RotateCA() error {
+	next, err := i.Material().Rotate(i.caTTL)
+	if err != nil {
+		return err
+	}
+	return i.SetMaterial(next)
+}
+
+// SetCA swaps in an externally provided CA for signing. The outgoing CA is
+// kept in the bundle until it expires
+func (i *SVIDIssuer) SetCA(signer crypto.Signer, caCert *x509.Certificate) error {
+	if err := validateCA(signer, caCert); err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	now := time.Now()
+	retired := []*x509.Certificate{i.caCert}
+	retiredSigners := []crypto.Signer{i.signer}
+	for n, c := range i.retired {
+		if c.NotAfter.After(now) {
+			retired = append(retired, c)
+			retiredSigners = append(retiredSigners, i.retiredSigners[n])
+		}
+	}
+	i.signer = signer
+	i.caCert = caCert
+	i.retired = retired
+	i.retiredSigners = retiredSigners
+	i.sequence++
+	i.mu.Unlock()
+
+	// The cached CRL was signed by the outgoing CA. This happens outside of mu
+	// as CRL() takes the revocation lock before reading the current CA
+	i.invalidateCRL()
+	i.rotations.notify()
+	metrics.CAExpiry.Set(float64(caCert.NotAfter.Unix()))
+	return nil
+}
+
+// CheckCA reports whether the current CA can sign an X509-SVID now, which
+// requires it to be valid for the whole lifetime of the SVID
+func (i *SVIDIssuer) CheckCA() error {
+	_, caCert := i.currentCA()
+	now := time.Now()
+	if now.Before(caCert.NotBefore) {
+		return fmt.Errorf("CA is not valid until %s", caCert.NotBefore)
+	}
+	if now.Add(i.x509TTL).After(caCert.NotAfter) {
+		return fmt.Errorf("CA expires at %s, before an SVID issued now", caCert.NotAfter)
+	}
+	return nil
+}
+
+// LoadCA reads a PEM encoded CA certificate and its private key, for use with
+// WithCA
+func LoadCA(certFile, keyFile string) (crypto.Signer, *x509.Certificate, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("problem loading CA: %w", err)
+	}
+	signer, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported CA key %T", pair.PrivateKey)
+	}
+	caCert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("problem parsing CA certificate: %w", err)
+	}
+	return signer, caCert, validateCA(signer, caCert)
+}
+
+func validateCA(signer crypto.Signer, caCert *x509.Certificate) error {
+	if caCert == nil {
+		return fmt.Errorf("no CA certificate for signer")
+	}
+	if !caCert.IsCA {
+		return fmt.Errorf("certificate %q is not a CA", caCert.Subject)
+	}
+	pub, ok := caCert.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(signer.Public()) {
+		return fmt.Errorf("CA certificate does not match signer")
+	}
+	return nil
+}
+
+func (i *SVIDIssuer) currentCA() (crypto.Signer, *x509.Certificate) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.signer, i.caCert
+}
+
+func (i *SVIDIssuer) Ledger() *Ledger {
+	return i.ledger
 }
 
 func randomSerial() *big.Int {
@@ -104,6 +404,10 @@ func randomSerial() *big.Int {
 	return n
 }
 
+func serviceAccountUsername(namespace, serviceAccount string) string {
+	return fmt.Sprintf("system:serviceaccount:%s:%s", namespace, serviceAccount)
+}
+
 func pkixNameFrom(spiffeID string) pkix.Name {
 	return pkix.Name{
 		CommonName:   spiffeID,
@@ -111,6 +415,19 @@ func pkixNameFrom(spiffeID string) pkix.Name {
 	}
 }
 
+// TrustDomain is the trust domain the issuer signs SVIDs for
+func (i *SVIDIssuer) TrustDomain() string {
+	return i.trustDomain
+}
+
+func (i *SVIDIssuer) checkTrustDomain(spiffeID string) error {
+	id, err := url.Parse(spiffeID)
+	if err != nil || id.Scheme != "spiffe" || id.Host != i.trustDomain {
+		return fmt.Errorf("%w: %s is not in %s", ErrForeignTrustDomain, spiffeID, i.trustDomain)
+	}
+	return nil
+}
+
 func mustParseSPIFFEID(spiffeID string) *url.URL {
 	uri, err := url.Parse(spiffeID)
 	if err != nil {
@@ -1,13 +1,18 @@
 package svid
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"crypto/x509"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestNewSVIDIssuer(t *testing.T) {
@@ -25,14 +30,204 @@ func TestIssueX509SVID(t *testing.T) {
 	require.NoError(t, err)
 
 	wr := &v1alpha1.WorkloadRegistration{
+		ObjectMeta: metav1.ObjectMeta{Name: "workload"},
 		Spec: v1alpha1.WorkloadRegistrationSpec{
-			SPIFFEID: "spiffe://trusted.org/a/spiffeid",
+			SPIFFEID: "spiffe://example.org/a/spiffeid",
 			SVIDType: "svid",
 		},
 	}
-	bytes, _, err := issuer.IssueX509SVID(wr)
+	bytes, _, err := issuer.IssueX509SVID(context.Background(), wr, Workload{
+		PodName:        "workload-abc",
+		PodUID:         "pod-uid",
+		Namespace:      "default",
+		Node:           "node-1",
+		ServiceAccount: "default",
+	})
 
 	require.NoError(t, err)
 	assert.NotNil(t, bytes)
-	assert.NotNil(t, issuer.svids[wr.Spec.SPIFFEID])
+
+	cert, err := x509.ParseCertificate(bytes)
+	require.NoError(t, err)
+
+	records := issuer.Ledger().Query(LedgerQuery{SPIFFEID: wr.Spec.SPIFFEID})
+	require.Len(t, records, 1)
+	assert.Equal(t, cert.SerialNumber.Text(16), records[0].Serial)
+	assert.Equal(t, "pod-uid", records[0].PodUID)
+	assert.Equal(t, "node-1", records[0].Node)
+	assert.Equal(t, "workload", records[0].Registration)
+	assert.Equal(t, "system:serviceaccount:default:default", records[0].Requester)
+}
+
+func TestIssueForeignTrustDomain(t *testing.T) {
+	issuer, err := NewSVIDIssuer(WithTrustDomain("corp.example"))
+	require.NoError(t, err)
+	assert.Equal(t, "corp.example", issuer.TrustDomain())
+
+	tests := []struct {
+		name     string
+		spiffeID string
+		wantErr  error
+	}{
+		{name: "in the trust domain", spiffeID: "spiffe://corp.example/workload"},
+		{name: "another trust domain", spiffeID: "spiffe://example.org/workload", wantErr: ErrForeignTrustDomain},
+		{name: "suffix of the trust domain", spiffeID: "spiffe://evil-corp.example/workload", wantErr: ErrForeignTrustDomain},
+		{name: "not a SPIFFE ID", spiffeID: "https://corp.example/workload", wantErr: ErrForeignTrustDomain},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wr := &v1alpha1.WorkloadRegistration{
+				ObjectMeta: metav1.ObjectMeta{Name: "workload"},
+				Spec:       v1alpha1.WorkloadRegistrationSpec{SPIFFEID: tt.spiffeID},
+			}
+			_, _, err := issuer.IssueX509SVID(context.Background(), wr, Workload{PodUID: "pod-1"})
+			assert.ErrorIs(t, err, tt.wantErr)
+			_, _, err = issuer.IssueJWTSVID(wr, Workload{PodUID: "pod-1"}, []string{"api"})
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+	assert.Len(t, issuer.Ledger().Query(LedgerQuery{}), 1)
+}
+
+func mockRegistration(name string) *v1alpha1.WorkloadRegistration {
+	return &v1alpha1.WorkloadRegistration{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1alpha1.WorkloadRegistrationSpec{
+			SPIFFEID: "spiffe://example.org/" + name,
+			SVIDType: "X509",
+		},
+	}
+}
+
+func verifyAgainstBundle(t *testing.T, issuer *SVIDIssuer, svidBytes []byte) {
+	t.Helper()
+	cert, err := x509.ParseCertificate(svidBytes)
+	require.NoError(t, err)
+
+	roots := x509.NewCertPool()
+	for _, raw := range issuer.GetCACerts() {
+		ca, err := x509.ParseCertificate(raw)
+		require.NoError(t, err)
+		roots.AddCert(ca)
+	}
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	assert.NoError(t, err)
+}
+
+func TestRotateCA(t *testing.T) {
+	issuer, err := NewSVIDIssuer()
+	require.NoError(t, err)
+
+	before, _, err := issuer.IssueX509SVID(context.Background(), mockRegistration("before"), Workload{})
+	require.NoError(t, err)
+	oldCA := issuer.GetCACert()
+	rotations, unsubscribe := issuer.SubscribeRotations()
+	defer unsubscribe()
+
+	require.NoError(t, issuer.RotateCA())
+	select {
+	case <-rotations:
+	default:
+		t.Fatal("expected a rotation signal")
+	}
+	assert.NotEqual(t, oldCA, issuer.GetCACert())
+	assert.Len(t, issuer.GetCACerts(), 2)
+
+	after, _, err := issuer.IssueX509SVID(context.Background(), mockRegistration("after"), Workload{})
+	require.NoError(t, err)
+
+	verifyAgainstBundle(t, issuer, before)
+	verifyAgainstBundle(t, issuer, after)
+}
+
+func TestSetCARejectsMismatchedSigner(t *testing.T) {
+	issuer, err := NewSVIDIssuer()
+	require.NoError(t, err)
+
+	otherKey, err := createCAKey()
+	require.NoError(t, err)
+
+	err = issuer.SetCA(otherKey, issuer.caCert)
+	assert.Error(t, err)
+}
+
+func TestIssuerOptions(t *testing.T) {
+	first, err := NewSVIDIssuer()
+	require.NoError(t, err)
+
+	issuer, err := NewSVIDIssuer(
+		WithCA(first.signer, first.caCert),
+		WithX509SVIDTTL(time.Hour),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, first.GetCACert(), issuer.GetCACert())
+
+	svidBytes, _, err := issuer.IssueX509SVID(context.Background(), mockRegistration("ttl"), Workload{})
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(svidBytes)
+	require.NoError(t, err)
+	assert.InDelta(t, time.Hour, cert.NotAfter.Sub(cert.NotBefore), float64(time.Second))
+	verifyAgainstBundle(t, first, svidBytes)
+
+	otherKey, err := createCAKey()
+	require.NoError(t, err)
+	_, err = NewSVIDIssuer(WithCA(otherKey, first.caCert))
+	assert.Error(t, err)
+}
+
+// Run with -race: issuance from many goroutines while the CA is rotated
+func TestIssueX509SVIDConcurrent(t *testing.T) {
+	issuer, err := NewSVIDIssuer()
+	require.NoError(t, err)
+
+	const workers, perWorker = 8, 10
+	issued := make(chan []byte, workers*perWorker)
+
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range perWorker {
+				wr := mockRegistration(fmt.Sprintf("workload-%d-%d", w, n))
+				svidBytes, _, err := issuer.IssueX509SVID(context.Background(), wr, Workload{PodUID: wr.Name})
+				assert.NoError(t, err)
+				issued <- svidBytes
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 3 {
+			assert.NoError(t, issuer.RotateCA())
+		}
+	}()
+
+	wg.Wait()
+	close(issued)
+
+	for svidBytes := range issued {
+		verifyAgainstBundle(t, issuer, svidBytes)
+	}
+	assert.Len(t, issuer.Ledger().Query(LedgerQuery{}), workers*perWorker)
+}
+
+func BenchmarkIssueX509SVIDParallel(b *testing.B) {
+	issuer, err := NewSVIDIssuer(WithLedger(NewLedger(DefaultLedgerRetention, nil)))
+	require.NoError(b, err)
+	wr := mockRegistration("workload")
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, _, err := issuer.IssueX509SVID(context.Background(), wr, Workload{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
 }
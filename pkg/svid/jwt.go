@@ -0,0 +1,53 @@
+package svid
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	"github.com/jsnctl/kubespiffe/pkg/metrics"
+)
+
+const (
+	DefaultJWTSVIDTTL = 5 * time.Minute
+)
+
+// IssueJWTSVID signs a JWT-SVID for the registration's SPIFFE ID with the
+// current JWT authority, returning the token and its expiry. JWT-SVIDs have no
+// serial and can't be revoked once issued, so they are not recorded in the
+// ledger, but denied SPIFFE IDs and pods are still refused
+func (i *SVIDIssuer) IssueJWTSVID(wr *v1alpha1.WorkloadRegistration, workload Workload, audience []string) (string, time.Time, error) {
+	if len(audience) == 0 {
+		return "", time.Time{}, errors.New("at least one audience is required")
+	}
+	if err := i.checkTrustDomain(wr.Spec.SPIFFEID); err != nil {
+		return "", time.Time{}, err
+	}
+	if err := i.checkDenied(wr.Spec.SPIFFEID, workload.PodUID); err != nil {
+		metrics.SVIDsDenied.WithLabelValues(wr.Name, metrics.TypeJWT).Inc()
+		return "", time.Time{}, err
+	}
+
+	i.mu.RLock()
+	signer := i.jwt
+	i.mu.RUnlock()
+
+	now := time.Now()
+	// NumericDate only has second precision
+	expiry := now.Add(i.jwtTTL).Truncate(time.Second)
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{
+		Subject:   wr.Spec.SPIFFEID,
+		Audience:  audience,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(expiry),
+	})
+	token.Header["kid"] = signer.keyID
+
+	signed, err := token.SignedString(signer.key)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	metrics.SVIDsIssued.WithLabelValues(wr.Name, metrics.TypeJWT).Inc()
+	return signed, expiry, nil
+}
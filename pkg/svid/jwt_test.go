@@ -0,0 +1,38 @@
+package svid
+
+import (
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssueJWTSVID(t *testing.T) {
+	issuer, err := NewSVIDIssuer()
+	require.NoError(t, err)
+	wr := mockRegistration("workload")
+
+	token, expiry, err := issuer.IssueJWTSVID(wr, Workload{PodUID: "pod-1"}, []string{"spiffe://example.org/db"})
+	require.NoError(t, err)
+
+	authorities := issuer.Authorities()
+	parsed, err := jwt.ParseWithClaims(token, &jwt.RegisteredClaims{}, func(tok *jwt.Token) (any, error) {
+		assert.Equal(t, authorities.JWT[0].KeyID, tok.Header["kid"])
+		return authorities.JWT[0].PublicKey, nil
+	}, jwt.WithValidMethods([]string{"ES256"}), jwt.WithAudience("spiffe://example.org/db"))
+	require.NoError(t, err)
+
+	claims := parsed.Claims.(*jwt.RegisteredClaims)
+	assert.Equal(t, wr.Spec.SPIFFEID, claims.Subject)
+	assert.WithinDuration(t, expiry, claims.ExpiresAt.Time, 0)
+	assert.Empty(t, issuer.Ledger().Query(LedgerQuery{}))
+
+	_, _, err = issuer.IssueJWTSVID(wr, Workload{}, nil)
+	assert.Error(t, err)
+
+	_, err = issuer.Revoke(RevocationRequest{PodUID: "pod-1"})
+	require.NoError(t, err)
+	_, _, err = issuer.IssueJWTSVID(wr, Workload{PodUID: "pod-1"}, []string{"spiffe://example.org/db"})
+	assert.ErrorIs(t, err, ErrRevoked)
+}
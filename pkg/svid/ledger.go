@@ -0,0 +1,247 @@
+package svid
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultLedgerRetention keeps expired records in memory for a day, long
+	// enough to answer who held an SVID behind yesterday's incident. Audit
+	// beyond that is left to the sink
+	DefaultLedgerRetention = 24 * time.Hour
+
+	// ledgerPruneInterval is how often Record drops records past retention
+	ledgerPruneInterval = time.Minute
+
+	// ledgerSinkBuffer is how many records may wait to be written to the sink
+	// before Record blocks
+	ledgerSinkBuffer = 1024
+)
+
+// ErrLedgerClosed is returned by Record once the ledger has been closed
+var ErrLedgerClosed = errors.New("issuance ledger is closed")
+
+// Workload describes the attested caller that an SVID is being issued to
+type Workload struct {
+	PodName        string
+	PodUID         string
+	Namespace      string
+	Node           string
+	ServiceAccount string
+	RemoteAddr     string
+}
+
+// IssuanceRecord is a single entry in the issuance ledger, describing which
+// workload held an SVID and for how long it was valid
+type IssuanceRecord struct {
+	Serial       string    `json:"serial"`
+	SPIFFEID     string    `json:"spiffeID"`
+	PodName      string    `json:"podName"`
+	PodUID       string    `json:"podUID"`
+	Namespace    string    `json:"namespace"`
+	Node         string    `json:"node"`
+	Registration string    `json:"registration"`
+	Requester    string    `json:"requester"`
+	RemoteAddr   string    `json:"remoteAddr,omitempty"`
+	NotBefore    time.Time `json:"notBefore"`
+	NotAfter     time.Time `json:"notAfter"`
+	IssuedAt     time.Time `json:"issuedAt"`
+}
+
+// LedgerQuery filters ledger records. Empty fields match everything, and a
+// non-zero At only matches records whose SVID was valid at that instant
+type LedgerQuery struct {
+	Serial       string
+	SPIFFEID     string
+	PodUID       string
+	Registration string
+	At           time.Time
+}
+
+func (q LedgerQuery) matches(r IssuanceRecord) bool {
+	if q.Serial != "" && q.Serial != r.Serial {
+		return false
+	}
+	if q.SPIFFEID != "" && q.SPIFFEID != r.SPIFFEID {
+		return false
+	}
+	if q.PodUID != "" && q.PodUID != r.PodUID {
+		return false
+	}
+	if q.Registration != "" && q.Registration != r.Registration {
+		return false
+	}
+	if !q.At.IsZero() && (q.At.Before(r.NotBefore) || !q.At.Before(r.NotAfter)) {
+		return false
+	}
+	return true
+}
+
+// Ledger is an append-only record of every SVID issued. Records are kept in
+// memory until they have been expired for longer than the retention period,
+// and are optionally streamed as JSON lines to a sink for long-term audit.
+// The sink is written in the background, so a slow disk doesn't hold up
+// queries or other issuances
+type Ledger struct {
+	mu        sync.RWMutex
+	records   []IssuanceRecord
+	retention time.Duration
+	pruned    time.Time
+
+	// queueMu guards sending on queue against Close closing it
+	queueMu sync.RWMutex
+	queue   chan IssuanceRecord
+	closed  bool
+	written chan struct{}
+	sink    io.Writer
+	sinkErr error
+}
+
+// NewLedger keeps records for retention after they expire. When sink is set,
+// the ledger owns it, and Close closes it if it is an io.Closer
+func NewLedger(retention time.Duration, sink io.Writer) *Ledger {
+	if retention <= 0 {
+		retention = DefaultLedgerRetention
+	}
+	l := &Ledger{
+		retention: retention,
+		sink:      sink,
+	}
+	if sink != nil {
+		l.queue = make(chan IssuanceRecord, ledgerSinkBuffer)
+		l.written = make(chan struct{})
+		go l.write()
+	}
+	return l
+}
+
+// Record adds r to the ledger and queues it for the sink. Once a record has
+// failed to reach the sink, Record fails too, so that issuance stops rather
+// than going unaudited
+func (l *Ledger) Record(r IssuanceRecord) error {
+	if l.sink != nil {
+		l.queueMu.RLock()
+		defer l.queueMu.RUnlock()
+		if l.closed {
+			return ErrLedgerClosed
+		}
+		if err := l.sinkError(); err != nil {
+			return fmt.Errorf("writing audit record: %w", err)
+		}
+	}
+
+	l.mu.Lock()
+	if now := time.Now(); now.Sub(l.pruned) >= ledgerPruneInterval {
+		l.prune(now)
+		l.pruned = now
+	}
+	l.records = append(l.records, r)
+	l.mu.Unlock()
+
+	if l.sink != nil {
+		l.queue <- r
+	}
+	return nil
+}
+
+// Close writes any queued records to the sink, then closes it
+func (l *Ledger) Close() error {
+	if l.sink == nil {
+		return nil
+	}
+	l.queueMu.Lock()
+	if l.closed {
+		l.queueMu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.queue)
+	l.queueMu.Unlock()
+
+	<-l.written
+	err := l.sinkError()
+	if c, ok := l.sink.(io.Closer); ok {
+		err = errors.Join(err, c.Close())
+	}
+	return err
+}
+
+// write drains the queue into the sink until Close
+func (l *Ledger) write() {
+	defer close(l.written)
+	enc := json.NewEncoder(l.sink)
+	for r := range l.queue {
+		if l.sinkError() != nil {
+			continue
+		}
+		if err := enc.Encode(r); err != nil {
+			slog.Error("❌ Problem writing audit record", "serial", r.Serial, "error", err)
+			l.mu.Lock()
+			l.sinkErr = err
+			l.mu.Unlock()
+		}
+	}
+}
+
+func (l *Ledger) sinkError() error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.sinkErr
+}
+
+// Query returns the records matching q. Its serial may be given in any form
+// NormalizeSerial accepts
+func (l *Ledger) Query(q LedgerQuery) []IssuanceRecord {
+	if q.Serial != "" {
+		serial, ok := NormalizeSerial(q.Serial)
+		if !ok {
+			return []IssuanceRecord{}
+		}
+		q.Serial = serial
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	// Records past retention may not have been pruned yet
+	cutoff := time.Now().Add(-l.retention)
+	matched := []IssuanceRecord{}
+	for _, r := range l.records {
+		if r.NotAfter.After(cutoff) && q.matches(r) {
+			matched = append(matched, r)
+		}
+	}
+	return matched
+}
+
+// Export writes every record matching the query to w as JSON lines
+func (l *Ledger) Export(w io.Writer, q LedgerQuery) error {
+	enc := json.NewEncoder(w)
+	for _, r := range l.Query(q) {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prune drops records that expired before the retention window. Records are
+// appended in issuance order but TTLs may differ, so the whole slice is
+// scanned, which is why Record only prunes every ledgerPruneInterval
+func (l *Ledger) prune(now time.Time) {
+	cutoff := now.Add(-l.retention)
+	kept := l.records[:0]
+	for _, r := range l.records {
+		if r.NotAfter.After(cutoff) {
+			kept = append(kept, r)
+		}
+	}
+	clear(l.records[len(kept):])
+	l.records = kept
+}
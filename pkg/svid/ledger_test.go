@@ -0,0 +1,156 @@
+package svid
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mockRecord(serial, spiffeID, podUID string, notBefore time.Time, ttl time.Duration) IssuanceRecord {
+	return IssuanceRecord{
+		Serial:    serial,
+		SPIFFEID:  spiffeID,
+		PodUID:    podUID,
+		NotBefore: notBefore,
+		NotAfter:  notBefore.Add(ttl),
+		IssuedAt:  notBefore,
+	}
+}
+
+func TestLedgerQuery(t *testing.T) {
+	now := time.Now()
+	ledger := NewLedger(time.Hour, nil)
+	require.NoError(t, ledger.Record(mockRecord("a1", "spiffe://example.org/a", "pod-1", now.Add(-10*time.Minute), 5*time.Minute)))
+	require.NoError(t, ledger.Record(mockRecord("a2", "spiffe://example.org/a", "pod-2", now.Add(-2*time.Minute), 5*time.Minute)))
+	require.NoError(t, ledger.Record(mockRecord("b1", "spiffe://example.org/b", "pod-3", now.Add(-2*time.Minute), 5*time.Minute)))
+
+	tests := []struct {
+		name  string
+		query LedgerQuery
+		want  []string
+	}{
+		{
+			name:  "empty query matches everything",
+			query: LedgerQuery{},
+			want:  []string{"a1", "a2", "b1"},
+		},
+		{
+			name:  "by SPIFFE ID",
+			query: LedgerQuery{SPIFFEID: "spiffe://example.org/a"},
+			want:  []string{"a1", "a2"},
+		},
+		{
+			name:  "by pod UID",
+			query: LedgerQuery{PodUID: "pod-3"},
+			want:  []string{"b1"},
+		},
+		{
+			name:  "who held the identity at time T",
+			query: LedgerQuery{SPIFFEID: "spiffe://example.org/a", At: now.Add(-8 * time.Minute)},
+			want:  []string{"a1"},
+		},
+		{
+			name:  "not valid at NotAfter",
+			query: LedgerQuery{Serial: "a1", At: now.Add(-5 * time.Minute)},
+			want:  []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, r := range ledger.Query(tt.query) {
+				got = append(got, r.Serial)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestLedgerPrunesExpiredRecords(t *testing.T) {
+	now := time.Now()
+	ledger := NewLedger(time.Hour, nil)
+	require.NoError(t, ledger.Record(mockRecord("old", "spiffe://example.org/a", "pod-1", now.Add(-3*time.Hour), 5*time.Minute)))
+	require.NoError(t, ledger.Record(mockRecord("new", "spiffe://example.org/a", "pod-1", now, 5*time.Minute)))
+
+	records := ledger.Query(LedgerQuery{})
+	require.Len(t, records, 1)
+	assert.Equal(t, "new", records[0].Serial)
+
+	// Pruning waits for the interval, while queries already skip the record
+	require.NoError(t, ledger.Record(mockRecord("stale", "spiffe://example.org/a", "pod-1", now.Add(-3*time.Hour), 5*time.Minute)))
+	assert.Len(t, ledger.Query(LedgerQuery{}), 1)
+	assert.Len(t, ledger.records, 3)
+
+	ledger.pruned = now.Add(-ledgerPruneInterval)
+	require.NoError(t, ledger.Record(mockRecord("newer", "spiffe://example.org/a", "pod-1", now, 5*time.Minute)))
+	assert.Len(t, ledger.records, 2)
+}
+
+func TestLedgerSinkAndExport(t *testing.T) {
+	var sink bytes.Buffer
+	ledger := NewLedger(time.Hour, &sink)
+	require.NoError(t, ledger.Record(mockRecord("a1", "spiffe://example.org/a", "pod-1", time.Now(), 5*time.Minute)))
+	require.NoError(t, ledger.Record(mockRecord("b1", "spiffe://example.org/b", "pod-2", time.Now(), 5*time.Minute)))
+	require.NoError(t, ledger.Close())
+	assert.ErrorIs(t, ledger.Record(mockRecord("c1", "spiffe://example.org/c", "pod-3", time.Now(), 5*time.Minute)), ErrLedgerClosed)
+
+	var export bytes.Buffer
+	require.NoError(t, ledger.Export(&export, LedgerQuery{}))
+	assert.Equal(t, sink.String(), export.String())
+
+	scanner := bufio.NewScanner(&export)
+	var lines int
+	for scanner.Scan() {
+		var r IssuanceRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &r))
+		lines++
+	}
+	assert.Equal(t, 2, lines)
+}
+
+// blockingSink holds every write until released, then fails them with err
+type blockingSink struct {
+	release chan struct{}
+	err     error
+}
+
+func (b *blockingSink) Write(p []byte) (int, error) {
+	<-b.release
+	if b.err != nil {
+		return 0, b.err
+	}
+	return len(p), nil
+}
+
+func TestLedgerSlowSink(t *testing.T) {
+	sink := &blockingSink{release: make(chan struct{}), err: errors.New("disk full")}
+	ledger := NewLedger(time.Hour, sink)
+	require.NoError(t, ledger.Record(mockRecord("a1", "spiffe://example.org/a", "pod-1", time.Now(), 5*time.Minute)))
+
+	// The sink is stuck on the first record, yet issuance and queries carry on
+	require.NoError(t, ledger.Record(mockRecord("a2", "spiffe://example.org/a", "pod-1", time.Now(), 5*time.Minute)))
+	assert.Len(t, ledger.Query(LedgerQuery{SPIFFEID: "spiffe://example.org/a"}), 2)
+
+	close(sink.release)
+	assert.ErrorContains(t, ledger.Close(), "disk full")
+	assert.ErrorIs(t, ledger.Record(mockRecord("a3", "spiffe://example.org/a", "pod-1", time.Now(), 5*time.Minute)), ErrLedgerClosed)
+}
+
+func TestLedgerSinkFailureStopsRecording(t *testing.T) {
+	sink := &blockingSink{release: make(chan struct{}), err: errors.New("disk full")}
+	close(sink.release)
+	ledger := NewLedger(time.Hour, sink)
+	require.NoError(t, ledger.Record(mockRecord("a1", "spiffe://example.org/a", "pod-1", time.Now(), 5*time.Minute)))
+
+	assert.Eventually(t, func() bool {
+		return ledger.Record(mockRecord("a2", "spiffe://example.org/a", "pod-1", time.Now(), 5*time.Minute)) != nil
+	}, time.Second, 10*time.Millisecond)
+	assert.ErrorContains(t, ledger.Record(mockRecord("a3", "spiffe://example.org/a", "pod-1", time.Now(), 5*time.Minute)), "disk full")
+}
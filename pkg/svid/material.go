@@ -0,0 +1,185 @@
+package svid
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/jsnctl/kubespiffe/pkg/metrics"
+)
+
+// JWTSigningKey is a key JWT-SVIDs are signed with, and its key ID
+type JWTSigningKey struct {
+	KeyID string
+	Key   *ecdsa.PrivateKey
+}
+
+// Material is everything the trust domain signs with: the CA and JWT signing
+// key, the retired authorities still published in bundles, and the bundle
+// sequence. Replicas sharing it issue SVIDs chaining to the same roots and
+// publish identical bundles. RetiredSigners holds the key of each of
+// RetiredCAs, for signing their CRLs, or nil where it isn't known
+type Material struct {
+	Signer         crypto.Signer
+	CACert         *x509.Certificate
+	RetiredCAs     []*x509.Certificate
+	RetiredSigners []crypto.Signer
+	JWT            JWTSigningKey
+	RetiredJWT     *JWTSigningKey
+	Sequence       uint64
+}
+
+// GenerateMaterial creates a CA valid for caTTL and a JWT signing key
+func GenerateMaterial(caTTL time.Duration) (Material, error) {
+	caKey, err := createCAKey()
+	if err != nil {
+		return Material{}, fmt.Errorf("problem with CA key: %w", err)
+	}
+	caCert, err := createCACert(caKey, caTTL)
+	if err != nil {
+		return Material{}, fmt.Errorf("problem with CA cert: %w", err)
+	}
+	jwt, err := createJWTSigner()
+	if err != nil {
+		return Material{}, fmt.Errorf("problem with JWT signing key: %w", err)
+	}
+	return Material{
+		Signer:   caKey,
+		CACert:   caCert,
+		JWT:      JWTSigningKey{KeyID: jwt.keyID, Key: jwt.key},
+		Sequence: 1,
+	}, nil
+}
+
+// Rotate returns material with a fresh CA valid for caTTL and a fresh JWT
+// signing key. The outgoing CA is retired alongside any earlier CAs that have
+// not expired, and the outgoing JWT signing key replaces the retired one
+func (m Material) Rotate(caTTL time.Duration) (Material, error) {
+	next, err := GenerateMaterial(caTTL)
+	if err != nil {
+		return Material{}, err
+	}
+	now := time.Now()
+	next.RetiredCAs = []*x509.Certificate{m.CACert}
+	next.RetiredSigners = []crypto.Signer{m.Signer}
+	for n, c := range m.RetiredCAs {
+		if c.NotAfter.After(now) {
+			next.RetiredCAs = append(next.RetiredCAs, c)
+			next.RetiredSigners = append(next.RetiredSigners, m.retiredSigner(n))
+		}
+	}
+	retiredJWT := m.JWT
+	next.RetiredJWT = &retiredJWT
+	next.Sequence = m.Sequence + 1
+	return next, nil
+}
+
+// Equal reports whether both hold the same authorities at the same sequence
+func (m Material) Equal(o Material) bool {
+	if m.Sequence != o.Sequence || m.JWT.KeyID != o.JWT.KeyID || len(m.RetiredCAs) != len(o.RetiredCAs) {
+		return false
+	}
+	if (m.RetiredJWT == nil) != (o.RetiredJWT == nil) || (m.RetiredJWT != nil && m.RetiredJWT.KeyID != o.RetiredJWT.KeyID) {
+		return false
+	}
+	if m.CACert == nil || o.CACert == nil || !bytes.Equal(m.CACert.Raw, o.CACert.Raw) {
+		return false
+	}
+	for n := range m.RetiredCAs {
+		if !bytes.Equal(m.RetiredCAs[n].Raw, o.RetiredCAs[n].Raw) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m Material) validate() error {
+	if err := validateCA(m.Signer, m.CACert); err != nil {
+		return err
+	}
+	if m.JWT.Key == nil || m.JWT.KeyID == "" {
+		return fmt.Errorf("no JWT signing key")
+	}
+	if len(m.RetiredSigners) > len(m.RetiredCAs) {
+		return fmt.Errorf("%d retired CA keys for %d retired CAs", len(m.RetiredSigners), len(m.RetiredCAs))
+	}
+	return nil
+}
+
+// retiredSigner returns the key of the nth retired CA, or nil if it isn't
+// known
+func (m Material) retiredSigner(n int) crypto.Signer {
+	if n < len(m.RetiredSigners) {
+		return m.RetiredSigners[n]
+	}
+	return nil
+}
+
+// WithMaterial signs with shared material from the start, instead of a CA and
+// JWT signing key generated by the issuer
+func WithMaterial(m Material) Option {
+	return func(i *SVIDIssuer) {
+		i.applyMaterial(m)
+	}
+}
+
+// Material returns what the issuer currently signs with
+func (i *SVIDIssuer) Material() Material {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	m := Material{
+		Signer:         i.signer,
+		CACert:         i.caCert,
+		RetiredCAs:     append([]*x509.Certificate(nil), i.retired...),
+		RetiredSigners: append([]crypto.Signer(nil), i.retiredSigners...),
+		JWT:            JWTSigningKey{KeyID: i.jwt.keyID, Key: i.jwt.key},
+		Sequence:       i.sequence,
+	}
+	if i.retiredJWT != nil {
+		m.RetiredJWT = &JWTSigningKey{KeyID: i.retiredJWT.keyID, Key: i.retiredJWT.key}
+	}
+	return m
+}
+
+// SetMaterial swaps in material rotated elsewhere, such as by another
+// replica. Material equal to the current material is ignored, so it is safe
+// to apply on every resync
+func (i *SVIDIssuer) SetMaterial(m Material) error {
+	if err := m.validate(); err != nil {
+		return err
+	}
+	if m.Equal(i.Material()) {
+		return nil
+	}
+
+	i.mu.Lock()
+	i.applyMaterial(m)
+	i.mu.Unlock()
+
+	// As in SetCA, the cached CRL was signed by the outgoing CA
+	i.invalidateCRL()
+	i.rotations.notify()
+	metrics.CAExpiry.Set(float64(m.CACert.NotAfter.Unix()))
+	return nil
+}
+
+// applyMaterial must be called with mu held, or before the issuer is shared
+func (i *SVIDIssuer) applyMaterial(m Material) {
+	i.signer = m.Signer
+	i.caCert = m.CACert
+	i.retired = append([]*x509.Certificate(nil), m.RetiredCAs...)
+	i.retiredSigners = make([]crypto.Signer, len(m.RetiredCAs))
+	for n := range m.RetiredCAs {
+		i.retiredSigners[n] = m.retiredSigner(n)
+	}
+	i.jwt = &jwtSigner{keyID: m.JWT.KeyID, key: m.JWT.Key}
+	i.retiredJWT = nil
+	if m.RetiredJWT != nil {
+		i.retiredJWT = &jwtSigner{keyID: m.RetiredJWT.KeyID, key: m.RetiredJWT.Key}
+	}
+	i.sequence = m.Sequence
+}
@@ -0,0 +1,75 @@
+package svid
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaterialRotate(t *testing.T) {
+	m, err := GenerateMaterial(time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, m.validate())
+
+	next, err := m.Rotate(time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, m.Sequence+1, next.Sequence)
+	assert.Equal(t, m.CACert, next.RetiredCAs[0])
+	assert.Equal(t, m.JWT.KeyID, next.RetiredJWT.KeyID)
+	assert.False(t, next.Equal(m))
+	assert.True(t, next.Equal(next))
+
+	// Only unexpired CAs stay retired
+	expired, err := GenerateMaterial(-time.Minute)
+	require.NoError(t, err)
+	next.RetiredCAs = append(next.RetiredCAs, expired.CACert)
+	third, err := next.Rotate(time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, []*x509.Certificate{next.CACert, m.CACert}, third.RetiredCAs)
+	assert.Equal(t, []crypto.Signer{next.Signer, m.Signer}, third.RetiredSigners)
+}
+
+func TestSetMaterial(t *testing.T) {
+	m, err := GenerateMaterial(time.Hour)
+	require.NoError(t, err)
+	first, err := NewSVIDIssuer(WithMaterial(m))
+	require.NoError(t, err)
+	second, err := NewSVIDIssuer(WithMaterial(m))
+	require.NoError(t, err)
+	assert.Equal(t, first.Authorities(), second.Authorities())
+
+	rotations, unsubscribe := second.SubscribeRotations()
+	defer unsubscribe()
+
+	// Material applied again on a resync doesn't count as a rotation
+	require.NoError(t, second.SetMaterial(second.Material()))
+	select {
+	case <-rotations:
+		t.Fatal("unexpected rotation signal")
+	default:
+	}
+
+	before, _, err := second.IssueX509SVID(context.Background(), mockRegistration("before"), Workload{})
+	require.NoError(t, err)
+	next, err := first.Material().Rotate(time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, first.SetMaterial(next))
+	require.NoError(t, second.SetMaterial(next))
+	select {
+	case <-rotations:
+	default:
+		t.Fatal("expected a rotation signal")
+	}
+	assert.Equal(t, first.Authorities(), second.Authorities())
+	verifyAgainstBundle(t, first, before)
+
+	other, err := GenerateMaterial(time.Hour)
+	require.NoError(t, err)
+	other.Signer = m.Signer
+	assert.Error(t, second.SetMaterial(other))
+}
@@ -0,0 +1,149 @@
+package svid
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	DefaultOCSPResponderValidity = time.Hour
+	ocspResponseValidity         = 5 * time.Minute
+)
+
+// id-pkix-ocsp-nocheck tells clients not to check the revocation status of
+// the delegated responder certificate itself (RFC 6960 4.2.2.2.1)
+var oidOCSPNoCheck = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+
+// ocspResponder is a delegated signing key certified by the CA for OCSP
+// signing only, so the CA key itself never signs OCSP responses
+type ocspResponder struct {
+	mu     sync.Mutex
+	caCert *x509.Certificate
+	cert   *x509.Certificate
+	key    crypto.Signer
+}
+
+// OCSPResponse answers a DER encoded OCSP request from the revocation state.
+// Any serial that isn't revoked is good, as RFC 6960 allows: the ledger only
+// holds the SVIDs this replica issued, whereas revocations are shared between
+// replicas, so every replica gives the same answer
+func (i *SVIDIssuer) OCSPResponse(reqDER []byte) ([]byte, error) {
+	req, err := ocsp.ParseRequest(reqDER)
+	if err != nil {
+		return ocsp.MalformedRequestErrorResponse, fmt.Errorf("parsing OCSP request: %w", err)
+	}
+
+	caCert, responderCert, responderKey, err := i.ocspResponderFor()
+	if err != nil {
+		return ocsp.InternalErrorErrorResponse, err
+	}
+
+	// Only the current CA has a delegated responder. SVIDs from a retired CA
+	// are covered by the CRL that CA still signs, which their distribution
+	// point names
+	issuerKeyHash, err := hashIssuerKey(req.HashAlgorithm, caCert)
+	if err != nil || !bytes.Equal(issuerKeyHash, req.IssuerKeyHash) {
+		return ocsp.UnauthorizedErrorResponse, nil
+	}
+
+	now := time.Now()
+	template := ocsp.Response{
+		SerialNumber: req.SerialNumber,
+		Certificate:  responderCert,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(ocspResponseValidity),
+		Status:       ocsp.Good,
+	}
+
+	if revoked, ok := i.revokedSVID(req.SerialNumber.Text(16)); ok {
+		template.Status = ocsp.Revoked
+		template.RevokedAt = revoked.RevokedAt
+		template.RevocationReason = revocationReasons[revoked.Reason]
+	}
+
+	resp, err := ocsp.CreateResponse(caCert, responderCert, template, responderKey)
+	if err != nil {
+		return ocsp.InternalErrorErrorResponse, fmt.Errorf("problem signing OCSP response: %w", err)
+	}
+	return resp, nil
+}
+
+func (i *SVIDIssuer) revokedSVID(serial string) (RevokedSVID, bool) {
+	r := i.revocations
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	revoked, ok := r.serials[serial]
+	return revoked, ok
+}
+
+// ocspResponderFor returns the delegated responder for the current CA,
+// reissuing it after rotation or once half of its validity has elapsed
+func (i *SVIDIssuer) ocspResponderFor() (*x509.Certificate, *x509.Certificate, crypto.Signer, error) {
+	signer, caCert := i.currentCA()
+
+	o := i.ocsp
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	halfLife := o.cert != nil && time.Now().After(o.cert.NotBefore.Add(o.cert.NotAfter.Sub(o.cert.NotBefore)/2))
+	if o.cert != nil && o.caCert == caCert && !halfLife {
+		return o.caCert, o.cert, o.key, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	noCheck, err := asn1.Marshal(asn1.NullRawValue)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:    randomSerial(),
+		Subject:         pkix.Name{CommonName: "kubespiffe OCSP responder"},
+		NotBefore:       time.Now(),
+		NotAfter:        time.Now().Add(i.ocspValidity),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+		ExtraExtensions: []pkix.Extension{{Id: oidOCSPNoCheck, Value: noCheck}},
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, signer)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("problem issuing OCSP responder: %w", err)
+	}
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	o.caCert, o.cert, o.key = caCert, cert, key
+	return caCert, cert, key, nil
+}
+
+func hashIssuerKey(hash crypto.Hash, caCert *x509.Certificate) ([]byte, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(caCert.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, err
+	}
+	if !hash.Available() {
+		return nil, fmt.Errorf("unsupported hash %v", hash)
+	}
+	h := hash.New()
+	h.Write(spki.PublicKey.RightAlign())
+	return h.Sum(nil), nil
+}
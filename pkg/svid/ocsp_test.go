@@ -0,0 +1,94 @@
+package svid
+
+import (
+	"crypto"
+	"crypto/x509"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestOCSPResponse(t *testing.T) {
+	issuer, err := NewSVIDIssuer(WithOCSPServer("http://kubespiffed/v1/ocsp"))
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(issuer.GetCACert())
+	require.NoError(t, err)
+
+	good := issueMock(t, issuer, "workload", "pod-1")
+	revoked := issueMock(t, issuer, "another", "pod-2")
+	_, err = issuer.Revoke(RevocationRequest{Serial: revoked.SerialNumber.Text(16), Reason: "keyCompromise"})
+	require.NoError(t, err)
+	unknown := &x509.Certificate{SerialNumber: big.NewInt(42)}
+
+	assert.Equal(t, []string{"http://kubespiffed/v1/ocsp"}, good.OCSPServer)
+
+	tests := []struct {
+		name       string
+		cert       *x509.Certificate
+		wantStatus int
+	}{
+		{
+			name:       "issued",
+			cert:       good,
+			wantStatus: ocsp.Good,
+		},
+		{
+			name:       "revoked",
+			cert:       revoked,
+			wantStatus: ocsp.Revoked,
+		},
+		{
+			// Such as one issued by another replica
+			name:       "not in the ledger",
+			cert:       unknown,
+			wantStatus: ocsp.Good,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := ocsp.CreateRequest(tt.cert, caCert, &ocsp.RequestOptions{Hash: crypto.SHA256})
+			require.NoError(t, err)
+
+			der, err := issuer.OCSPResponse(req)
+			require.NoError(t, err)
+
+			resp, err := ocsp.ParseResponseForCert(der, tt.cert, caCert)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, resp.Status)
+			assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning}, resp.Certificate.ExtKeyUsage)
+			assert.NotEqual(t, caCert.PublicKey, resp.Certificate.PublicKey)
+		})
+	}
+}
+
+func TestOCSPResponseAfterRotation(t *testing.T) {
+	issuer, err := NewSVIDIssuer()
+	require.NoError(t, err)
+	oldCA, err := x509.ParseCertificate(issuer.GetCACert())
+	require.NoError(t, err)
+	before := issueMock(t, issuer, "workload", "pod-1")
+
+	require.NoError(t, issuer.RotateCA())
+	newCA, err := x509.ParseCertificate(issuer.GetCACert())
+	require.NoError(t, err)
+	after := issueMock(t, issuer, "workload", "pod-1")
+
+	req, err := ocsp.CreateRequest(after, newCA, nil)
+	require.NoError(t, err)
+	der, err := issuer.OCSPResponse(req)
+	require.NoError(t, err)
+	resp, err := ocsp.ParseResponseForCert(der, after, newCA)
+	require.NoError(t, err)
+	assert.Equal(t, ocsp.Good, resp.Status)
+
+	req, err = ocsp.CreateRequest(before, oldCA, nil)
+	require.NoError(t, err)
+	der, err = issuer.OCSPResponse(req)
+	require.NoError(t, err)
+	_, err = ocsp.ParseResponse(der, nil)
+	assert.Equal(t, ocsp.ResponseError{Status: ocsp.Unauthorized}, err)
+}
@@ -0,0 +1,524 @@
+package svid
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultCRLValidity = time.Hour
+	subscriberBuffer   = 16
+)
+
+var ErrRevoked = errors.New("identity has been revoked")
+
+// ErrUnknownCA is returned for a CRL of a CA that isn't current, or retired
+// and unexpired
+var ErrUnknownCA = errors.New("no CRL for CA")
+
+// RFC 5280 CRLReason codes
+var revocationReasons = map[string]int{
+	"":                     0,
+	"unspecified":          0,
+	"keyCompromise":        1,
+	"cACompromise":         2,
+	"affiliationChanged":   3,
+	"superseded":           4,
+	"cessationOfOperation": 5,
+	"privilegeWithdrawn":   9,
+}
+
+// RevocationRequest selects SVIDs to revoke. Exactly one of Serial, SPIFFEID,
+// PodUID or Registration must be set. Revoking a SPIFFE ID or pod UID also
+// denies any further issuance to it
+type RevocationRequest struct {
+	Serial       string `json:"serial,omitempty"`
+	SPIFFEID     string `json:"spiffeID,omitempty"`
+	PodUID       string `json:"podUID,omitempty"`
+	Registration string `json:"registration,omitempty"`
+	Reason       string `json:"reason,omitempty"`
+}
+
+// NormalizeSerial returns a hex encoded serial in the form SVIDs' serials are
+// kept in, lowercase without leading zeros, so that serials printed by other
+// tools match. Bytes may be separated by colons, as openssl x509 -text prints
+// them. It reports false if serial isn't hex
+func NormalizeSerial(serial string) (string, bool) {
+	n, ok := new(big.Int).SetString(strings.ReplaceAll(serial, ":", ""), 16)
+	if !ok || n.Sign() < 0 {
+		return "", false
+	}
+	return n.Text(16), true
+}
+
+// validate checks the request, normalizing its serial
+func (r *RevocationRequest) validate() error {
+	set := 0
+	for _, f := range []string{r.Serial, r.SPIFFEID, r.PodUID, r.Registration} {
+		if f != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return errors.New("exactly one of serial, spiffeID, podUID or registration must be set")
+	}
+	if r.Serial != "" {
+		serial, ok := NormalizeSerial(r.Serial)
+		if !ok {
+			return fmt.Errorf("invalid serial %q: must be hex encoded", r.Serial)
+		}
+		r.Serial = serial
+	}
+	if _, ok := revocationReasons[r.Reason]; !ok {
+		return fmt.Errorf("unknown revocation reason %q", r.Reason)
+	}
+	return nil
+}
+
+// RevokedSVID is an entry on the CRL. NotAfter is kept so that entries can be
+// dropped once the certificate would have expired anyway
+type RevokedSVID struct {
+	Serial    string    `json:"serial"`
+	SPIFFEID  string    `json:"spiffeID,omitempty"`
+	PodUID    string    `json:"podUID,omitempty"`
+	RevokedAt time.Time `json:"revokedAt"`
+	Reason    string    `json:"reason,omitempty"`
+	NotAfter  time.Time `json:"notAfter"`
+}
+
+type revocations struct {
+	mu        sync.RWMutex
+	serials   map[string]RevokedSVID
+	spiffeIDs map[string]struct{}
+	// podUIDs maps denied pods to when the denial lapses, or to the zero time
+	// for a denial that never does
+	podUIDs map[string]time.Time
+
+	// crls caches the CRL signed by each CA, by CA key ID. CRL numbers are
+	// shared between CAs, so that they only increase whichever CA signs
+	crls      map[string]signedCRL
+	crlNumber int64
+
+	subscribers map[chan []RevokedSVID]struct{}
+	signals     map[chan struct{}]struct{}
+}
+
+func newRevocations() *revocations {
+	return &revocations{
+		serials:   make(map[string]RevokedSVID),
+		spiffeIDs: make(map[string]struct{}),
+		podUIDs:   make(map[string]time.Time),
+
+		subscribers: make(map[chan []RevokedSVID]struct{}),
+		signals:     make(map[chan struct{}]struct{}),
+	}
+}
+
+// Revoke marks every SVID selected by the request as revoked and returns their
+// serials. The cached CRL is invalidated so the next fetch is re-signed
+func (i *SVIDIssuer) Revoke(req RevocationRequest) ([]string, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	// Denials are made before the ledger is queried, as an SVID issued
+	// meanwhile is only revoked by its issuance if it sees the denial
+	r := i.revocations
+	r.mu.Lock()
+	if req.SPIFFEID != "" {
+		r.spiffeIDs[req.SPIFFEID] = struct{}{}
+	}
+	if req.PodUID != "" {
+		r.podUIDs[req.PodUID] = time.Time{}
+	}
+	r.mu.Unlock()
+
+	q := LedgerQuery{
+		Serial:       req.Serial,
+		SPIFFEID:     req.SPIFFEID,
+		PodUID:       req.PodUID,
+		Registration: req.Registration,
+	}
+	records := i.ledger.Query(q)
+
+	// A serial missing from the ledger was issued before a restart or has aged
+	// out. It can't outlive the current CA, so keep it listed until then
+	if req.Serial != "" && len(records) == 0 {
+		_, caCert := i.currentCA()
+		records = []IssuanceRecord{{Serial: req.Serial, NotAfter: caCert.NotAfter}}
+	}
+	return i.revokeRecords(records, req.Reason), nil
+}
+
+// revokeRecords revokes SVIDs found in the ledger, returning the serials of
+// those not already revoked
+func (i *SVIDIssuer) revokeRecords(records []IssuanceRecord, reason string) []string {
+	r := i.revocations
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.revoke(records, reason)
+}
+
+// RevokeMatching revokes every unexpired SVID in the ledger matching the
+// query, without denying reissuance. It is used when the Kubernetes object an
+// SVID was issued for goes away
+func (i *SVIDIssuer) RevokeMatching(q LedgerQuery, reason string) []string {
+	q.At = time.Now()
+	records := i.ledger.Query(q)
+	if len(records) == 0 {
+		return []string{}
+	}
+	return i.revokeRecords(records, reason)
+}
+
+// RevokePod revokes every unexpired SVID issued to a pod that has gone away,
+// and denies its UID further SVIDs until until, by when any PSAT issued to
+// the pod has expired. Pod UIDs are never reused, so this only refuses
+// replayed tokens
+func (i *SVIDIssuer) RevokePod(podUID, reason string, until time.Time) []string {
+	now := time.Now()
+	r := i.revocations
+	r.mu.Lock()
+	for uid, lapses := range r.podUIDs {
+		if !lapses.IsZero() && !lapses.After(now) {
+			delete(r.podUIDs, uid)
+		}
+	}
+	if lapses, ok := r.podUIDs[podUID]; !ok || (!lapses.IsZero() && lapses.Before(until)) {
+		r.podUIDs[podUID] = until
+	}
+	r.mu.Unlock()
+
+	records := i.ledger.Query(LedgerQuery{PodUID: podUID, At: now})
+	return i.revokeRecords(records, reason)
+}
+
+// SubscribeRevocations returns a channel receiving each batch of newly revoked
+// SVIDs, and a func to unsubscribe. Slow subscribers miss batches rather than
+// block revocation, so those that can't afford to should use
+// SubscribeRevocationSignals instead
+func (i *SVIDIssuer) SubscribeRevocations() (<-chan []RevokedSVID, func()) {
+	r := i.revocations
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ch := make(chan []RevokedSVID, subscriberBuffer)
+	r.subscribers[ch] = struct{}{}
+	return ch, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if _, ok := r.subscribers[ch]; ok {
+			delete(r.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// SubscribeRevocationSignals returns a channel signalled whenever SVIDs are
+// revoked, and a func to unsubscribe. Signals coalesce, so a subscriber that
+// falls behind still sees one, after which it should check IsRevoked
+func (i *SVIDIssuer) SubscribeRevocationSignals() (<-chan struct{}, func()) {
+	r := i.revocations
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ch := make(chan struct{}, 1)
+	r.signals[ch] = struct{}{}
+	return ch, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if _, ok := r.signals[ch]; ok {
+			delete(r.signals, ch)
+			close(ch)
+		}
+	}
+}
+
+// revoke must be called with mu held
+func (r *revocations) revoke(records []IssuanceRecord, reason string) []string {
+	now := time.Now()
+	serials := []string{}
+	batch := []RevokedSVID{}
+	for _, rec := range records {
+		if _, ok := r.serials[rec.Serial]; ok {
+			continue
+		}
+		revoked := RevokedSVID{
+			Serial:    rec.Serial,
+			SPIFFEID:  rec.SPIFFEID,
+			PodUID:    rec.PodUID,
+			RevokedAt: now,
+			Reason:    reason,
+			NotAfter:  rec.NotAfter,
+		}
+		r.serials[rec.Serial] = revoked
+		serials = append(serials, rec.Serial)
+		batch = append(batch, revoked)
+	}
+	r.publish(batch)
+	return serials
+}
+
+// publish invalidates the cached CRL and notifies subscribers of a batch of
+// revocations. It must be called with mu held
+func (r *revocations) publish(batch []RevokedSVID) {
+	if len(batch) == 0 {
+		return
+	}
+
+	r.crls = nil
+	for ch := range r.subscribers {
+		select {
+		case ch <- batch:
+		default:
+		}
+	}
+	for ch := range r.signals {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// AddRevoked lists SVIDs revoked elsewhere, such as by another replica
+// sharing the CA, on the CRL and in OCSP responses. Serials already listed and
+// expired entries are skipped. Unlike Revoke, no further issuance is denied
+func (i *SVIDIssuer) AddRevoked(revoked []RevokedSVID) {
+	r := i.revocations
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	batch := []RevokedSVID{}
+	for _, s := range revoked {
+		serial, valid := NormalizeSerial(s.Serial)
+		if _, ok := r.serials[serial]; ok || !valid || !s.NotAfter.After(now) {
+			continue
+		}
+		s.Serial = serial
+		r.serials[s.Serial] = s
+		batch = append(batch, s)
+	}
+	r.publish(batch)
+}
+
+// LiftDenial allows a previously revoked SPIFFE ID or pod UID to be issued
+// SVIDs again. Certificates that were already revoked stay on the CRL
+func (i *SVIDIssuer) LiftDenial(req RevocationRequest) {
+	r := i.revocations
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.spiffeIDs, req.SPIFFEID)
+	delete(r.podUIDs, req.PodUID)
+}
+
+func (i *SVIDIssuer) invalidateCRL() {
+	r := i.revocations
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.crls = nil
+}
+
+// IsRevoked reports whether the SVID with the hex encoded serial is revoked
+func (i *SVIDIssuer) IsRevoked(serial string) bool {
+	serial, valid := NormalizeSerial(serial)
+	if !valid {
+		return false
+	}
+
+	r := i.revocations
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.serials[serial]
+	return ok
+}
+
+// Revoked lists the unexpired revoked SVIDs, ordered by serial
+func (i *SVIDIssuer) Revoked() []RevokedSVID {
+	r := i.revocations
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	revoked := []RevokedSVID{}
+	for _, s := range r.serials {
+		if s.NotAfter.After(now) {
+			revoked = append(revoked, s)
+		}
+	}
+	sort.Slice(revoked, func(a, b int) bool { return revoked[a].Serial < revoked[b].Serial })
+	return revoked
+}
+
+func (i *SVIDIssuer) checkDenied(spiffeID, podUID string) error {
+	r := i.revocations
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, ok := r.spiffeIDs[spiffeID]; ok {
+		return fmt.Errorf("%w: %s", ErrRevoked, spiffeID)
+	}
+	if lapses, ok := r.podUIDs[podUID]; podUID != "" && ok && (lapses.IsZero() || time.Now().Before(lapses)) {
+		return fmt.Errorf("%w: pod %s", ErrRevoked, podUID)
+	}
+	return nil
+}
+
+// AdvanceCRLNumber makes the next CRL number greater than n, the number of a
+// CRL signed elsewhere with the same CA, so that CRL numbers keep increasing
+// when another replica takes over signing
+func (i *SVIDIssuer) AdvanceCRLNumber(n int64) {
+	r := i.revocations
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.crlNumber < n {
+		r.crlNumber = n
+		r.crls = nil
+	}
+}
+
+// CRL returns the DER encoded revocation list signed by the current CA
+func (i *SVIDIssuer) CRL() ([]byte, error) {
+	return i.CRLFor("")
+}
+
+// CRLFor returns the DER encoded revocation list signed by the CA with the
+// key ID caID, or by the current CA if caID is empty. It is cached until
+// revocations change or half of its validity has elapsed
+func (i *SVIDIssuer) CRLFor(caID string) ([]byte, error) {
+	r := i.revocations
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, ca := range i.crlSigners() {
+		if caID == "" || ca.id == caID {
+			return i.signCRL(ca, time.Now())
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownCA, caID)
+}
+
+// CRLs returns the revocation list of the current CA and of each retired CA
+// that hasn't expired, by CA key ID, since SVIDs any of them issued may still
+// be in use
+func (i *SVIDIssuer) CRLs() (map[string][]byte, error) {
+	r := i.revocations
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	crls := make(map[string][]byte)
+	for _, ca := range i.crlSigners() {
+		crl, err := i.signCRL(ca, now)
+		if err != nil {
+			return nil, err
+		}
+		crls[ca.id] = crl
+	}
+	return crls, nil
+}
+
+type signedCRL struct {
+	der    []byte
+	expiry time.Time
+}
+
+type crlSigner struct {
+	id     string
+	signer crypto.Signer
+	caCert *x509.Certificate
+}
+
+// crlSigners returns the current CA followed by the retired CAs that haven't
+// expired and whose keys are known
+func (i *SVIDIssuer) crlSigners() []crlSigner {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	now := time.Now()
+	signers := []crlSigner{{id: CAKeyID(i.caCert), signer: i.signer, caCert: i.caCert}}
+	for n, c := range i.retired {
+		if c.NotAfter.After(now) && i.retiredSigners[n] != nil {
+			signers = append(signers, crlSigner{id: CAKeyID(c), signer: i.retiredSigners[n], caCert: c})
+		}
+	}
+	return signers
+}
+
+// signCRL must be called with the revocation lock held. Every CA lists every
+// revoked serial, as the CA that issued each isn't recorded. Serials are
+// random, so a CA's CRL never lists a serial it did issue by mistake
+func (i *SVIDIssuer) signCRL(ca crlSigner, now time.Time) ([]byte, error) {
+	r := i.revocations
+	if cached, ok := r.crls[ca.id]; ok && now.Before(cached.expiry) {
+		return cached.der, nil
+	}
+
+	entries := []x509.RevocationListEntry{}
+	for serial, s := range r.serials {
+		if !s.NotAfter.After(now) {
+			delete(r.serials, serial)
+			continue
+		}
+		n, _ := new(big.Int).SetString(serial, 16)
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   n,
+			RevocationTime: s.RevokedAt,
+			ReasonCode:     revocationReasons[s.Reason],
+		})
+	}
+
+	r.crlNumber++
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		RevokedCertificateEntries: entries,
+		Number:                    big.NewInt(r.crlNumber),
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(i.crlValidity),
+	}, ca.caCert, ca.signer)
+	if err != nil {
+		return nil, fmt.Errorf("problem signing CRL: %w", err)
+	}
+
+	if r.crls == nil {
+		r.crls = make(map[string]signedCRL)
+	}
+	r.crls[ca.id] = signedCRL{der: crl, expiry: now.Add(i.crlValidity / 2)}
+	return crl, nil
+}
+
+// CAKeyID identifies a CA by the SHA-1 hash of its public key, which is how
+// subject key IDs are derived (RFC 5280 4.2.1.2)
+func CAKeyID(caCert *x509.Certificate) string {
+	id, err := hashIssuerKey(crypto.SHA1, caCert)
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id)
+}
+
+// crlURLFor adds the key ID of the CA an SVID is signed by to the CRL
+// distribution point, so that relying parties fetch the CRL that CA signed
+func crlURLFor(crlURL string, caCert *x509.Certificate) string {
+	u, err := url.Parse(crlURL)
+	if err != nil {
+		return crlURL
+	}
+	q := u.Query()
+	q.Set("ca", CAKeyID(caCert))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
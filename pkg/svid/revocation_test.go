@@ -0,0 +1,351 @@
+package svid
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func issueMock(t *testing.T, issuer *SVIDIssuer, name, podUID string) *x509.Certificate {
+	t.Helper()
+	svidBytes, _, err := issuer.IssueX509SVID(context.Background(), mockRegistration(name), Workload{PodUID: podUID})
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(svidBytes)
+	require.NoError(t, err)
+	return cert
+}
+
+func TestRevoke(t *testing.T) {
+	tests := []struct {
+		name        string
+		req         func(cert *x509.Certificate) RevocationRequest
+		wantDenied  bool
+		wantErr     bool
+		wantRevoked int
+	}{
+		{
+			name: "by serial",
+			req: func(cert *x509.Certificate) RevocationRequest {
+				return RevocationRequest{Serial: cert.SerialNumber.Text(16)}
+			},
+			wantRevoked: 1,
+		},
+		{
+			// As openssl x509 -serial prints it
+			name: "by uppercase serial",
+			req: func(cert *x509.Certificate) RevocationRequest {
+				return RevocationRequest{Serial: "00" + strings.ToUpper(cert.SerialNumber.Text(16))}
+			},
+			wantRevoked: 1,
+		},
+		{
+			name: "invalid serial",
+			req: func(cert *x509.Certificate) RevocationRequest {
+				return RevocationRequest{Serial: "not-hex"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "by SPIFFE ID",
+			req: func(cert *x509.Certificate) RevocationRequest {
+				return RevocationRequest{SPIFFEID: cert.URIs[0].String(), Reason: "keyCompromise"}
+			},
+			wantDenied:  true,
+			wantRevoked: 2,
+		},
+		{
+			name: "by pod UID",
+			req: func(cert *x509.Certificate) RevocationRequest {
+				return RevocationRequest{PodUID: "pod-1"}
+			},
+			wantDenied:  true,
+			wantRevoked: 2,
+		},
+		{
+			name: "nothing selected",
+			req: func(cert *x509.Certificate) RevocationRequest {
+				return RevocationRequest{}
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown reason",
+			req: func(cert *x509.Certificate) RevocationRequest {
+				return RevocationRequest{PodUID: "pod-1", Reason: "bored"}
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer, err := NewSVIDIssuer()
+			require.NoError(t, err)
+			cert := issueMock(t, issuer, "workload", "pod-1")
+			issueMock(t, issuer, "workload", "pod-1")
+			issueMock(t, issuer, "another", "pod-2")
+
+			serials, err := issuer.Revoke(tt.req(cert))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, serials, tt.wantRevoked)
+			assert.True(t, issuer.IsRevoked(cert.SerialNumber.Text(16)))
+
+			_, _, err = issuer.IssueX509SVID(context.Background(), mockRegistration("workload"), Workload{PodUID: "pod-1"})
+			assert.Equal(t, tt.wantDenied, errors.Is(err, ErrRevoked))
+
+			_, _, err = issuer.IssueX509SVID(context.Background(), mockRegistration("another"), Workload{PodUID: "pod-2"})
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestRevokeDuringIssuance(t *testing.T) {
+	issuer, err := NewSVIDIssuer()
+	require.NoError(t, err)
+
+	// Every SVID handed out must be revoked once Revoke returns, however the
+	// issuances interleave with it
+	var (
+		mu     sync.Mutex
+		issued []string
+		wg     sync.WaitGroup
+	)
+	start := make(chan struct{})
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for {
+				der, _, err := issuer.IssueX509SVID(context.Background(), mockRegistration("workload"), Workload{PodUID: "pod-1"})
+				if errors.Is(err, ErrRevoked) {
+					return
+				}
+				require.NoError(t, err)
+				cert, err := x509.ParseCertificate(der)
+				require.NoError(t, err)
+				mu.Lock()
+				issued = append(issued, cert.SerialNumber.Text(16))
+				mu.Unlock()
+			}
+		}()
+	}
+	close(start)
+	time.Sleep(20 * time.Millisecond)
+	_, err = issuer.Revoke(RevocationRequest{SPIFFEID: mockRegistration("workload").Spec.SPIFFEID})
+	require.NoError(t, err)
+	wg.Wait()
+
+	require.NotEmpty(t, issued)
+	for _, serial := range issued {
+		assert.True(t, issuer.IsRevoked(serial), serial)
+	}
+}
+
+func TestNormalizeSerial(t *testing.T) {
+	for serial, want := range map[string]string{
+		"1f3a":       "1f3a",
+		"001F3A":     "1f3a",
+		"1f:3a":      "1f3a",
+		"1F:3A:00":   "1f3a00",
+		"":           "",
+		"0x1f3a":     "",
+		"-1f3a":      "",
+		"spiffe://x": "",
+	} {
+		got, ok := NormalizeSerial(serial)
+		assert.Equal(t, want != "", ok, serial)
+		assert.Equal(t, want, got, serial)
+	}
+
+	issuer, err := NewSVIDIssuer()
+	require.NoError(t, err)
+	cert := issueMock(t, issuer, "workload", "pod-1")
+	upper := strings.ToUpper(cert.SerialNumber.Text(16))
+	assert.Len(t, issuer.Ledger().Query(LedgerQuery{Serial: upper}), 1)
+	_, err = issuer.Revoke(RevocationRequest{Serial: upper})
+	require.NoError(t, err)
+	assert.True(t, issuer.IsRevoked(upper))
+	assert.Equal(t, cert.SerialNumber.Text(16), issuer.Revoked()[0].Serial)
+	assert.Equal(t, cert.NotAfter.Unix(), issuer.Revoked()[0].NotAfter.Unix(), "found in the ledger")
+}
+
+func TestLiftDenial(t *testing.T) {
+	issuer, err := NewSVIDIssuer()
+	require.NoError(t, err)
+	cert := issueMock(t, issuer, "workload", "pod-1")
+
+	req := RevocationRequest{SPIFFEID: "spiffe://example.org/workload"}
+	_, err = issuer.Revoke(req)
+	require.NoError(t, err)
+
+	issuer.LiftDenial(req)
+	issueMock(t, issuer, "workload", "pod-1")
+	assert.True(t, issuer.IsRevoked(cert.SerialNumber.Text(16)))
+}
+
+func TestRevokePod(t *testing.T) {
+	issuer, err := NewSVIDIssuer()
+	require.NoError(t, err)
+	cert := issueMock(t, issuer, "workload", "pod-1")
+	issueMock(t, issuer, "another", "pod-2")
+
+	serials := issuer.RevokePod("pod-1", "cessationOfOperation", time.Now().Add(time.Hour))
+	assert.Equal(t, []string{cert.SerialNumber.Text(16)}, serials)
+	_, _, err = issuer.IssueX509SVID(context.Background(), mockRegistration("workload"), Workload{PodUID: "pod-1"})
+	assert.ErrorIs(t, err, ErrRevoked)
+	issueMock(t, issuer, "another", "pod-2")
+
+	// A lapsed denial is dropped by the next, and the pod can be issued again
+	issuer.RevokePod("pod-3", "cessationOfOperation", time.Now().Add(-time.Second))
+	issueMock(t, issuer, "workload", "pod-3")
+	issuer.RevokePod("pod-4", "cessationOfOperation", time.Now().Add(time.Hour))
+	issuer.revocations.mu.RLock()
+	assert.NotContains(t, issuer.revocations.podUIDs, "pod-3")
+	issuer.revocations.mu.RUnlock()
+
+	// A lasting denial by Revoke isn't shortened
+	_, err = issuer.Revoke(RevocationRequest{PodUID: "pod-5"})
+	require.NoError(t, err)
+	issuer.RevokePod("pod-5", "cessationOfOperation", time.Now().Add(-time.Second))
+	_, _, err = issuer.IssueX509SVID(context.Background(), mockRegistration("workload"), Workload{PodUID: "pod-5"})
+	assert.ErrorIs(t, err, ErrRevoked)
+}
+
+func TestCRL(t *testing.T) {
+	issuer, err := NewSVIDIssuer(WithCRLDistributionPoint("http://kubespiffed/v1/crl"))
+	require.NoError(t, err)
+	cert := issueMock(t, issuer, "workload", "pod-1")
+	caCert, err := x509.ParseCertificate(issuer.GetCACert())
+	require.NoError(t, err)
+	assert.Equal(t, hex.EncodeToString(caCert.SubjectKeyId), CAKeyID(caCert))
+	assert.Equal(t, []string{"http://kubespiffed/v1/crl?ca=" + CAKeyID(caCert)}, cert.CRLDistributionPoints)
+
+	_, err = issuer.Revoke(RevocationRequest{Serial: cert.SerialNumber.Text(16), Reason: "keyCompromise"})
+	require.NoError(t, err)
+
+	der, err := issuer.CRL()
+	require.NoError(t, err)
+	crl, err := x509.ParseRevocationList(der)
+	require.NoError(t, err)
+	require.NoError(t, crl.CheckSignatureFrom(caCert))
+	require.Len(t, crl.RevokedCertificateEntries, 1)
+	assert.Equal(t, cert.SerialNumber, crl.RevokedCertificateEntries[0].SerialNumber)
+	assert.Equal(t, 1, crl.RevokedCertificateEntries[0].ReasonCode)
+
+	cached, err := issuer.CRL()
+	require.NoError(t, err)
+	assert.Equal(t, der, cached)
+
+	require.NoError(t, issuer.RotateCA())
+	rotated, err := issuer.CRL()
+	require.NoError(t, err)
+	assert.NotEqual(t, der, rotated)
+
+	// The retired CA goes on signing a CRL for the SVIDs it issued
+	der, err = issuer.CRLFor(CAKeyID(caCert))
+	require.NoError(t, err)
+	crl, err = x509.ParseRevocationList(der)
+	require.NoError(t, err)
+	require.NoError(t, crl.CheckSignatureFrom(caCert))
+	require.Len(t, crl.RevokedCertificateEntries, 1)
+	assert.Equal(t, cert.SerialNumber, crl.RevokedCertificateEntries[0].SerialNumber)
+
+	crls, err := issuer.CRLs()
+	require.NoError(t, err)
+	assert.Len(t, crls, 2)
+	assert.Contains(t, crls, CAKeyID(caCert))
+
+	_, err = issuer.CRLFor("unknown")
+	assert.ErrorIs(t, err, ErrUnknownCA)
+}
+
+func TestAddRevoked(t *testing.T) {
+	m, err := GenerateMaterial(time.Hour)
+	require.NoError(t, err)
+	first, err := NewSVIDIssuer(WithMaterial(m))
+	require.NoError(t, err)
+	second, err := NewSVIDIssuer(WithMaterial(m))
+	require.NoError(t, err)
+
+	cert := issueMock(t, first, "workload", "pod-1")
+	_, err = first.Revoke(RevocationRequest{PodUID: "pod-1", Reason: "keyCompromise"})
+	require.NoError(t, err)
+
+	revoked, unsubscribe := second.SubscribeRevocations()
+	defer unsubscribe()
+	expired := RevokedSVID{Serial: "ff", NotAfter: time.Now().Add(-time.Minute)}
+	second.AddRevoked(append(first.Revoked(), expired))
+	second.AddRevoked(first.Revoked())
+
+	assert.Len(t, <-revoked, 1)
+	select {
+	case batch := <-revoked:
+		t.Fatalf("unexpected second batch %v", batch)
+	default:
+	}
+	assert.True(t, second.IsRevoked(cert.SerialNumber.Text(16)))
+	assert.False(t, second.IsRevoked("ff"))
+
+	// Unlike Revoke, shared revocations don't deny issuance
+	issueMock(t, second, "workload", "pod-1")
+}
+
+func TestSubscribeRevocationSignals(t *testing.T) {
+	issuer, err := NewSVIDIssuer()
+	require.NoError(t, err)
+	batches, unsubscribeBatches := issuer.SubscribeRevocations()
+	defer unsubscribeBatches()
+	signals, unsubscribe := issuer.SubscribeRevocationSignals()
+	defer unsubscribe()
+
+	// A subscriber that falls behind misses batches, but not the signal
+	var last string
+	for n := range subscriberBuffer + 1 {
+		last = fmt.Sprintf("%x", n+1)
+		_, err := issuer.Revoke(RevocationRequest{Serial: last})
+		require.NoError(t, err)
+	}
+	assert.Len(t, batches, subscriberBuffer)
+	<-signals
+	select {
+	case <-signals:
+		t.Fatal("signals didn't coalesce")
+	default:
+	}
+	assert.True(t, issuer.IsRevoked(last))
+
+	unsubscribe()
+	_, ok := <-signals
+	assert.False(t, ok)
+}
+
+func TestAdvanceCRLNumber(t *testing.T) {
+	issuer, err := NewSVIDIssuer()
+	require.NoError(t, err)
+
+	issuer.AdvanceCRLNumber(41)
+	der, err := issuer.CRL()
+	require.NoError(t, err)
+	crl, err := x509.ParseRevocationList(der)
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), crl.Number.Int64())
+
+	issuer.AdvanceCRLNumber(7)
+	cached, err := issuer.CRL()
+	require.NoError(t, err)
+	assert.Equal(t, der, cached)
+}
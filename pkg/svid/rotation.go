@@ -0,0 +1,46 @@
+package svid
+
+import "sync"
+
+// rotations signals subscribers whenever the trust domain's authorities
+// change. Signals coalesce, so a subscriber that falls behind sees one
+type rotations struct {
+	mu          sync.Mutex
+	subscribers map[chan struct{}]struct{}
+}
+
+func newRotations() *rotations {
+	return &rotations{
+		subscribers: make(map[chan struct{}]struct{}),
+	}
+}
+
+// SubscribeRotations returns a channel signalled after the CA or JWT signing
+// key is rotated, and a func to unsubscribe
+func (i *SVIDIssuer) SubscribeRotations() (<-chan struct{}, func()) {
+	r := i.rotations
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ch := make(chan struct{}, 1)
+	r.subscribers[ch] = struct{}{}
+	return ch, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if _, ok := r.subscribers[ch]; ok {
+			delete(r.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (r *rotations) notify() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for ch := range r.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
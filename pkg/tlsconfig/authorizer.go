@@ -0,0 +1,65 @@
+package tlsconfig
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+)
+
+// Authorizer decides whether a peer, whose chain has already been verified
+// against the bundle of its trust domain, is allowed. id is the peer's SPIFFE
+// ID
+type Authorizer func(id string, verifiedChains [][]*x509.Certificate) error
+
+// AuthorizeAny allows any peer with a valid SVID from a trusted trust domain
+func AuthorizeAny() Authorizer {
+	return func(string, [][]*x509.Certificate) error {
+		return nil
+	}
+}
+
+// AuthorizeID allows only the peer with the given SPIFFE ID
+func AuthorizeID(allowed string) Authorizer {
+	return func(id string, _ [][]*x509.Certificate) error {
+		if id != allowed {
+			return fmt.Errorf("unexpected peer ID %q", id)
+		}
+		return nil
+	}
+}
+
+// AuthorizeOneOf allows peers with any of the given SPIFFE IDs
+func AuthorizeOneOf(allowed ...string) Authorizer {
+	return func(id string, _ [][]*x509.Certificate) error {
+		if !slices.Contains(allowed, id) {
+			return fmt.Errorf("unexpected peer ID %q", id)
+		}
+		return nil
+	}
+}
+
+// AuthorizeMemberOf allows any peer in the given trust domain
+func AuthorizeMemberOf(trustDomain string) Authorizer {
+	return func(id string, _ [][]*x509.Certificate) error {
+		u, err := url.Parse(id)
+		if err != nil || u.Host != trustDomain {
+			return fmt.Errorf("peer ID %q is not a member of trust domain %q", id, trustDomain)
+		}
+		return nil
+	}
+}
+
+// AuthorizeMatchingPath allows peers whose SPIFFE ID path matches pattern, in
+// any trusted trust domain. Patterns should be anchored, e.g.
+// ^/ns/payments/sa/[^/]+$
+func AuthorizeMatchingPath(pattern *regexp.Regexp) Authorizer {
+	return func(id string, _ [][]*x509.Certificate) error {
+		u, err := url.Parse(id)
+		if err != nil || !pattern.MatchString(u.Path) {
+			return fmt.Errorf("peer ID %q does not match %q", id, pattern)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,58 @@
+package tlsconfig
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorizers(t *testing.T) {
+	tests := []struct {
+		name      string
+		authorize Authorizer
+		allowed   []string
+		denied    []string
+	}{
+		{
+			name:      "any",
+			authorize: AuthorizeAny(),
+			allowed:   []string{"spiffe://example.org/frontend", "spiffe://partner.org/billing"},
+		},
+		{
+			name:      "ID",
+			authorize: AuthorizeID("spiffe://example.org/frontend"),
+			allowed:   []string{"spiffe://example.org/frontend"},
+			denied:    []string{"spiffe://example.org/backend", "spiffe://partner.org/frontend"},
+		},
+		{
+			name:      "one of",
+			authorize: AuthorizeOneOf("spiffe://example.org/frontend", "spiffe://partner.org/billing"),
+			allowed:   []string{"spiffe://example.org/frontend", "spiffe://partner.org/billing"},
+			denied:    []string{"spiffe://example.org/billing"},
+		},
+		{
+			name:      "member of",
+			authorize: AuthorizeMemberOf("partner.org"),
+			allowed:   []string{"spiffe://partner.org/billing", "spiffe://partner.org/ns/default/sa/default"},
+			denied:    []string{"spiffe://example.org/billing", "spiffe://partner.org.evil/billing"},
+		},
+		{
+			name:      "matching path",
+			authorize: AuthorizeMatchingPath(regexp.MustCompile(`^/ns/payments/sa/[^/]+$`)),
+			allowed:   []string{"spiffe://example.org/ns/payments/sa/api", "spiffe://partner.org/ns/payments/sa/worker"},
+			denied:    []string{"spiffe://example.org/ns/payments/sa/api/extra", "spiffe://example.org/ns/default/sa/api"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, id := range tt.allowed {
+				assert.NoError(t, tt.authorize(id, nil), id)
+			}
+			for _, id := range tt.denied {
+				assert.Error(t, tt.authorize(id, nil), id)
+			}
+		})
+	}
+}
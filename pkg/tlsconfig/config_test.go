@@ -0,0 +1,147 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/jsnctl/kubespiffe/pkg/apis/kubespiffe/v1alpha1"
+	"github.com/jsnctl/kubespiffe/pkg/svid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type staticSVID struct {
+	cert *tls.Certificate
+}
+
+func (s staticSVID) GetTLSCertificate() (*tls.Certificate, error) {
+	return s.cert, nil
+}
+
+func mockSVID(t *testing.T, issuer *svid.SVIDIssuer, spiffeID string) staticSVID {
+	certDER, keyDER, err := issuer.IssueX509SVID(context.Background(), &v1alpha1.WorkloadRegistration{
+		ObjectMeta: metav1.ObjectMeta{Name: "workload"},
+		Spec:       v1alpha1.WorkloadRegistrationSpec{SPIFFEID: spiffeID},
+	}, svid.Workload{})
+	require.NoError(t, err)
+	key, err := x509.ParsePKCS8PrivateKey(keyDER)
+	require.NoError(t, err)
+	return staticSVID{cert: &tls.Certificate{Certificate: [][]byte{certDER}, PrivateKey: key}}
+}
+
+func bundleOf(t *testing.T, issuer *svid.SVIDIssuer) []*x509.Certificate {
+	ca, err := x509.ParseCertificate(issuer.GetCACert())
+	require.NoError(t, err)
+	return []*x509.Certificate{ca}
+}
+
+func TestHTTPFederated(t *testing.T) {
+	local, err := svid.NewSVIDIssuer()
+	require.NoError(t, err)
+	partner, err := svid.NewSVIDIssuer(svid.WithTrustDomain("partner.org"))
+	require.NoError(t, err)
+	untrusted, err := svid.NewSVIDIssuer(svid.WithTrustDomain("partner.org"))
+	require.NoError(t, err)
+
+	bundles := Bundles{
+		"example.org": bundleOf(t, local),
+		"partner.org": bundleOf(t, partner),
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, err := PeerID(*r.TLS)
+			require.NoError(t, err)
+			io.WriteString(w, id)
+		}),
+		TLSConfig: MTLSServerConfig(mockSVID(t, local, "spiffe://example.org/api"), bundles, AuthorizeMemberOf("partner.org")),
+	}
+	go srv.ServeTLS(l, "", "")
+	defer srv.Close()
+
+	get := func(client SVIDSource, authorize Authorizer) (string, error) {
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: MTLSClientConfig(client, bundles, authorize)}}
+		resp, err := c.Get("https://" + l.Addr().String())
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	// A federated peer is verified against its own trust domain's bundle
+	body, err := get(mockSVID(t, partner, "spiffe://partner.org/billing"), AuthorizeID("spiffe://example.org/api"))
+	require.NoError(t, err)
+	assert.Equal(t, "spiffe://partner.org/billing", body)
+
+	// Local peers are trusted, but not authorized by the server
+	_, err = get(mockSVID(t, local, "spiffe://example.org/frontend"), AuthorizeID("spiffe://example.org/api"))
+	assert.Error(t, err)
+
+	// Claiming a trusted trust domain with an untrusted CA fails verification
+	_, err = get(mockSVID(t, untrusted, "spiffe://partner.org/billing"), AuthorizeID("spiffe://example.org/api"))
+	assert.Error(t, err)
+
+	// The client rejects a server it doesn't authorize
+	_, err = get(mockSVID(t, partner, "spiffe://partner.org/billing"), AuthorizeID("spiffe://example.org/other"))
+	assert.ErrorContains(t, err, "unexpected peer ID")
+
+	// Server-only authentication
+	c := &http.Client{Transport: &http.Transport{TLSClientConfig: TLSClientConfig(bundles, AuthorizeAny())}}
+	_, err = c.Get("https://" + l.Addr().String())
+	assert.Error(t, err, "server requires a client SVID")
+}
+
+func TestGRPC(t *testing.T) {
+	issuer, err := svid.NewSVIDIssuer()
+	require.NoError(t, err)
+	bundles := Bundles{"example.org": bundleOf(t, issuer)}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	var peerID string
+	srv := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(MTLSServerConfig(mockSVID(t, issuer, "spiffe://example.org/api"), bundles, AuthorizeOneOf("spiffe://example.org/frontend")))),
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			p, _ := peer.FromContext(ctx)
+			peerID, err = PeerID(p.AuthInfo.(credentials.TLSInfo).State)
+			require.NoError(t, err)
+			return handler(ctx, req)
+		}),
+	)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	check := func(client SVIDSource) error {
+		conn, err := grpc.NewClient(l.Addr().String(),
+			grpc.WithTransportCredentials(credentials.NewTLS(MTLSClientConfig(client, bundles, AuthorizeID("spiffe://example.org/api")))),
+		)
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+		return err
+	}
+
+	require.NoError(t, check(mockSVID(t, issuer, "spiffe://example.org/frontend")))
+	assert.Equal(t, "spiffe://example.org/frontend", peerID)
+
+	err = check(mockSVID(t, issuer, "spiffe://example.org/intruder"))
+	require.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "tls") || strings.Contains(err.Error(), "connection"), err.Error())
+}
@@ -0,0 +1,64 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestSetup(t *testing.T) {
+	shutdown, err := Setup(context.Background(), Config{})
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	// Without an endpoint no provider is installed, so spans are no-ops
+	ctx, span := Start(context.Background(), "noop")
+	defer span.End()
+	assert.False(t, trace.SpanContextFromContext(ctx).IsValid())
+}
+
+func TestInstrumentHandler(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+
+	tests := []struct {
+		name   string
+		status int
+		code   codes.Code
+	}{
+		{name: "ok", status: http.StatusOK, code: codes.Unset},
+		{name: "client error", status: http.StatusForbidden, code: codes.Unset},
+		{name: "server error", status: http.StatusServiceUnavailable, code: codes.Error},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder.Reset()
+			h := InstrumentHandler("GET /test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test", nil))
+			assert.Equal(t, tt.status, rec.Code)
+
+			spans := recorder.Ended()
+			require.Len(t, spans, 1)
+			assert.Equal(t, "GET /test", spans[0].Name())
+			assert.Equal(t, trace.SpanKindServer, spans[0].SpanKind())
+			assert.Equal(t, tt.code, spans[0].Status().Code)
+			assert.Contains(t, spans[0].Attributes(), semconv.HTTPResponseStatusCode(tt.status))
+		})
+	}
+}
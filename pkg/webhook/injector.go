@@ -0,0 +1,194 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	InjectAnnotation = "kubespiffe.io/inject"
+
+	PSATVolumeName = "kubespiffe-psat"
+	SVIDVolumeName = "kubespiffe-svid"
+	PSATMountPath  = "/var/run/secrets/tokens"
+	SVIDMountPath  = "/var/run/secrets/spiffe"
+
+	helperContainerName     = "kubespiffe-helper"
+	helperInitContainerName = "kubespiffe-helper-init"
+	helperBinary            = "/app/kubespiffe-helper"
+	psatAudience            = "kubespiffed"
+	psatExpirationSeconds   = 3600
+	maxReviewBytes          = 4 * 1024 * 1024
+)
+
+// Config describes the helper containers added to pods
+type Config struct {
+	HelperImage    string
+	KubespiffedURL string
+}
+
+// Injector is a mutating admission webhook adding a projected PSAT, the SVID
+// helper as an init container and sidecar, and a shared in-memory volume for
+// the SVID files to pods annotated with kubespiffe.io/inject: "true"
+type Injector struct {
+	cfg Config
+}
+
+func NewInjector(cfg Config) *Injector {
+	return &Injector{cfg: cfg}
+}
+
+type patchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value,omitempty"`
+}
+
+func (i *Injector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var review admissionv1.AdmissionReview
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxReviewBytes)).Decode(&review); err != nil {
+		http.Error(w, fmt.Sprintf("invalid admission review: %v", err), http.StatusBadRequest)
+		return
+	}
+	if review.Request == nil {
+		http.Error(w, "admission review has no request", http.StatusBadRequest)
+		return
+	}
+
+	review.Response = i.review(review.Request)
+	review.Response.UID = review.Request.UID
+	review.Request = nil
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(review)
+}
+
+func (i *Injector) review(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	resp := &admissionv1.AdmissionResponse{Allowed: true}
+	if req.Kind.Kind != "Pod" || req.Operation != admissionv1.Create {
+		return resp
+	}
+
+	var pod corev1.Pod
+	if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
+		slog.Error("problem decoding pod", "error", err)
+		resp.Allowed = false
+		resp.Result = &metav1.Status{Message: fmt.Sprintf("invalid pod: %v", err)}
+		return resp
+	}
+
+	patch := i.mutate(&pod)
+	if len(patch) == 0 {
+		return resp
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		slog.Error("problem encoding patch", "error", err)
+		return resp
+	}
+	slog.Info("💉 Pod injected", "namespace", req.Namespace, "pod", pod.GenerateName+pod.Name)
+
+	patchType := admissionv1.PatchTypeJSONPatch
+	resp.Patch = data
+	resp.PatchType = &patchType
+	return resp
+}
+
+// mutate returns the JSON patch injecting the helper into the pod, or nothing
+// if the pod is not annotated or has already been injected. Each list is
+// replaced whole, as JSON patch can't append to a list that may not exist
+func (i *Injector) mutate(pod *corev1.Pod) []patchOperation {
+	if pod.Annotations[InjectAnnotation] != "true" {
+		return nil
+	}
+	for _, v := range pod.Spec.Volumes {
+		if v.Name == PSATVolumeName || v.Name == SVIDVolumeName {
+			return nil
+		}
+	}
+
+	volumes := append(pod.Spec.Volumes,
+		corev1.Volume{
+			Name: PSATVolumeName,
+			VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{
+				Sources: []corev1.VolumeProjection{{
+					ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+						Path:              "psat",
+						Audience:          psatAudience,
+						ExpirationSeconds: ptr.To[int64](psatExpirationSeconds),
+					},
+				}},
+			}},
+		},
+		corev1.Volume{
+			Name:         SVIDVolumeName,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{Medium: corev1.StorageMediumMemory}},
+		},
+	)
+
+	containers := make([]corev1.Container, 0, len(pod.Spec.Containers)+1)
+	for _, c := range pod.Spec.Containers {
+		if hasMountPath(c, SVIDMountPath) {
+			containers = append(containers, c)
+			continue
+		}
+		c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{
+			Name:      SVIDVolumeName,
+			MountPath: SVIDMountPath,
+			ReadOnly:  true,
+		})
+		containers = append(containers, c)
+	}
+	containers = append(containers, i.helperContainer(helperContainerName))
+
+	// The init container runs last, so other init containers can't hold up
+	// the SVID it blocks on, but the SVID is there before any app container
+	initContainers := append(pod.Spec.InitContainers, i.helperContainer(helperInitContainerName, "-init"))
+
+	return []patchOperation{
+		{Op: "add", Path: "/spec/volumes", Value: volumes},
+		{Op: "add", Path: "/spec/initContainers", Value: initContainers},
+		{Op: "add", Path: "/spec/containers", Value: containers},
+	}
+}
+
+func (i *Injector) helperContainer(name string, args ...string) corev1.Container {
+	return corev1.Container{
+		Name:    name,
+		Image:   i.cfg.HelperImage,
+		Command: []string{helperBinary},
+		// The app containers may run as a different user to the helper, and the
+		// volume is only shared within the pod
+		Args: append([]string{"-url", i.cfg.KubespiffedURL, "-key-mode", "0644"}, args...),
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: PSATVolumeName, MountPath: PSATMountPath, ReadOnly: true},
+			{Name: SVIDVolumeName, MountPath: SVIDMountPath},
+		},
+		SecurityContext: &corev1.SecurityContext{
+			AllowPrivilegeEscalation: ptr.To(false),
+			ReadOnlyRootFilesystem:   ptr.To(true),
+		},
+	}
+}
+
+func hasMountPath(c corev1.Container, path string) bool {
+	for _, m := range c.VolumeMounts {
+		if m.MountPath == path {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func mockPod(annotations map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "workload-", Namespace: "default", Annotations: annotations},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "app", Image: "nginx"},
+				{Name: "certs", Image: "busybox", VolumeMounts: []corev1.VolumeMount{{Name: "own", MountPath: SVIDMountPath}}},
+			},
+			Volumes: []corev1.Volume{{Name: "own"}},
+		},
+	}
+}
+
+func admit(t *testing.T, injector *Injector, pod *corev1.Pod, operation admissionv1.Operation) (*corev1.Pod, *admissionv1.AdmissionResponse) {
+	raw, err := json.Marshal(pod)
+	require.NoError(t, err)
+	review := admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       types.UID("req-1"),
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Namespace: "default",
+			Operation: operation,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+	body, err := json.Marshal(review)
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	injector.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var out admissionv1.AdmissionReview
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&out))
+	require.NotNil(t, out.Response)
+	assert.Equal(t, types.UID("req-1"), out.Response.UID)
+	assert.True(t, out.Response.Allowed)
+	if out.Response.Patch == nil {
+		return pod, out.Response
+	}
+
+	patch, err := jsonpatch.DecodePatch(out.Response.Patch)
+	require.NoError(t, err)
+	patched, err := patch.Apply(raw)
+	require.NoError(t, err)
+	var mutated corev1.Pod
+	require.NoError(t, json.Unmarshal(patched, &mutated))
+	return &mutated, out.Response
+}
+
+func TestInjector(t *testing.T) {
+	injector := NewInjector(Config{HelperImage: "kubespiffed:latest", KubespiffedURL: "http://kubespiffed:8080"})
+
+	pod, resp := admit(t, injector, mockPod(map[string]string{InjectAnnotation: "true"}), admissionv1.Create)
+	require.NotNil(t, resp.PatchType)
+	assert.Equal(t, admissionv1.PatchTypeJSONPatch, *resp.PatchType)
+
+	volumes := map[string]corev1.Volume{}
+	for _, v := range pod.Spec.Volumes {
+		volumes[v.Name] = v
+	}
+	assert.Contains(t, volumes, "own")
+	require.Contains(t, volumes, PSATVolumeName)
+	token := volumes[PSATVolumeName].Projected.Sources[0].ServiceAccountToken
+	assert.Equal(t, "kubespiffed", token.Audience)
+	assert.Equal(t, "psat", token.Path)
+	require.Contains(t, volumes, SVIDVolumeName)
+	assert.Equal(t, corev1.StorageMediumMemory, volumes[SVIDVolumeName].EmptyDir.Medium)
+
+	require.Len(t, pod.Spec.InitContainers, 1)
+	assert.Equal(t, helperInitContainerName, pod.Spec.InitContainers[0].Name)
+	assert.Contains(t, pod.Spec.InitContainers[0].Args, "-init")
+
+	require.Len(t, pod.Spec.Containers, 3)
+	app, certs, sidecar := pod.Spec.Containers[0], pod.Spec.Containers[1], pod.Spec.Containers[2]
+	assert.Equal(t, []corev1.VolumeMount{{Name: SVIDVolumeName, MountPath: SVIDMountPath, ReadOnly: true}}, app.VolumeMounts)
+	assert.Equal(t, []corev1.VolumeMount{{Name: "own", MountPath: SVIDMountPath}}, certs.VolumeMounts, "existing mounts are left alone")
+	assert.Equal(t, helperContainerName, sidecar.Name)
+	assert.Equal(t, "kubespiffed:latest", sidecar.Image)
+	assert.NotContains(t, sidecar.Args, "-init")
+
+	// Reinvocation doesn't inject twice
+	_, resp = admit(t, injector, pod, admissionv1.Create)
+	assert.Nil(t, resp.Patch)
+}
+
+func TestInjectorSkips(t *testing.T) {
+	injector := NewInjector(Config{HelperImage: "kubespiffed:latest"})
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		operation   admissionv1.Operation
+	}{
+		{name: "not annotated", operation: admissionv1.Create},
+		{name: "annotated false", annotations: map[string]string{InjectAnnotation: "false"}, operation: admissionv1.Create},
+		{name: "update", annotations: map[string]string{InjectAnnotation: "true"}, operation: admissionv1.Update},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, resp := admit(t, injector, mockPod(tt.annotations), tt.operation)
+			assert.Nil(t, resp.Patch)
+		})
+	}
+}